@@ -0,0 +1,147 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+const (
+	RememberActionName   = "remember"
+	ForgetActionName     = "forget"
+	UpdateGoalActionName = "update_goal"
+)
+
+// MemoryWriter updates the short-term memory and the goal of an agent, the
+// memory actions write through it
+type MemoryWriter interface {
+	Remember(ctx context.Context, memory string) (string, error)
+	Forget(ctx context.Context, memory string) (string, error)
+	UpdateGoal(ctx context.Context, goal string) (string, error)
+}
+
+func NewRemember(writer MemoryWriter) *RememberAction {
+	return &RememberAction{writer: writer}
+}
+
+func NewForget(writer MemoryWriter) *ForgetAction {
+	return &ForgetAction{writer: writer}
+}
+
+func NewUpdateGoal(writer MemoryWriter) *UpdateGoalAction {
+	return &UpdateGoalAction{writer: writer}
+}
+
+type RememberAction struct{ writer MemoryWriter }
+type ForgetAction struct{ writer MemoryWriter }
+type UpdateGoalAction struct{ writer MemoryWriter }
+
+type MemoryParams struct {
+	Memory string `json:"memory"`
+}
+
+type GoalParams struct {
+	Goal string `json:"goal"`
+}
+
+func (a *RememberAction) Run(ctx context.Context, sharedState *types.AgentSharedState, params types.ActionParams) (types.ActionResult, error) {
+	p := MemoryParams{}
+	if err := params.Unmarshal(&p); err != nil {
+		return types.ActionResult{}, err
+	}
+	if strings.TrimSpace(p.Memory) == "" {
+		return types.ActionResult{}, fmt.Errorf("memory is required")
+	}
+
+	result, err := a.writer.Remember(ctx, p.Memory)
+	if err != nil {
+		return types.ActionResult{}, err
+	}
+	return types.ActionResult{Result: result}, nil
+}
+
+func (a *RememberAction) Plannable() bool {
+	return true
+}
+
+func (a *RememberAction) Definition() types.ActionDefinition {
+	return types.ActionDefinition{
+		Name:        RememberActionName,
+		Description: "Remember something across conversations, e.g. a fact about the user, a preference or a commitment. Keep memories short and self-contained.",
+		Properties: map[string]jsonschema.Definition{
+			"memory": {
+				Type:        jsonschema.String,
+				Description: "What to remember, as a short sentence.",
+			},
+		},
+		Required: []string{"memory"},
+	}
+}
+
+func (a *ForgetAction) Run(ctx context.Context, sharedState *types.AgentSharedState, params types.ActionParams) (types.ActionResult, error) {
+	p := MemoryParams{}
+	if err := params.Unmarshal(&p); err != nil {
+		return types.ActionResult{}, err
+	}
+	if strings.TrimSpace(p.Memory) == "" {
+		return types.ActionResult{}, fmt.Errorf("memory is required")
+	}
+
+	result, err := a.writer.Forget(ctx, p.Memory)
+	if err != nil {
+		return types.ActionResult{}, err
+	}
+	return types.ActionResult{Result: result}, nil
+}
+
+func (a *ForgetAction) Plannable() bool {
+	return true
+}
+
+func (a *ForgetAction) Definition() types.ActionDefinition {
+	return types.ActionDefinition{
+		Name:        ForgetActionName,
+		Description: "Forget a memory which is wrong or outdated.",
+		Properties: map[string]jsonschema.Definition{
+			"memory": {
+				Type:        jsonschema.String,
+				Description: "The memory to forget, as it appears in your short-term memory.",
+			},
+		},
+		Required: []string{"memory"},
+	}
+}
+
+func (a *UpdateGoalAction) Run(ctx context.Context, sharedState *types.AgentSharedState, params types.ActionParams) (types.ActionResult, error) {
+	p := GoalParams{}
+	if err := params.Unmarshal(&p); err != nil {
+		return types.ActionResult{}, err
+	}
+
+	result, err := a.writer.UpdateGoal(ctx, p.Goal)
+	if err != nil {
+		return types.ActionResult{}, err
+	}
+	return types.ActionResult{Result: result}, nil
+}
+
+func (a *UpdateGoalAction) Plannable() bool {
+	return true
+}
+
+func (a *UpdateGoalAction) Definition() types.ActionDefinition {
+	return types.ActionDefinition{
+		Name:        UpdateGoalActionName,
+		Description: "Set your current goal, pursued across conversations and periodic runs. An empty goal clears it.",
+		Properties: map[string]jsonschema.Definition{
+			"goal": {
+				Type:        jsonschema.String,
+				Description: "The new goal, or an empty string when the goal is achieved.",
+			},
+		},
+		Required: []string{"goal"},
+	}
+}
@@ -0,0 +1,62 @@
+package action_test
+
+import (
+	"context"
+
+	. "github.com/mudler/LocalAGI/core/action"
+	"github.com/mudler/LocalAGI/core/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type fakeMemoryWriter struct {
+	memories []string
+	goal     string
+}
+
+func (w *fakeMemoryWriter) Remember(ctx context.Context, memory string) (string, error) {
+	w.memories = append(w.memories, memory)
+	return "Remembered: " + memory, nil
+}
+
+func (w *fakeMemoryWriter) Forget(ctx context.Context, memory string) (string, error) {
+	w.memories = nil
+	return "Forgot: " + memory, nil
+}
+
+func (w *fakeMemoryWriter) UpdateGoal(ctx context.Context, goal string) (string, error) {
+	w.goal = goal
+	return "Goal updated: " + goal, nil
+}
+
+var _ = Describe("Memory actions", func() {
+	var writer *fakeMemoryWriter
+
+	BeforeEach(func() {
+		writer = &fakeMemoryWriter{}
+	})
+
+	It("writes the memories through the writer", func() {
+		result, err := NewRemember(writer).Run(context.Background(), nil, types.ActionParams{"memory": "The user lives in Rome"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Result).To(Equal("Remembered: The user lives in Rome"))
+		Expect(writer.memories).To(Equal([]string{"The user lives in Rome"}))
+
+		_, err = NewForget(writer).Run(context.Background(), nil, types.ActionParams{"memory": "The user lives in Rome"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writer.memories).To(BeEmpty())
+	})
+
+	It("rejects empty memories", func() {
+		_, err := NewRemember(writer).Run(context.Background(), nil, types.ActionParams{"memory": "  "})
+		Expect(err).To(HaveOccurred())
+		Expect(writer.memories).To(BeEmpty())
+	})
+
+	It("clears the goal with an empty one", func() {
+		writer.goal = "Book a flight"
+		_, err := NewUpdateGoal(writer).Run(context.Background(), nil, types.ActionParams{"goal": ""})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writer.goal).To(BeEmpty())
+	})
+})
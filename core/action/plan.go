@@ -2,6 +2,8 @@ package action
 
 import (
 	"context"
+	"fmt"
+	"strconv"
 
 	"github.com/mudler/LocalAGI/core/types"
 	"github.com/sashabaranov/go-openai/jsonschema"
@@ -26,8 +28,91 @@ type PlanResult struct {
 	Goal     string        `json:"goal"`
 }
 type PlanSubtask struct {
-	Action    string `json:"action"`
-	Reasoning string `json:"reasoning"`
+	ID        string   `json:"id,omitempty"`
+	Action    string   `json:"action"`
+	Reasoning string   `json:"reasoning"`
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+// Waves orders the subtasks of the plan by their dependencies: the subtasks
+// of a wave only depend on subtasks of the previous waves, so they can run
+// concurrently. Dependencies on the completed subtasks, e.g. of a previous
+// version of the plan, are already satisfied.
+//
+// Subtasks without an id are numbered from 1. Plans that declare neither
+// ids nor dependencies run in sequence, one subtask per wave.
+func (p PlanResult) Waves(completed ...string) ([][]PlanSubtask, error) {
+	subtasks := make([]PlanSubtask, len(p.Subtasks))
+	copy(subtasks, p.Subtasks)
+
+	sequential := true
+	for _, s := range subtasks {
+		if s.ID != "" || len(s.DependsOn) > 0 {
+			sequential = false
+			break
+		}
+	}
+
+	done := map[string]bool{}
+	for _, id := range completed {
+		done[id] = true
+	}
+
+	byID := map[string]int{}
+	next := 1
+	for i := range subtasks {
+		for subtasks[i].ID == "" {
+			id := strconv.Itoa(next)
+			next++
+			if _, taken := byID[id]; !taken && !done[id] {
+				subtasks[i].ID = id
+			}
+		}
+		if sequential && i > 0 {
+			subtasks[i].DependsOn = []string{subtasks[i-1].ID}
+		}
+		if _, exists := byID[subtasks[i].ID]; exists || done[subtasks[i].ID] {
+			return nil, fmt.Errorf("duplicate subtask id %q", subtasks[i].ID)
+		}
+		byID[subtasks[i].ID] = i
+	}
+
+	for _, s := range subtasks {
+		for _, dep := range s.DependsOn {
+			if _, exists := byID[dep]; !exists && !done[dep] {
+				return nil, fmt.Errorf("subtask %q depends on unknown subtask %q", s.ID, dep)
+			}
+		}
+	}
+
+	waves := [][]PlanSubtask{}
+	for target := len(done) + len(subtasks); len(done) < target; {
+		wave := []PlanSubtask{}
+		for _, s := range subtasks {
+			if done[s.ID] {
+				continue
+			}
+			ready := true
+			for _, dep := range s.DependsOn {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				wave = append(wave, s)
+			}
+		}
+		if len(wave) == 0 {
+			return nil, fmt.Errorf("the dependencies of the subtasks form a cycle")
+		}
+		for _, s := range wave {
+			done[s.ID] = true
+		}
+		waves = append(waves, wave)
+	}
+
+	return waves, nil
 }
 
 func (a *PlanAction) Run(ctx context.Context, sharedState *types.AgentSharedState, params types.ActionParams) (types.ActionResult, error) {
@@ -41,7 +126,7 @@ func (a *PlanAction) Plannable() bool {
 func (a *PlanAction) Definition() types.ActionDefinition {
 	return types.ActionDefinition{
 		Name:        PlanActionName,
-		Description: "Use it for situations that involves doing more actions in sequence.",
+		Description: "Use it for situations that involves doing more actions. Subtasks that don't depend on each other run at the same time.",
 		Properties: map[string]jsonschema.Definition{
 			"subtasks": {
 				Type:        jsonschema.Array,
@@ -49,6 +134,10 @@ func (a *PlanAction) Definition() types.ActionDefinition {
 				Items: &jsonschema.Definition{
 					Type: jsonschema.Object,
 					Properties: map[string]jsonschema.Definition{
+						"id": {
+							Type:        jsonschema.String,
+							Description: "A unique identifier of the subtask",
+						},
 						"action": {
 							Type:        jsonschema.String,
 							Description: "The action to call",
@@ -58,7 +147,13 @@ func (a *PlanAction) Definition() types.ActionDefinition {
 							Type:        jsonschema.String,
 							Description: "The reasoning for calling this action",
 						},
+						"depends_on": {
+							Type:        jsonschema.Array,
+							Description: "The ids of the subtasks whose results this subtask needs",
+							Items:       &jsonschema.Definition{Type: jsonschema.String},
+						},
 					},
+					Required: []string{"id", "action", "reasoning"},
 				},
 			},
 			"goal": {
@@ -0,0 +1,65 @@
+package action_test
+
+import (
+	. "github.com/mudler/LocalAGI/core/action"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PlanResult", func() {
+	ids := func(waves [][]PlanSubtask) [][]string {
+		result := [][]string{}
+		for _, wave := range waves {
+			w := []string{}
+			for _, s := range wave {
+				w = append(w, s.ID)
+			}
+			result = append(result, w)
+		}
+		return result
+	}
+
+	It("groups independent subtasks in the same wave", func() {
+		waves, err := PlanResult{Subtasks: []PlanSubtask{
+			{ID: "paris", Action: "search"},
+			{ID: "boston", Action: "search"},
+			{ID: "compare", Action: "write", DependsOn: []string{"paris", "boston"}},
+			{ID: "email", Action: "send_email", DependsOn: []string{"compare"}},
+		}}.Waves()
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ids(waves)).To(Equal([][]string{{"paris", "boston"}, {"compare"}, {"email"}}))
+	})
+
+	It("runs plans without ids nor dependencies in sequence", func() {
+		waves, err := PlanResult{Subtasks: []PlanSubtask{
+			{Action: "search"},
+			{Action: "write"},
+		}}.Waves()
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ids(waves)).To(Equal([][]string{{"1"}, {"2"}}))
+	})
+
+	It("rejects unknown dependencies and cycles", func() {
+		_, err := PlanResult{Subtasks: []PlanSubtask{
+			{ID: "a", Action: "search", DependsOn: []string{"missing"}},
+		}}.Waves()
+		Expect(err).To(HaveOccurred())
+
+		_, err = PlanResult{Subtasks: []PlanSubtask{
+			{ID: "a", Action: "search", DependsOn: []string{"b"}},
+			{ID: "b", Action: "search", DependsOn: []string{"a"}},
+		}}.Waves()
+		Expect(err).To(MatchError(ContainSubstring("cycle")))
+	})
+	It("satisfies the dependencies on completed subtasks", func() {
+		waves, err := PlanResult{Subtasks: []PlanSubtask{
+			{ID: "retry", Action: "search", DependsOn: []string{"paris"}},
+			{Action: "write"},
+		}}.Waves("paris", "1")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ids(waves)).To(Equal([][]string{{"retry", "2"}}))
+	})
+})
@@ -0,0 +1,264 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/db"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/llm"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+)
+
+// Classes of the errors of the actions
+const (
+	ActionErrorTimeout       = "timeout"
+	ActionErrorRateLimited   = "rate_limited"
+	ActionErrorAuth          = "auth"
+	ActionErrorNotFound      = "not_found"
+	ActionErrorNetwork       = "network"
+	ActionErrorInvalidParams = "invalid_params"
+	ActionErrorCanceled      = "canceled"
+	ActionErrorOther         = "other"
+)
+
+const (
+	// actionHintsWindow is how far back the failures of the actions are
+	// reported in the HUD
+	actionHintsWindow = time.Hour
+	// actionHintsMinFailures is how many recent failures make an action worth
+	// a warning
+	actionHintsMinFailures = 3
+	// actionHintsRefresh is how long the warnings are cached
+	actionHintsRefresh = time.Minute
+)
+
+// ClassifyActionError returns the class of the error of an action
+func ClassifyActionError(err error) string {
+	if errors.Is(err, context.Canceled) {
+		return ActionErrorCanceled
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return ActionErrorTimeout
+	}
+	if llm.IsRateLimited(err) {
+		return ActionErrorRateLimited
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return ActionErrorNetwork
+	}
+
+	// most integrations only report the status of the API in the message
+	msg := strings.ToLower(err.Error())
+	for _, class := range []struct {
+		name     string
+		keywords []string
+	}{
+		{ActionErrorTimeout, []string{"timeout", "timed out", "deadline exceeded"}},
+		{ActionErrorRateLimited, []string{"rate limit", "too many requests", "429"}},
+		{ActionErrorAuth, []string{"unauthorized", "forbidden", "401", "403", "invalid_grant", "permission denied", "credentials"}},
+		{ActionErrorNotFound, []string{"not found", "404"}},
+		{ActionErrorNetwork, []string{"connection refused", "connection reset", "no such host", "eof"}},
+		{ActionErrorInvalidParams, []string{"invalid", "required", "missing", "unmarshal"}},
+	} {
+		for _, keyword := range class.keywords {
+			if strings.Contains(msg, keyword) {
+				return class.name
+			}
+		}
+	}
+	return ActionErrorOther
+}
+
+// HashActionParams returns a digest of the parameters of an action call
+func HashActionParams(params types.ActionParams) string {
+	// maps are marshalled with sorted keys
+	b, err := json.Marshal(params)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordActionExecution persists the outcome of an action run by the agent
+func (a *Agent) recordActionExecution(name string, params types.ActionParams, duration time.Duration, runErr error) {
+	if !a.persisted() {
+		return
+	}
+
+	agentID := a.options.agentID
+	execution := models.ActionExecution{
+		ID:         uuid.New(),
+		UserID:     a.options.userID,
+		AgentID:    &agentID,
+		ActionName: name,
+		Status:     "success",
+		DurationMs: duration.Milliseconds(),
+		ParamsHash: HashActionParams(params),
+	}
+	if runErr != nil {
+		execution.Status = "error"
+		execution.ErrorClass = ClassifyActionError(runErr)
+		execution.Error = runErr.Error()
+
+		// the warnings of the HUD are outdated
+		a.actionHintsMutex.Lock()
+		a.actionHintsAt = time.Time{}
+		a.actionHintsMutex.Unlock()
+	}
+
+	if err := db.DB.Create(&execution).Error; err != nil {
+		xlog.Error("Failed to record action execution", "error", err, "agent", a.Character.Name, "action", name)
+	}
+}
+
+// ActionStats is the reliability of an action of an agent
+type ActionStats struct {
+	Action        string  `json:"action"`
+	Executions    int64   `json:"executions"`
+	Failures      int64   `json:"failures"`
+	SuccessRate   float64 `json:"success_rate"`
+	AvgDurationMs float64 `json:"avg_duration_ms"`
+	// ErrorClasses counts the failures by class
+	ErrorClasses   map[string]int64 `json:"error_classes,omitempty"`
+	LastError      string           `json:"last_error,omitempty"`
+	LastErrorClass string           `json:"last_error_class,omitempty"`
+	LastFailureAt  *time.Time       `json:"last_failure_at,omitempty"`
+}
+
+// LoadActionStats returns the reliability of the actions run by an agent
+// since the given time, the least reliable first
+func LoadActionStats(agentID uuid.UUID, since time.Time) ([]ActionStats, error) {
+	var rows []struct {
+		ActionName    string
+		Executions    int64
+		Failures      int64
+		AvgDurationMs float64
+	}
+	if err := db.DB.Model(&models.ActionExecution{}).
+		Select("ActionName, COUNT(*) AS Executions, SUM(CASE WHEN Status = 'error' THEN 1 ELSE 0 END) AS Failures, AVG(DurationMs) AS AvgDurationMs").
+		Where("AgentID = ? AND CreatedAt >= ?", agentID, since).
+		Group("ActionName").
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load action executions: %w", err)
+	}
+
+	var classes []struct {
+		ActionName string
+		ErrorClass string
+		Failures   int64
+	}
+	if err := db.DB.Model(&models.ActionExecution{}).
+		Select("ActionName, ErrorClass, COUNT(*) AS Failures").
+		Where("AgentID = ? AND CreatedAt >= ? AND Status = ?", agentID, since, "error").
+		Group("ActionName, ErrorClass").
+		Scan(&classes).Error; err != nil {
+		return nil, fmt.Errorf("failed to load action errors: %w", err)
+	}
+
+	byAction := map[string]*ActionStats{}
+	stats := make([]ActionStats, len(rows))
+	for i, row := range rows {
+		stats[i] = ActionStats{
+			Action:        row.ActionName,
+			Executions:    row.Executions,
+			Failures:      row.Failures,
+			SuccessRate:   float64(row.Executions-row.Failures) / float64(row.Executions),
+			AvgDurationMs: row.AvgDurationMs,
+		}
+		byAction[row.ActionName] = &stats[i]
+	}
+
+	for _, class := range classes {
+		s, ok := byAction[class.ActionName]
+		if !ok {
+			continue
+		}
+		if s.ErrorClasses == nil {
+			s.ErrorClasses = map[string]int64{}
+		}
+		s.ErrorClasses[class.ErrorClass] = class.Failures
+	}
+
+	for i := range stats {
+		if stats[i].Failures == 0 {
+			continue
+		}
+		var last models.ActionExecution
+		if err := db.DB.Where("AgentID = ? AND ActionName = ? AND Status = ?", agentID, stats[i].Action, "error").
+			Order("CreatedAt DESC").
+			First(&last).Error; err != nil {
+			continue
+		}
+		stats[i].LastError = last.Error
+		stats[i].LastErrorClass = last.ErrorClass
+		stats[i].LastFailureAt = &last.CreatedAt
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].SuccessRate != stats[j].SuccessRate {
+			return stats[i].SuccessRate < stats[j].SuccessRate
+		}
+		return stats[i].Action < stats[j].Action
+	})
+	return stats, nil
+}
+
+// actionHints returns the warnings about the actions that failed repeatedly
+// in the last hour, shown in the HUD so the agent stops calling them
+func (a *Agent) actionHints() []string {
+	if !a.options.actionReliabilityHints || !a.persisted() {
+		return nil
+	}
+
+	a.actionHintsMutex.Lock()
+	defer a.actionHintsMutex.Unlock()
+
+	if time.Since(a.actionHintsAt) < actionHintsRefresh {
+		return a.actionHintsCache
+	}
+
+	stats, err := LoadActionStats(a.options.agentID, time.Now().Add(-actionHintsWindow))
+	if err != nil {
+		xlog.Warn("Failed to load action stats", "error", err, "agent", a.Character.Name)
+		return a.actionHintsCache
+	}
+
+	hints := []string{}
+	for _, s := range stats {
+		if s.Failures < actionHintsMinFailures || s.SuccessRate >= 0.5 {
+			continue
+		}
+		hint := fmt.Sprintf("%s failed %d times out of %d in the last hour", s.Action, s.Failures, s.Executions)
+		if s.LastErrorClass != "" {
+			hint += fmt.Sprintf(" (last error: %s: %s)", s.LastErrorClass, truncateHint(s.LastError))
+		}
+		hints = append(hints, hint)
+	}
+
+	a.actionHintsCache = hints
+	a.actionHintsAt = time.Now()
+	return hints
+}
+
+func truncateHint(s string) string {
+	const maxLength = 200
+	if len(s) <= maxLength {
+		return s
+	}
+	return s[:maxLength] + "..."
+}
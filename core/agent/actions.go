@@ -3,8 +3,11 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -13,6 +16,7 @@ import (
 	"github.com/mudler/LocalAGI/db"
 	models "github.com/mudler/LocalAGI/dbmodels"
 
+	"github.com/mudler/LocalAGI/pkg/llm"
 	"github.com/mudler/LocalAGI/pkg/utils"
 	"github.com/mudler/LocalAGI/pkg/xlog"
 
@@ -51,9 +55,10 @@ type decisionResult struct {
 	actionName   string
 }
 
-// decision forces the agent to take one of the available actions
+// decision forces the agent to take one of the available actions, asking
+// the model of the given phase
 func (a *Agent) decision(
-	job *types.Job,
+	job *types.Job, phase string,
 	conversation []openai.ChatCompletionMessage,
 	tools []openai.Tool, toolchoice string, maxRetries int) (*decisionResult, error) {
 
@@ -123,8 +128,11 @@ Choose the most appropriate single tool call to fulfill the user's complete requ
 		}
 	}
 
+	model := a.options.modelFor(phase)
+	enhancedConversation = a.fitToContext(job.GetContext(), model, enhancedConversation, tools)
+
 	decision := openai.ChatCompletionRequest{
-		Model:             a.options.LLMAPI.Model,
+		Model:             model,
 		Messages:          enhancedConversation,
 		Tools:             tools,
 		ParallelToolCalls: false,
@@ -150,7 +158,7 @@ Choose the most appropriate single tool call to fulfill the user's complete requ
 	for attempts := 0; attempts < maxRetries; attempts++ {
 		// If toolChoice is "reply" and we have a stream callback, use streaming
 		if (toolchoice == "" && len(tools) == 0) && !a.options.forceReasoning && job.StreamCallback != nil {
-			msg, err := a.askLLMStream(job.GetContext(), enhancedConversation, job.StreamCallback, maxRetries)
+			msg, err := a.askLLMStream(job.GetContext(), PhaseReply, enhancedConversation, job.StreamCallback)
 			if err != nil {
 				lastErr = err
 				xlog.Warn("Attempt to make a streaming decision failed", "attempt", attempts+1, "error", err)
@@ -214,18 +222,17 @@ Choose the most appropriate single tool call to fulfill the user's complete requ
 				ID:               uuid.New(),
 				UserID:           a.options.userID,
 				AgentID:          a.options.agentID,
-				Model:            a.options.LLMAPI.Model,
+				Model:            llm.ServedModel(resp, decision.Model),
+				Provider:         llm.ServedProvider(resp.Header()),
 				PromptTokens:     usage.PromptTokens,
 				CompletionTokens: usage.CompletionTokens,
 				TotalTokens:      usage.TotalTokens,
 				Cost:             usage.Cost,
-				RequestType:      "chat",
+				RequestType:      phase,
 				GenID:            resp.ID,
 				CreatedAt:        time.Now(),
 			}
-			if err := db.DB.Create(llmUsage).Error; err != nil {
-				xlog.Error("Error tracking LLM usage", "error", err)
-			}
+			llm.TrackUsage(job.GetContext(), llmUsage)
 		}
 
 		jsonResp, _ := json.Marshal(resp)
@@ -453,12 +460,11 @@ func (a *Agent) generateParameters(job *types.Job, pickTemplate string, act type
 			a.Character.Name)
 
 		// Get initial reasoning about parameters using askLLM
-		paramReasoningMsg, err := a.askLLM(job.GetContext(),
+		paramReasoningMsg, err := a.askLLM(job.GetContext(), PhaseParams,
 			append(conversation, openai.ChatCompletionMessage{
 				Role:    "system",
 				Content: parameterReasoningPrompt,
 			}),
-			maxAttempts,
 		)
 		if err != nil {
 			xlog.Warn("Failed to get parameter reasoning", "error", err)
@@ -480,7 +486,7 @@ func (a *Agent) generateParameters(job *types.Job, pickTemplate string, act type
 	var attemptErr error
 
 	for attempts := 0; attempts < maxAttempts; attempts++ {
-		result, attemptErr = a.decision(job,
+		result, attemptErr = a.decision(job, PhaseParams,
 			cc,
 			a.availableActions().ToTools(),
 			act.Definition().Name.String(),
@@ -498,8 +504,8 @@ func (a *Agent) generateParameters(job *types.Job, pickTemplate string, act type
 // Helper function to format properties for the prompt
 func formatProperties(props map[string]jsonschema.Definition) string {
 	var result strings.Builder
-	for name, prop := range props {
-		result.WriteString(fmt.Sprintf("- %s: %s\n", name, prop.Description))
+	for _, name := range slices.Sorted(maps.Keys(props)) {
+		result.WriteString(fmt.Sprintf("- %s: %s\n", name, props[name].Description))
 	}
 	return result.String()
 }
@@ -533,81 +539,14 @@ func (a *Agent) handlePlanning(ctx context.Context, job *types.Job, chosenAction
 
 	xlog.Info("[Planning] starts", "agent", a.Character.Name, "goal", planResult.Goal)
 	for _, s := range planResult.Subtasks {
-		xlog.Info("[Planning] subtask", "agent", a.Character.Name, "action", s.Action, "reasoning", s.Reasoning)
+		xlog.Info("[Planning] subtask", "agent", a.Character.Name, "id", s.ID, "action", s.Action, "depends_on", s.DependsOn, "reasoning", s.Reasoning)
 	}
 
 	if len(planResult.Subtasks) == 0 {
 		return conv, fmt.Errorf("no subtasks")
 	}
 
-	// Execute all subtasks in sequence
-	for _, subtask := range planResult.Subtasks {
-		xlog.Info("[subtask] Generating parameters",
-			"agent", a.Character.Name,
-			"action", subtask.Action,
-			"reasoning", reasoning,
-		)
-
-		subTaskAction := a.availableActions().Find(subtask.Action)
-		if subTaskAction == nil {
-			xlog.Error("Action not found: %s", subtask.Action)
-			return conv, fmt.Errorf("action %s not found", subtask.Action)
-		}
-
-		subTaskReasoning := fmt.Sprintf("%s Overall goal is: %s", subtask.Reasoning, planResult.Goal)
-
-		params, err := a.generateParameters(job, pickTemplate, subTaskAction, conv, subTaskReasoning, maxRetries)
-		if err != nil {
-			xlog.Error("error generating action's parameters", "error", err)
-			return conv, fmt.Errorf("error generating action's parameters: %w", err)
-
-		}
-		actionParams = params.actionParams
-
-		if !job.Callback(types.ActionCurrentState{
-			Job:       job,
-			Action:    subTaskAction,
-			Params:    actionParams,
-			Reasoning: subTaskReasoning,
-		}) {
-			job.Result.SetResult(types.ActionState{
-				ActionCurrentState: types.ActionCurrentState{
-					Job:       job,
-					Action:    chosenAction,
-					Params:    actionParams,
-					Reasoning: subTaskReasoning,
-				},
-				ActionResult: types.ActionResult{
-					Result: "stopped by callback",
-				},
-			})
-			job.Result.Conversation = conv
-			job.Result.Finish(nil)
-			break
-		}
-
-		result, err := a.runAction(job, subTaskAction, actionParams)
-		if err != nil {
-			xlog.Error("error running action", "error", err)
-			return conv, fmt.Errorf("error running action: %w", err)
-		}
-
-		stateResult := types.ActionState{
-			ActionCurrentState: types.ActionCurrentState{
-				Job:       job,
-				Action:    subTaskAction,
-				Params:    actionParams,
-				Reasoning: subTaskReasoning,
-			},
-			ActionResult: result,
-		}
-		job.Result.SetResult(stateResult)
-		job.CallbackWithResult(stateResult)
-		xlog.Debug("[subtask] Action executed", "agent", a.Character.Name, "action", subTaskAction.Definition().Name, "result", result)
-		conv = a.addFunctionResultToConversation(subTaskAction, actionParams, result, conv)
-	}
-
-	return conv, nil
+	return a.executePlan(job, planResult, actionParams, pickTemplate, conv)
 }
 
 // getAvailableActionsForJob returns available actions including user-defined ones for a specific job
@@ -645,6 +584,9 @@ func (a *Agent) availableActions() types.Actions {
 	}
 
 	defaultActions := append(a.mcpActions, a.options.userActions...)
+	if a.options.memoryActions {
+		defaultActions = append(slices.Clip(defaultActions), action.NewRemember(a), action.NewForget(a), action.NewUpdateGoal(a))
+	}
 
 	if a.options.initiateConversations && a.selfEvaluationInProgress { // && self-evaluation..
 		acts := append(defaultActions, action.NewConversation())
@@ -679,21 +621,54 @@ func (a *Agent) prepareHUD() (promptHUD *PromptHUD) {
 	}
 
 	return &PromptHUD{
-		Character:     a.Character,
-		CurrentState:  *a.currentState,
-		PermanentGoal: a.options.permanentGoal,
-		ShowCharacter: a.options.showCharacter,
+		Character:      a.Character,
+		CurrentState:   a.State(),
+		PermanentGoal:  a.options.permanentGoal,
+		ShowCharacter:  a.options.showCharacter,
+		ActionWarnings: a.actionHints(),
 	}
 }
 
 // pickAction picks an action based on the conversation
 func (a *Agent) pickAction(job *types.Job, templ string, messages []openai.ChatCompletionMessage, maxRetries int) (types.Action, types.ActionParams, string, error) {
-	c := messages
-
 	xlog.Debug("[pickAction] picking action starts", "messages", messages)
 
 	// Get available actions including user-defined ones
 	availableActions := a.getAvailableActionsForJob(job)
+	offeredActions := a.retrieveActions(job, messages, availableActions)
+
+	chosenAction, params, reasoning, err := a.pickActionFrom(job, templ, messages, offeredActions, availableActions, maxRetries)
+	if errors.Is(err, errUnavailableTool) {
+		xlog.Info("Model asked for a tool which wasn't retrieved, offering every action", "agent", a.Character.Name)
+		return a.pickActionFrom(job, templ, messages, availableActions, availableActions, maxRetries)
+	}
+	return chosenAction, params, reasoning, err
+}
+
+// pickActionFrom picks one of the offered actions. The model may still pick
+// any of the available actions, it fails with errUnavailableTool if it asks
+// for a tool that doesn't exist while not every action was offered.
+func (a *Agent) pickActionFrom(job *types.Job, templ string, messages []openai.ChatCompletionMessage, offeredActions, availableActions types.Actions, maxRetries int) (types.Action, types.ActionParams, string, error) {
+	c := messages
+	narrowed := len(offeredActions) < len(availableActions)
+
+	if a.useNativeToolCalls() {
+		requests, message, err := a.pickNativeToolCalls(job, messages, offeredActions, availableActions, maxRetries)
+		if errors.Is(err, errUnavailableTool) {
+			return nil, nil, "", err
+		}
+		if err == nil {
+			if len(requests) == 0 {
+				xlog.Debug("[pickAction] no native tool calls, replying")
+				return nil, nil, message, nil
+			}
+
+			// Additional tool calls of the same turn run after the first one
+			job.QueueActions(requests[1:]...)
+			return requests[0].Action, *requests[0].Params, message, nil
+		}
+		xlog.Warn("Native tool calling failed, falling back to template-based selection", "agent", a.Character.Name, "error", err)
+	}
 
 	// Identify the goal of this conversation
 
@@ -701,9 +676,9 @@ func (a *Agent) pickAction(job *types.Job, templ string, messages []openai.ChatC
 		xlog.Debug("not forcing reasoning", "forceReasoning", a.options.forceReasoning, "ToolChoice", job.ToolChoice)
 		// We also could avoid to use functions here and get just a reply from the LLM
 		// and then use the reply to get the action
-		thought, err := a.decision(job,
+		thought, err := a.decision(job, PhasePick,
 			messages,
-			availableActions.ToTools(),
+			offeredActions.ToTools(),
 			job.ToolChoice,
 			maxRetries)
 		if err != nil {
@@ -715,6 +690,9 @@ func (a *Agent) pickAction(job *types.Job, templ string, messages []openai.ChatC
 
 		// Find the action
 		chosenAction := availableActions.Find(thought.actionName)
+		if chosenAction == nil && thought.actionName != "" && narrowed {
+			return nil, nil, "", errUnavailableTool
+		}
 		if chosenAction == nil || thought.actionName == "" {
 			xlog.Debug("no answer")
 
@@ -729,7 +707,11 @@ func (a *Agent) pickAction(job *types.Job, templ string, messages []openai.ChatC
 	// Force the LLM to think and we extract a "reasoning" to pick a specific action and with which parameters
 	xlog.Debug("[pickAction] forcing reasoning")
 
-	prompt, err := renderTemplate(templ, a.prepareHUD(), a.availableActions(), "")
+	// only the definitions of the offered actions are in the prompt, any
+	// action can still be picked by name
+	promptActions := keepOffered(a.availableActions(), offeredActions)
+
+	prompt, err := renderTemplate(templ, a.prepareHUD(), promptActions, "")
 	if err != nil {
 		return nil, nil, "", err
 	}
@@ -748,12 +730,13 @@ func (a *Agent) pickAction(job *types.Job, templ string, messages []openai.ChatC
 	reasoningPrompt := "Analyze the current situation and determine the best course of action. Consider the following:\n\n"
 	reasoningPrompt += "CRITICAL: When the user requests an action to be performed, execute it immediately without asking for permission.\n\n"
 	reasoningPrompt += "Available Actions:\n"
-	for _, act := range a.availableActions() {
+	for _, act := range promptActions {
 		reasoningPrompt += fmt.Sprintf("- %s: %s\n", act.Definition().Name, act.Definition().Description)
 		if len(act.Definition().Properties) > 0 {
 			reasoningPrompt += "  Properties:\n"
-			for name, prop := range act.Definition().Properties {
-				reasoningPrompt += fmt.Sprintf("  - %s: %s\n", name, prop.Description)
+			properties := act.Definition().Properties
+			for _, name := range slices.Sorted(maps.Keys(properties)) {
+				reasoningPrompt += fmt.Sprintf("  - %s: %s\n", name, properties[name].Description)
 			}
 		}
 		reasoningPrompt += "\n"
@@ -761,12 +744,12 @@ func (a *Agent) pickAction(job *types.Job, templ string, messages []openai.ChatC
 	reasoningPrompt += "\nProvide a detailed reasoning about what action would be most appropriate in this situation and why. If the user has requested a specific action (like sending an email, searching, etc.), execute that action directly. You can also just reply with a simple message by choosing the 'reply' or 'answer' action only when no specific action is needed."
 
 	// Get reasoning using askLLM
-	reasoningMsg, err := a.askLLM(job.GetContext(),
+	reasoningMsg, err := a.askLLM(job.GetContext(), PhasePick,
 		append(c, openai.ChatCompletionMessage{
 			Role:    "system",
 			Content: reasoningPrompt,
 		}),
-		maxRetries)
+	)
 	if err != nil {
 		return nil, nil, "", fmt.Errorf("failed to get reasoning: %w", err)
 	}
@@ -788,7 +771,7 @@ func (a *Agent) pickAction(job *types.Job, templ string, messages []openai.ChatC
 	// to avoid hallucinations
 
 	// Extract an action
-	params, err := a.decision(job,
+	params, err := a.decision(job, PhasePick,
 		append(c, openai.ChatCompletionMessage{
 			Role:    "system",
 			Content: "Pick the relevant action given the following reasoning: " + originalReasoning,
@@ -821,20 +804,20 @@ func (a *Agent) pickAction(job *types.Job, templ string, messages []openai.ChatC
 		var err error
 
 		if job.StreamCallback != nil && !a.options.forceReasoning {
-			replyMsg, err = a.askLLMStream(job.GetContext(),
+			replyMsg, err = a.askLLMStream(job.GetContext(), PhaseReply,
 				append(c, openai.ChatCompletionMessage{
 					Role:    "system",
 					Content: replyPrompt,
 				}),
 				job.StreamCallback,
-				maxRetries)
+			)
 		} else {
-			replyMsg, err = a.askLLM(job.GetContext(),
+			replyMsg, err = a.askLLM(job.GetContext(), PhaseReply,
 				append(c, openai.ChatCompletionMessage{
 					Role:    "system",
 					Content: replyPrompt,
 				}),
-				maxRetries)
+			)
 		}
 
 		if err != nil {
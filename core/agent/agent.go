@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mudler/LocalAGI/pkg/utils"
@@ -20,6 +21,7 @@ import (
 	"github.com/mudler/LocalAGI/db"
 	models "github.com/mudler/LocalAGI/dbmodels"
 	"github.com/mudler/LocalAGI/pkg/llm"
+	"github.com/mudler/LocalAGI/pkg/vectorstore"
 	"github.com/robfig/cron/v3"
 	"github.com/sashabaranov/go-openai"
 )
@@ -35,14 +37,18 @@ type Agent struct {
 	sync.Mutex
 	options   *options
 	Character Character
-	client    *openai.Client
-	jobQueue  chan *types.Job
+	client    llm.Provider
+	embedder  llm.Embedder
+	jobQueue  *jobQueue
 	context   *types.ActionContext
 
 	currentState *types.AgentInternalState
+	stateMutex   sync.Mutex
 
 	selfEvaluationInProgress bool
 	pause                    bool
+	// parkedJobs are the jobs received while paused, see PauseModePark
+	parkedJobs []*parkedJob
 
 	newConversations chan openai.ChatCompletionMessage
 
@@ -54,6 +60,31 @@ type Agent struct {
 	observer Observer
 
 	sharedState *types.AgentSharedState
+
+	checkpointMutex  sync.Mutex
+	checkpointedJobs map[string]struct{}
+
+	jobsMutex  sync.Mutex
+	activeJobs map[string]*activeJob
+
+	// toolIndex caches the embeddings of the actions, see WithToolRetrieval
+	toolIndexMutex sync.Mutex
+	toolIndex      map[string]toolEmbedding
+
+	// actionHintsCache caches the warnings about the failing actions, see
+	// EnableActionReliabilityHints
+	actionHintsMutex sync.Mutex
+	actionHintsCache []string
+	actionHintsAt    time.Time
+
+	// memoryIndexing guards the indexing of the semantic memory, see
+	// WithSemanticMemory
+	memoryIndexing   atomic.Bool
+	memoryIndexCheck sync.Once
+
+	// set when the model rejected a native tool calling request, the time
+	// in unix nanoseconds until which the tools aren't sent
+	nativeToolCallsDisabledUntil atomic.Int64
 }
 
 type RAGDB interface {
@@ -61,6 +92,34 @@ type RAGDB interface {
 	Reset() error
 	Search(s string, similarEntries int) ([]string, error)
 	Count() int
+
+	// StoreDocuments stores documents with their ID and metadata, see the
+	// vectorstore.Metadata keys. Documents with the ID of a stored one
+	// replace it.
+	StoreDocuments(ctx context.Context, documents []vectorstore.Document) error
+	// SearchDocuments returns the documents most similar to the query among
+	// the ones matching the filter, a nil filter matches all of them
+	SearchDocuments(ctx context.Context, query string, similarEntries int, filter vectorstore.Filter) ([]vectorstore.Result, error)
+	// DeleteDocuments removes documents by ID
+	DeleteDocuments(ctx context.Context, documents []vectorstore.Document) error
+	// DeleteWhere removes the documents matching the filter and returns how
+	// many were removed, the stores which can't list their documents return
+	// errors.ErrUnsupported
+	DeleteWhere(ctx context.Context, filter vectorstore.Filter) (int, error)
+}
+
+var (
+	_ RAGDB = &vectorstore.ChromemDB{}
+	_ RAGDB = &vectorstore.LocalAIRAGDB{}
+)
+
+// RAGDBEntries is implemented by the RAGDB which can list and delete the
+// entries of their collection
+type RAGDBEntries interface {
+	Entries() ([]string, error)
+	RemoveEntry(entry string) error
+	// RemoveContent deletes the entries stored from the string
+	RemoveContent(s string) error
 }
 
 func New(opts ...Option) (*Agent, error) {
@@ -69,7 +128,7 @@ func New(opts ...Option) (*Agent, error) {
 		return nil, fmt.Errorf("failed to set options: %v", err)
 	}
 
-	client := llm.NewClient(options.LLMAPI.APIKey, options.LLMAPI.APIURL, options.timeout)
+	client := newLLMChain(options)
 
 	c := context.Background()
 	if options.context != nil {
@@ -78,15 +137,19 @@ func New(opts ...Option) (*Agent, error) {
 
 	ctx, cancel := context.WithCancel(c)
 	a := &Agent{
-		jobQueue:               make(chan *types.Job),
+		jobQueue:               newJobQueue(options.jobQueueSize),
 		options:                options,
 		client:                 client,
+		embedder:               llm.NewClientWithTransport(options.LLMAPI.APIKey, options.LLMAPI.APIURL, options.timeout, options.llmTransport),
 		Character:              options.character,
 		currentState:           &types.AgentInternalState{},
 		context:                types.NewActionContext(ctx, cancel),
 		newConversations:       make(chan openai.ChatCompletionMessage),
 		newMessagesSubscribers: options.newConversationsSubscribers,
 		sharedState:            types.NewAgentSharedStateWithIDs(options.lastMessageDuration, options.userID, options.agentID),
+		checkpointedJobs:       make(map[string]struct{}),
+		activeJobs:             make(map[string]*activeJob),
+		toolIndex:              make(map[string]toolEmbedding),
 	}
 
 	// Initialize observer if provided
@@ -129,6 +192,10 @@ func (a *Agent) SharedState() *types.AgentSharedState {
 
 // storeErrorMessage stores an error message as an AgentMessage record in the database
 func (a *Agent) storeErrorMessage(errorMsg string) {
+	if !a.persisted() {
+		return
+	}
+
 	agentMessage := models.AgentMessage{
 		ID:        uuid.New(),
 		AgentID:   a.options.agentID,
@@ -201,6 +268,12 @@ func (a *Agent) Ask(opts ...types.JobOption) *types.JobResult {
 		xlog.Debug("Agent has finished being asked", "agent", a.Character.Name)
 	}()
 
+	return a.Submit(opts...).WaitResult()
+}
+
+// Submit queues a job like Ask, without waiting for its result. Once it
+// returns the job is registered, so it can be stopped with CancelJob.
+func (a *Agent) Submit(opts ...types.JobOption) *types.JobResult {
 	if a.observer != nil {
 		obs := a.observer.NewObservable()
 		obs.Name = "job"
@@ -209,7 +282,7 @@ func (a *Agent) Ask(opts ...types.JobOption) *types.JobResult {
 		opts = append(opts, types.WithObservable(obs))
 	}
 
-	return a.Execute(types.NewJob(
+	return a.submit(types.NewJob(
 		append(
 			opts,
 			types.WithReasoningCallback(a.options.reasoningCallback),
@@ -226,6 +299,11 @@ func (a *Agent) Execute(j *types.Job) *types.JobResult {
 		xlog.Debug("Agent has finished", "agent", a.Character.Name)
 	}()
 
+	return a.submit(j).WaitResult()
+}
+
+// submit queues a job, recording its outcome on its observable
+func (a *Agent) submit(j *types.Job) *types.JobResult {
 	if j.Obs != nil {
 		if len(j.ConversationHistory) > 0 {
 			m := j.ConversationHistory[len(j.ConversationHistory)-1]
@@ -233,82 +311,91 @@ func (a *Agent) Execute(j *types.Job) *types.JobResult {
 			a.observer.Update(*j.Obs)
 		}
 
-		j.Result.AddFinalizer(func(ccm []openai.ChatCompletionMessage) {
-			j.Obs.Completion = &types.Completion{
-				Conversation: ccm,
-			}
-
-			if j.Result.Error != nil {
-				j.Obs.Completion.Error = j.Result.Error.Error()
-			}
-
-			a.observer.Update(*j.Obs)
-		})
+		a.observeCompletion(j)
 	}
 
 	a.Enqueue(j)
-	return j.Result.WaitResult()
+	return j.Result
 }
 
+// observeCompletion records the outcome of the job on its observable once
+// the job is finished
+func (a *Agent) observeCompletion(j *types.Job) {
+	j.Result.AddFinalizer(func(ccm []openai.ChatCompletionMessage) {
+		j.Obs.Completion = &types.Completion{
+			Conversation: ccm,
+		}
+
+		if j.Result.Error != nil {
+			j.Obs.Completion.Error = j.Result.Error.Error()
+		}
+
+		a.observer.Update(*j.Obs)
+	})
+}
+
+// Enqueue queues a job to be run by the agent. The job fails right away
+// with ErrJobQueueFull when the backlog of the agent is full.
 func (a *Agent) Enqueue(j *types.Job) {
 	j.ReasoningCallback = a.options.reasoningCallback
 	j.ResultCallback = a.options.resultCallback
 
-	a.jobQueue <- j
+	a.enqueue(j, UserRole)
 }
 
-func (a *Agent) askLLM(ctx context.Context, conversation []openai.ChatCompletionMessage, maxRetries int) (openai.ChatCompletionMessage, error) {
-	var resp openai.ChatCompletionResponse
-	var err error
-
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		resp, err = a.client.CreateChatCompletion(ctx,
-			openai.ChatCompletionRequest{
-				Model:    a.options.LLMAPI.Model,
-				Messages: conversation,
-			},
-		)
-		// prettyJSON, errr := json.MarshalIndent(resp, "", "  ")
-		// if errr != nil {
-		// 	fmt.Println("Error pretty printing response", "error", err)
-		// } else {
-		// 	fmt.Println("LLM Response", "response", string(prettyJSON))
-		// }
-		if err == nil && len(resp.Choices) == 1 && (resp.Choices[0].Message.Content != "" || len(resp.Choices[0].Message.ToolCalls) > 0) {
-			// Track usage after successful API call
-			usage := utils.GetOpenRouterUsage(resp.ID)
-			llmUsage := &models.LLMUsage{
-				ID:               uuid.New(),
-				UserID:           a.options.userID,
-				AgentID:          a.options.agentID,
-				Model:            a.options.LLMAPI.Model,
-				PromptTokens:     usage.PromptTokens,
-				CompletionTokens: usage.CompletionTokens,
-				TotalTokens:      usage.TotalTokens,
-				Cost:             usage.Cost,
-				RequestType:      "chat",
-				GenID:            resp.ID,
-				CreatedAt:        time.Now(),
-			}
-			if err := db.DB.Create(llmUsage).Error; err != nil {
-				xlog.Error("Error tracking LLM usage", "error", err)
-			}
-			break
-		}
-		xlog.Warn("Error asking LLM, retrying", "attempt", attempt+1, "error", err)
-		if attempt < maxRetries {
-			time.Sleep(2 * time.Second) // Optional: Add a delay between retries
-		}
+func (a *Agent) enqueue(j *types.Job, role string) {
+	if role != SystemRole && a.retriesEnabled() && !j.Result.HasRetryHook() {
+		a.retryOnFailure(j, role, 1)
 	}
+	a.registerJob(j)
+	if err := a.jobQueue.push(j, role); err != nil {
+		xlog.Warn("Could not queue job", "agent", a.Character.Name, "job", j.UUID, "error", err)
+		j.Result.Finish(err)
+	}
+}
+
+// QueueStats returns the state of the job queue of the agent
+func (a *Agent) QueueStats() QueueStats {
+	return a.jobQueue.stats()
+}
 
+// askLLM asks the model of the given phase to complete the conversation
+func (a *Agent) askLLM(ctx context.Context, phase string, conversation []openai.ChatCompletionMessage) (openai.ChatCompletionMessage, error) {
+	model := a.options.modelFor(phase)
+	conversation = a.fitToContext(ctx, model, conversation, nil)
+
+	resp, err := a.client.CreateChatCompletion(ctx,
+		openai.ChatCompletionRequest{
+			Model:    model,
+			Messages: conversation,
+		},
+	)
 	if err != nil {
 		// Store the error message in the database before returning
 		a.storeErrorMessage(err.Error())
 		return openai.ChatCompletionMessage{}, err
 	}
 
+	// Track usage after successful API call
+	usage := utils.GetOpenRouterUsage(resp.ID)
+	llmUsage := &models.LLMUsage{
+		ID:               uuid.New(),
+		UserID:           a.options.userID,
+		AgentID:          a.options.agentID,
+		Model:            llm.ServedModel(resp, model),
+		Provider:         llm.ServedProvider(resp.Header()),
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+		Cost:             usage.Cost,
+		RequestType:      phase,
+		GenID:            resp.ID,
+		CreatedAt:        time.Now(),
+	}
+	llm.TrackUsage(ctx, llmUsage)
+
 	if len(resp.Choices) != 1 {
-		notEnoughChoicesErr := fmt.Errorf("not enough choices: %w", err)
+		notEnoughChoicesErr := fmt.Errorf("not enough choices: %d", len(resp.Choices))
 		a.storeErrorMessage(notEnoughChoicesErr.Error())
 		return openai.ChatCompletionMessage{}, notEnoughChoicesErr
 	}
@@ -317,27 +404,17 @@ func (a *Agent) askLLM(ctx context.Context, conversation []openai.ChatCompletion
 }
 
 // askLLMStream creates a streaming chat completion and sends chunks via the callback
-func (a *Agent) askLLMStream(ctx context.Context, conversation []openai.ChatCompletionMessage, streamCallback func(string), maxRetries int) (openai.ChatCompletionMessage, error) {
-	var resp *openai.ChatCompletionStream
-	var err error
-
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		resp, err = a.client.CreateChatCompletionStream(ctx,
-			openai.ChatCompletionRequest{
-				Model:    a.options.LLMAPI.Model,
-				Messages: conversation,
-				Stream:   true,
-			},
-		)
-		if err == nil {
-			break
-		}
-		xlog.Warn("Error creating LLM stream, retrying", "attempt", attempt+1, "error", err)
-		if attempt < maxRetries {
-			time.Sleep(2 * time.Second)
-		}
-	}
+func (a *Agent) askLLMStream(ctx context.Context, phase string, conversation []openai.ChatCompletionMessage, streamCallback func(string)) (openai.ChatCompletionMessage, error) {
+	model := a.options.modelFor(phase)
+	conversation = a.fitToContext(ctx, model, conversation, nil)
 
+	resp, err := a.client.CreateChatCompletionStream(ctx,
+		openai.ChatCompletionRequest{
+			Model:    model,
+			Messages: conversation,
+			Stream:   true,
+		},
+	)
 	if err != nil {
 		// Store the error message in the database before returning
 		a.storeErrorMessage(err.Error())
@@ -376,18 +453,17 @@ func (a *Agent) askLLMStream(ctx context.Context, conversation []openai.ChatComp
 			ID:               uuid.New(),
 			UserID:           a.options.userID,
 			AgentID:          a.options.agentID,
-			Model:            a.options.LLMAPI.Model,
+			Model:            llm.ServedModel(openai.ChatCompletionResponse{Model: lastResponse.Model}, model),
+			Provider:         llm.ServedProvider(resp.Header()),
 			PromptTokens:     usage.PromptTokens,
 			CompletionTokens: usage.CompletionTokens,
 			TotalTokens:      usage.TotalTokens,
 			Cost:             usage.Cost,
-			RequestType:      "chat_stream",
+			RequestType:      phase,
 			GenID:            lastResponse.ID,
 			CreatedAt:        time.Now(),
 		}
-		if err := db.DB.Create(llmUsage).Error; err != nil {
-			xlog.Error("Error tracking LLM usage for stream", "error", err)
-		}
+		llm.TrackUsage(ctx, llmUsage)
 	}
 
 	return openai.ChatCompletionMessage{
@@ -412,10 +488,13 @@ func (a *Agent) Pause() {
 
 }
 
+// Resume resumes the agent, the jobs parked while it was paused are queued
 func (a *Agent) Resume() {
 	a.Lock()
-	defer a.Unlock()
 	a.pause = false
+	a.Unlock()
+
+	a.drainParkedJobs()
 }
 
 func (a *Agent) Paused() bool {
@@ -446,7 +525,9 @@ func (a *Agent) runAction(job *types.Job, chosenAction types.Action, params type
 
 	for _, act := range a.availableActions() {
 		if act.Definition().Name == chosenAction.Definition().Name {
-			res, err := act.Run(job.GetContext(), a.sharedState, params)
+			start := time.Now()
+			res, err := act.Run(withJob(job.GetContext(), job), a.sharedState, params)
+			a.recordActionExecution(act.Definition().Name.String(), params, time.Since(start), err)
 			if err != nil {
 				if obs != nil {
 					obs.Completion = &types.Completion{
@@ -483,7 +564,12 @@ func (a *Agent) runAction(job *types.Job, chosenAction types.Action, params type
 			return types.ActionResult{}, werr
 		}
 		// update the current state with the one we just got from the action
+		state.Memories = a.limitMemories(state.Memories)
+		a.stateMutex.Lock()
+		old := *a.currentState
 		a.currentState = &state
+		a.stateMutex.Unlock()
+		a.auditStateChange(withJob(job.GetContext(), job), old, state)
 		if obs != nil {
 			obs.Progress = append(obs.Progress, types.Progress{
 				AgentState: &state,
@@ -579,18 +665,17 @@ func (a *Agent) describeImage(ctx context.Context, model, imageURL string) (stri
 			ID:               uuid.New(),
 			UserID:           a.options.userID,
 			AgentID:          a.options.agentID,
-			Model:            model,
+			Model:            llm.ServedModel(resp, model),
+			Provider:         llm.ServedProvider(resp.Header()),
 			PromptTokens:     usage.PromptTokens,
 			CompletionTokens: usage.CompletionTokens,
 			TotalTokens:      usage.TotalTokens,
 			Cost:             usage.Cost,
-			RequestType:      "chat",
+			RequestType:      PhaseMultimodal,
 			GenID:            resp.ID,
 			CreatedAt:        time.Now(),
 		}
-		if err := db.DB.Create(llmUsage).Error; err != nil {
-			xlog.Error("Error tracking LLM usage", "error", err)
-		}
+		llm.TrackUsage(ctx, llmUsage)
 	}
 
 	if len(resp.Choices) == 0 {
@@ -645,7 +730,7 @@ func (a *Agent) processUserInputs(job *types.Job, role string, conv Messages) Me
 			// Process each image in the message
 			var imageDescriptions []string
 			for j, image := range images {
-				imageDescription, err := a.describeImage(a.context.Context, a.options.LLMAPI.MultimodalModel, image)
+				imageDescription, err := a.describeImage(a.context.Context, a.options.modelFor(PhaseMultimodal), image)
 				if err != nil {
 					xlog.Error("Error describing image", "error", err, "messageIndex", i, "imageIndex", j)
 					imageDescriptions = append(imageDescriptions, fmt.Sprintf("Image %d: [Error describing image: %v]", j+1, err))
@@ -841,14 +926,9 @@ func (a *Agent) replyWithToolCall(job *types.Job, conv []openai.ChatCompletionMe
 	job.Result.Finish(nil)
 }
 
-func (a *Agent) consumeJob(job *types.Job, role string, retries int) {
+func (a *Agent) consumeJob(job *types.Job, role string) {
 	if err := job.GetContext().Err(); err != nil {
-		job.Result.Finish(fmt.Errorf("expired"))
-		return
-	}
-
-	if retries < 1 {
-		job.Result.Finish(fmt.Errorf("Exceeded recursive retries"))
+		job.Result.Finish(jobError(job, fmt.Errorf("expired")))
 		return
 	}
 
@@ -857,16 +937,24 @@ func (a *Agent) consumeJob(job *types.Job, role string, retries int) {
 	a.Unlock()
 
 	if paused {
+		// periodic runs are skipped, there is no point in catching up on them
+		if a.options.pauseMode == PauseModePark && role != SystemRole {
+			a.parkJob(job, role)
+			return
+		}
 		xlog.Info("Agent is paused, skipping job", "agent", a.Character.Name)
-		job.Result.Finish(fmt.Errorf("agent is paused"))
+		job.Result.Finish(ErrAgentPaused)
 		return
 	}
 
+	a.updateJob(job, func(j *activeJob) {
+		j.status = JobStatusRunning
+		j.startedAt = time.Now()
+	})
+
 	// We are self evaluating if we consume the job as a system role
 	selfEvaluation := role == SystemRole
 
-	conv := job.ConversationHistory
-
 	a.Lock()
 	a.selfEvaluationInProgress = selfEvaluation
 	a.Unlock()
@@ -880,7 +968,10 @@ func (a *Agent) consumeJob(job *types.Job, role string, retries int) {
 		}()
 	}
 
-	conv = a.processPrompts(conv)
+	conv := job.ConversationHistory
+	if !job.Restored {
+		conv = a.processPrompts(conv)
+	}
 	if ok, failedBy, err := a.filterJob(job); !ok || err != nil {
 		if err != nil {
 			job.Result.Finish(fmt.Errorf("Error in job filter: %w", err))
@@ -893,333 +984,19 @@ func (a *Agent) consumeJob(job *types.Job, role string, retries int) {
 		}
 		return
 	}
-	conv = a.processUserInputs(job, role, conv)
+	if !job.Restored {
+		conv = a.processUserInputs(job, role, conv)
 
-	// RAG
-	conv = a.knowledgeBaseLookup(job, conv)
+		// RAG
+		conv = a.knowledgeBaseLookup(job, conv)
+		conv = a.pinnedMemoryLookup(conv)
+	}
+	a.trackJob(job, role, conv)
 
 	// Validate builtin tools against available actions
 	a.validateBuiltinTools(job)
 
-	var pickTemplate string
-	var reEvaluationTemplate string
-
-	if selfEvaluation {
-		pickTemplate = pickSelfTemplate
-		reEvaluationTemplate = reSelfEvalTemplate
-	} else {
-		pickTemplate = pickActionTemplate
-		reEvaluationTemplate = reEvalTemplate
-	}
-
-	// choose an action first
-	var chosenAction types.Action
-	var reasoning string
-	var actionParams types.ActionParams
-
-	if job.HasNextAction() {
-		// if we are being re-evaluated, we already have the action
-		// and the reasoning. Consume it here and reset it
-		action, params, reason := job.GetNextAction()
-		chosenAction = *action
-		reasoning = reason
-		if params == nil {
-			p, err := a.generateParameters(job, pickTemplate, chosenAction, conv, reasoning, maxRetries)
-			if err != nil {
-				xlog.Error("Error generating parameters, trying again", "error", err)
-				// try again
-				job.SetNextAction(&chosenAction, nil, reasoning)
-				a.consumeJob(job, role, retries-1)
-				return
-			}
-			actionParams = p.actionParams
-		} else {
-			actionParams = *params
-		}
-		job.ResetNextAction()
-	} else {
-		var err error
-		chosenAction, actionParams, reasoning, err = a.pickAction(job, pickTemplate, conv, maxRetries)
-		if err != nil {
-			xlog.Error("Error picking action", "error", err)
-			job.Result.Finish(err)
-			return
-		}
-	}
-
-	if chosenAction == nil {
-		// If no action was picked up, the reasoning is the message returned by the assistant
-		// so we can consume it as if it was a reply.
-		xlog.Info("No action to do, just reply", "agent", a.Character.Name, "reasoning", reasoning)
-
-		if reasoning != "" {
-			// When we have reasoning but no action, we still need to check for streaming
-
-			println("NoReasoning")
-
-			conv = append(conv, openai.ChatCompletionMessage{
-				Role:    "assistant",
-				Content: a.cleanupLLMResponse(reasoning),
-			})
-		} else {
-			xlog.Info("No reasoning, just reply", "agent", a.Character.Name)
-
-			// Check if streaming is enabled and there's a stream callback
-			if job.StreamCallback != nil {
-				msg, err := a.askLLMStream(job.GetContext(), conv, job.StreamCallback, maxRetries)
-				fmt.Println("streaming test")
-				if err != nil {
-					job.Result.Finish(fmt.Errorf("error asking LLM for a streaming reply: %w", err))
-					return
-				}
-				msg.Content = a.cleanupLLMResponse(msg.Content)
-				conv = append(conv, msg)
-				reasoning = msg.Content
-			} else {
-				msg, err := a.askLLM(job.GetContext(), conv, maxRetries)
-				fmt.Println("nonstreaming test")
-				if err != nil {
-					job.Result.Finish(fmt.Errorf("error asking LLM for a reply: %w", err))
-					return
-				}
-				msg.Content = a.cleanupLLMResponse(msg.Content)
-				conv = append(conv, msg)
-				reasoning = msg.Content
-			}
-		}
-
-		var satisfied bool
-		var err error
-		// Evaluate the response
-		satisfied, conv, err = a.handleEvaluation(job, conv, job.GetEvaluationLoop())
-		if err != nil {
-			job.Result.Finish(fmt.Errorf("error evaluating response: %w", err))
-			return
-		}
-
-		if !satisfied {
-			// If not satisfied, continue with the conversation
-			job.ConversationHistory = conv
-			job.IncrementEvaluationLoop()
-			a.consumeJob(job, role, retries)
-			return
-		}
-
-		xlog.Debug("Finish job with reasoning", "reasoning", reasoning, "agent", a.Character.Name, "conversation", fmt.Sprintf("%+v", conv))
-		job.Result.Conversation = conv
-		job.Result.AddFinalizer(func(conv []openai.ChatCompletionMessage) {
-			a.saveCurrentConversation(conv)
-		})
-		job.Result.SetResponse(reasoning)
-		job.Result.Finish(nil)
-		return
-	}
-
-	if chosenAction.Definition().Name.Is(action.StopActionName) {
-		xlog.Info("LLM decided to stop")
-		job.Result.Finish(nil)
-		return
-	}
-
-	// if we force a reasoning, we need to generate the parameters
-	if a.options.forceReasoning || actionParams == nil {
-		xlog.Info("Generating parameters",
-			"agent", a.Character.Name,
-			"action", chosenAction.Definition().Name,
-			"reasoning", reasoning,
-		)
-
-		params, err := a.generateParameters(job, pickTemplate, chosenAction, conv, reasoning, maxRetries)
-		if err != nil {
-			xlog.Error("Error generating parameters, trying again", "error", err)
-			// try again
-			job.SetNextAction(&chosenAction, nil, reasoning)
-			a.consumeJob(job, role, retries-1)
-			return
-		}
-		actionParams = params.actionParams
-	}
-
-	xlog.Info(
-		"Generated parameters",
-		"agent", a.Character.Name,
-		"action", chosenAction.Definition().Name,
-		"reasoning", reasoning,
-		"params", actionParams.String(),
-	)
-
-	if actionParams == nil {
-		job.Result.Finish(fmt.Errorf("no parameters"))
-		xlog.Error("No parameters", "agent", a.Character.Name)
-		return
-	}
-
-	if a.options.loopDetectionSteps > 0 && len(job.GetPastActions()) > 0 {
-		count := 0
-		for _, pastAction := range job.GetPastActions() {
-			if pastAction.Action.Definition().Name == chosenAction.Definition().Name &&
-				pastAction.Params.String() == actionParams.String() {
-				count++
-			}
-		}
-		if count >= a.options.loopDetectionSteps {
-			xlog.Info("Loop detected, stopping agent", "agent", a.Character.Name, "action", chosenAction.Definition().Name)
-			a.reply(job, role, conv, actionParams, chosenAction, reasoning)
-			return
-		}
-		xlog.Debug("Checked for loops", "action", chosenAction.Definition().Name, "count", count)
-	}
-
-	job.AddPastAction(chosenAction, &actionParams)
-
-	if !job.Callback(types.ActionCurrentState{
-		Job:       job,
-		Action:    chosenAction,
-		Params:    actionParams,
-		Reasoning: reasoning}) {
-		job.Result.SetResult(types.ActionState{
-			ActionCurrentState: types.ActionCurrentState{
-				Job:       job,
-				Action:    chosenAction,
-				Params:    actionParams,
-				Reasoning: reasoning,
-			},
-			ActionResult: types.ActionResult{Result: "stopped by callback"}})
-		job.Result.Conversation = conv
-		job.Result.Finish(nil)
-		return
-	}
-
-	var err error
-	conv, err = a.handlePlanning(job.GetContext(), job, chosenAction, actionParams, reasoning, pickTemplate, conv)
-	if err != nil {
-		xlog.Error("error handling planning", "error", err)
-		a.reply(job, role, append(conv, openai.ChatCompletionMessage{
-			Role:    "assistant",
-			Content: fmt.Sprintf("Error handling planning: %v", err),
-		}), actionParams, chosenAction, reasoning)
-		return
-	}
-
-	if selfEvaluation && a.options.initiateConversations &&
-		chosenAction.Definition().Name.Is(action.ConversationActionName) {
-
-		xlog.Info("LLM decided to initiate a new conversation", "agent", a.Character.Name)
-
-		message := action.ConversationActionResponse{}
-		if err := actionParams.Unmarshal(&message); err != nil {
-			xlog.Error("Error unmarshalling conversation response", "error", err)
-			job.Result.Finish(fmt.Errorf("error unmarshalling conversation response: %w", err))
-			return
-		}
-
-		msg := openai.ChatCompletionMessage{
-			Role:    "assistant",
-			Content: message.Message,
-		}
-
-		go func(agent *Agent) {
-			xlog.Info("Sending new conversation to channel", "agent", agent.Character.Name, "message", msg.Content)
-			agent.newConversations <- msg
-		}(a)
-
-		job.Result.Conversation = []openai.ChatCompletionMessage{
-			msg,
-		}
-		job.Result.SetResponse("decided to initiate a new conversation")
-		job.Result.Finish(nil)
-		return
-	}
-
-	// if we have a reply action, we need to run it
-	if chosenAction.Definition().Name.Is(action.ReplyActionName) {
-		a.reply(job, role, conv, actionParams, chosenAction, reasoning)
-		return
-	}
-
-	if !chosenAction.Definition().Name.Is(action.PlanActionName) {
-		// Check if this is a user-defined action
-		if types.IsActionUserDefined(chosenAction) {
-			xlog.Debug("User-defined action chosen, returning tool call", "action", chosenAction.Definition().Name)
-			a.replyWithToolCall(job, conv, actionParams, chosenAction, reasoning)
-			return
-		}
-
-		result, err := a.runAction(job, chosenAction, actionParams)
-		if err != nil {
-			result.Result = fmt.Sprintf("Error running tool: %v", err)
-		}
-
-		stateResult := types.ActionState{
-			ActionCurrentState: types.ActionCurrentState{
-				Job:       job,
-				Action:    chosenAction,
-				Params:    actionParams,
-				Reasoning: reasoning,
-			},
-			ActionResult: result,
-		}
-		job.Result.SetResult(stateResult)
-		job.CallbackWithResult(stateResult)
-		xlog.Debug("Action executed", "agent", a.Character.Name, "action", chosenAction.Definition().Name, "result", result)
-
-		conv = a.addFunctionResultToConversation(chosenAction, actionParams, result, conv)
-	}
-
-	// given the result, we can now re-evaluate the conversation
-	followingAction, followingParams, reasoning, err := a.pickAction(job, reEvaluationTemplate, conv, maxRetries)
-	if err != nil {
-		job.Result.Conversation = conv
-		job.Result.Finish(fmt.Errorf("error picking action: %w", err))
-		return
-	}
-
-	if followingAction != nil &&
-		!followingAction.Definition().Name.Is(action.ReplyActionName) &&
-		!chosenAction.Definition().Name.Is(action.ReplyActionName) {
-
-		xlog.Info("Following action", "action", followingAction.Definition().Name, "agent", a.Character.Name)
-		job.ConversationHistory = conv
-
-		// Check if we've exceeded the loop detection steps before recursing
-		if a.options.loopDetectionSteps > 0 && len(job.GetPastActions()) >= a.options.loopDetectionSteps {
-			xlog.Info("Loop detection: Maximum actions reached, stopping recursion", "agent", a.Character.Name, "actions_count", len(job.GetPastActions()))
-			a.reply(job, role, conv, actionParams, chosenAction, "Reached maximum action limit")
-			return
-		}
-
-		// We need to do another action (?)
-		// The agent decided to do another action
-		// call ourselves again
-		job.SetNextAction(&followingAction, &followingParams, reasoning)
-		a.consumeJob(job, role, retries)
-		return
-	}
-
-	// Evaluate the final response
-	var satisfied bool
-	satisfied, conv, err = a.handleEvaluation(job, conv, job.GetEvaluationLoop())
-	if err != nil {
-		job.Result.Finish(fmt.Errorf("error evaluating response: %w", err))
-		return
-	}
-
-	if !satisfied {
-		// Check if we've exceeded evaluation loop limit before recursing
-		if a.options.loopDetectionSteps > 0 && job.GetEvaluationLoop() >= a.options.loopDetectionSteps {
-			xlog.Info("Loop detection: Maximum evaluation loops reached, stopping", "agent", a.Character.Name, "evaluation_loops", job.GetEvaluationLoop())
-			a.reply(job, role, conv, actionParams, chosenAction, "Reached maximum evaluation limit")
-			return
-		}
-
-		// If not satisfied, continue with the conversation
-		job.ConversationHistory = conv
-		job.IncrementEvaluationLoop()
-		a.consumeJob(job, role, retries)
-		return
-	}
-
-	a.reply(job, role, conv, actionParams, chosenAction, reasoning)
+	a.newJobExecution(job, role, conv).run()
 }
 
 func stripThinkingTags(content string) string {
@@ -1241,9 +1018,38 @@ func (a *Agent) cleanupLLMResponse(content string) string {
 }
 
 func (a *Agent) reply(job *types.Job, role string, conv Messages, actionParams types.ActionParams, chosenAction types.Action, reasoning string) {
+	msg, err := a.generateReply(job, conv, actionParams, chosenAction, reasoning)
+	if err != nil {
+		job.Result.Conversation = conv
+		job.Result.Finish(jobError(job, err))
+		xlog.Error("Error asking LLM for a reply", "error", err)
+		return
+	}
+
+	conv = append(conv, msg)
+	if job.ResponseSchema == nil {
+		job.Result.SetResponse(msg.Content)
+	}
+	xlog.Info("Response from LLM", "response", msg.Content, "agent", a.Character.Name)
 	job.Result.Conversation = conv
+	job.Result.Finish(nil)
+}
+
+// generateReply asks the LLM for the response to the user, given the
+// conversation so far. The response is not added to the conversation.
+// Jobs with a response schema get a structured response, which is also
+// set as their result.
+func (a *Agent) generateReply(job *types.Job, conv Messages, actionParams types.ActionParams, chosenAction types.Action, reasoning string) (openai.ChatCompletionMessage, error) {
+	if job.ResponseSchema != nil {
+		if err := a.structuredResponse(job, conv); err != nil {
+			return openai.ChatCompletionMessage{}, err
+		}
+		return openai.ChatCompletionMessage{
+			Role:    "assistant",
+			Content: job.Result.Response,
+		}, nil
+	}
 
-	// At this point can only be a reply action
 	xlog.Info("Computing reply", "agent", a.Character.Name)
 
 	// Base response prompt
@@ -1356,9 +1162,7 @@ CRITICAL GMAIL SEARCH RESULT FORMATTING RULES:
 	if a.options.enableHUD {
 		prompt, err := renderTemplate(hudTemplate, a.prepareHUD(), a.availableActions(), reasoning)
 		if err != nil {
-			job.Result.Conversation = conv
-			job.Result.Finish(fmt.Errorf("error renderTemplate: %w", err))
-			return
+			return openai.ChatCompletionMessage{}, fmt.Errorf("error renderTemplate: %w", err)
 		}
 		if !Messages(conv).Exist(prompt) {
 			conv = append([]openai.ChatCompletionMessage{
@@ -1384,87 +1188,35 @@ CRITICAL GMAIL SEARCH RESULT FORMATTING RULES:
 	xlog.Info("Reasoning, ask LLM for a reply", "agent", a.Character.Name)
 	xlog.Debug("Conversation", "conversation", fmt.Sprintf("%+v", conv))
 
+	var msg openai.ChatCompletionMessage
+	var err error
 	// Check if streaming is enabled and there's a stream callback
 	if job.StreamCallback != nil {
-		a.replyWithStream(job, conv, actionParams, chosenAction)
-		return
+		msg, err = a.askLLMStream(job.GetContext(), PhaseReply, conv, job.StreamCallback)
+	} else {
+		msg, err = a.askLLM(job.GetContext(), PhaseReply, conv)
 	}
-
-	msg, err := a.askLLM(job.GetContext(), conv, maxRetries)
 	if err != nil {
-		job.Result.Conversation = conv
-		job.Result.Finish(err)
-		xlog.Error("Error asking LLM for a reply", "error", err)
 		// Error message is already stored by askLLM function
-		return
+		return msg, err
 	}
 
 	msg.Content = a.cleanupLLMResponse(msg.Content)
 
-	if msg.Content == "" {
+	if msg.Content == "" && chosenAction != nil && chosenAction.Definition().Name.Is(action.ReplyActionName) {
 		// If we didn't got any message, we can use the response from the action (it should be a reply)
-
 		replyResponse := action.ReplyResponse{}
 		if err := actionParams.Unmarshal(&replyResponse); err != nil {
-			job.Result.Conversation = conv
-			job.Result.Finish(fmt.Errorf("error unmarshalling reply response: %w", err))
-			return
+			return msg, fmt.Errorf("error unmarshalling reply response: %w", err)
 		}
 
-		if chosenAction.Definition().Name.Is(action.ReplyActionName) && replyResponse.Message != "" {
+		if replyResponse.Message != "" {
 			xlog.Info("No output returned from conversation, using the action response as a reply " + replyResponse.Message)
 			msg.Content = a.cleanupLLMResponse(replyResponse.Message)
 		}
 	}
 
-	conv = append(conv, msg)
-	job.Result.SetResponse(msg.Content)
-	xlog.Info("Response from LLM", "response", msg.Content, "agent", a.Character.Name)
-	job.Result.Conversation = conv
-	job.Result.AddFinalizer(func(conv []openai.ChatCompletionMessage) {
-		// a.saveCurrentConversation(conv)
-	})
-	job.Result.Finish(nil)
-}
-
-// replyWithStream handles streaming replies by sending partial responses via the stream callback
-func (a *Agent) replyWithStream(job *types.Job, conv Messages, actionParams types.ActionParams, chosenAction types.Action) {
-	xlog.Info("Computing streaming reply", "agent", a.Character.Name)
-
-	msg, err := a.askLLMStream(job.GetContext(), conv, job.StreamCallback, maxRetries)
-	if err != nil {
-		job.Result.Conversation = conv
-		job.Result.Finish(err)
-		xlog.Error("Error asking LLM for streaming reply", "error", err)
-		// Error message is already stored by askLLMStream function
-		return
-	}
-
-	msg.Content = a.cleanupLLMResponse(msg.Content)
-
-	if msg.Content == "" {
-		// If we didn't got any message, we can use the response from the action (it should be a reply)
-		replyResponse := action.ReplyResponse{}
-		if err := actionParams.Unmarshal(&replyResponse); err != nil {
-			job.Result.Conversation = conv
-			job.Result.Finish(fmt.Errorf("error unmarshalling reply response: %w", err))
-			return
-		}
-
-		if chosenAction.Definition().Name.Is(action.ReplyActionName) && replyResponse.Message != "" {
-			xlog.Info("No output returned from conversation, using the action response as a reply " + replyResponse.Message)
-			msg.Content = a.cleanupLLMResponse(replyResponse.Message)
-		}
-	}
-
-	conv = append(conv, msg)
-	job.Result.SetResponse(msg.Content)
-	xlog.Info("Streaming response from LLM completed", "response", msg.Content, "agent", a.Character.Name)
-	job.Result.Conversation = conv
-	job.Result.AddFinalizer(func(conv []openai.ChatCompletionMessage) {
-		// a.saveCurrentConversation(conv)
-	})
-	job.Result.Finish(nil)
+	return msg, nil
 }
 
 func (a *Agent) addFunctionResultToConversation(chosenAction types.Action, actionParams types.ActionParams, result types.ActionResult, conv Messages) Messages {
@@ -1553,6 +1305,7 @@ func (a *Agent) periodicallyRun(timer *time.Timer) {
 			types.WithText(fmt.Sprintf("I have a reminder for you: %s", reminder.Message)),
 			types.WithReasoningCallback(a.options.reasoningCallback),
 			types.WithResultCallback(a.options.resultCallback),
+			types.WithPriority(types.JobPriorityReminder),
 		)
 
 		// Add the reminder message to the job's metadata
@@ -1562,7 +1315,8 @@ func (a *Agent) periodicallyRun(timer *time.Timer) {
 		}
 
 		// Process the reminder as a normal conversation
-		a.consumeJob(reminderJob, UserRole, a.options.loopDetectionSteps)
+		a.enqueue(reminderJob, UserRole)
+		reminderJob.Result.WaitResult()
 
 		// After the reminder job is complete, ensure the user is notified
 		if reminderJob.Result != nil && reminderJob.Result.Conversation != nil {
@@ -1604,8 +1358,10 @@ func (a *Agent) periodicallyRun(timer *time.Timer) {
 		types.WithText(innerMonologueTemplate),
 		types.WithReasoningCallback(a.options.reasoningCallback),
 		types.WithResultCallback(a.options.resultCallback),
+		types.WithPriority(types.JobPriorityPeriodic),
 	)
-	a.consumeJob(whatNext, SystemRole, a.options.loopDetectionSteps)
+	a.enqueue(whatNext, SystemRole)
+	whatNext.Result.WaitResult()
 
 	xlog.Info("STOP -- Periodically run is done", "agent", a.Character.Name)
 }
@@ -1626,9 +1382,12 @@ func (a *Agent) Run() error {
 	// Expose a REST API to interact with the agent to ask it things
 
 	timer := time.NewTimer(a.options.periodicRuns)
+	a.jobQueue.closeWith(a.context.Context)
+	context.AfterFunc(a.context.Context, a.releaseParkedJobs)
 
 	// we fire the periodicalRunner only once.
 	go a.periodicalRunRunner(timer)
+	go a.consolidateSummariesLoop()
 	var errs []error
 	var muErr sync.Mutex
 	var wg sync.WaitGroup
@@ -1658,19 +1417,28 @@ func (a *Agent) Run() error {
 func (a *Agent) run(timer *time.Timer) error {
 	for {
 		xlog.Debug("Agent is now waiting for a new job", "agent", a.Character.Name)
-		select {
-		case job := <-a.jobQueue:
-			if !timer.Stop() {
-				<-timer.C
-			}
-			xlog.Debug("Agent is consuming a job", "agent", a.Character.Name, "job", job)
-			a.consumeJob(job, UserRole, a.options.loopDetectionSteps)
-			timer.Reset(a.options.periodicRuns)
-		case <-a.context.Done():
+		item := a.jobQueue.pop()
+		if item == nil {
 			// Agent has been canceled, return error
 			xlog.Warn("Agent has been canceled", "agent", a.Character.Name)
 			return ErrContextCanceled
 		}
+
+		// the periodic runs are served by the queue too, only the other
+		// jobs postpone the next one
+		periodic := item.job.Priority == types.JobPriorityPeriodic
+		if !periodic && !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		xlog.Debug("Agent is consuming a job", "agent", a.Character.Name, "job", item.job.UUID, "priority", item.job.Priority.String())
+		a.consumeJob(item.job, item.role)
+		a.jobQueue.done(item)
+		if !periodic {
+			timer.Reset(a.options.periodicRuns)
+		}
 	}
 }
 
@@ -1690,3 +1458,39 @@ func (a *Agent) periodicalRunRunner(timer *time.Timer) {
 func (a *Agent) Observer() Observer {
 	return a.observer
 }
+
+// newLLMChain builds the LLM provider of the agent: its LLM API followed by
+// the configured fallbacks
+func newLLMChain(options *options) *llm.Chain {
+	entries := []llm.ChainEntry{
+		{
+			Name:     options.LLMAPI.APIURL,
+			Provider: llm.NewOpenAIProviderWithTransport(options.LLMAPI.APIKey, options.LLMAPI.APIURL, options.timeout, options.llmTransport),
+		},
+	}
+
+	for _, fallback := range options.llmFallbacks {
+		entry := llm.ChainEntry{
+			Name:     options.LLMAPI.APIURL,
+			Provider: entries[0].Provider,
+			Model:    fallback.Model,
+		}
+		if fallback.APIURL != "" || fallback.APIKey != "" {
+			apiURL := fallback.APIURL
+			if apiURL == "" {
+				apiURL = options.LLMAPI.APIURL
+			}
+			apiKey := fallback.APIKey
+			if apiKey == "" {
+				apiKey = options.LLMAPI.APIKey
+			}
+			entry.Name = apiURL
+			entry.Provider = llm.NewOpenAIProviderWithTransport(apiKey, apiURL, options.timeout, options.llmTransport)
+		}
+		entries = append(entries, entry)
+	}
+
+	chain := llm.NewChain(entries...)
+	chain.Sampling = options.sampling
+	return chain
+}
@@ -2,8 +2,11 @@ package agent_test
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 
+	. "github.com/mudler/LocalAGI/core/agent"
+	"github.com/mudler/LocalAGI/pkg/llm"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -17,6 +20,10 @@ var testModel = os.Getenv("LOCALAGI_MODEL")
 var apiURL = os.Getenv("LOCALAI_API_URL")
 var apiKeyURL = os.Getenv("LOCALAI_API_KEY")
 
+// cassetteMode records the LLM interactions of the tests to testdata/cassettes
+// ("record"), or replays them without a live LLM ("replay")
+var cassetteMode = llm.CassetteMode(os.Getenv("LOCALAGI_LLM_CASSETTE_MODE"))
+
 func init() {
 	if testModel == "" {
 		testModel = "hermes-2-pro-mistral"
@@ -25,3 +32,21 @@ func init() {
 		apiURL = "http://192.168.68.113:8080"
 	}
 }
+
+// withCassette sends the LLM requests of a test through the named cassette
+// when LOCALAGI_LLM_CASSETTE_MODE is set. In replay mode the tests without a
+// recorded cassette fail, the tests with one are labeled "replay".
+func withCassette(name string) Option {
+	if cassetteMode == "" {
+		return WithLLMTransport(nil)
+	}
+
+	path := filepath.Join("testdata", "cassettes", name+".json")
+	if cassetteMode == llm.CassetteReplay {
+		Expect(path).To(BeAnExistingFile(), "no cassette recorded at %s, run make test-agent-record against a live LLM first", path)
+	}
+
+	cassette, err := llm.NewCassette(path, cassetteMode)
+	Expect(err).ToNot(HaveOccurred())
+	return WithLLMTransport(cassette)
+}
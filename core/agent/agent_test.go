@@ -7,6 +7,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/mudler/LocalAGI/pkg/llm"
 	"github.com/mudler/LocalAGI/pkg/xlog"
 	"github.com/mudler/LocalAGI/services/actions"
 
@@ -115,6 +116,9 @@ var _ = Describe("Agent test", func() {
 	Context("jobs", func() {
 
 		BeforeEach(func() {
+			if cassetteMode == llm.CassetteReplay {
+				return
+			}
 			Eventually(func() error {
 				// test apiURL is working and available
 				_, err := http.Get(apiURL + "/readyz")
@@ -122,8 +126,25 @@ var _ = Describe("Agent test", func() {
 			}, "10m", "10s").ShouldNot(HaveOccurred())
 		})
 
+		It("answers a question", Label("replay"), func() {
+			agent, err := New(
+				withCassette("ask"),
+				WithLLMAPIURL(apiURL),
+				WithModel(testModel),
+				WithTimeout("10m"),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			go agent.Run()
+			defer agent.Stop()
+
+			result := agent.Ask(types.WithText("What is the capital of Italy?"))
+			Expect(result.Error).ToNot(HaveOccurred())
+			Expect(result.Response).To(ContainSubstring("Rome"), fmt.Sprint(result))
+		})
+
 		It("pick the correct action", func() {
 			agent, err := New(
+				withCassette("pick_action_reasoning"),
 				WithLLMAPIURL(apiURL),
 				WithModel(testModel),
 				EnableForceReasoning,
@@ -174,6 +195,7 @@ var _ = Describe("Agent test", func() {
 		})
 		It("pick the correct action", func() {
 			agent, err := New(
+				withCassette("pick_action"),
 				WithLLMAPIURL(apiURL),
 				WithModel(testModel),
 				WithTimeout("10m"),
@@ -199,6 +221,7 @@ var _ = Describe("Agent test", func() {
 
 		It("updates the state with internal actions", func() {
 			agent, err := New(
+				withCassette("internal_actions"),
 				WithLLMAPIURL(apiURL),
 				WithModel(testModel),
 				WithTimeout("10m"),
@@ -219,8 +242,9 @@ var _ = Describe("Agent test", func() {
 			Expect(agent.State().Goal).To(ContainSubstring("guitar"), fmt.Sprint(agent.State()))
 		})
 
-		It("Can generate a plan", func() {
+		It("Can generate a plan", Label("replay"), func() {
 			agent, err := New(
+				withCassette("plan"),
 				WithLLMAPIURL(apiURL),
 				WithModel(testModel),
 				WithLLMAPIKey(apiKeyURL),
@@ -258,11 +282,38 @@ var _ = Describe("Agent test", func() {
 			Expect(actionResults).To(ContainElement(testActionResult2), fmt.Sprint(result))
 		})
 
+		It("evaluates its response", Label("replay"), func() {
+			agent, err := New(
+				withCassette("evaluation"),
+				WithLLMAPIURL(apiURL),
+				WithModel(testModel),
+				WithTimeout("10m"),
+				WithActions(&TestAction{response: map[string]string{
+					"boston": testActionResult,
+				}}),
+				EnableEvaluation(),
+				WithMaxEvaluationLoops(1),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			go agent.Run()
+			defer agent.Stop()
+
+			result := agent.Ask(types.WithText("can you get the weather in boston? Use celsius units"))
+			Expect(result.Error).ToNot(HaveOccurred())
+			results := []string{}
+			for _, r := range result.State {
+				results = append(results, r.Result)
+			}
+			Expect(results).To(ContainElement(testActionResult), fmt.Sprint(result))
+			Expect(result.Response).To(ContainSubstring("30"), fmt.Sprint(result))
+		})
+
 		It("Can initiate conversations", func() {
 
 			message := openai.ChatCompletionMessage{}
 			mu := &sync.Mutex{}
 			agent, err := New(
+				withCassette("initiate_conversations"),
 				WithLLMAPIURL(apiURL),
 				WithModel(testModel),
 				WithLLMAPIKey(apiKeyURL),
@@ -299,6 +350,7 @@ var _ = Describe("Agent test", func() {
 		/*
 			It("it automatically performs things in the background", func() {
 				agent, err := New(
+					withCassette("background"),
 					WithLLMAPIURL(apiURL),
 					WithModel(testModel),
 					EnableHUD,
@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/db"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+)
+
+// ErrActionDenied is returned when the user denied an action that required approval
+var ErrActionDenied = errors.New("action denied")
+
+const approvalPollInterval = 2 * time.Second
+
+// pendingApproval is an approval request waiting for the decision of the user
+type pendingApproval struct {
+	call *types.ActionRequest
+	id   uuid.UUID
+	obs  *types.Observable
+}
+
+func (a *Agent) requiresApproval(act types.Action) bool {
+	return a.options.approvalRequired[act.Definition().Name.String()]
+}
+
+// approveActions asks for the approval of the calls that require it and
+// waits for all the decisions. Modified parameters replace the ones of the
+// call. It returns ErrActionDenied if any of the calls was denied, or if
+// the approvals can't be recorded because the agent is not persisted: the
+// approvals left pending by a denial are expired.
+func (a *Agent) approveActions(job *types.Job, calls []*types.ActionRequest, reasoning string) error {
+	var required []*types.ActionRequest
+	for _, call := range calls {
+		if a.requiresApproval(call.Action) {
+			required = append(required, call)
+		}
+	}
+	if len(required) == 0 {
+		return nil
+	}
+	if !a.persisted() {
+		xlog.Warn("Action requires approval but the agent is not persisted, denying it", "agent", a.Character.Name, "action", required[0].Action.Definition().Name)
+		return fmt.Errorf("%w: %s requires approval, which can't be recorded for this agent", ErrActionDenied, required[0].Action.Definition().Name)
+	}
+
+	// Send all the requests first, so the user can decide on them together
+	pending := make([]pendingApproval, 0, len(required))
+	for _, call := range required {
+		id, obs, err := a.requestApproval(job, call.Action, *call.Params, reasoning)
+		if err != nil {
+			a.expireApprovals(pending)
+			return err
+		}
+		pending = append(pending, pendingApproval{call: call, id: id, obs: obs})
+	}
+
+	for i, p := range pending {
+		params, err := a.waitForApproval(job, p.id, p.call.Action, *p.call.Params)
+		if p.obs != nil {
+			if err != nil {
+				p.obs.Completion = &types.Completion{Error: err.Error()}
+			} else {
+				p.obs.Completion = &types.Completion{}
+			}
+			a.observer.Update(*p.obs)
+		}
+		if err != nil {
+			a.expireApprovals(pending[i+1:])
+			return err
+		}
+		*p.call.Params = params
+	}
+
+	return nil
+}
+
+// requestApproval persists an approval request for the action and notifies
+// the approval callbacks of the agent
+func (a *Agent) requestApproval(job *types.Job, act types.Action, params types.ActionParams, reasoning string) (uuid.UUID, *types.Observable, error) {
+	approval := models.ActionApproval{
+		ID:        uuid.New(),
+		AgentID:   a.options.agentID,
+		UserID:    a.options.userID,
+		JobID:     job.UUID,
+		Action:    act.Definition().Name.String(),
+		Params:    marshalCheckpointField(params),
+		Reasoning: reasoning,
+		Status:    types.ApprovalPending,
+	}
+	if err := db.DB.Create(&approval).Error; err != nil {
+		return uuid.Nil, nil, fmt.Errorf("failed to create approval request: %w", err)
+	}
+
+	xlog.Info("Waiting for action approval", "agent", a.Character.Name, "action", approval.Action, "approval", approval.ID)
+
+	var obs *types.Observable
+	if job.Obs != nil {
+		obs = a.observer.NewObservable()
+		obs.Name = "approval"
+		obs.Icon = "hand"
+		obs.ParentID = job.Obs.ID
+		obs.Creation = &types.Creation{
+			FunctionDefinition: act.Definition().ToFunctionDefinition(),
+			FunctionParams:     params,
+		}
+		a.observer.Update(*obs)
+	}
+
+	request := types.ApprovalRequest{
+		ID:        approval.ID.String(),
+		JobID:     job.UUID,
+		Action:    approval.Action,
+		Params:    params,
+		Reasoning: reasoning,
+		Job:       job,
+	}
+	for _, cb := range a.options.approvalCallbacks {
+		cb(request)
+	}
+
+	return approval.ID, obs, nil
+}
+
+// waitForApproval blocks until the approval request is decided and returns
+// the parameters to run the action with
+func (a *Agent) waitForApproval(job *types.Job, id uuid.UUID, act types.Action, params types.ActionParams) (types.ActionParams, error) {
+	ticker := time.NewTicker(approvalPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-job.GetContext().Done():
+			if err := db.DB.Model(&models.ActionApproval{}).
+				Where("ID = ? AND Status = ?", id, types.ApprovalPending).
+				Update("Status", types.ApprovalExpired).Error; err != nil {
+				xlog.Error("Failed to expire approval request", "error", err, "approval", id)
+			}
+			return nil, job.GetContext().Err()
+		case <-ticker.C:
+			var approval models.ActionApproval
+			if err := db.DB.Where("ID = ?", id).First(&approval).Error; err != nil {
+				xlog.Error("Error checking approval request", "error", err, "approval", id)
+				continue
+			}
+
+			switch approval.Status {
+			case types.ApprovalApproved:
+				xlog.Info("Action approved", "agent", a.Character.Name, "action", approval.Action)
+				return params, nil
+			case types.ApprovalModified:
+				modified := types.ActionParams{}
+				if err := unmarshalCheckpointField(approval.Params, &modified); err != nil {
+					return nil, fmt.Errorf("invalid modified parameters for %s: %w", approval.Action, err)
+				}
+				xlog.Info("Action approved with modified parameters", "agent", a.Character.Name, "action", approval.Action, "params", modified.String())
+				return modified, nil
+			case types.ApprovalDenied, types.ApprovalExpired:
+				xlog.Info("Action denied", "agent", a.Character.Name, "action", approval.Action)
+				return nil, fmt.Errorf("%w: %s", ErrActionDenied, act.Definition().Name)
+			}
+		}
+	}
+}
+
+// expireApprovals marks the approvals of a batch still pending as expired,
+// once a denial made the job give up on the batch
+func (a *Agent) expireApprovals(approvals []pendingApproval) {
+	if len(approvals) == 0 {
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(approvals))
+	for _, p := range approvals {
+		ids = append(ids, p.id)
+		if p.obs != nil {
+			p.obs.Completion = &types.Completion{Error: "approval expired"}
+			a.observer.Update(*p.obs)
+		}
+	}
+
+	if err := db.DB.Model(&models.ActionApproval{}).
+		Where("ID IN ? AND Status = ?", ids, types.ApprovalPending).
+		Update("Status", types.ApprovalExpired).Error; err != nil {
+		xlog.Error("Failed to expire approval requests", "error", err, "agent", a.Character.Name)
+	}
+}
+
+// expirePendingApprovals marks the approvals left pending by a previous run
+// of the agent as expired: resumed jobs ask for them again.
+func (a *Agent) expirePendingApprovals() {
+	if err := db.DB.Model(&models.ActionApproval{}).
+		Where("AgentID = ? AND Status = ?", a.options.agentID, types.ApprovalPending).
+		Update("Status", types.ApprovalExpired).Error; err != nil {
+		xlog.Error("Failed to expire pending approvals", "error", err, "agent", a.Character.Name)
+	}
+}
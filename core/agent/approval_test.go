@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"errors"
+
+	"github.com/mudler/LocalAGI/core/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Action approval", func() {
+	It("denies the actions requiring approval when it can't be recorded", func() {
+		counter := &countAction{name: "count"}
+		llm := newFakeLLM(toolReply("count", `{"what":"sheep"}`))
+		a := newTestAgent(llm, EnableNativeToolCalls, WithActions(counter),
+			WithActionsRequiringApproval("count"))
+
+		result := a.Ask(types.WithText("count the sheep"))
+		Expect(errors.Is(result.Error, ErrActionDenied)).To(BeTrue())
+		Expect(counter.runs.Load()).To(BeZero())
+	})
+
+	It("runs the actions which don't require approval", func() {
+		counter := &countAction{name: "count"}
+		llm := newFakeLLM(
+			toolReply("count", `{"what":"sheep"}`),
+			textReply("no more actions"),
+			textReply("I counted the sheep"),
+		)
+		a := newTestAgent(llm, EnableNativeToolCalls, WithActions(counter),
+			WithActionsRequiringApproval("send_email"))
+
+		result := a.Ask(types.WithText("count the sheep"))
+		Expect(result.Error).ToNot(HaveOccurred())
+		Expect(counter.runs.Load()).To(Equal(int32(1)))
+	})
+})
@@ -0,0 +1,315 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/db"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/sashabaranov/go-openai"
+	"gorm.io/datatypes"
+)
+
+const (
+	JobStatusRunning     = "running"
+	JobStatusCompleted   = "completed"
+	JobStatusFailed      = "failed"
+	JobStatusInterrupted = "interrupted"
+)
+
+// trackJob creates the checkpoint of a job the first time the agent
+// starts working on it, and marks it as completed or failed once
+// the job result is ready.
+func (a *Agent) trackJob(job *types.Job, role string, conv []openai.ChatCompletionMessage) {
+	if a.options.agentID == uuid.Nil || a.options.userID == uuid.Nil {
+		return
+	}
+
+	a.checkpointMutex.Lock()
+	if _, ok := a.checkpointedJobs[job.UUID]; ok {
+		a.checkpointMutex.Unlock()
+		return
+	}
+	a.checkpointedJobs[job.UUID] = struct{}{}
+	a.checkpointMutex.Unlock()
+
+	checkpoint := models.JobCheckpoint{
+		ID:      uuid.New(),
+		JobID:   job.UUID,
+		AgentID: a.options.agentID,
+		UserID:  a.options.userID,
+		Role:    role,
+		Status:  JobStatusRunning,
+	}
+	// Resumed jobs already have a checkpoint: keep its ID and reset the status
+	if err := db.DB.Where("JobID = ?", job.UUID).
+		Assign(models.JobCheckpoint{Status: JobStatusRunning, Role: role}).
+		FirstOrCreate(&checkpoint).Error; err != nil {
+		xlog.Error("Failed to create job checkpoint", "error", err, "agent", a.Character.Name, "job", job.UUID)
+	}
+
+	a.checkpointJob(job, conv)
+
+	job.Result.AddFinalizer(func(conv []openai.ChatCompletionMessage) {
+		a.finishJobCheckpoint(job, conv)
+	})
+}
+
+// checkpointJob persists the current state of a tracked job: the conversation
+// so far, the completed steps and the pending next action, if any.
+func (a *Agent) checkpointJob(job *types.Job, conv []openai.ChatCompletionMessage) {
+	a.checkpointMutex.Lock()
+	_, tracked := a.checkpointedJobs[job.UUID]
+	a.checkpointMutex.Unlock()
+	if !tracked {
+		return
+	}
+
+	updates := map[string]interface{}{
+		"Conversation":        marshalCheckpointField(conv),
+		"Steps":               marshalCheckpointField(job.GetSteps()),
+		"Metadata":            marshalCheckpointField(job.Metadata),
+		"EvaluationLoop":      job.GetEvaluationLoop(),
+		"NextAction":          "",
+		"NextActionParams":    nil,
+		"NextActionReasoning": "",
+	}
+
+	if job.HasNextAction() {
+		action, params, reasoning := job.GetNextAction()
+		updates["NextAction"] = (*action).Definition().Name.String()
+		updates["NextActionReasoning"] = reasoning
+		if params != nil {
+			updates["NextActionParams"] = marshalCheckpointField(params)
+		}
+	}
+
+	if err := db.DB.Model(&models.JobCheckpoint{}).Where("JobID = ?", job.UUID).Updates(updates).Error; err != nil {
+		xlog.Error("Failed to update job checkpoint", "error", err, "agent", a.Character.Name, "job", job.UUID)
+	}
+}
+
+// recordJobStep adds a completed step to the job and checkpoints it together
+// with the conversation that already contains the step result.
+func (a *Agent) recordJobStep(job *types.Job, chosenAction types.Action, params types.ActionParams, reasoning string, result types.ActionResult, runErr error, conv []openai.ChatCompletionMessage) {
+	step := types.JobStep{
+		Action:         chosenAction.Definition().Name.String(),
+		Params:         params,
+		Reasoning:      reasoning,
+		Result:         result.Result,
+		EvaluationLoop: job.GetEvaluationLoop(),
+		CompletedAt:    time.Now(),
+	}
+	if runErr != nil {
+		step.Error = runErr.Error()
+	}
+	job.AddStep(step)
+
+	a.checkpointJob(job, conv)
+}
+
+func (a *Agent) finishJobCheckpoint(job *types.Job, conv []openai.ChatCompletionMessage) {
+	a.checkpointMutex.Lock()
+	delete(a.checkpointedJobs, job.UUID)
+	a.checkpointMutex.Unlock()
+
+	updates := map[string]interface{}{
+		"Status":       JobStatusCompleted,
+		"Conversation": marshalCheckpointField(conv),
+		"Steps":        marshalCheckpointField(job.GetSteps()),
+		"NextAction":   "",
+		"Error":        "",
+	}
+	if job.Result.Error != nil {
+		updates["Status"] = JobStatusFailed
+		updates["Error"] = job.Result.Error.Error()
+	}
+
+	if err := db.DB.Model(&models.JobCheckpoint{}).Where("JobID = ?", job.UUID).Updates(updates).Error; err != nil {
+		xlog.Error("Failed to finish job checkpoint", "error", err, "agent", a.Character.Name, "job", job.UUID)
+	}
+}
+
+// failJobCheckpoint marks the checkpoint of a failed attempt of a job, the
+// next attempt starts a new one
+func (a *Agent) failJobCheckpoint(job *types.Job, err error) {
+	a.checkpointMutex.Lock()
+	_, tracked := a.checkpointedJobs[job.UUID]
+	delete(a.checkpointedJobs, job.UUID)
+	a.checkpointMutex.Unlock()
+	if !tracked {
+		return
+	}
+
+	if err := db.DB.Model(&models.JobCheckpoint{}).Where("JobID = ?", job.UUID).Updates(map[string]interface{}{
+		"Status": JobStatusFailed,
+		"Error":  err.Error(),
+	}).Error; err != nil {
+		xlog.Error("Failed to mark job checkpoint as failed", "error", err, "agent", a.Character.Name, "job", job.UUID)
+	}
+}
+
+// ResumeJobs loads the jobs of the agent that were still running when the
+// process stopped and resumes them from their last completed step, then
+// runs the jobs parked while the agent was paused and schedules the retries
+// of the failed jobs.
+// It returns the number of resumed jobs.
+func (a *Agent) ResumeJobs() (int, error) {
+	a.expirePendingApprovals()
+
+	var checkpoints []models.JobCheckpoint
+	if err := db.DB.Where("AgentID = ? AND Status = ?", a.options.agentID, JobStatusRunning).
+		Order("CreatedAt ASC").
+		Find(&checkpoints).Error; err != nil {
+		return 0, fmt.Errorf("failed to load job checkpoints: %w", err)
+	}
+
+	resumed := 0
+	for _, checkpoint := range checkpoints {
+		job, err := a.jobFromCheckpoint(checkpoint)
+		if err != nil {
+			xlog.Warn("Cannot resume job, marking it as interrupted", "agent", a.Character.Name, "job", checkpoint.JobID, "error", err)
+			if err := db.DB.Model(&models.JobCheckpoint{}).Where("ID = ?", checkpoint.ID).Updates(map[string]interface{}{
+				"Status": JobStatusInterrupted,
+				"Error":  err.Error(),
+			}).Error; err != nil {
+				xlog.Error("Failed to mark job checkpoint as interrupted", "error", err, "job", checkpoint.JobID)
+			}
+			continue
+		}
+
+		xlog.Info("Resuming job from checkpoint", "agent", a.Character.Name, "job", job.UUID, "steps", len(job.GetSteps()))
+		if attempts := a.failedAttempts(job.UUID); attempts > 0 {
+			// interrupted while being retried
+			a.retryOnFailure(job, checkpoint.Role, attempts+1)
+		}
+		go a.resumeJob(job, checkpoint.Role)
+		resumed++
+	}
+
+	// the pause of the agent doesn't survive a restart, run the jobs that
+	// were parked before it
+	if !a.Paused() {
+		a.drainParkedJobs()
+	}
+
+	resumed += a.resumeFailedJobs()
+
+	return resumed, nil
+}
+
+func (a *Agent) jobFromCheckpoint(checkpoint models.JobCheckpoint) (*types.Job, error) {
+	conv := []openai.ChatCompletionMessage{}
+	if err := unmarshalCheckpointField(checkpoint.Conversation, &conv); err != nil {
+		return nil, fmt.Errorf("invalid conversation: %w", err)
+	}
+	if len(conv) == 0 {
+		return nil, fmt.Errorf("empty conversation")
+	}
+
+	metadata := map[string]interface{}{}
+	if err := unmarshalCheckpointField(checkpoint.Metadata, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+
+	steps := []types.JobStep{}
+	if err := unmarshalCheckpointField(checkpoint.Steps, &steps); err != nil {
+		return nil, fmt.Errorf("invalid steps: %w", err)
+	}
+
+	job := types.NewJob(
+		types.WithUUID(checkpoint.JobID),
+		types.WithConversationHistory(conv),
+		types.WithMetadata(metadata),
+		types.WithReasoningCallback(a.options.reasoningCallback),
+		types.WithResultCallback(a.options.resultCallback),
+	)
+	// Filters and preprocessing were already applied before the first checkpoint
+	job.DoneFilter = true
+	job.Restored = true
+	// JSON turns the loop counter into a float, restore it as an int
+	job.Metadata["evaluation_loop"] = checkpoint.EvaluationLoop
+
+	for _, step := range steps {
+		job.AddStep(step)
+		if act := a.getAvailableActionsForJob(job).Find(step.Action); act != nil {
+			params := step.Params
+			job.AddPastAction(act, &params)
+		}
+	}
+
+	if checkpoint.NextAction != "" {
+		nextAction := a.getAvailableActionsForJob(job).Find(checkpoint.NextAction)
+		if nextAction == nil {
+			return nil, fmt.Errorf("action %s is not available anymore", checkpoint.NextAction)
+		}
+
+		var params *types.ActionParams
+		if len(checkpoint.NextActionParams) > 0 && string(checkpoint.NextActionParams) != "null" {
+			p := types.ActionParams{}
+			if err := unmarshalCheckpointField(checkpoint.NextActionParams, &p); err != nil {
+				return nil, fmt.Errorf("invalid next action parameters: %w", err)
+			}
+			params = &p
+		}
+		job.SetNextAction(&nextAction, params, checkpoint.NextActionReasoning)
+	}
+
+	return job, nil
+}
+
+// resumeJob runs a job restored from a checkpoint. Nobody is waiting for its
+// result anymore, so the final response is delivered as a new conversation.
+func (a *Agent) resumeJob(job *types.Job, role string) {
+	if a.observer != nil {
+		obs := a.observer.NewObservable()
+		obs.Name = "job"
+		obs.Icon = "sync"
+		job.Obs = obs
+	}
+
+	if role == SystemRole {
+		if job.Obs != nil {
+			a.observer.Update(*job.Obs)
+		}
+		job.Priority = types.JobPriorityPeriodic
+		a.enqueue(job, role)
+	} else {
+		// Execute takes care of the observable and respects the parallel jobs limit
+		a.Execute(job)
+	}
+
+	result := job.Result.WaitResult()
+	if result.Error != nil {
+		xlog.Error("Resumed job failed", "agent", a.Character.Name, "job", job.UUID, "error", result.Error)
+		return
+	}
+	if result.Response == "" {
+		return
+	}
+
+	a.newConversations <- openai.ChatCompletionMessage{
+		Role:    AssistantRole,
+		Content: result.Response,
+	}
+}
+
+func marshalCheckpointField(v interface{}) datatypes.JSON {
+	b, err := json.Marshal(v)
+	if err != nil {
+		xlog.Warn("Failed to marshal job checkpoint field", "error", err)
+		return nil
+	}
+	return datatypes.JSON(b)
+}
+
+func unmarshalCheckpointField(data datatypes.JSON, v interface{}) error {
+	if len(data) == 0 || string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,246 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/core/types"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/llm"
+	"github.com/mudler/LocalAGI/pkg/utils"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/mudler/LocalAGI/pkg/xstrings"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+const (
+	// share of the context window left to the completion
+	completionReserve = 0.2
+	// share of the context window a single tool result can take
+	maxToolResultShare = 0.25
+	// share of the budget kept verbatim when summarizing older turns
+	recentMessagesShare = 0.5
+)
+
+const compactionPrompt = `Summarize the following conversation between a user and an AI agent. Keep every fact, decision, result of tools and open question that could be needed to continue the conversation. Be concise and do not add anything that is not in the conversation.`
+
+func (a *Agent) contextWindow(model string) int {
+	if a.options.contextWindow > 0 {
+		return a.options.contextWindow
+	}
+	return llm.ContextWindow(model)
+}
+
+// fitToContext compacts the conversation so that a request with the given
+// tools fits in the context window of model:
+//   - oversized tool results are truncated
+//   - older turns are summarized into a single message
+//   - as a last resort, the oldest turns are dropped
+//
+// The leading system messages and the last message are always kept.
+func (a *Agent) fitToContext(ctx context.Context, model string, conv []openai.ChatCompletionMessage, tools []openai.Tool) []openai.ChatCompletionMessage {
+	window := a.contextWindow(model)
+	budget := int(float64(window) * (1 - completionReserve))
+
+	before := llm.EstimateConversationTokens(conv, tools)
+	if before <= budget {
+		return conv
+	}
+
+	result := &types.CompactionResult{
+		ContextWindow: window,
+		TokensBefore:  before,
+	}
+
+	compacted := truncateToolResults(conv, int(float64(window)*maxToolResultShare), result)
+	if llm.EstimateConversationTokens(compacted, tools) > budget {
+		compacted = a.summarizeOlderTurns(ctx, compacted, tools, budget, result)
+	}
+	if llm.EstimateConversationTokens(compacted, tools) > budget {
+		compacted = dropOldestTurns(compacted, tools, budget, result)
+	}
+
+	result.TokensAfter = llm.EstimateConversationTokens(compacted, tools)
+	a.reportCompaction(ctx, result)
+
+	return compacted
+}
+
+// generateJSON generates a JSON document following the schema with
+// llm.GenerateTypedJSONWithConversation, once the conversation is compacted
+// to fit the context window of model
+func (a *Agent) generateJSON(ctx context.Context, model string, conv []openai.ChatCompletionMessage, schema jsonschema.Definition, dst any) error {
+	conv = a.fitToContext(ctx, model, conv, []openai.Tool{llm.JSONTool(schema)})
+	return llm.GenerateTypedJSONWithConversation(ctx, a.client, conv, model, a.options.userID, a.options.agentID, schema, dst)
+}
+
+// generateJSONWithGuidance is generateJSON for a conversation made of the
+// guidance alone
+func (a *Agent) generateJSONWithGuidance(ctx context.Context, model string, guidance string, schema jsonschema.Definition, dst any) error {
+	return a.generateJSON(ctx, model, []openai.ChatCompletionMessage{{Role: UserRole, Content: guidance}}, schema, dst)
+}
+
+// truncateToolResults cuts the tool results longer than maxTokens
+func truncateToolResults(conv []openai.ChatCompletionMessage, maxTokens int, result *types.CompactionResult) []openai.ChatCompletionMessage {
+	compacted := make([]openai.ChatCompletionMessage, len(conv))
+	copy(compacted, conv)
+
+	maxChars := maxTokens * 4
+	for i, msg := range compacted {
+		if msg.Role != openai.ChatMessageRoleTool || len(msg.Content) <= maxChars {
+			continue
+		}
+
+		kept := xstrings.Truncate(msg.Content, maxChars)
+		omitted := utf8.RuneCountInString(msg.Content[len(kept):])
+		msg.Content = kept + fmt.Sprintf("\n\n[truncated: %d characters of the tool result were omitted]", omitted)
+		compacted[i] = msg
+		result.TruncatedResults++
+	}
+
+	return compacted
+}
+
+// leadingSystemMessages returns how many messages at the start of the conversation are system messages
+func leadingSystemMessages(conv []openai.ChatCompletionMessage) int {
+	n := 0
+	for n < len(conv)-1 && conv[n].Role == SystemRole {
+		n++
+	}
+	return n
+}
+
+// summarizeOlderTurns replaces the messages between the leading system
+// messages and the most recent turns with a summary written by the LLM
+func (a *Agent) summarizeOlderTurns(ctx context.Context, conv []openai.ChatCompletionMessage, tools []openai.Tool, budget int, result *types.CompactionResult) []openai.ChatCompletionMessage {
+	head := leadingSystemMessages(conv)
+
+	// Keep the most recent messages verbatim, within their share of the budget
+	recentBudget := int(float64(budget) * recentMessagesShare)
+	start := len(conv) - 1
+	tokens := llm.EstimateMessageTokens(conv[start])
+	for start > head {
+		t := llm.EstimateMessageTokens(conv[start-1])
+		if tokens+t > recentBudget {
+			break
+		}
+		tokens += t
+		start--
+	}
+	// Tool results can't be separated from the call that produced them
+	for start < len(conv)-1 && conv[start].Role == openai.ChatMessageRoleTool {
+		start++
+	}
+
+	older := conv[head:start]
+	if len(older) == 0 {
+		return conv
+	}
+
+	transcript := Messages(older).String()
+	// The transcript itself has to fit in the summarization request
+	transcript = xstrings.TruncateStart(transcript, budget*4)
+
+	summary, err := a.summarize(ctx, transcript)
+	if err != nil {
+		xlog.Warn("Failed to summarize older turns", "agent", a.Character.Name, "error", err)
+		return conv
+	}
+
+	compacted := make([]openai.ChatCompletionMessage, 0, head+1+len(conv)-start)
+	compacted = append(compacted, conv[:head]...)
+	compacted = append(compacted, openai.ChatCompletionMessage{
+		Role:    SystemRole,
+		Content: "Summary of the earlier conversation:\n" + summary,
+	})
+	compacted = append(compacted, conv[start:]...)
+
+	result.SummarizedMessages += len(older)
+	return compacted
+}
+
+func (a *Agent) summarize(ctx context.Context, transcript string) (string, error) {
+	model := a.options.modelFor(PhaseSummarize)
+	resp, err := a.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: SystemRole, Content: compactionPrompt},
+			{Role: UserRole, Content: transcript},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) != 1 || resp.Choices[0].Message.Content == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+
+	usage := utils.GetOpenRouterUsage(resp.ID)
+	llm.TrackUsage(ctx, &models.LLMUsage{
+		ID:               uuid.New(),
+		UserID:           a.options.userID,
+		AgentID:          a.options.agentID,
+		Model:            llm.ServedModel(resp, model),
+		Provider:         llm.ServedProvider(resp.Header()),
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+		Cost:             usage.Cost,
+		RequestType:      PhaseSummarize,
+		GenID:            resp.ID,
+		CreatedAt:        time.Now(),
+	})
+
+	return resp.Choices[0].Message.Content, nil
+}
+
+// dropOldestTurns removes the oldest messages after the leading system
+// messages until the conversation fits the budget
+func dropOldestTurns(conv []openai.ChatCompletionMessage, tools []openai.Tool, budget int, result *types.CompactionResult) []openai.ChatCompletionMessage {
+	head := leadingSystemMessages(conv)
+	compacted := make([]openai.ChatCompletionMessage, len(conv))
+	copy(compacted, conv)
+
+	for len(compacted)-head > 1 && llm.EstimateConversationTokens(compacted, tools) > budget {
+		compacted = append(compacted[:head], compacted[head+1:]...)
+		result.DroppedMessages++
+		// don't leave tool results without their call
+		for len(compacted)-head > 1 && compacted[head].Role == openai.ChatMessageRoleTool {
+			compacted = append(compacted[:head], compacted[head+1:]...)
+			result.DroppedMessages++
+		}
+	}
+
+	return compacted
+}
+
+func (a *Agent) reportCompaction(ctx context.Context, result *types.CompactionResult) {
+	xlog.Info("Compacted conversation to fit the context window",
+		"agent", a.Character.Name,
+		"context_window", result.ContextWindow,
+		"tokens_before", result.TokensBefore,
+		"tokens_after", result.TokensAfter,
+		"truncated_results", result.TruncatedResults,
+		"summarized_messages", result.SummarizedMessages,
+		"dropped_messages", result.DroppedMessages,
+	)
+
+	if a.observer == nil {
+		return
+	}
+
+	obs := a.observer.NewObservable()
+	obs.Name = "compaction"
+	obs.Icon = "compress"
+	if job := types.JobFromContext(ctx); job != nil && job.Obs != nil {
+		obs.ParentID = job.Obs.ID
+	}
+	obs.Completion = &types.Completion{
+		CompactionResult: result,
+	}
+	a.observer.Update(*obs)
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mudler/LocalAGI/core/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// longConversation is a conversation of turns messages of about 30 tokens
+// each, after a system message
+func longConversation(turns int) []openai.ChatCompletionMessage {
+	conv := []openai.ChatCompletionMessage{{Role: SystemRole, Content: "You are a tester"}}
+	for i := 0; i < turns; i++ {
+		role := UserRole
+		if i%2 == 1 {
+			role = AssistantRole
+		}
+		conv = append(conv, openai.ChatCompletionMessage{Role: role, Content: strings.Repeat("word ", 20)})
+	}
+	return conv
+}
+
+var _ = Describe("Context compaction", func() {
+	It("truncates the oversized tool results without splitting characters", func() {
+		conv := []openai.ChatCompletionMessage{
+			{Role: UserRole, Content: "search"},
+			{Role: openai.ChatMessageRoleTool, Content: strings.Repeat("€", 100)},
+		}
+		result := &types.CompactionResult{}
+
+		compacted := truncateToolResults(conv, 10, result)
+		Expect(result.TruncatedResults).To(Equal(1))
+		Expect(utf8.ValidString(compacted[1].Content)).To(BeTrue())
+		Expect(compacted[1].Content).To(HavePrefix(strings.Repeat("€", 13) + "\n\n[truncated: 87 characters"))
+		Expect(conv[1].Content).To(Equal(strings.Repeat("€", 100)))
+	})
+
+	It("drops the oldest turns, keeping the system message, the last message and the tool calls", func() {
+		conv := []openai.ChatCompletionMessage{
+			{Role: SystemRole, Content: "You are a tester"},
+			{Role: AssistantRole, ToolCalls: []openai.ToolCall{{ID: "1", Function: openai.FunctionCall{Name: "search"}}}},
+			{Role: openai.ChatMessageRoleTool, Content: strings.Repeat("result ", 20), ToolCallID: "1"},
+			{Role: UserRole, Content: strings.Repeat("question ", 20)},
+		}
+		result := &types.CompactionResult{}
+
+		compacted := dropOldestTurns(conv, nil, 30, result)
+		Expect(compacted).To(HaveLen(2))
+		Expect(compacted[0].Role).To(Equal(SystemRole))
+		Expect(compacted[1].Role).To(Equal(UserRole))
+		Expect(result.DroppedMessages).To(Equal(2))
+	})
+
+	It("leaves the conversations fitting the context window untouched", func() {
+		llm := newFakeLLM()
+		a := newTestAgent(llm, WithContextWindow(100000))
+
+		conv := longConversation(10)
+		Expect(a.fitToContext(context.Background(), "fake", conv, nil)).To(Equal(conv))
+		Expect(llm.Requests()).To(BeEmpty())
+	})
+
+	It("summarizes the older turns of the conversation", func() {
+		llm := newFakeLLM(textReply("the user and the agent talked about words"))
+		a := newTestAgent(llm, WithContextWindow(200))
+
+		conv := longConversation(10)
+		compacted := a.fitToContext(context.Background(), "fake", conv, nil)
+		Expect(len(compacted)).To(BeNumerically("<", len(conv)))
+		Expect(compacted[0]).To(Equal(conv[0]))
+		Expect(compacted[1].Content).To(ContainSubstring("the user and the agent talked about words"))
+		Expect(compacted[len(compacted)-1]).To(Equal(conv[len(conv)-1]))
+	})
+
+	It("compacts the conversations of the JSON generations", func() {
+		llm := newFakeLLM(
+			textReply("the user and the agent talked about words"),
+			toolReply("json", `{"answer":"words"}`),
+		)
+		a := newTestAgent(llm, WithContextWindow(300))
+
+		var result struct {
+			Answer string `json:"answer"`
+		}
+		schema := jsonschema.Definition{
+			Type:       jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{"answer": {Type: jsonschema.String}},
+		}
+		conv := longConversation(16)
+		Expect(a.generateJSON(context.Background(), "fake", conv, schema, &result)).To(Succeed())
+		Expect(result.Answer).To(Equal("words"))
+
+		requests := llm.Requests()
+		Expect(requests).To(HaveLen(2))
+		Expect(len(requests[1].Messages)).To(BeNumerically("<", len(conv)))
+		Expect(requests[1].Messages[1].Content).To(ContainSubstring("the user and the agent talked about words"))
+	})
+})
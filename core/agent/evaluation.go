@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/mudler/LocalAGI/core/types"
@@ -52,7 +53,7 @@ Consider the entire conversation history to understand the complete context and
 Focus on identifying the primary objective and any specific requirements or limitations mentioned.`
 
 	var result GoalExtraction
-	err := llm.GenerateTypedJSONWithConversation(job.GetContext(), a.client,
+	err := a.generateJSON(llm.WithRequestType(job.GetContext(), PhaseEvaluate), a.options.modelFor(PhaseEvaluate),
 		append(
 			[]openai.ChatCompletionMessage{
 				{
@@ -60,7 +61,7 @@ Focus on identifying the primary objective and any specific requirements or limi
 					Content: prompt,
 				},
 			},
-			conv...), a.options.LLMAPI.Model, a.options.userID, a.options.agentID, schema, &result)
+			conv...), schema, &result)
 	if err != nil {
 		return nil, fmt.Errorf("error extracting goal: %w", err)
 	}
@@ -114,7 +115,7 @@ Provide a detailed evaluation with specific gaps if any are found.`,
 		goal.Context)
 
 	var result EvaluationResult
-	err = llm.GenerateTypedJSONWithConversation(job.GetContext(), a.client,
+	err = a.generateJSON(llm.WithRequestType(job.GetContext(), PhaseEvaluate), a.options.modelFor(PhaseEvaluate),
 		append(
 			[]openai.ChatCompletionMessage{
 				{
@@ -122,8 +123,7 @@ Provide a detailed evaluation with specific gaps if any are found.`,
 					Content: prompt,
 				},
 			},
-			conv...),
-		a.options.LLMAPI.Model, a.options.userID, a.options.agentID, schema, &result)
+			conv...), schema, &result)
 	if err != nil {
 		return nil, fmt.Errorf("error generating evaluation: %w", err)
 	}
@@ -131,27 +131,102 @@ Provide a detailed evaluation with specific gaps if any are found.`,
 	return &result, nil
 }
 
+// runEvaluators runs the configured evaluators on the outcome of the job
+// and records their verdicts on the job observable
+func (a *Agent) runEvaluators(job *types.Job, conv []openai.ChatCompletionMessage) []types.EvaluationVerdict {
+	if len(a.options.evaluators) == 0 {
+		return nil
+	}
+
+	input := types.EvaluationInput{
+		Job:          job,
+		Conversation: conv,
+		Judge: func(ctx context.Context, prompt string, schema jsonschema.Definition, dst any) error {
+			return a.generateJSONWithGuidance(llm.WithRequestType(ctx, PhaseEvaluate), a.options.modelFor(PhaseEvaluate),
+				prompt, schema, dst)
+		},
+	}
+	for i := len(conv) - 1; i >= 0; i-- {
+		if conv[i].Role == "assistant" && conv[i].Content != "" {
+			input.Response = conv[i].Content
+			break
+		}
+	}
+	for _, step := range job.GetSteps() {
+		input.Actions = append(input.Actions, step.Action)
+	}
+
+	verdicts := []types.EvaluationVerdict{}
+	for _, evaluator := range a.options.evaluators {
+		verdict, err := evaluator.Evaluate(job.GetContext(), input)
+		if err != nil {
+			xlog.Warn("Evaluator failed", "agent", a.Character.Name, "evaluator", evaluator.Name(), "error", err)
+			verdict = types.EvaluationVerdict{
+				Reason: fmt.Sprintf("the evaluation failed: %v", err),
+			}
+		}
+		verdict.Evaluator = evaluator.Name()
+		verdicts = append(verdicts, verdict)
+	}
+
+	if job.Obs != nil {
+		job.Obs.AddProgress(types.Progress{
+			Evaluations: verdicts,
+		})
+		a.observer.Update(*job.Obs)
+	}
+
+	return verdicts
+}
+
+// handleEvaluation evaluates the outcome of the job. It returns false, with
+// the gaps found added to the conversation, when the job has to loop to
+// address them. After the last loop the evaluators still judge the response:
+// the verdicts it failed are set on the job result instead.
 func (a *Agent) handleEvaluation(job *types.Job, conv []openai.ChatCompletionMessage, currentLoop int) (bool, []openai.ChatCompletionMessage, error) {
-	if !a.options.enableEvaluation || currentLoop >= a.options.maxEvaluationLoops {
+	lastLoop := currentLoop >= a.options.maxEvaluationLoops
+	if len(a.options.evaluators) == 0 && (!a.options.enableEvaluation || lastLoop) {
 		return true, conv, nil
 	}
 
-	result, err := a.evaluateJob(job, conv)
-	if err != nil {
-		return false, conv, err
+	gaps := []string{}
+	reasoning := ""
+
+	// the LLM judge can only ask for another loop
+	if a.options.enableEvaluation && !lastLoop {
+		result, err := a.evaluateJob(job, conv)
+		if err != nil {
+			return false, conv, err
+		}
+		if !result.Satisfied {
+			gaps = append(gaps, result.Gaps...)
+			reasoning = result.Reasoning
+		}
+	}
+
+	failed := []types.EvaluationVerdict{}
+	for _, verdict := range a.runEvaluators(job, conv) {
+		if !verdict.Passed {
+			failed = append(failed, verdict)
+			gaps = append(gaps, fmt.Sprintf("%s: %s", verdict.Evaluator, verdict.Reason))
+		}
 	}
 
-	if result.Satisfied {
+	if lastLoop {
+		if len(failed) > 0 {
+			xlog.Warn("Response failed its evaluation after the last loop", "agent", a.Character.Name, "loops", currentLoop, "gaps", gaps)
+			job.Result.SetFailedEvaluations(failed)
+		}
 		return true, conv, nil
 	}
 
 	// If there are gaps, we need to address them
-	if len(result.Gaps) > 0 {
+	if len(gaps) > 0 {
 		// Add the evaluation result to the conversation
 		conv = append(conv, openai.ChatCompletionMessage{
 			Role: "system",
 			Content: fmt.Sprintf("Evaluation found gaps that need to be addressed:\n%s\nReasoning: %s",
-				result.Gaps, result.Reasoning),
+				gaps, reasoning),
 		})
 
 		xlog.Debug("Evaluation found gaps, incrementing loop count", "loop", currentLoop+1)
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mudler/LocalAGI/core/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// containsEvaluator passes the responses containing its word
+type containsEvaluator struct {
+	word  string
+	calls atomic.Int32
+}
+
+func (c *containsEvaluator) Name() string {
+	return "contains_" + c.word
+}
+
+func (c *containsEvaluator) Evaluate(ctx context.Context, input types.EvaluationInput) (types.EvaluationVerdict, error) {
+	c.calls.Add(1)
+	if strings.Contains(input.Response, c.word) {
+		return types.EvaluationVerdict{Passed: true}, nil
+	}
+	return types.EvaluationVerdict{Reason: "the response must mention " + c.word}, nil
+}
+
+var _ = Describe("Job evaluation", func() {
+	It("loops until the evaluators pass", func() {
+		evaluator := &containsEvaluator{word: "banana"}
+		llm := newFakeLLM(textReply("an apple"), textReply("a banana"))
+		a := newTestAgent(llm, EnableNativeToolCalls, WithEvaluators(evaluator), WithMaxEvaluationLoops(2))
+
+		result := a.Ask(types.WithText("name a yellow fruit"))
+		Expect(result.Error).ToNot(HaveOccurred())
+		Expect(result.Response).To(Equal("a banana"))
+		Expect(result.FailedEvaluations).To(BeEmpty())
+		Expect(evaluator.calls.Load()).To(Equal(int32(2)))
+	})
+
+	It("evaluates the response of the last loop and flags the verdicts it failed", func() {
+		evaluator := &containsEvaluator{word: "banana"}
+		llm := newFakeLLM(textReply("an apple"), textReply("a pear"))
+		a := newTestAgent(llm, EnableNativeToolCalls, WithEvaluators(evaluator), WithMaxEvaluationLoops(1))
+
+		result := a.Ask(types.WithText("name a yellow fruit"))
+		Expect(result.Error).ToNot(HaveOccurred())
+		Expect(result.Response).To(Equal("a pear"))
+		Expect(evaluator.calls.Load()).To(Equal(int32(2)))
+		Expect(result.FailedEvaluations).To(HaveLen(1))
+		Expect(result.FailedEvaluations[0].Evaluator).To(Equal("contains_banana"))
+		Expect(result.FailedEvaluations[0].Reason).To(ContainSubstring("banana"))
+	})
+
+	It("evaluates the response even without evaluation loops", func() {
+		evaluator := &containsEvaluator{word: "banana"}
+		llm := newFakeLLM(textReply("an apple"))
+		a := newTestAgent(llm, EnableNativeToolCalls, WithEvaluators(evaluator), WithMaxEvaluationLoops(0))
+
+		result := a.Ask(types.WithText("name a yellow fruit"))
+		Expect(result.Response).To(Equal("an apple"))
+		Expect(evaluator.calls.Load()).To(Equal(int32(1)))
+		Expect(result.FailedEvaluations).To(HaveLen(1))
+	})
+
+	It("writes the response after the actions the same way as without evaluators", func() {
+		replies := func() *fakeLLM {
+			return newFakeLLM(
+				toolReply("count", `{"what":"sheep"}`),
+				textReply("no more actions"),
+				textReply("I counted 3 sheep"),
+			)
+		}
+		evaluator := &containsEvaluator{word: "sheep"}
+
+		plain := replies()
+		result := newTestAgent(plain, EnableNativeToolCalls, WithActions(&countAction{name: "count"})).
+			Ask(types.WithText("count the sheep"))
+		Expect(result.Error).ToNot(HaveOccurred())
+		Expect(result.Response).To(Equal("I counted 3 sheep"))
+
+		evaluated := replies()
+		result = newTestAgent(evaluated, EnableNativeToolCalls, WithActions(&countAction{name: "count"}), WithEvaluators(evaluator)).
+			Ask(types.WithText("count the sheep"))
+		Expect(result.Error).ToNot(HaveOccurred())
+		Expect(result.Response).To(Equal("I counted 3 sheep"))
+		Expect(result.FailedEvaluations).To(BeEmpty())
+		Expect(evaluator.calls.Load()).To(Equal(int32(1)))
+
+		Expect(evaluated.Requests()).To(HaveLen(3))
+		Expect(evaluated.Requests()[2].Messages).To(Equal(plain.Requests()[2].Messages))
+	})
+})
@@ -0,0 +1,189 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// fakeLLM is a LLM API answering the chat completions with scripted replies,
+// in order, so that jobs run offline. Once the script is over it answers
+// with fallback. The embeddings are derived from the words of the input, so
+// texts sharing words are similar.
+type fakeLLM struct {
+	sync.Mutex
+	replies  []fakeReply
+	fallback string
+	requests []openai.ChatCompletionRequest
+	embedded []string
+	// embeddingError, when set, is returned to the embedding requests
+	embeddingError string
+}
+
+// fakeReply is a message returned by the fake LLM, or an HTTP error
+type fakeReply struct {
+	message openai.ChatCompletionMessage
+	status  int
+	body    string
+}
+
+func newFakeLLM(replies ...fakeReply) *fakeLLM {
+	return &fakeLLM{replies: replies, fallback: "done"}
+}
+
+func textReply(content string) fakeReply {
+	return fakeReply{message: openai.ChatCompletionMessage{Role: AssistantRole, Content: content}}
+}
+
+func toolReply(name, arguments string) fakeReply {
+	return fakeReply{message: openai.ChatCompletionMessage{
+		Role: AssistantRole,
+		ToolCalls: []openai.ToolCall{{
+			ID:       "call_" + name,
+			Type:     openai.ToolTypeFunction,
+			Function: openai.FunctionCall{Name: name, Arguments: arguments},
+		}},
+	}}
+}
+
+func errorReply(status int, body string) fakeReply {
+	return fakeReply{status: status, body: body}
+}
+
+// fakeLLMOptions point the agent to the fake LLM
+func fakeLLMOptions(llm *fakeLLM) []Option {
+	return []Option{
+		WithLLMAPIURL("http://fake-llm/v1"),
+		WithModel("fake"),
+		WithLLMTransport(llm),
+		WithCharacter(Character{Name: "tester"}),
+	}
+}
+
+// Requests returns the chat completion requests received so far
+func (f *fakeLLM) Requests() []openai.ChatCompletionRequest {
+	f.Lock()
+	defer f.Unlock()
+	return append([]openai.ChatCompletionRequest{}, f.requests...)
+}
+
+// Embedded returns the texts embedded so far
+func (f *fakeLLM) Embedded() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string{}, f.embedded...)
+}
+
+func (f *fakeLLM) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/embeddings"):
+		var request openai.EmbeddingRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		return f.embeddings(request)
+	case strings.HasSuffix(req.URL.Path, "/chat/completions"):
+		var request openai.ChatCompletionRequest
+		if err := json.Unmarshal(body, &request); err != nil {
+			return nil, err
+		}
+		return f.chat(request)
+	}
+	return fakeResponse(http.StatusNotFound, map[string]string{"error": "not found"})
+}
+
+func (f *fakeLLM) chat(request openai.ChatCompletionRequest) (*http.Response, error) {
+	f.Lock()
+	f.requests = append(f.requests, request)
+	reply := textReply(f.fallback)
+	if len(f.replies) > 0 {
+		reply, f.replies = f.replies[0], f.replies[1:]
+	}
+	f.Unlock()
+
+	if reply.status != 0 {
+		return fakeResponse(reply.status, map[string]any{"error": map[string]string{"message": reply.body}})
+	}
+
+	finishReason := openai.FinishReasonStop
+	if len(reply.message.ToolCalls) > 0 {
+		finishReason = openai.FinishReasonToolCalls
+	}
+	return fakeResponse(http.StatusOK, openai.ChatCompletionResponse{
+		ID:     "fake",
+		Object: "chat.completion",
+		Model:  request.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      reply.message,
+			FinishReason: finishReason,
+		}},
+		Usage: openai.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+	})
+}
+
+func (f *fakeLLM) embeddings(request openai.EmbeddingRequest) (*http.Response, error) {
+	var inputs []string
+	switch input := request.Input.(type) {
+	case string:
+		inputs = []string{input}
+	case []any:
+		for _, i := range input {
+			s, _ := i.(string)
+			inputs = append(inputs, s)
+		}
+	}
+
+	f.Lock()
+	f.embedded = append(f.embedded, inputs...)
+	embeddingError := f.embeddingError
+	f.Unlock()
+
+	if embeddingError != "" {
+		return fakeResponse(http.StatusBadRequest, map[string]any{"error": map[string]string{"message": embeddingError}})
+	}
+
+	response := openai.EmbeddingResponse{Object: "list", Model: request.Model}
+	for i, input := range inputs {
+		response.Data = append(response.Data, openai.Embedding{
+			Object:    "embedding",
+			Index:     i,
+			Embedding: wordsEmbedding(input),
+		})
+	}
+	return fakeResponse(http.StatusOK, response)
+}
+
+// wordsEmbedding hashes the words of a text into a small vector
+func wordsEmbedding(s string) []float32 {
+	embedding := make([]float32, 32)
+	for _, word := range strings.Fields(strings.ToLower(s)) {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		embedding[h.Sum32()%32]++
+	}
+	embedding[0] += 0.01
+	return embedding
+}
+
+func fakeResponse(status int, v any) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader(body)),
+	}, nil
+}
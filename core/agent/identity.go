@@ -2,17 +2,14 @@ package agent
 
 import (
 	"fmt"
-
-	"github.com/google/uuid"
-	"github.com/mudler/LocalAGI/pkg/llm"
 )
 
-func (a *Agent) generateIdentity(guidance string, userID, agentID uuid.UUID, model string) error {
+func (a *Agent) generateIdentity(guidance string, model string) error {
 	if guidance == "" {
 		guidance = "Generate a random character for roleplaying."
 	}
 
-	err := llm.GenerateTypedJSONWithGuidance(a.context.Context, a.client, "Generate a character as JSON data. "+guidance, model, userID, agentID, a.options.character.ToJSONSchema(), &a.options.character)
+	err := a.generateJSONWithGuidance(a.context.Context, model, "Generate a character as JSON data. "+guidance, a.options.character.ToJSONSchema(), &a.options.character)
 	//err := llm.GenerateJSONFromStruct(a.context.Context, a.client, guidance, a.options.LLMAPI.Model, &a.options.character)
 	a.Character = a.options.character
 
@@ -43,7 +40,7 @@ func (a *Agent) prepareIdentity() error {
 	}
 
 	// No character found, generate a new one
-	if err := a.generateIdentity(a.options.randomIdentityGuidance, a.options.userID, a.options.agentID, a.options.LLMAPI.Model); err != nil {
+	if err := a.generateIdentity(a.options.randomIdentityGuidance, a.options.LLMAPI.Model); err != nil {
 		return fmt.Errorf("failed to generate identity: %v", err)
 	}
 
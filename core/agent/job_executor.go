@@ -0,0 +1,603 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mudler/LocalAGI/core/action"
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/sashabaranov/go-openai"
+)
+
+// jobState is a state of the job step-machine
+type jobState string
+
+const (
+	jobStatePick       jobState = "pick"
+	jobStateParams     jobState = "params"
+	jobStateRun        jobState = "run"
+	jobStateReEvaluate jobState = "re-evaluate"
+	jobStateEvaluate   jobState = "evaluate"
+	jobStateReply      jobState = "reply"
+	jobStateDone       jobState = "done"
+)
+
+// jobExecution carries a job through the step-machine:
+//
+//	pick -> params -> run -> re-evaluate -> evaluate -> reply
+//
+// Every state returns the next one. States that finish the job
+// return jobStateDone. Before each state the job budget is checked.
+type jobExecution struct {
+	agent *Agent
+	job   *types.Job
+	role  string
+	conv  Messages
+
+	pickTemplate         string
+	reEvaluationTemplate string
+
+	startedAt time.Time
+
+	chosenAction  types.Action
+	actionParams  types.ActionParams
+	reasoning     string
+	paramAttempts int
+
+	// answered is set when the LLM replied without picking any action
+	answered bool
+}
+
+func (a *Agent) newJobExecution(job *types.Job, role string, conv Messages) *jobExecution {
+	e := &jobExecution{
+		agent:                a,
+		job:                  job,
+		role:                 role,
+		conv:                 conv,
+		pickTemplate:         pickActionTemplate,
+		reEvaluationTemplate: reEvalTemplate,
+		startedAt:            time.Now(),
+	}
+
+	if role == SystemRole {
+		e.pickTemplate = pickSelfTemplate
+		e.reEvaluationTemplate = reSelfEvalTemplate
+	}
+
+	job.Budget = job.Budget.Merge(a.options.jobBudget)
+
+	return e
+}
+
+func (e *jobExecution) run() {
+	if e.job.Budget.MaxDuration > 0 {
+		// Cancel in-flight LLM and action calls when running out of time
+		timer := time.AfterFunc(e.job.Budget.MaxDuration, e.job.Cancel)
+		defer timer.Stop()
+	}
+
+	state := jobStatePick
+	for state != jobStateDone {
+		if exceeded := e.exceededBudget(state); exceeded != nil {
+			e.finishOverBudget(exceeded)
+			return
+		}
+
+		if err := e.job.GetContext().Err(); err != nil {
+			e.job.Result.Finish(jobError(e.job, fmt.Errorf("expired")))
+			return
+		}
+
+		xlog.Debug("Job step", "agent", e.agent.Character.Name, "job", e.job.UUID, "state", state)
+		e.agent.updateJob(e.job, func(j *activeJob) {
+			j.step = string(state)
+			j.action = ""
+			if state == jobStateRun && e.chosenAction != nil {
+				j.action = e.chosenAction.Definition().Name.String()
+			}
+			// the executor keeps appending to its conversation
+			j.conv = append(Messages{}, e.conv...)
+		})
+		state = e.step(state)
+	}
+}
+
+func (e *jobExecution) step(state jobState) jobState {
+	switch state {
+	case jobStatePick:
+		return e.pick()
+	case jobStateParams:
+		return e.params()
+	case jobStateRun:
+		return e.runAction()
+	case jobStateReEvaluate:
+		return e.reEvaluate()
+	case jobStateEvaluate:
+		return e.evaluate()
+	case jobStateReply:
+		return e.reply()
+	}
+
+	return e.fail(fmt.Errorf("unknown job state: %s", state))
+}
+
+// exceededBudget checks the job budget before entering the next state.
+// Steps are accounted before running an action and LLM calls before any
+// other state, except the reply: a job which used all its steps or LLM
+// calls can still reply. Duration and cost always stop the job.
+func (e *jobExecution) exceededBudget(next jobState) *types.BudgetExceeded {
+	usage := e.job.Usage()
+
+	steps, calls := 0, 0
+	if next == jobStateRun {
+		steps = len(e.job.GetSteps())
+	}
+	if next != jobStateReply {
+		calls = usage.Calls()
+	}
+
+	return e.job.Budget.Check(steps, calls, time.Since(e.startedAt), usage.Cost())
+}
+
+func (e *jobExecution) finishOverBudget(exceeded *types.BudgetExceeded) {
+	xlog.Warn("Job budget exceeded, stopping", "agent", e.agent.Character.Name, "job", e.job.UUID, "budget", exceeded.Budget, "limit", exceeded.Limit, "used", exceeded.Used)
+
+	e.job.Result.BudgetExceeded = exceeded
+	e.job.Result.Conversation = e.conv
+	e.job.Result.Finish(exceeded)
+}
+
+// fail finishes the job with an error. If the error was caused by the job
+// running out of budget (e.g. a canceled call), the budget is reported instead.
+func (e *jobExecution) fail(err error) jobState {
+	if exceeded := e.exceededBudget(jobStateDone); exceeded != nil {
+		e.finishOverBudget(exceeded)
+		return jobStateDone
+	}
+
+	e.job.Result.Finish(jobError(e.job, err))
+	return jobStateDone
+}
+
+func (e *jobExecution) pick() jobState {
+	a := e.agent
+	e.answered = false
+
+	if e.job.HasNextAction() {
+		// if we are being re-evaluated, we already have the action
+		// and the reasoning. Consume it here and reset it
+		action, params, reasoning := e.job.GetNextAction()
+		e.chosenAction = *action
+		e.reasoning = reasoning
+		e.actionParams = nil
+		if params != nil {
+			e.actionParams = *params
+		}
+		e.job.ResetNextAction()
+	} else if queued := e.job.PopQueuedAction(); queued != nil {
+		// another tool call returned by the model in the same turn
+		e.chosenAction = queued.Action
+		e.actionParams = *queued.Params
+	} else {
+		chosenAction, actionParams, reasoning, err := a.pickAction(e.job, e.pickTemplate, e.conv, maxRetries)
+		if err != nil {
+			xlog.Error("Error picking action", "error", err)
+			return e.fail(err)
+		}
+		e.chosenAction = chosenAction
+		e.actionParams = actionParams
+		e.reasoning = reasoning
+	}
+	e.paramAttempts = 0
+
+	if e.chosenAction == nil {
+		return e.answer()
+	}
+
+	if e.chosenAction.Definition().Name.Is(action.StopActionName) {
+		xlog.Info("LLM decided to stop")
+		e.job.Result.Finish(nil)
+		return jobStateDone
+	}
+
+	return jobStateParams
+}
+
+// answer handles the case where no action was picked: the reasoning is the
+// message returned by the assistant, or we ask the LLM for one.
+func (e *jobExecution) answer() jobState {
+	a := e.agent
+	xlog.Info("No action to do, just reply", "agent", a.Character.Name, "reasoning", e.reasoning)
+
+	if e.job.ResponseSchema != nil || e.reasoning == "" {
+		return e.writeReply()
+	}
+
+	e.conv = append(e.conv, openai.ChatCompletionMessage{
+		Role:    "assistant",
+		Content: a.cleanupLLMResponse(e.reasoning),
+	})
+	e.answered = true
+	return jobStateEvaluate
+}
+
+// writeReply asks the LLM for the response to the user, as reply does, and
+// adds it to the conversation so that it can be evaluated.
+func (e *jobExecution) writeReply() jobState {
+	msg, err := e.agent.generateReply(e.job, e.conv, e.actionParams, e.chosenAction, e.reasoning)
+	if err != nil {
+		return e.fail(fmt.Errorf("error asking LLM for a reply: %w", err))
+	}
+
+	e.conv = append(e.conv, msg)
+	e.reasoning = msg.Content
+	e.answered = true
+	return jobStateEvaluate
+}
+
+func (e *jobExecution) params() jobState {
+	a := e.agent
+
+	// if we force a reasoning, we need to generate the parameters,
+	// unless they already come from a native tool call
+	if (a.options.forceReasoning && !a.options.nativeToolCalls) || e.actionParams == nil {
+		xlog.Info("Generating parameters",
+			"agent", a.Character.Name,
+			"action", e.chosenAction.Definition().Name,
+			"reasoning", e.reasoning,
+		)
+
+		params, err := a.generateParameters(e.job, e.pickTemplate, e.chosenAction, e.conv, e.reasoning, maxRetries)
+		if err != nil {
+			e.paramAttempts++
+			if e.paramAttempts < maxRetries {
+				xlog.Error("Error generating parameters, trying again", "error", err, "attempt", e.paramAttempts)
+				e.actionParams = nil
+				return jobStateParams
+			}
+			return e.fail(fmt.Errorf("error generating parameters for %s: %w", e.chosenAction.Definition().Name, err))
+		}
+		e.actionParams = params.actionParams
+	}
+
+	xlog.Info(
+		"Generated parameters",
+		"agent", a.Character.Name,
+		"action", e.chosenAction.Definition().Name,
+		"reasoning", e.reasoning,
+		"params", e.actionParams.String(),
+	)
+
+	if e.actionParams == nil {
+		xlog.Error("No parameters", "agent", a.Character.Name)
+		return e.fail(fmt.Errorf("no parameters"))
+	}
+
+	if a.options.loopDetectionSteps > 0 && len(e.job.GetPastActions()) > 0 {
+		count := 0
+		for _, pastAction := range e.job.GetPastActions() {
+			if pastAction.Action.Definition().Name == e.chosenAction.Definition().Name &&
+				pastAction.Params.String() == e.actionParams.String() {
+				count++
+			}
+		}
+		if count >= a.options.loopDetectionSteps {
+			xlog.Info("Loop detected, stopping agent", "agent", a.Character.Name, "action", e.chosenAction.Definition().Name)
+			return jobStateReply
+		}
+		xlog.Debug("Checked for loops", "action", e.chosenAction.Definition().Name, "count", count)
+	}
+
+	params := e.actionParams
+	e.job.AddPastAction(e.chosenAction, &params)
+
+	if !e.job.Callback(types.ActionCurrentState{
+		Job:       e.job,
+		Action:    e.chosenAction,
+		Params:    e.actionParams,
+		Reasoning: e.reasoning}) {
+		e.job.Result.SetResult(types.ActionState{
+			ActionCurrentState: types.ActionCurrentState{
+				Job:       e.job,
+				Action:    e.chosenAction,
+				Params:    e.actionParams,
+				Reasoning: e.reasoning,
+			},
+			ActionResult: types.ActionResult{Result: "stopped by callback"}})
+		e.job.Result.Conversation = e.conv
+		e.job.Result.Finish(nil)
+		return jobStateDone
+	}
+
+	return jobStateRun
+}
+
+func (e *jobExecution) runAction() jobState {
+	a := e.agent
+	job := e.job
+
+	conv, err := a.handlePlanning(job.GetContext(), job, e.chosenAction, e.actionParams, e.reasoning, e.pickTemplate, e.conv)
+	if errors.Is(err, ErrActionDenied) {
+		job.Result.Conversation = conv
+		return e.fail(err)
+	}
+	if err != nil {
+		xlog.Error("error handling planning", "error", err)
+		e.conv = append(conv, openai.ChatCompletionMessage{
+			Role:    "assistant",
+			Content: fmt.Sprintf("Error handling planning: %v", err),
+		})
+		return jobStateReply
+	}
+	e.conv = conv
+
+	if e.role == SystemRole && a.options.initiateConversations &&
+		e.chosenAction.Definition().Name.Is(action.ConversationActionName) {
+
+		xlog.Info("LLM decided to initiate a new conversation", "agent", a.Character.Name)
+
+		message := action.ConversationActionResponse{}
+		if err := e.actionParams.Unmarshal(&message); err != nil {
+			xlog.Error("Error unmarshalling conversation response", "error", err)
+			return e.fail(fmt.Errorf("error unmarshalling conversation response: %w", err))
+		}
+
+		msg := openai.ChatCompletionMessage{
+			Role:    "assistant",
+			Content: message.Message,
+		}
+
+		go func(agent *Agent) {
+			xlog.Info("Sending new conversation to channel", "agent", agent.Character.Name, "message", msg.Content)
+			agent.newConversations <- msg
+		}(a)
+
+		job.Result.Conversation = []openai.ChatCompletionMessage{
+			msg,
+		}
+		job.Result.SetResponse("decided to initiate a new conversation")
+		job.Result.Finish(nil)
+		return jobStateDone
+	}
+
+	// if we have a reply action, we need to run it
+	if e.chosenAction.Definition().Name.Is(action.ReplyActionName) {
+		return jobStateReply
+	}
+
+	if !e.chosenAction.Definition().Name.Is(action.PlanActionName) {
+		// Check if this is a user-defined action
+		if types.IsActionUserDefined(e.chosenAction) {
+			xlog.Debug("User-defined action chosen, returning tool call", "action", e.chosenAction.Definition().Name)
+			a.replyWithToolCall(job, e.conv, e.actionParams, e.chosenAction, e.reasoning)
+			return jobStateDone
+		}
+
+		calls := []*types.ActionRequest{{Action: e.chosenAction, Params: &e.actionParams}}
+		if canRunInParallel(e.chosenAction) {
+			batch, ok := e.parallelBatch()
+			if !ok {
+				return jobStateDone
+			}
+			calls = append(calls, batch...)
+		}
+
+		if err := a.approveActions(job, calls, e.reasoning); err != nil {
+			job.Result.Conversation = e.conv
+			return e.fail(err)
+		}
+
+		results := e.runCalls(calls)
+		for i, call := range calls {
+			result := results[i].result
+			if results[i].err != nil {
+				result.Result = fmt.Sprintf("Error running tool: %v", results[i].err)
+			}
+
+			stateResult := types.ActionState{
+				ActionCurrentState: types.ActionCurrentState{
+					Job:       job,
+					Action:    call.Action,
+					Params:    *call.Params,
+					Reasoning: e.reasoning,
+				},
+				ActionResult: result,
+			}
+			job.Result.SetResult(stateResult)
+			job.CallbackWithResult(stateResult)
+			xlog.Debug("Action executed", "agent", a.Character.Name, "action", call.Action.Definition().Name, "result", result)
+
+			e.conv = a.addFunctionResultToConversation(call.Action, *call.Params, result, e.conv)
+			a.recordJobStep(job, call.Action, *call.Params, e.reasoning, result, results[i].err, e.conv)
+		}
+	}
+
+	return jobStateReEvaluate
+}
+
+type callResult struct {
+	result types.ActionResult
+	err    error
+}
+
+// canRunInParallel reports whether an action can run concurrently with
+// other tool calls: actions that drive the job itself or change the
+// agent state run alone.
+func canRunInParallel(act types.Action) bool {
+	name := act.Definition().Name
+	return !types.IsActionUserDefined(act) &&
+		!name.Is(action.PlanActionName) &&
+		!name.Is(action.ReplyActionName) &&
+		!name.Is(action.StopActionName) &&
+		!name.Is(action.ConversationActionName) &&
+		!name.Is(action.StateActionName)
+}
+
+// parallelBatch dequeues the tool calls of the current turn that can run
+// together with the chosen action, within the parallel tool calls limit and
+// the steps budget. Each call goes through the job callback as if picked on
+// its own; it returns false if the callback stopped the job.
+func (e *jobExecution) parallelBatch() ([]*types.ActionRequest, bool) {
+	a := e.agent
+	batch := []*types.ActionRequest{}
+
+	for 1+len(batch) < a.options.parallelToolCalls {
+		next := e.job.PeekQueuedAction()
+		if next == nil || !canRunInParallel(next.Action) {
+			break
+		}
+		if e.job.Budget.MaxSteps > 0 && len(e.job.GetSteps())+1+len(batch) >= e.job.Budget.MaxSteps {
+			break
+		}
+		e.job.PopQueuedAction()
+
+		e.job.AddPastAction(next.Action, next.Params)
+		if !e.job.Callback(types.ActionCurrentState{
+			Job:       e.job,
+			Action:    next.Action,
+			Params:    *next.Params,
+			Reasoning: e.reasoning}) {
+			e.job.Result.SetResult(types.ActionState{
+				ActionCurrentState: types.ActionCurrentState{
+					Job:       e.job,
+					Action:    next.Action,
+					Params:    *next.Params,
+					Reasoning: e.reasoning,
+				},
+				ActionResult: types.ActionResult{Result: "stopped by callback"}})
+			e.job.Result.Conversation = e.conv
+			e.job.Result.Finish(nil)
+			return nil, false
+		}
+
+		batch = append(batch, next)
+	}
+
+	return batch, true
+}
+
+// runCalls runs the tool calls concurrently, bounded by the parallel tool
+// calls limit of the agent, and returns their results in the same order.
+func (e *jobExecution) runCalls(calls []*types.ActionRequest) []callResult {
+	a := e.agent
+	results := make([]callResult, len(calls))
+
+	if len(calls) == 1 {
+		results[0].result, results[0].err = a.runAction(e.job, calls[0].Action, *calls[0].Params)
+		return results
+	}
+
+	xlog.Info("Running tool calls in parallel", "agent", a.Character.Name, "calls", len(calls))
+
+	limit := a.options.parallelToolCalls
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	for i, call := range calls {
+		wg.Add(1)
+		go func(i int, call *types.ActionRequest) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			results[i].result, results[i].err = a.runAction(e.job, call.Action, *call.Params)
+		}(i, call)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// reEvaluate asks the LLM, given the result of the action, if there is
+// another action to run before replying.
+func (e *jobExecution) reEvaluate() jobState {
+	a := e.agent
+
+	if e.job.HasQueuedActions() {
+		// run the remaining tool calls of the turn before asking again
+		return jobStatePick
+	}
+
+	followingAction, followingParams, reasoning, err := a.pickAction(e.job, e.reEvaluationTemplate, e.conv, maxRetries)
+	if err != nil {
+		e.job.Result.Conversation = e.conv
+		return e.fail(fmt.Errorf("error picking action: %w", err))
+	}
+	e.reasoning = reasoning
+
+	if followingAction != nil &&
+		!followingAction.Definition().Name.Is(action.ReplyActionName) &&
+		!e.chosenAction.Definition().Name.Is(action.ReplyActionName) {
+
+		xlog.Info("Following action", "action", followingAction.Definition().Name, "agent", a.Character.Name)
+		e.job.ConversationHistory = e.conv
+
+		if a.options.loopDetectionSteps > 0 && len(e.job.GetPastActions()) >= a.options.loopDetectionSteps {
+			xlog.Info("Loop detection: Maximum actions reached, stopping", "agent", a.Character.Name, "actions_count", len(e.job.GetPastActions()))
+			e.reasoning = "Reached maximum action limit"
+			return jobStateReply
+		}
+
+		// The agent decided to do another action
+		e.job.SetNextAction(&followingAction, &followingParams, reasoning)
+		a.checkpointJob(e.job, e.conv)
+		return jobStatePick
+	}
+
+	return jobStateEvaluate
+}
+
+func (e *jobExecution) evaluate() jobState {
+	a := e.agent
+
+	if !e.answered && len(a.options.evaluators) > 0 && e.job.GetEvaluationLoop() <= a.options.maxEvaluationLoops {
+		// the evaluators judge the response, write it before evaluating
+		return e.writeReply()
+	}
+
+	satisfied, conv, err := a.handleEvaluation(e.job, e.conv, e.job.GetEvaluationLoop())
+	if err != nil {
+		return e.fail(fmt.Errorf("error evaluating response: %w", err))
+	}
+	e.conv = conv
+
+	if !satisfied {
+		if !e.answered && a.options.loopDetectionSteps > 0 && e.job.GetEvaluationLoop() >= a.options.loopDetectionSteps {
+			xlog.Info("Loop detection: Maximum evaluation loops reached, stopping", "agent", a.Character.Name, "evaluation_loops", e.job.GetEvaluationLoop())
+			e.reasoning = "Reached maximum evaluation limit"
+			return jobStateReply
+		}
+
+		// If not satisfied, continue with the conversation
+		e.job.ConversationHistory = e.conv
+		e.job.IncrementEvaluationLoop()
+		a.checkpointJob(e.job, e.conv)
+		return jobStatePick
+	}
+
+	if e.answered {
+		xlog.Debug("Finish job with reasoning", "reasoning", e.reasoning, "agent", a.Character.Name, "conversation", fmt.Sprintf("%+v", e.conv))
+		e.job.Result.Conversation = e.conv
+		e.job.Result.AddFinalizer(func(conv []openai.ChatCompletionMessage) {
+			a.saveCurrentConversation(conv)
+		})
+		if e.job.ResponseSchema == nil {
+			e.job.Result.SetResponse(e.reasoning)
+		}
+		e.job.Result.Finish(nil)
+		return jobStateDone
+	}
+
+	return jobStateReply
+}
+
+func (e *jobExecution) reply() jobState {
+	e.agent.reply(e.job, e.role, e.conv, e.actionParams, e.chosenAction, e.reasoning)
+	return jobStateDone
+}
@@ -0,0 +1,195 @@
+package agent
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/pkg/llm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// countAction counts its runs
+type countAction struct {
+	name string
+	runs atomic.Int32
+}
+
+func (c *countAction) Run(context.Context, *types.AgentSharedState, types.ActionParams) (types.ActionResult, error) {
+	c.runs.Add(1)
+	return types.ActionResult{Result: "counted"}, nil
+}
+
+func (c *countAction) Definition() types.ActionDefinition {
+	return types.ActionDefinition{
+		Name:        types.ActionDefinitionName(c.name),
+		Description: "count something",
+		Properties: map[string]jsonschema.Definition{
+			"what": {Type: jsonschema.String, Description: "What to count"},
+		},
+	}
+}
+
+func (c *countAction) Plannable() bool {
+	return true
+}
+
+// staticPrompt is a dynamic prompt always rendering the same text
+type staticPrompt string
+
+func (s staticPrompt) Render(*Agent) (string, error) {
+	return string(s), nil
+}
+
+func (s staticPrompt) Role() string {
+	return SystemRole
+}
+
+func countContent(messages []openai.ChatCompletionMessage, content string) int {
+	n := 0
+	for _, m := range messages {
+		if m.Content == content {
+			n++
+		}
+	}
+	return n
+}
+
+// newTestAgent starts an agent answered by the fake LLM
+func newTestAgent(fake *fakeLLM, opts ...Option) *Agent {
+	a, err := New(append(fakeLLMOptions(fake), opts...)...)
+	Expect(err).ToNot(HaveOccurred())
+	if chain, ok := a.client.(*llm.Chain); ok {
+		chain.InitialBackoff = time.Millisecond
+		chain.MaxBackoff = time.Millisecond
+	}
+	go a.Run()
+	DeferCleanup(a.Stop)
+	return a
+}
+
+var _ = Describe("Job step-machine", func() {
+	It("runs the picked action and replies", func() {
+		counter := &countAction{name: "count"}
+		llm := newFakeLLM(
+			toolReply("count", `{"what":"sheep"}`),
+			textReply("no more actions"),
+			textReply("I counted the sheep"),
+		)
+		a := newTestAgent(llm, EnableNativeToolCalls, WithActions(counter))
+
+		result := a.Ask(types.WithText("count the sheep"))
+		Expect(result.Error).ToNot(HaveOccurred())
+		Expect(counter.runs.Load()).To(Equal(int32(1)))
+		Expect(result.State).To(HaveLen(1))
+		Expect(result.State[0].Result).To(Equal("counted"))
+		Expect(result.Response).To(Equal("I counted the sheep"))
+	})
+
+	It("replies without running any action", func() {
+		llm := newFakeLLM(textReply("Hello there"))
+		a := newTestAgent(llm, EnableNativeToolCalls, WithActions(&countAction{name: "count"}))
+
+		result := a.Ask(types.WithText("hi"))
+		Expect(result.Error).ToNot(HaveOccurred())
+		Expect(result.State).To(BeEmpty())
+		Expect(result.Response).To(Equal("Hello there"))
+		Expect(llm.Requests()).To(HaveLen(1))
+	})
+
+	It("renders the prompts into the conversation", func() {
+		llm := newFakeLLM(textReply("It is sunny"))
+		a := newTestAgent(llm, EnableNativeToolCalls, WithPrompts(staticPrompt("the sky is clear")))
+
+		Expect(a.Ask(types.WithText("how is the weather")).Error).ToNot(HaveOccurred())
+		Expect(countContent(llm.Requests()[0].Messages, "the sky is clear")).To(Equal(1))
+	})
+
+	It("doesn't render the prompts again into the conversation of a restored job", func() {
+		llm := newFakeLLM(textReply("It is sunny"))
+		a := newTestAgent(llm, EnableNativeToolCalls, WithPrompts(staticPrompt("the sky is clear")))
+
+		job := types.NewJob(types.WithConversationHistory([]openai.ChatCompletionMessage{
+			{Role: SystemRole, Content: "the sky is clear"},
+			{Role: UserRole, Content: "how is the weather"},
+		}))
+		job.DoneFilter = true
+		job.Restored = true
+
+		Expect(a.Execute(job).Error).ToNot(HaveOccurred())
+		Expect(countContent(llm.Requests()[0].Messages, "the sky is clear")).To(Equal(1))
+	})
+})
+
+var _ = Describe("Job budget", func() {
+	It("stops a job which used all its LLM calls", func() {
+		counter := &countAction{name: "count"}
+		llm := newFakeLLM(
+			toolReply("count", `{"what":"sheep"}`),
+			toolReply("count", `{"what":"goats"}`),
+		)
+		a := newTestAgent(llm, EnableNativeToolCalls, WithActions(counter),
+			WithJobBudget(types.JobBudget{MaxLLMCalls: 2}))
+
+		result := a.Ask(types.WithText("count everything"))
+		Expect(result.BudgetExceeded).ToNot(BeNil())
+		Expect(result.BudgetExceeded.Budget).To(Equal(types.BudgetLLMCalls))
+		Expect(counter.runs.Load()).To(Equal(int32(1)))
+		Expect(llm.Requests()).To(HaveLen(2))
+	})
+
+	It("stops a job which used all its steps before running another action", func() {
+		counter := &countAction{name: "count"}
+		llm := newFakeLLM(
+			toolReply("count", `{"what":"sheep"}`),
+			toolReply("count", `{"what":"goats"}`),
+		)
+		a := newTestAgent(llm, EnableNativeToolCalls, WithActions(counter),
+			WithJobBudget(types.JobBudget{MaxSteps: 1}))
+
+		result := a.Ask(types.WithText("count everything"))
+		Expect(result.BudgetExceeded).ToNot(BeNil())
+		Expect(result.BudgetExceeded.Budget).To(Equal(types.BudgetSteps))
+		Expect(counter.runs.Load()).To(Equal(int32(1)))
+	})
+
+	It("lets a job reply after using all its steps or LLM calls", func() {
+		e := &jobExecution{
+			job:       types.NewJob(types.WithBudget(types.JobBudget{MaxSteps: 1, MaxLLMCalls: 1})),
+			startedAt: time.Now(),
+		}
+		e.job.AddStep(types.JobStep{})
+		e.job.Usage().Add(10, 0)
+
+		Expect(e.exceededBudget(jobStateReply)).To(BeNil())
+
+		exceeded := e.exceededBudget(jobStateRun)
+		Expect(exceeded).ToNot(BeNil())
+		Expect(exceeded.Budget).To(Equal(types.BudgetSteps))
+
+		exceeded = e.exceededBudget(jobStatePick)
+		Expect(exceeded).ToNot(BeNil())
+		Expect(exceeded.Budget).To(Equal(types.BudgetLLMCalls))
+	})
+
+	It("always stops a job over its duration", func() {
+		e := &jobExecution{
+			job:       types.NewJob(types.WithBudget(types.JobBudget{MaxDuration: time.Second})),
+			startedAt: time.Now().Add(-time.Minute),
+		}
+
+		exceeded := e.exceededBudget(jobStateReply)
+		Expect(exceeded).ToNot(BeNil())
+		Expect(exceeded.Budget).To(Equal(types.BudgetDuration))
+	})
+
+	It("takes the unset limits from the defaults of the agent", func() {
+		budget := types.JobBudget{MaxSteps: 3}.Merge(types.JobBudget{MaxSteps: 10, MaxLLMCalls: 20})
+		Expect(budget.MaxSteps).To(Equal(3))
+		Expect(budget.MaxLLMCalls).To(Equal(20))
+	})
+})
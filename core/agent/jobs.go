@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	JobStatusQueued = "queued"
+	JobStatusParked = "parked"
+)
+
+var (
+	// ErrJobCanceled is the error of the jobs stopped with CancelJob
+	ErrJobCanceled = errors.New("job canceled")
+	ErrJobNotFound = errors.New("job not found")
+)
+
+// JobInfo describes a job queued or run by an agent
+type JobInfo struct {
+	ID              string `json:"id"`
+	Status          string `json:"status"`
+	Priority        string `json:"priority"`
+	ConversationKey string `json:"conversation_key,omitempty"`
+	// Step is the state of the job (e.g. pick, run or reply) and Action the
+	// action being run, if any
+	Step           string     `json:"step,omitempty"`
+	Action         string     `json:"action,omitempty"`
+	CompletedSteps int        `json:"completed_steps"`
+	QueuedAt       time.Time  `json:"queued_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	ElapsedSeconds float64    `json:"elapsed_seconds"`
+}
+
+type activeJob struct {
+	job       *types.Job
+	status    string
+	step      string
+	action    string
+	conv      Messages
+	queuedAt  time.Time
+	startedAt time.Time
+}
+
+func (j *activeJob) info() JobInfo {
+	info := JobInfo{
+		ID:              j.job.UUID,
+		Status:          j.status,
+		Priority:        j.job.Priority.String(),
+		ConversationKey: j.job.ConversationKey,
+		Step:            j.step,
+		Action:          j.action,
+		CompletedSteps:  len(j.job.GetSteps()),
+		QueuedAt:        j.queuedAt,
+		ElapsedSeconds:  time.Since(j.queuedAt).Seconds(),
+	}
+	if !j.startedAt.IsZero() {
+		startedAt := j.startedAt
+		info.StartedAt = &startedAt
+		info.ElapsedSeconds = time.Since(startedAt).Seconds()
+	}
+	return info
+}
+
+// registerJob adds a queued job to the active jobs, until it's finished
+func (a *Agent) registerJob(job *types.Job) {
+	a.jobsMutex.Lock()
+	if entry, ok := a.activeJobs[job.UUID]; ok && entry.job == job {
+		// queued again, e.g. after being parked
+		entry.status = JobStatusQueued
+		a.jobsMutex.Unlock()
+		return
+	}
+	a.activeJobs[job.UUID] = &activeJob{
+		job:      job,
+		status:   JobStatusQueued,
+		queuedAt: time.Now(),
+	}
+	a.jobsMutex.Unlock()
+
+	job.Result.AddFinalizer(func(_ []openai.ChatCompletionMessage) {
+		a.jobsMutex.Lock()
+		defer a.jobsMutex.Unlock()
+		if entry, ok := a.activeJobs[job.UUID]; ok && entry.job == job {
+			delete(a.activeJobs, job.UUID)
+		}
+	})
+}
+
+// updateJob updates the active job entry of a job, if it's registered
+func (a *Agent) updateJob(job *types.Job, update func(*activeJob)) {
+	a.jobsMutex.Lock()
+	defer a.jobsMutex.Unlock()
+
+	if entry, ok := a.activeJobs[job.UUID]; ok && entry.job == job {
+		update(entry)
+	}
+}
+
+// ActiveJobs lists the jobs queued, parked or run by the agent, oldest first
+func (a *Agent) ActiveJobs() []JobInfo {
+	a.jobsMutex.Lock()
+	defer a.jobsMutex.Unlock()
+
+	jobs := make([]JobInfo, 0, len(a.activeJobs))
+	for _, entry := range a.activeJobs {
+		jobs = append(jobs, entry.info())
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].QueuedAt.Before(jobs[j].QueuedAt)
+	})
+	return jobs
+}
+
+// ActiveJob returns an active job and its conversation so far
+func (a *Agent) ActiveJob(id string) (JobInfo, Messages, error) {
+	a.jobsMutex.Lock()
+	defer a.jobsMutex.Unlock()
+
+	entry, ok := a.activeJobs[id]
+	if !ok {
+		return JobInfo{}, nil, ErrJobNotFound
+	}
+
+	conv := entry.conv
+	if conv == nil {
+		conv = entry.job.ConversationHistory
+	}
+	return entry.info(), append(Messages{}, conv...), nil
+}
+
+// CancelJob stops an active job: queued and parked jobs are dropped, the
+// LLM and action calls of a running job are canceled
+func (a *Agent) CancelJob(id string) error {
+	a.jobsMutex.Lock()
+	entry, ok := a.activeJobs[id]
+	a.jobsMutex.Unlock()
+	if !ok {
+		return ErrJobNotFound
+	}
+
+	entry.job.Stop()
+
+	if a.jobQueue.remove(entry.job) {
+		entry.job.Result.Finish(ErrJobCanceled)
+		return nil
+	}
+	if a.dropParkedJob(id, ErrJobCanceled) {
+		return nil
+	}
+	// the running job fails as soon as its context is canceled
+	return nil
+}
+
+// jobError returns the error to finish a job with, ErrJobCanceled if the job
+// was stopped by the user
+func jobError(job *types.Job, err error) error {
+	if job.Stopped() {
+		return ErrJobCanceled
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/mudler/LocalAGI/core/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// blockAction runs until its job is stopped
+type blockAction struct {
+	countAction
+	started chan struct{}
+}
+
+func (b *blockAction) Run(ctx context.Context, state *types.AgentSharedState, params types.ActionParams) (types.ActionResult, error) {
+	b.runs.Add(1)
+	close(b.started)
+	<-ctx.Done()
+	return types.ActionResult{}, ctx.Err()
+}
+
+var _ = Describe("Job cancellation", func() {
+	It("registers the submitted jobs before returning", func() {
+		a := newTestAgent(newFakeLLM(), WithPauseMode(PauseModePark))
+		a.Pause()
+
+		result := a.Submit(types.WithText("hello"), types.WithUUID("submitted"))
+		_, _, err := a.ActiveJob("submitted")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(a.CancelJob("submitted")).To(Succeed())
+		Expect(result.WaitResult().Error).To(MatchError(ErrJobCanceled))
+	})
+
+	It("stops a running job", func() {
+		block := &blockAction{countAction: countAction{name: "block"}, started: make(chan struct{})}
+		a := newTestAgent(newFakeLLM(toolReply("block", `{}`)), EnableNativeToolCalls, WithActions(block))
+
+		result := a.Submit(types.WithText("block"), types.WithUUID("running"))
+		Eventually(block.started).Should(BeClosed())
+
+		info, _, err := a.ActiveJob("running")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Status).To(Equal(JobStatusRunning))
+
+		Expect(a.CancelJob("running")).To(Succeed())
+		Expect(result.WaitResult().Error).To(MatchError(ErrJobCanceled))
+		Eventually(a.ActiveJobs).Should(BeEmpty())
+	})
+
+	It("drops a queued job without running it", func() {
+		block := &blockAction{countAction: countAction{name: "block"}, started: make(chan struct{})}
+		fake := newFakeLLM(toolReply("block", `{}`))
+		a := newTestAgent(fake, EnableNativeToolCalls, WithActions(block))
+
+		running := a.Submit(types.WithText("block"), types.WithConversationKey("thread"), types.WithUUID("running"))
+		Eventually(block.started).Should(BeClosed())
+
+		queued := a.Submit(types.WithText("then this"), types.WithConversationKey("thread"), types.WithUUID("queued"))
+		info, _, err := a.ActiveJob("queued")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Status).To(Equal(JobStatusQueued))
+
+		Expect(a.CancelJob("queued")).To(Succeed())
+		Expect(queued.WaitResult().Error).To(MatchError(ErrJobCanceled))
+		Expect(a.QueueStats().Depth).To(BeZero())
+
+		requests := len(fake.Requests())
+		Consistently(func() int { return len(fake.Requests()) }, 50*time.Millisecond).Should(Equal(requests))
+
+		Expect(a.CancelJob("running")).To(Succeed())
+		Expect(running.WaitResult().Error).To(MatchError(ErrJobCanceled))
+	})
+
+	It("drops a parked job", func() {
+		a := newTestAgent(newFakeLLM(), WithPauseMode(PauseModePark))
+		a.Pause()
+
+		result := a.Submit(types.WithText("hello"), types.WithUUID("parked"))
+		Eventually(func() []string { return parkedIDs(a) }).Should(ConsistOf("parked"))
+
+		Expect(a.CancelJob("parked")).To(Succeed())
+		Expect(result.WaitResult().Error).To(MatchError(ErrJobCanceled))
+		Expect(parkedIDs(a)).To(BeEmpty())
+
+		a.Resume()
+		Expect(a.ActiveJobs()).To(BeEmpty())
+	})
+
+	It("reports the unknown jobs", func() {
+		a := newTestAgent(newFakeLLM())
+		Expect(a.CancelJob("unknown")).To(MatchError(ErrJobNotFound))
+	})
+})
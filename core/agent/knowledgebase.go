@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -8,6 +9,7 @@ import (
 	"time"
 
 	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/pkg/vectorstore"
 	"github.com/mudler/LocalAGI/pkg/xlog"
 	"github.com/sashabaranov/go-openai"
 )
@@ -45,7 +47,14 @@ func (a *Agent) knowledgeBaseLookup(job *types.Job, conv Messages) Messages {
 	var results []MemoryResult
 	var err error
 
-	if a.options.useMySQLForSummaries && a.options.enableKB {
+	ctx := a.context.Context
+	if job != nil {
+		ctx = job.GetContext()
+	}
+
+	if a.options.enableKB && (a.options.enableSummaryMemory || a.options.enableLongTermMemory) && a.semanticMemoryEnabled() {
+		results, err = a.searchMemories(ctx, userMessage, a.options.kbResults, 1)
+	} else if a.options.useMySQLForSummaries && a.options.enableKB {
 		mysqlStorage := NewMySQLStorage(a.options.agentID, a.options.userID)
 		excludeCount := 1
 		if a.options.enableSummaryMemory || a.options.enableLongTermMemory {
@@ -57,7 +66,7 @@ func (a *Agent) knowledgeBaseLookup(job *types.Job, conv Messages) Messages {
 			results, err = mysqlStorage.GetLastMessagesExcludingCount(a.options.kbResults, excludeCount)
 			fmt.Printf("DEBUG: MySQL get last messages results: %d memories found\n", len(results))
 		}
-	} else {
+	} else if !a.options.enableKB || a.options.ragdb == nil {
 		return conv
 	}
 
@@ -76,11 +85,29 @@ func (a *Agent) knowledgeBaseLookup(job *types.Job, conv Messages) Messages {
 
 	// Improved formatting with better context and structure
 	formatResults := a.formatEnhancedMemoryResults(processedResults)
+
+	// the summaries of the past conversations complete the raw messages
+	if a.options.enableSummaryMemory && a.persisted() {
+		summaries, err := a.searchSummaries(userMessage, a.options.kbResults)
+		if err != nil {
+			xlog.Warn("Error searching conversation summaries", "error", err, "agent", a.Character.Name)
+		} else if len(summaries) > 0 {
+			formatResults += "\n" + a.formatSummaries(summaries)
+		}
+	}
+
+	// the documents of the knowledge base are cited with their sources
+	documents := a.searchKnowledge(ctx, userMessage)
+	instructions := ""
+	if len(documents) > 0 {
+		formatResults += "\n" + formatKnowledge(documents)
+		instructions = " When you use a document of the knowledge base, cite it with its number, e.g. [1], and name its source."
+	}
 	xlog.Info("[Knowledge Base Lookup] Found similar strings in KB", "agent", a.Character.Name, "results", formatResults)
 
 	if obs != nil {
 		obs.AddProgress(types.Progress{
-			ActionResult: fmt.Sprintf("Found %d results in knowledge base", len(processedResults)),
+			ActionResult: fmt.Sprintf("Found %d results in knowledge base", len(processedResults)+len(documents)),
 		})
 		a.observer.Update(*obs)
 	}
@@ -92,8 +119,8 @@ func (a *Agent) knowledgeBaseLookup(job *types.Job, conv Messages) Messages {
 
 %s
 
-INSTRUCTIONS: Use this historical context to inform your response, but prioritize the current conversation. If the user is asking about previous interactions, reference these memories appropriately. If no relevant context is found above, respond based on the current conversation only.`,
-			formatResults),
+INSTRUCTIONS: Use this historical context to inform your response, but prioritize the current conversation. If the user is asking about previous interactions, reference these memories appropriately. If no relevant context is found above, respond based on the current conversation only.%s`,
+			formatResults, instructions),
 	}
 
 	// Add the message to the conversation
@@ -109,6 +136,61 @@ INSTRUCTIONS: Use this historical context to inform your response, but prioritiz
 	return conv
 }
 
+// searchKnowledge returns the documents of the knowledge base most similar to
+// the query. The RAG service is optional, an empty or unreachable collection
+// is skipped.
+func (a *Agent) searchKnowledge(ctx context.Context, query string) []vectorstore.Result {
+	if !a.options.enableKB || a.options.ragdb == nil || a.options.ragdb.Count() == 0 {
+		return nil
+	}
+
+	results, err := a.options.ragdb.SearchDocuments(ctx, query, a.options.kbResults, nil)
+	if err != nil {
+		xlog.Warn("Error searching the knowledge base", "error", err, "agent", a.Character.Name)
+		return nil
+	}
+	return results
+}
+
+// formatKnowledge lists the documents of the knowledge base with their
+// sources, numbered to be cited
+func formatKnowledge(results []vectorstore.Result) string {
+	var formatted strings.Builder
+	formatted.WriteString(fmt.Sprintf("Knowledge base documents (%d found, most relevant first):\n\n", len(results)))
+
+	for i, result := range results {
+		formatted.WriteString(fmt.Sprintf("[%d] %s\n", i+1, strings.TrimSpace(result.Content)))
+		if citation := citeDocument(result.Document); citation != "" {
+			formatted.WriteString("    " + citation + "\n")
+		}
+		formatted.WriteString("\n")
+	}
+	return formatted.String()
+}
+
+// citeDocument describes where a document comes from
+func citeDocument(d vectorstore.Document) string {
+	var parts []string
+	if source := d.Metadata[vectorstore.MetadataSource]; source != "" {
+		parts = append(parts, "Source: "+source)
+	} else if d.ID != "" {
+		parts = append(parts, "Document: "+d.ID)
+	}
+	if conversation := d.Metadata[vectorstore.MetadataConversation]; conversation != "" {
+		parts = append(parts, "Conversation: "+conversation)
+	}
+	if timestamp := d.Metadata[vectorstore.MetadataTimestamp]; timestamp != "" {
+		if t, err := time.Parse(time.RFC3339, timestamp); err == nil {
+			timestamp = t.Format(time.DateOnly)
+		}
+		parts = append(parts, "Date: "+timestamp)
+	}
+	if tags := vectorstore.SplitTags(d.Metadata[vectorstore.MetadataTags]); len(tags) > 0 {
+		parts = append(parts, "Tags: "+strings.Join(tags, ", "))
+	}
+	return strings.Join(parts, " | ")
+}
+
 // processMemoryResults applies advanced filtering: deduplication, length limiting, etc.
 func (a *Agent) processMemoryResults(results []MemoryResult) []MemoryResult {
 	if len(results) == 0 {
@@ -310,44 +392,11 @@ func (a *Agent) saveCurrentConversation(conv Messages) {
 		return
 	}
 
-	// xlog.Info("Saving conversation", "agent", a.Character.Name, "conversation size", len(conv))
-
-	// if a.options.enableSummaryMemory && len(conv) > 0 {
-	// 	msg, err := a.askLLM(a.context.Context, []openai.ChatCompletionMessage{{
-	// 		Role:    "user",
-	// 		Content: "Summarize the conversation below, keep the highlights as a bullet list:\n" + Messages(conv).String(),
-	// 	}}, maxRetries)
-	// 	if err != nil {
-	// 		xlog.Error("Error summarizing conversation", "error", err)
-	// 	}
-
-	// 	// Use MySQL storage for summaries if configured
-	// 	if a.options.useMySQLForSummaries {
-	// 		fmt.Printf("DEBUG: Storing summary into MySQL: %s\n", msg.Content)
-	// 		mysqlStorage := NewMySQLStorage(a.options.agentID, a.options.userID)
-	// 		if err := mysqlStorage.Store(msg.Content); err != nil {
-	// 			xlog.Error("Error storing summary into MySQL", "error", err)
-	// 		}
-	// 	} else {
-	// 		// Fallback to RagDB
-	// 		if err := a.options.ragdb.Store(msg.Content); err != nil {
-	// 			xlog.Error("Error storing into memory", "error", err)
-	// 		}
-	// 	}
-	// } else {
-	// 	for _, message := range conv {
-	// 		if message.Role == "user" {
-	// 			if a.options.useMySQLForSummaries {
-	// 				mysqlStorage := NewMySQLStorage(a.options.agentID, a.options.userID)
-	// 				if err := mysqlStorage.Store(message.Content); err != nil {
-	// 					xlog.Error("Error storing user message into MySQL", "error", err)
-	// 				}
-	// 			} else {
-	// 				if err := a.options.ragdb.Store(message.Content); err != nil {
-	// 					xlog.Error("Error storing into memory", "error", err)
-	// 				}
-	// 			}
-	// 		}
-	// 	}
-	// }
+	if a.semanticMemoryEnabled() {
+		go a.indexMemories(a.context.Context)
+	}
+
+	if a.options.enableSummaryMemory && a.persisted() {
+		go a.summarizeConversation(a.context.Context, conv)
+	}
 }
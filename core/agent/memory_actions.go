@@ -0,0 +1,301 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/core/action"
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/db"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/mudler/LocalAGI/pkg/xstrings"
+)
+
+var _ action.MemoryWriter = &Agent{}
+
+const (
+	// DefaultMaxMemories bounds the short-term memories of an agent
+	DefaultMaxMemories = 50
+	// MaxMemoryLength bounds the length of a short-term memory
+	MaxMemoryLength = 500
+	// MaxGoalLength bounds the length of the goal of an agent
+	MaxGoalLength = 1000
+
+	// memoryDuplicateThreshold is the similarity above which a memory
+	// repeats one already remembered
+	memoryDuplicateThreshold = 0.85
+	// forgetMatchThreshold is the similarity above which a memory is the one
+	// the agent asked to forget
+	forgetMatchThreshold = 0.5
+)
+
+// Who changed a memory, see models.MemoryAudit
+const (
+	MemoryActorAgent = "agent"
+	MemoryActorUser  = "user"
+)
+
+// Operations recorded in the audit trail of the memories
+const (
+	MemoryOperationRemember   = "remember"
+	MemoryOperationForget     = "forget"
+	MemoryOperationUpdate     = "update"
+	MemoryOperationUpdateGoal = "update_goal"
+	MemoryOperationPin        = "pin"
+	MemoryOperationUnpin      = "unpin"
+	MemoryOperationWipe       = "wipe"
+)
+
+type jobContextKey struct{}
+
+// withJob makes the job running an action available to the action
+func withJob(ctx context.Context, job *types.Job) context.Context {
+	return context.WithValue(ctx, jobContextKey{}, job)
+}
+
+func jobFromContext(ctx context.Context) *types.Job {
+	job, _ := ctx.Value(jobContextKey{}).(*types.Job)
+	return job
+}
+
+// normalizeMemory is the form memories are compared by
+func normalizeMemory(s string) string {
+	return strings.TrimRight(strings.ToLower(strings.Join(strings.Fields(s), " ")), ".!")
+}
+
+func (a *Agent) maxMemories() int {
+	if a.options.maxMemories > 0 {
+		return a.options.maxMemories
+	}
+	return DefaultMaxMemories
+}
+
+// updateState applies a change to the state of the agent and saves it
+func (a *Agent) updateState(update func(state *types.AgentInternalState) error) error {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+
+	state := *a.currentState
+	state.Memories = slices.Clone(state.Memories)
+	state.DoneHistory = slices.Clone(state.DoneHistory)
+	if err := update(&state); err != nil {
+		return err
+	}
+
+	a.currentState = &state
+	if !a.persisted() {
+		return nil
+	}
+	return a.saveState()
+}
+
+// Remember adds a memory to the short-term memory of the agent
+func (a *Agent) Remember(ctx context.Context, memory string) (string, error) {
+	memory = strings.Join(strings.Fields(memory), " ")
+	if len(memory) > MaxMemoryLength {
+		return "", fmt.Errorf("the memory is too long (%d characters, at most %d), keep it short", len(memory), MaxMemoryLength)
+	}
+
+	result := ""
+	err := a.updateState(func(state *types.AgentInternalState) error {
+		for _, m := range state.Memories {
+			if normalizeMemory(m) == normalizeMemory(memory) || a.calculateContentSimilarity(normalizeMemory(m), normalizeMemory(memory)) >= memoryDuplicateThreshold {
+				result = "Already remembered: " + m
+				return nil
+			}
+		}
+		if len(state.Memories) >= a.maxMemories() {
+			return fmt.Errorf("the memory is full (%d memories), forget an outdated memory first", len(state.Memories))
+		}
+		state.Memories = append(state.Memories, memory)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if result != "" {
+		return result, nil
+	}
+
+	a.recordMemoryAudit(ctx, models.MemoryAudit{
+		Operation: MemoryOperationRemember,
+		Source:    MemorySourceState,
+		MemoryID:  stateMemoryID(memory),
+		NewValue:  memory,
+	})
+	return "Remembered: " + memory, nil
+}
+
+// Forget removes the short-term memory closest to the given one
+func (a *Agent) Forget(ctx context.Context, memory string) (string, error) {
+	forgotten := ""
+	err := a.updateState(func(state *types.AgentInternalState) error {
+		best, bestScore := -1, 0.0
+		for i, m := range state.Memories {
+			score := a.calculateContentSimilarity(normalizeMemory(m), normalizeMemory(memory))
+			if normalizeMemory(m) == normalizeMemory(memory) {
+				score = 1
+			}
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 || bestScore < forgetMatchThreshold {
+			return fmt.Errorf("no memory matches %q, the memories are: %s", memory, strings.Join(state.Memories, " | "))
+		}
+
+		forgotten = state.Memories[best]
+		state.Memories = slices.Delete(state.Memories, best, best+1)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	a.recordMemoryAudit(ctx, models.MemoryAudit{
+		Operation: MemoryOperationForget,
+		Source:    MemorySourceState,
+		MemoryID:  stateMemoryID(forgotten),
+		OldValue:  forgotten,
+	})
+	return "Forgot: " + forgotten, nil
+}
+
+// UpdateGoal replaces the goal of the agent, an empty goal clears it
+func (a *Agent) UpdateGoal(ctx context.Context, goal string) (string, error) {
+	goal = strings.TrimSpace(goal)
+	if len(goal) > MaxGoalLength {
+		return "", fmt.Errorf("the goal is too long (%d characters, at most %d)", len(goal), MaxGoalLength)
+	}
+
+	old := ""
+	err := a.updateState(func(state *types.AgentInternalState) error {
+		old = state.Goal
+		state.Goal = goal
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if old == goal {
+		return "The goal is unchanged", nil
+	}
+
+	a.recordMemoryAudit(ctx, models.MemoryAudit{
+		Operation: MemoryOperationUpdateGoal,
+		Source:    MemorySourceGoal,
+		OldValue:  old,
+		NewValue:  goal,
+	})
+	if goal == "" {
+		return "Goal cleared", nil
+	}
+	return "Goal updated: " + goal, nil
+}
+
+// limitMemories drops the duplicates and the memories above the limits from
+// a state written at once by the update_state action, the latest memories
+// are kept
+func (a *Agent) limitMemories(memories []string) []string {
+	seen := map[string]bool{}
+	limited := []string{}
+	for _, m := range memories {
+		m = strings.Join(strings.Fields(m), " ")
+		if m == "" || seen[normalizeMemory(m)] {
+			continue
+		}
+		seen[normalizeMemory(m)] = true
+		m = xstrings.Truncate(m, MaxMemoryLength)
+		limited = append(limited, m)
+	}
+	if len(limited) > a.maxMemories() {
+		limited = limited[len(limited)-a.maxMemories():]
+	}
+	return limited
+}
+
+// auditStateChange records the memories and the goal changed by the
+// update_state action
+func (a *Agent) auditStateChange(ctx context.Context, old, state types.AgentInternalState) {
+	for _, m := range state.Memories {
+		if !slices.Contains(old.Memories, m) {
+			a.recordMemoryAudit(ctx, models.MemoryAudit{
+				Operation: MemoryOperationRemember,
+				Source:    MemorySourceState,
+				MemoryID:  stateMemoryID(m),
+				NewValue:  m,
+			})
+		}
+	}
+	for _, m := range old.Memories {
+		if !slices.Contains(state.Memories, m) {
+			a.recordMemoryAudit(ctx, models.MemoryAudit{
+				Operation: MemoryOperationForget,
+				Source:    MemorySourceState,
+				MemoryID:  stateMemoryID(m),
+				OldValue:  m,
+			})
+		}
+	}
+	if old.Goal != state.Goal {
+		a.recordMemoryAudit(ctx, models.MemoryAudit{
+			Operation: MemoryOperationUpdateGoal,
+			Source:    MemorySourceGoal,
+			OldValue:  old.Goal,
+			NewValue:  state.Goal,
+		})
+	}
+}
+
+// recordMemoryAudit stores a change of the memories
+func (a *Agent) recordMemoryAudit(ctx context.Context, audit models.MemoryAudit) {
+	if !a.persisted() {
+		return
+	}
+
+	audit.ID = uuid.New()
+	audit.AgentID = a.options.agentID
+	audit.UserID = a.options.userID
+	auditActor(ctx, &audit)
+
+	if err := db.DB.Create(&audit).Error; err != nil {
+		xlog.Error("Failed to record memory audit", "error", err, "agent", a.Character.Name, "operation", audit.Operation)
+	}
+}
+
+// auditActor sets who changed the memories and from where. The changes made
+// while running a job are made by the agent, the others by the user.
+func auditActor(ctx context.Context, audit *models.MemoryAudit) {
+	if job := jobFromContext(ctx); job != nil {
+		audit.Actor = MemoryActorAgent
+		audit.JobID = job.UUID
+		switch {
+		case job.Priority == types.JobPriorityPeriodic:
+			audit.Origin = "periodic run"
+		case job.ConversationKey != "":
+			audit.Origin = "conversation " + job.ConversationKey
+		default:
+			audit.Origin = "conversation"
+		}
+	} else if audit.Actor == "" {
+		audit.Actor = MemoryActorUser
+		audit.Origin = "api"
+	}
+}
+
+// MemoryAuditTrail returns the changes of the memories of an agent, the
+// latest first
+func MemoryAuditTrail(agentID uuid.UUID, source string, limit, offset int) ([]models.MemoryAudit, error) {
+	query := db.DB.Where("AgentID = ?", agentID)
+	if source != "" {
+		query = query.Where("Source = ?", source)
+	}
+
+	var trail []models.MemoryAudit
+	err := query.Order("CreatedAt DESC").Limit(limit).Offset(offset).Find(&trail).Error
+	return trail, err
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mudler/LocalAGI/core/types"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Short-term memory", func() {
+	var (
+		a   *Agent
+		ctx context.Context
+	)
+
+	BeforeEach(func() {
+		a = newTestAgent(newFakeLLM(), WithMaxMemories(3))
+		ctx = context.Background()
+	})
+
+	Context("remembering", func() {
+		It("adds the memory to the state", func() {
+			result, err := a.Remember(ctx, "  the user\tlives in   Rome ")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal("Remembered: the user lives in Rome"))
+			Expect(a.State().Memories).To(Equal([]string{"the user lives in Rome"}))
+		})
+
+		It("doesn't repeat a memory", func() {
+			_, err := a.Remember(ctx, "the user likes to drink green tea")
+			Expect(err).ToNot(HaveOccurred())
+
+			result, err := a.Remember(ctx, "The user likes to drink green tea!")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal("Already remembered: the user likes to drink green tea"))
+
+			result, err = a.Remember(ctx, "the user likes to drink green tea daily")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(HavePrefix("Already remembered"))
+
+			Expect(a.State().Memories).To(HaveLen(1))
+		})
+
+		It("rejects the memories too long", func() {
+			_, err := a.Remember(ctx, strings.Repeat("a", MaxMemoryLength+1))
+			Expect(err).To(MatchError(ContainSubstring("too long")))
+			Expect(a.State().Memories).To(BeEmpty())
+		})
+
+		It("rejects the memories once full", func() {
+			for _, m := range []string{"likes tea", "lives in Rome", "works as a baker"} {
+				_, err := a.Remember(ctx, m)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			_, err := a.Remember(ctx, "has a cat")
+			Expect(err).To(MatchError(ContainSubstring("the memory is full")))
+			Expect(a.State().Memories).To(HaveLen(3))
+		})
+	})
+
+	Context("forgetting", func() {
+		BeforeEach(func() {
+			for _, m := range []string{"the user likes tea", "the user lives in Rome"} {
+				_, err := a.Remember(ctx, m)
+				Expect(err).ToNot(HaveOccurred())
+			}
+		})
+
+		It("removes the closest memory", func() {
+			result, err := a.Forget(ctx, "user lives in Rome")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal("Forgot: the user lives in Rome"))
+			Expect(a.State().Memories).To(Equal([]string{"the user likes tea"}))
+		})
+
+		It("removes nothing when no memory is close enough", func() {
+			_, err := a.Forget(ctx, "the user has a cat")
+			Expect(err).To(MatchError(ContainSubstring("no memory matches")))
+			Expect(a.State().Memories).To(HaveLen(2))
+		})
+	})
+
+	Context("replacing the whole state", func() {
+		It("drops the blank and repeated memories", func() {
+			Expect(a.limitMemories([]string{"likes tea", " ", "Likes  tea.", "lives in Rome"})).
+				To(Equal([]string{"likes tea", "lives in Rome"}))
+		})
+
+		It("keeps the latest memories", func() {
+			Expect(a.limitMemories([]string{"one", "two", "three", "four", "five"})).
+				To(Equal([]string{"three", "four", "five"}))
+		})
+
+		It("truncates the memories too long without splitting a character", func() {
+			limited := a.limitMemories([]string{"a" + strings.Repeat("é", MaxMemoryLength)})
+			Expect(limited).To(HaveLen(1))
+			Expect(len(limited[0])).To(BeNumerically("<=", MaxMemoryLength))
+			Expect(len(limited[0])).To(BeNumerically(">=", MaxMemoryLength-1))
+			Expect(utf8.ValidString(limited[0])).To(BeTrue())
+		})
+	})
+
+	Context("auditing", func() {
+		It("attributes the changes outside of a job to the user", func() {
+			audit := models.MemoryAudit{}
+			auditActor(ctx, &audit)
+			Expect(audit.Actor).To(Equal(MemoryActorUser))
+			Expect(audit.Origin).To(Equal("api"))
+			Expect(audit.JobID).To(BeEmpty())
+		})
+
+		It("attributes the changes made by a job to the agent", func() {
+			job := types.NewJob()
+			audit := models.MemoryAudit{}
+			auditActor(withJob(ctx, job), &audit)
+			Expect(audit.Actor).To(Equal(MemoryActorAgent))
+			Expect(audit.Origin).To(Equal("conversation"))
+			Expect(audit.JobID).To(Equal(job.UUID))
+		})
+
+		It("tells where the job comes from", func() {
+			audit := models.MemoryAudit{}
+			auditActor(withJob(ctx, types.NewJob(types.WithConversationKey("telegram-42"))), &audit)
+			Expect(audit.Origin).To(Equal("conversation telegram-42"))
+
+			audit = models.MemoryAudit{}
+			auditActor(withJob(ctx, types.NewJob(types.WithPriority(types.JobPriorityPeriodic))), &audit)
+			Expect(audit.Origin).To(Equal("periodic run"))
+		})
+	})
+})
@@ -0,0 +1,723 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/db"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/sashabaranov/go-openai"
+	"gorm.io/gorm"
+)
+
+// Sources of the memories of an agent
+const (
+	// MemorySourceState is the short-term memory of the agent state
+	MemorySourceState = "state"
+	// MemorySourceMessage is a past message, the long-term memory
+	MemorySourceMessage = "message"
+	// MemorySourceSummary is the summary of a past conversation
+	MemorySourceSummary = "summary"
+	// MemorySourceRAG is an entry of the RAG collection of the agent
+	MemorySourceRAG = "rag"
+	// MemorySourceGoal is the goal of the agent state, only found in the
+	// audit trail
+	MemorySourceGoal = "goal"
+)
+
+var (
+	ErrMemoryNotFound    = errors.New("memory not found")
+	ErrMemoryUnsupported = errors.New("operation not supported for this memory")
+)
+
+const (
+	// DefaultMemoryLimit is how many memories are listed by default
+	DefaultMemoryLimit = 50
+	// MaxMemoryLimit bounds the memories listed at once
+	MaxMemoryLimit = 500
+	// maxPinnedMemories bounds the pinned memories recalled in a job
+	maxPinnedMemories = 20
+
+	// ragEntryTimeLayout prefixes the names of the entries stored in the
+	// RAG collection
+	ragEntryTimeLayout = "2006-01-02-15-04-05"
+)
+
+// Memory is something an agent remembers
+type Memory struct {
+	ID        string     `json:"id"`
+	Source    string     `json:"source"`
+	Sender    string     `json:"sender,omitempty"`
+	Content   string     `json:"content"`
+	Pinned    bool       `json:"pinned"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+
+	// the details of the summaries
+	Facts            []string `json:"facts,omitempty"`
+	Entities         []string `json:"entities,omitempty"`
+	Decisions        []string `json:"decisions,omitempty"`
+	SourceMessageIDs []string `json:"sourceMessageIds,omitempty"`
+}
+
+// MemoryFilter selects the memories to list
+type MemoryFilter struct {
+	// Source restricts the memories to a source, all of them when empty
+	Source string
+	// Query searches the memories, they are sorted by relevance instead of
+	// recency
+	Query string
+	// From and To bound the time of the memories, zero times leaving the
+	// range open. The short-term memories, which have no time, are left out
+	// when set.
+	From, To time.Time
+	Pinned   bool
+	Limit    int
+	Offset   int
+}
+
+// MemoryWipe counts the memories deleted by WipeMemories
+type MemoryWipe struct {
+	Messages   int `json:"messages"`
+	Summaries  int `json:"summaries"`
+	RAGEntries int `json:"ragEntries"`
+}
+
+// stateMemoryID identifies a short-term memory by its content, the memories
+// of the state have no identifier
+func stateMemoryID(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:8])
+}
+
+func messageMemory(m models.AgentMessage) Memory {
+	createdAt := m.CreatedAt
+	return Memory{
+		ID:        m.ID.String(),
+		Source:    MemorySourceMessage,
+		Sender:    m.Sender,
+		Content:   m.Content,
+		Pinned:    m.Pinned,
+		CreatedAt: &createdAt,
+	}
+}
+
+func summaryMemory(s models.MemorySummary) Memory {
+	endedAt := s.EndedAt
+	return Memory{
+		ID:               s.ID.String(),
+		Source:           MemorySourceSummary,
+		Content:          s.Summary,
+		Pinned:           s.Pinned,
+		CreatedAt:        &endedAt,
+		Facts:            parseJSONList(s.Facts),
+		Entities:         parseJSONList(s.Entities),
+		Decisions:        parseJSONList(s.Decisions),
+		SourceMessageIDs: parseJSONList(s.SourceMessageIDs),
+	}
+}
+
+// ragEntryTime returns the time an entry was stored in the RAG collection,
+// when its name carries it
+func ragEntryTime(entry string) *time.Time {
+	if len(entry) < len(ragEntryTimeLayout) {
+		return nil
+	}
+	t, err := time.ParseInLocation(ragEntryTimeLayout, entry[:len(ragEntryTimeLayout)], time.Local)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func inRange(t *time.Time, from, to time.Time) bool {
+	if from.IsZero() && to.IsZero() {
+		return true
+	}
+	if t == nil {
+		return false
+	}
+	return (from.IsZero() || !t.Before(from)) && (to.IsZero() || !t.After(to))
+}
+
+// ListMemories returns the memories of the agent, the most recent first or
+// the most relevant to the query
+func (a *Agent) ListMemories(ctx context.Context, filter MemoryFilter) ([]Memory, error) {
+	if !a.persisted() {
+		return nil, fmt.Errorf("the memories of the agent are not persisted")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultMemoryLimit
+	}
+	limit = min(limit, MaxMemoryLimit)
+	n := limit + max(filter.Offset, 0)
+
+	sources := []string{MemorySourceState, MemorySourceSummary, MemorySourceMessage, MemorySourceRAG}
+	if filter.Source != "" {
+		if !isMemorySource(filter.Source) {
+			return nil, fmt.Errorf("unknown memory source: %s", filter.Source)
+		}
+		sources = []string{filter.Source}
+	}
+
+	memories := []Memory{}
+	for _, source := range sources {
+		var found []Memory
+		var err error
+		switch source {
+		case MemorySourceState:
+			found = a.listStateMemories(filter)
+		case MemorySourceMessage:
+			found, err = a.listMessageMemories(ctx, filter, n)
+		case MemorySourceSummary:
+			found, err = a.listSummaryMemories(filter, n)
+		case MemorySourceRAG:
+			found, err = a.listRAGMemories(filter)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s memories: %w", source, err)
+		}
+		memories = append(memories, found...)
+	}
+
+	// the search results are already sorted by relevance within a source
+	if filter.Query == "" {
+		sort.SliceStable(memories, func(i, j int) bool {
+			if memories[i].CreatedAt == nil || memories[j].CreatedAt == nil {
+				return memories[i].CreatedAt == nil && memories[j].CreatedAt != nil
+			}
+			return memories[i].CreatedAt.After(*memories[j].CreatedAt)
+		})
+	}
+
+	if filter.Offset >= len(memories) {
+		return []Memory{}, nil
+	}
+	memories = memories[max(filter.Offset, 0):]
+	return memories[:min(limit, len(memories))], nil
+}
+
+func isMemorySource(source string) bool {
+	switch source {
+	case MemorySourceState, MemorySourceMessage, MemorySourceSummary, MemorySourceRAG:
+		return true
+	}
+	return false
+}
+
+// listStateMemories returns the short-term memories, always recalled hence
+// pinned
+func (a *Agent) listStateMemories(filter MemoryFilter) []Memory {
+	if !filter.From.IsZero() || !filter.To.IsZero() {
+		return nil
+	}
+
+	keywords := extractKeywords(filter.Query)
+	memories := []Memory{}
+	for _, content := range a.State().Memories {
+		if filter.Query != "" && keywordScore(keywords, content) == 0 &&
+			!strings.Contains(strings.ToLower(content), strings.ToLower(filter.Query)) {
+			continue
+		}
+		memories = append(memories, Memory{
+			ID:      stateMemoryID(content),
+			Source:  MemorySourceState,
+			Content: content,
+			Pinned:  true,
+		})
+	}
+	return memories
+}
+
+func (a *Agent) listMessageMemories(ctx context.Context, filter MemoryFilter, n int) ([]Memory, error) {
+	storage := NewMySQLStorage(a.options.agentID, a.options.userID)
+
+	if filter.Query == "" {
+		messages, err := storage.List(filter.From, filter.To, filter.Pinned, n)
+		if err != nil {
+			return nil, err
+		}
+		memories := make([]Memory, 0, len(messages))
+		for _, m := range messages {
+			memories = append(memories, messageMemory(m))
+		}
+		return memories, nil
+	}
+
+	var results []MemoryResult
+	var err error
+	if a.semanticMemoryEnabled() {
+		results, err = a.searchMemories(ctx, filter.Query, n, 0)
+	} else {
+		results, err = storage.Search(filter.Query, n, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the results of the search lack the state of the messages
+	ids := make([]uuid.UUID, 0, len(results))
+	for _, r := range results {
+		ids = append(ids, r.ID)
+	}
+	var messages []models.AgentMessage
+	if err := db.DB.Where("AgentID = ? AND ID IN ?", a.options.agentID, ids).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	byID := map[uuid.UUID]models.AgentMessage{}
+	for _, m := range messages {
+		byID[m.ID] = m
+	}
+
+	memories := []Memory{}
+	for _, id := range ids {
+		m, ok := byID[id]
+		if !ok || (filter.Pinned && !m.Pinned) || !inRange(&m.CreatedAt, filter.From, filter.To) {
+			continue
+		}
+		memories = append(memories, messageMemory(m))
+	}
+	return memories, nil
+}
+
+func (a *Agent) listSummaryMemories(filter MemoryFilter, n int) ([]Memory, error) {
+	var summaries []models.MemorySummary
+	var err error
+	if filter.Query != "" {
+		summaries, err = a.searchSummaries(filter.Query, n)
+	} else {
+		query := db.DB.Where("AgentID = ?", a.options.agentID)
+		if !filter.From.IsZero() {
+			query = query.Where("EndedAt >= ?", filter.From)
+		}
+		if !filter.To.IsZero() {
+			query = query.Where("EndedAt <= ?", filter.To)
+		}
+		if filter.Pinned {
+			query = query.Where("Pinned = ?", true)
+		}
+		err = query.Order("EndedAt DESC").Limit(n).Find(&summaries).Error
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	memories := []Memory{}
+	for _, s := range summaries {
+		if (filter.Pinned && !s.Pinned) || !inRange(&s.EndedAt, filter.From, filter.To) {
+			continue
+		}
+		memories = append(memories, summaryMemory(s))
+	}
+	return memories, nil
+}
+
+// listRAGMemories returns the entries of the RAG collection, the collection
+// only lists the names of the entries
+func (a *Agent) listRAGMemories(filter MemoryFilter) ([]Memory, error) {
+	entries, ok := a.options.ragdb.(RAGDBEntries)
+	if !ok || filter.Pinned {
+		return nil, nil
+	}
+
+	names, err := entries.Entries()
+	if err != nil {
+		// the RAG service is optional
+		xlog.Warn("Failed to list the RAG entries", "error", err, "agent", a.Character.Name)
+		return nil, nil
+	}
+
+	memories := []Memory{}
+	for _, name := range names {
+		if filter.Query != "" && !strings.Contains(strings.ToLower(name), strings.ToLower(filter.Query)) {
+			continue
+		}
+		createdAt := ragEntryTime(name)
+		if !inRange(createdAt, filter.From, filter.To) {
+			continue
+		}
+		memories = append(memories, Memory{
+			ID:        name,
+			Source:    MemorySourceRAG,
+			Content:   name,
+			CreatedAt: createdAt,
+		})
+	}
+	return memories, nil
+}
+
+// UpdateMemory replaces the content of a memory. The semantic memory embeds
+// the new content of a message again.
+func (a *Agent) UpdateMemory(ctx context.Context, source, id, content string) (*Memory, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, fmt.Errorf("the content of the memory is empty")
+	}
+
+	switch source {
+	case MemorySourceState:
+		old := ""
+		err := a.updateState(func(state *types.AgentInternalState) error {
+			i := findStateMemory(state.Memories, id)
+			if i < 0 {
+				return ErrMemoryNotFound
+			}
+			old = state.Memories[i]
+			state.Memories[i] = content
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		a.forgetRAGContent(old)
+		a.recordMemoryAudit(ctx, models.MemoryAudit{
+			Operation: MemoryOperationUpdate,
+			Source:    MemorySourceState,
+			MemoryID:  id,
+			OldValue:  old,
+			NewValue:  content,
+		})
+		return &Memory{ID: stateMemoryID(content), Source: MemorySourceState, Content: content, Pinned: true}, nil
+
+	case MemorySourceMessage:
+		storage := NewMySQLStorage(a.options.agentID, a.options.userID)
+		message, err := a.findMessage(storage, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.forgetMessages(ctx, []uuid.UUID{message.ID}); err != nil {
+			return nil, err
+		}
+		if err := storage.Update(message.ID, content); err != nil {
+			return nil, err
+		}
+		a.forgetRAGContent(message.Content)
+		a.recordMemoryAudit(ctx, models.MemoryAudit{
+			Operation: MemoryOperationUpdate,
+			Source:    MemorySourceMessage,
+			MemoryID:  id,
+			OldValue:  message.Content,
+			NewValue:  content,
+		})
+		if a.semanticMemoryEnabled() {
+			go a.indexMemories(a.context.Context)
+		}
+		message.Content = content
+		memory := messageMemory(*message)
+		return &memory, nil
+
+	case MemorySourceSummary:
+		summary, err := a.findSummary(id)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.DB.Model(summary).Updates(map[string]interface{}{
+			"Summary": content,
+			"Edited":  true,
+		}).Error; err != nil {
+			return nil, err
+		}
+		a.recordMemoryAudit(ctx, models.MemoryAudit{
+			Operation: MemoryOperationUpdate,
+			Source:    MemorySourceSummary,
+			MemoryID:  id,
+			OldValue:  summary.Summary,
+			NewValue:  content,
+		})
+		summary.Summary = content
+		summary.Edited = true
+		memory := summaryMemory(*summary)
+		return &memory, nil
+	}
+
+	return nil, ErrMemoryUnsupported
+}
+
+// DeleteMemory forgets a memory, along with its copies in the semantic
+// memory and in the RAG collection
+func (a *Agent) DeleteMemory(ctx context.Context, source, id string) error {
+	old := ""
+	switch source {
+	case MemorySourceState:
+		err := a.updateState(func(state *types.AgentInternalState) error {
+			i := findStateMemory(state.Memories, id)
+			if i < 0 {
+				return ErrMemoryNotFound
+			}
+			old = state.Memories[i]
+			state.Memories = slices.Delete(state.Memories, i, i+1)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		a.forgetRAGContent(old)
+
+	case MemorySourceMessage:
+		storage := NewMySQLStorage(a.options.agentID, a.options.userID)
+		message, err := a.findMessage(storage, id)
+		if err != nil {
+			return err
+		}
+		if err := a.forgetMessages(ctx, []uuid.UUID{message.ID}); err != nil {
+			return err
+		}
+		if err := storage.Delete(message.ID); err != nil {
+			return err
+		}
+		a.forgetRAGContent(message.Content)
+		old = message.Content
+
+	case MemorySourceSummary:
+		summary, err := a.findSummary(id)
+		if err != nil {
+			return err
+		}
+		if err := db.DB.Delete(summary).Error; err != nil {
+			return err
+		}
+		old = summary.Summary
+
+	case MemorySourceRAG:
+		entries, ok := a.options.ragdb.(RAGDBEntries)
+		if !ok {
+			return ErrMemoryUnsupported
+		}
+		if err := entries.RemoveEntry(id); err != nil {
+			return err
+		}
+
+	default:
+		return ErrMemoryUnsupported
+	}
+
+	a.recordMemoryAudit(ctx, models.MemoryAudit{
+		Operation: MemoryOperationForget,
+		Source:    source,
+		MemoryID:  id,
+		OldValue:  old,
+	})
+	xlog.Info("Memory deleted", "agent", a.Character.Name, "source", source, "id", id)
+	return nil
+}
+
+// PinMemory pins a memory so it's recalled in every job, or unpins it
+func (a *Agent) PinMemory(ctx context.Context, source, id string, pinned bool) (*Memory, error) {
+	operation := MemoryOperationPin
+	if !pinned {
+		operation = MemoryOperationUnpin
+	}
+
+	switch source {
+	case MemorySourceMessage:
+		storage := NewMySQLStorage(a.options.agentID, a.options.userID)
+		message, err := a.findMessage(storage, id)
+		if err != nil {
+			return nil, err
+		}
+		if err := storage.SetPinned(message.ID, pinned); err != nil {
+			return nil, err
+		}
+		message.Pinned = pinned
+		a.recordMemoryAudit(ctx, models.MemoryAudit{Operation: operation, Source: source, MemoryID: id})
+		memory := messageMemory(*message)
+		return &memory, nil
+
+	case MemorySourceSummary:
+		summary, err := a.findSummary(id)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.DB.Model(summary).Update("Pinned", pinned).Error; err != nil {
+			return nil, err
+		}
+		summary.Pinned = pinned
+		a.recordMemoryAudit(ctx, models.MemoryAudit{Operation: operation, Source: source, MemoryID: id})
+		memory := summaryMemory(*summary)
+		return &memory, nil
+	}
+
+	// the short-term memories are always recalled
+	return nil, ErrMemoryUnsupported
+}
+
+// WipeMemories forgets the messages, summaries and RAG entries of the agent
+// between from and to, zero times leaving the range open
+func (a *Agent) WipeMemories(ctx context.Context, from, to time.Time) (MemoryWipe, error) {
+	wipe := MemoryWipe{}
+	if from.IsZero() && to.IsZero() {
+		return wipe, fmt.Errorf("a date range is required")
+	}
+
+	storage := NewMySQLStorage(a.options.agentID, a.options.userID)
+	for {
+		messages, err := storage.List(from, to, false, MaxMemoryLimit)
+		if err != nil {
+			return wipe, err
+		}
+		if len(messages) == 0 {
+			break
+		}
+		ids := make([]uuid.UUID, 0, len(messages))
+		for _, m := range messages {
+			ids = append(ids, m.ID)
+		}
+		if err := a.forgetMessages(ctx, ids); err != nil {
+			return wipe, err
+		}
+		if err := storage.Delete(ids...); err != nil {
+			return wipe, err
+		}
+		wipe.Messages += len(ids)
+	}
+
+	query := db.DB.Where("AgentID = ?", a.options.agentID)
+	if !from.IsZero() {
+		query = query.Where("EndedAt >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("EndedAt <= ?", to)
+	}
+	result := query.Delete(&models.MemorySummary{})
+	if result.Error != nil {
+		return wipe, result.Error
+	}
+	wipe.Summaries = int(result.RowsAffected)
+
+	// the entries stored by the agent carry their date
+	if entries, ok := a.options.ragdb.(RAGDBEntries); ok {
+		names, err := entries.Entries()
+		if err != nil {
+			xlog.Warn("Failed to list the RAG entries", "error", err, "agent", a.Character.Name)
+		}
+		for _, name := range names {
+			createdAt := ragEntryTime(name)
+			if createdAt == nil || !inRange(createdAt, from, to) {
+				continue
+			}
+			if err := entries.RemoveEntry(name); err != nil {
+				return wipe, err
+			}
+			wipe.RAGEntries++
+		}
+	}
+
+	a.recordMemoryAudit(ctx, models.MemoryAudit{
+		Operation: MemoryOperationWipe,
+		Source:    "all",
+		OldValue: fmt.Sprintf("%d messages, %d summaries and %d RAG entries between %s and %s",
+			wipe.Messages, wipe.Summaries, wipe.RAGEntries, from.Format(time.RFC3339), to.Format(time.RFC3339)),
+	})
+	xlog.Info("Memories wiped", "agent", a.Character.Name, "from", from, "to", to,
+		"messages", wipe.Messages, "summaries", wipe.Summaries, "rag_entries", wipe.RAGEntries)
+	return wipe, nil
+}
+
+func findStateMemory(memories []string, id string) int {
+	for i, content := range memories {
+		if stateMemoryID(content) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (a *Agent) findMessage(storage *MySQLStorage, id string) (*models.AgentMessage, error) {
+	messageID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrMemoryNotFound
+	}
+	message, err := storage.Get(messageID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMemoryNotFound
+	}
+	return message, err
+}
+
+func (a *Agent) findSummary(id string) (*models.MemorySummary, error) {
+	summaryID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrMemoryNotFound
+	}
+	var summary models.MemorySummary
+	err = db.DB.Where("ID = ? AND AgentID = ?", summaryID, a.options.agentID).First(&summary).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMemoryNotFound
+	}
+	return &summary, err
+}
+
+// forgetRAGContent removes the copies of a forgotten memory from the RAG
+// collection. The RAG service is optional, failures are only logged.
+func (a *Agent) forgetRAGContent(content string) {
+	entries, ok := a.options.ragdb.(RAGDBEntries)
+	if !ok {
+		return
+	}
+	if err := entries.RemoveContent(content); err != nil {
+		xlog.Warn("Failed to remove the memory from the RAG collection", "error", err, "agent", a.Character.Name)
+	}
+}
+
+// pinnedMemoryLookup adds the pinned memories to the conversation
+func (a *Agent) pinnedMemoryLookup(conv Messages) Messages {
+	if !a.persisted() {
+		return conv
+	}
+
+	var messages []models.AgentMessage
+	if err := db.DB.Where("AgentID = ? AND Pinned = ?", a.options.agentID, true).
+		Order("CreatedAt DESC").
+		Limit(maxPinnedMemories).
+		Find(&messages).Error; err != nil {
+		xlog.Warn("Failed to load the pinned messages", "error", err, "agent", a.Character.Name)
+	}
+	var summaries []models.MemorySummary
+	if err := db.DB.Where("AgentID = ? AND Pinned = ?", a.options.agentID, true).
+		Order("EndedAt DESC").
+		Limit(maxPinnedMemories).
+		Find(&summaries).Error; err != nil {
+		xlog.Warn("Failed to load the pinned summaries", "error", err, "agent", a.Character.Name)
+	}
+	// without the HUD the short-term memories written by the memory actions
+	// would never be seen
+	state := types.AgentInternalState{}
+	if a.options.memoryActions && !a.options.enableHUD {
+		state = a.State()
+	}
+	if len(messages) == 0 && len(summaries) == 0 && len(state.Memories) == 0 && state.Goal == "" {
+		return conv
+	}
+
+	content := strings.Builder{}
+	content.WriteString("PINNED MEMORIES: these memories were marked as important, keep them in mind:\n\n")
+	if state.Goal != "" {
+		content.WriteString("Current goal: " + state.Goal + "\n")
+	}
+	for _, m := range state.Memories {
+		content.WriteString("- " + m + "\n")
+	}
+	now := time.Now()
+	for _, m := range messages {
+		content.WriteString(fmt.Sprintf("- [%s, %s ago] %s\n", m.Sender, a.formatTimeAgo(now.Sub(m.CreatedAt)), m.Content))
+	}
+	if len(summaries) > 0 {
+		content.WriteString("\n" + a.formatSummaries(summaries))
+	}
+
+	return append([]openai.ChatCompletionMessage{{
+		Role:    SystemRole,
+		Content: content.String(),
+	}}, conv...)
+}
@@ -0,0 +1,135 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/core/types"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory API", func() {
+	Context("summary consolidation", func() {
+		summary := func(text string) models.MemorySummary {
+			return models.MemorySummary{ID: uuid.New(), Summary: text}
+		}
+		same := func(a, b string) float64 {
+			if a == b {
+				return 1
+			}
+			return 0
+		}
+
+		It("groups the duplicate summaries", func() {
+			first, second, other := summary("tea"), summary("tea"), summary("coffee")
+
+			groups := duplicateSummaries([]models.MemorySummary{first, other, second}, same)
+			Expect(groups).To(HaveLen(1))
+			Expect(groups[0]).To(Equal([]models.MemorySummary{first, second}))
+		})
+
+		It("leaves out the summaries pinned or edited by the user", func() {
+			first, second := summary("tea"), summary("tea")
+			pinned, edited := summary("tea"), summary("tea")
+			pinned.Pinned = true
+			edited.Edited = true
+
+			groups := duplicateSummaries([]models.MemorySummary{pinned, first, edited, second}, same)
+			Expect(groups).To(Equal([][]models.MemorySummary{{first, second}}))
+
+			Expect(duplicateSummaries([]models.MemorySummary{pinned, first, edited}, same)).To(BeEmpty())
+		})
+	})
+
+	Context("short-term memories", func() {
+		var (
+			a   *Agent
+			ctx context.Context
+		)
+
+		BeforeEach(func() {
+			a = newTestAgent(newFakeLLM())
+			ctx = context.Background()
+			Expect(a.updateState(func(state *types.AgentInternalState) error {
+				state.Memories = []string{"the user likes tea", "the user lives in Rome"}
+				return nil
+			})).To(Succeed())
+		})
+
+		It("lists them as pinned memories", func() {
+			memories := a.listStateMemories(MemoryFilter{})
+			Expect(memories).To(HaveLen(2))
+			Expect(memories[0]).To(Equal(Memory{
+				ID:      stateMemoryID("the user likes tea"),
+				Source:  MemorySourceState,
+				Content: "the user likes tea",
+				Pinned:  true,
+			}))
+		})
+
+		It("searches them by keywords", func() {
+			memories := a.listStateMemories(MemoryFilter{Query: "rome"})
+			Expect(memories).To(HaveLen(1))
+			Expect(memories[0].Content).To(Equal("the user lives in Rome"))
+		})
+
+		It("leaves them out of the time ranges", func() {
+			Expect(a.listStateMemories(MemoryFilter{From: time.Now().Add(-time.Hour)})).To(BeEmpty())
+		})
+
+		It("updates them", func() {
+			memory, err := a.UpdateMemory(ctx, MemorySourceState, stateMemoryID("the user likes tea"), "  the user likes coffee ")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(memory.ID).To(Equal(stateMemoryID("the user likes coffee")))
+			Expect(a.State().Memories).To(Equal([]string{"the user likes coffee", "the user lives in Rome"}))
+
+			_, err = a.UpdateMemory(ctx, MemorySourceState, stateMemoryID("the user likes tea"), "the user likes water")
+			Expect(err).To(MatchError(ErrMemoryNotFound))
+			_, err = a.UpdateMemory(ctx, MemorySourceState, memory.ID, " ")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("deletes them", func() {
+			Expect(a.DeleteMemory(ctx, MemorySourceState, stateMemoryID("the user likes tea"))).To(Succeed())
+			Expect(a.State().Memories).To(Equal([]string{"the user lives in Rome"}))
+
+			Expect(a.DeleteMemory(ctx, MemorySourceState, stateMemoryID("the user likes tea"))).To(MatchError(ErrMemoryNotFound))
+		})
+
+		It("can't pin them, they are always recalled", func() {
+			_, err := a.PinMemory(ctx, MemorySourceState, stateMemoryID("the user likes tea"), false)
+			Expect(err).To(MatchError(ErrMemoryUnsupported))
+		})
+
+		It("lists the memories only for the agents persisted in the database", func() {
+			_, err := a.ListMemories(ctx, MemoryFilter{})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("wipes the memories only in a date range", func() {
+			_, err := a.WipeMemories(ctx, time.Time{}, time.Time{})
+			Expect(err).To(MatchError("a date range is required"))
+		})
+	})
+
+	It("tells the time of the RAG entries from their name", func() {
+		t := ragEntryTime("2025-03-04-05-06-07-memory.txt")
+		Expect(t).ToNot(BeNil())
+		Expect(*t).To(Equal(time.Date(2025, 3, 4, 5, 6, 7, 0, time.Local)))
+
+		Expect(ragEntryTime("memory.txt")).To(BeNil())
+		Expect(ragEntryTime("not-a-date-at-all-memory.txt")).To(BeNil())
+	})
+
+	It("filters the memories by time range", func() {
+		now := time.Now()
+		Expect(inRange(nil, time.Time{}, time.Time{})).To(BeTrue())
+		Expect(inRange(nil, now, time.Time{})).To(BeFalse())
+		Expect(inRange(&now, now, now)).To(BeTrue())
+		Expect(inRange(&now, now.Add(time.Second), time.Time{})).To(BeFalse())
+		Expect(inRange(&now, time.Time{}, now.Add(-time.Second))).To(BeFalse())
+	})
+})
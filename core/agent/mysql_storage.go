@@ -263,3 +263,53 @@ func (m *MySQLStorage) GetLastMessagesExcludingCount(limit int, excludeCount int
 
 	return results, nil
 }
+
+// Get returns a message of the agent
+func (m *MySQLStorage) Get(id uuid.UUID) (*models.AgentMessage, error) {
+	var message models.AgentMessage
+	if err := db.DB.Where("ID = ? AND AgentID = ? AND Type = ?", id, m.agentID, "message").First(&message).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// List returns the messages of the agent created between from and to, zero
+// times leaving the range open, most recent first
+func (m *MySQLStorage) List(from, to time.Time, pinnedOnly bool, limit int) ([]models.AgentMessage, error) {
+	query := db.DB.Where("AgentID = ? AND Type = ?", m.agentID, "message")
+	if !from.IsZero() {
+		query = query.Where("CreatedAt >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("CreatedAt <= ?", to)
+	}
+	if pinnedOnly {
+		query = query.Where("Pinned = ?", true)
+	}
+
+	var messages []models.AgentMessage
+	err := query.Order("CreatedAt desc").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+// Update replaces the content of a message of the agent
+func (m *MySQLStorage) Update(id uuid.UUID, content string) error {
+	return db.DB.Model(&models.AgentMessage{}).
+		Where("ID = ? AND AgentID = ?", id, m.agentID).
+		Update("Content", content).Error
+}
+
+// SetPinned pins or unpins a message of the agent
+func (m *MySQLStorage) SetPinned(id uuid.UUID, pinned bool) error {
+	return db.DB.Model(&models.AgentMessage{}).
+		Where("ID = ? AND AgentID = ?", id, m.agentID).
+		Update("Pinned", pinned).Error
+}
+
+// Delete deletes messages of the agent
+func (m *MySQLStorage) Delete(ids ...uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return db.DB.Where("AgentID = ? AND ID IN ?", m.agentID, ids).Delete(&models.AgentMessage{}).Error
+}
@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/core/types"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/llm"
+	"github.com/mudler/LocalAGI/pkg/utils"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/sashabaranov/go-openai"
+)
+
+const nativeToolCallsPrompt = `Current Date and Time: %s
+Agent Name: %s
+
+Use the available tools to fulfill the user's request. Execute user-requested actions DIRECTLY without asking for permission.
+When the request needs several independent actions, call all the required tools at once.
+If no tool is needed, just reply to the user.`
+
+// errNativeToolCallsUnsupported is returned when the model rejected a
+// request with tools, the agent falls back to the template-based selection.
+var errNativeToolCallsUnsupported = errors.New("native tool calls are not supported by the model")
+
+// nativeToolCallsRetryAfter is how long the agent falls back to the
+// template-based selection after the model rejected the tools, the model
+// may have been swapped or the rejection be specific to a request
+const nativeToolCallsRetryAfter = 30 * time.Minute
+
+// useNativeToolCalls reports whether actions should be picked with the
+// native tool calling of the model
+func (a *Agent) useNativeToolCalls() bool {
+	return a.options.nativeToolCalls && time.Now().UnixNano() >= a.nativeToolCallsDisabledUntil.Load()
+}
+
+// pickNativeToolCalls sends all the available actions as tools in a single
+// request and returns the tool calls of the model as action requests, in the
+// order they were returned. If the model did not call any tool, its message
+// is returned instead.
+func (a *Agent) pickNativeToolCalls(job *types.Job, messages []openai.ChatCompletionMessage, offeredActions, availableActions types.Actions, maxRetries int) ([]*types.ActionRequest, string, error) {
+	conversation := append([]openai.ChatCompletionMessage{
+		{
+			Role:    "system",
+			Content: fmt.Sprintf(nativeToolCallsPrompt, time.Now().Format(time.RFC3339), a.Character.Name),
+		},
+	}, messages...)
+
+	tools := offeredActions.ToTools()
+	model := a.options.modelFor(PhasePick)
+	conversation = a.fitToContext(job.GetContext(), model, conversation, tools)
+
+	request := openai.ChatCompletionRequest{
+		Model:             model,
+		Messages:          conversation,
+		Tools:             tools,
+		ParallelToolCalls: true,
+	}
+	if job.ToolChoice != "" {
+		request.ToolChoice = openai.ToolChoice{
+			Type:     openai.ToolTypeFunction,
+			Function: openai.ToolFunction{Name: job.ToolChoice},
+		}
+	}
+
+	var obs *types.Observable
+	if job.Obs != nil {
+		obs = a.observer.NewObservable()
+		obs.Name = "decision"
+		obs.ParentID = job.Obs.ID
+		obs.Icon = "brain"
+		obs.Creation = &types.Creation{
+			ChatCompletionRequest: &request,
+		}
+		a.observer.Update(*obs)
+	}
+
+	var lastErr error
+	for attempts := 0; attempts < maxRetries; attempts++ {
+		resp, err := a.client.CreateChatCompletion(job.GetContext(), request)
+		if err != nil {
+			lastErr = err
+			xlog.Warn("Attempt to pick native tool calls failed", "attempt", attempts+1, "error", err)
+
+			if obs != nil {
+				obs.Progress = append(obs.Progress, types.Progress{
+					Error: err.Error(),
+				})
+				a.observer.Update(*obs)
+			}
+
+			if toolCallsRejected(err) {
+				a.nativeToolCallsDisabledUntil.Store(time.Now().Add(nativeToolCallsRetryAfter).UnixNano())
+				return nil, "", fmt.Errorf("%w: %v", errNativeToolCallsUnsupported, err)
+			}
+			continue
+		}
+
+		usage := utils.GetOpenRouterUsage(resp.ID)
+		llm.TrackUsage(job.GetContext(), &models.LLMUsage{
+			ID:               uuid.New(),
+			UserID:           a.options.userID,
+			AgentID:          a.options.agentID,
+			Model:            llm.ServedModel(resp, request.Model),
+			Provider:         llm.ServedProvider(resp.Header()),
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			TotalTokens:      usage.TotalTokens,
+			Cost:             usage.Cost,
+			RequestType:      PhasePick,
+			GenID:            resp.ID,
+			CreatedAt:        time.Now(),
+		})
+
+		jsonResp, _ := json.Marshal(resp)
+		xlog.Debug("Native tool calls response", "response", string(jsonResp))
+
+		if obs != nil {
+			obs.AddProgress(types.Progress{
+				ChatCompletionResponse: &resp,
+			})
+		}
+
+		if len(resp.Choices) != 1 {
+			lastErr = fmt.Errorf("no choices: %d", len(resp.Choices))
+			if obs != nil {
+				obs.Progress[len(obs.Progress)-1].Error = lastErr.Error()
+				a.observer.Update(*obs)
+			}
+			continue
+		}
+
+		msg := resp.Choices[0].Message
+		if len(offeredActions) < len(availableActions) && callsUnknownTool(msg.ToolCalls, availableActions) {
+			if obs != nil {
+				obs.Progress[len(obs.Progress)-1].Error = errUnavailableTool.Error()
+				a.observer.Update(*obs)
+			}
+			return nil, "", errUnavailableTool
+		}
+
+		requests, err := actionRequestsFromToolCalls(msg.ToolCalls, availableActions)
+		if err != nil {
+			lastErr = err
+			xlog.Warn("Attempt to parse native tool calls failed", "attempt", attempts+1, "error", err)
+			if obs != nil {
+				obs.Progress[len(obs.Progress)-1].Error = lastErr.Error()
+				a.observer.Update(*obs)
+			}
+			continue
+		}
+
+		if err := a.saveConversation(append(messages, msg), "decision"); err != nil {
+			xlog.Error("Error saving conversation", "error", err)
+		}
+
+		if obs != nil {
+			obs.MakeLastProgressCompletion()
+			a.observer.Update(*obs)
+		}
+
+		return requests, msg.Content, nil
+	}
+
+	return nil, "", fmt.Errorf("failed to pick native tool calls after %d attempts: %w", maxRetries, lastErr)
+}
+
+// actionRequestsFromToolCalls maps the tool calls of the model to the
+// available actions. Calls to unknown tools are skipped.
+func actionRequestsFromToolCalls(toolCalls []openai.ToolCall, availableActions types.Actions) ([]*types.ActionRequest, error) {
+	requests := []*types.ActionRequest{}
+	for _, toolCall := range toolCalls {
+		act := availableActions.Find(toolCall.Function.Name)
+		if act == nil {
+			xlog.Warn("Model called an unknown tool, skipping", "tool", toolCall.Function.Name)
+			continue
+		}
+
+		params := types.ActionParams{}
+		if toolCall.Function.Arguments != "" {
+			if err := params.Read(toolCall.Function.Arguments); err != nil {
+				return nil, fmt.Errorf("invalid arguments for tool %s: %w", toolCall.Function.Name, err)
+			}
+		}
+
+		requests = append(requests, &types.ActionRequest{
+			Action: act,
+			Params: &params,
+		})
+	}
+	return requests, nil
+}
+
+// callsUnknownTool reports whether one of the tool calls isn't an action
+func callsUnknownTool(toolCalls []openai.ToolCall, actions types.Actions) bool {
+	for _, toolCall := range toolCalls {
+		if actions.Find(toolCall.Function.Name) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// toolCallsRejected reports whether the API refused the request because of
+// the tools, as opposed to a transient failure worth retrying or a request
+// refused for another reason, e.g. a too long context
+func toolCallsRejected(err error) bool {
+	apiErr := &openai.APIError{}
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.HTTPStatusCode {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity:
+	default:
+		return false
+	}
+
+	message := strings.ToLower(apiErr.Message)
+	if strings.Contains(message, "context") || strings.Contains(message, "token") {
+		return false
+	}
+	return strings.Contains(message, "tool") || strings.Contains(message, "function")
+}
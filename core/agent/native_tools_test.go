@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mudler/LocalAGI/core/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sashabaranov/go-openai"
+)
+
+var _ = Describe("Native tool calls", func() {
+	DescribeTable("tell the rejections of the tools from the other errors",
+		func(err error, rejected bool) {
+			Expect(toolCallsRejected(err)).To(Equal(rejected))
+		},
+		Entry("tools not supported", &openai.APIError{HTTPStatusCode: http.StatusBadRequest, Message: "tools are not supported by this model"}, true),
+		Entry("unknown tool_choice", &openai.APIError{HTTPStatusCode: http.StatusUnprocessableEntity, Message: "invalid tool_choice"}, true),
+		Entry("function calling not found", &openai.APIError{HTTPStatusCode: http.StatusNotFound, Message: "function calling is not available"}, true),
+		Entry("context too long", &openai.APIError{HTTPStatusCode: http.StatusBadRequest, Message: "This model's maximum context length is 4096 tokens, tools included"}, false),
+		Entry("bad parameter", &openai.APIError{HTTPStatusCode: http.StatusBadRequest, Message: "temperature must be between 0 and 2"}, false),
+		Entry("server error", &openai.APIError{HTTPStatusCode: http.StatusInternalServerError, Message: "tool parser crashed"}, false),
+		Entry("not an API error", fmt.Errorf("connection reset"), false),
+	)
+
+	It("falls back to the template-based selection for a while when the model rejects the tools", func() {
+		// the LLM chain tries the request 3 times before giving up
+		rejected := errorReply(http.StatusBadRequest, "tools are not supported by this model")
+		llm := newFakeLLM(rejected, rejected, rejected, textReply("Hello there"))
+		a := newTestAgent(llm, EnableNativeToolCalls, WithActions(&countAction{name: "count"}))
+
+		result := a.Ask(types.WithText("hi"))
+		Expect(result.Error).ToNot(HaveOccurred())
+		Expect(result.Response).To(Equal("Hello there"))
+		Expect(a.useNativeToolCalls()).To(BeFalse())
+
+		a.nativeToolCallsDisabledUntil.Store(time.Now().Add(-time.Second).UnixNano())
+		Expect(a.useNativeToolCalls()).To(BeTrue())
+	})
+
+	It("keeps the native tool calls when a request is refused for another reason", func() {
+		tooLong := errorReply(http.StatusBadRequest, "maximum context length is 4096 tokens")
+		llm := newFakeLLM(tooLong, tooLong, tooLong, tooLong, tooLong, tooLong, tooLong, tooLong, tooLong, textReply("Hello there"))
+		a := newTestAgent(llm, EnableNativeToolCalls, WithActions(&countAction{name: "count"}))
+
+		result := a.Ask(types.WithText("hi"))
+		Expect(result.Error).ToNot(HaveOccurred())
+		Expect(a.useNativeToolCalls()).To(BeTrue())
+	})
+})
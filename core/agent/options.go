@@ -2,11 +2,14 @@ package agent
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/pkg/llm"
 	"github.com/sashabaranov/go-openai"
 )
 
@@ -19,6 +22,24 @@ type llmOptions struct {
 	MultimodalModel string
 }
 
+// LLMFallback is an LLM endpoint and model the agent fails over to when the
+// previous ones fail. Empty fields inherit the agent LLM settings.
+type LLMFallback struct {
+	APIURL string `json:"api_url,omitempty"`
+	APIKey string `json:"api_key,omitempty"`
+	Model  string `json:"model,omitempty"`
+}
+
+// Phases of a job that can be routed to different models
+const (
+	PhasePick       = "pick"
+	PhaseParams     = "params"
+	PhaseEvaluate   = "evaluate"
+	PhaseReply      = "reply"
+	PhaseSummarize  = "summarize"
+	PhaseMultimodal = "multimodal"
+)
+
 type options struct {
 	LLMAPI                                                                                       llmOptions
 	character                                                                                    Character
@@ -33,6 +54,7 @@ type options struct {
 	initiateConversations bool
 	loopDetectionSteps    int
 	forceReasoning        bool
+	nativeToolCalls       bool
 	canPlan               bool
 	context               context.Context
 	permanentGoal         string
@@ -44,9 +66,47 @@ type options struct {
 	agentID               uuid.UUID
 	useMySQLForSummaries  bool
 
+	llmFallbacks []LLMFallback
+	sampling     llm.SamplingParams
+	// llmTransport carries the requests to the LLM API, e.g. a llm.Cassette
+	llmTransport http.RoundTripper
+	// phaseModels routes the phases of a job to specific models
+	phaseModels map[string]string
+	// embeddingModel computes the embeddings of the agent, e.g. for tool
+	// retrieval
+	embeddingModel string
+	// actionReliabilityHints warns in the HUD about the actions failing
+	// repeatedly
+	actionReliabilityHints bool
+	// toolRetrievalTopK is how many actions relevant to the conversation are
+	// offered to the picker, zero offers every action
+	toolRetrievalTopK int
+	// semanticMemory stores the long-term memory as embeddings, the keyword
+	// search of MySQL is used when nil
+	semanticMemory SemanticMemory
+	// semanticMemoryKey identifies where semanticMemory keeps the chunks
+	semanticMemoryKey string
+	// memoryActions offers the remember, forget and update_goal actions
+	memoryActions bool
+	// maxMemories bounds the short-term memories, DefaultMaxMemories if zero
+	maxMemories int
+
+	jobBudget types.JobBudget
+
+	// contextWindow overrides the context length of the model, in tokens
+	contextWindow int
+
+	// actions that need to be approved before running
+	approvalRequired  map[string]bool
+	approvalCallbacks []func(types.ApprovalRequest)
+
 	// Evaluation settings
 	maxEvaluationLoops int
 	enableEvaluation   bool
+	evaluators         types.Evaluators
+
+	// maxReplans bounds the revisions of a plan when its subtasks fail
+	maxReplans int
 
 	prompts []DynamicPrompt
 
@@ -63,10 +123,29 @@ type options struct {
 
 	observer     Observer
 	parallelJobs int
+	// jobQueueSize bounds the backlog of jobs waiting for a worker
+	jobQueueSize int
+	// pauseMode is what happens to the jobs received while paused
+	pauseMode    string
+	parkedJobTTL time.Duration
+	retryPolicy  RetryPolicy
+	// parallelToolCalls bounds the tool calls of a single turn run concurrently
+	parallelToolCalls int
 
 	lastMessageDuration time.Duration
 }
 
+// modelFor returns the model serving a phase, the agent model by default
+func (o *options) modelFor(phase string) string {
+	if model := o.phaseModels[phase]; model != "" {
+		return model
+	}
+	if phase == PhaseMultimodal && o.LLMAPI.MultimodalModel != "" {
+		return o.LLMAPI.MultimodalModel
+	}
+	return o.LLMAPI.Model
+}
+
 func (o *options) SeparatedMultimodalModel() bool {
 	return o.LLMAPI.MultimodalModel != "" && o.LLMAPI.Model != o.LLMAPI.MultimodalModel
 }
@@ -74,9 +153,15 @@ func (o *options) SeparatedMultimodalModel() bool {
 func defaultOptions() *options {
 	return &options{
 		parallelJobs:       1,
+		jobQueueSize:       100,
+		pauseMode:          PauseModeReject,
+		parkedJobTTL:       24 * time.Hour,
+		retryPolicy:        DefaultRetryPolicy(),
+		parallelToolCalls:  1,
 		periodicRuns:       15 * time.Minute,
 		loopDetectionSteps: 10,
 		maxEvaluationLoops: 2,
+		maxReplans:         0,
 		enableEvaluation:   false,
 		LLMAPI: llmOptions{
 			APIURL: "http://localhost:8080",
@@ -112,6 +197,23 @@ var EnableForceReasoning = func(o *options) error {
 	return nil
 }
 
+// EnableNativeToolCalls makes the agent pick actions with the native tool
+// calling of the model: all the actions are sent in a single request and the
+// returned tool calls are run directly. The template-based selection is used
+// as a fallback when the model does not support tools.
+var EnableNativeToolCalls = func(o *options) error {
+	o.nativeToolCalls = true
+	return nil
+}
+
+// EnableActionReliabilityHints warns the agent in its HUD about the actions
+// that failed repeatedly in the last hour, with their last error, so it
+// stops calling a broken integration
+var EnableActionReliabilityHints = func(o *options) error {
+	o.actionReliabilityHints = true
+	return nil
+}
+
 var EnableKnowledgeBase = func(o *options) error {
 	o.enableKB = true
 	o.kbResults = 5
@@ -163,6 +265,14 @@ func WithLastMessageDuration(duration string) Option {
 	}
 }
 
+// WithJobBudget sets the default budget of the jobs run by the agent
+func WithJobBudget(budget types.JobBudget) Option {
+	return func(o *options) error {
+		o.jobBudget = budget
+		return nil
+	}
+}
+
 func WithParallelJobs(jobs int) Option {
 	return func(o *options) error {
 		o.parallelJobs = jobs
@@ -170,6 +280,171 @@ func WithParallelJobs(jobs int) Option {
 	}
 }
 
+// WithJobQueueSize bounds the number of jobs waiting to run, jobs enqueued
+// beyond it fail with ErrJobQueueFull. Zero means unbounded.
+func WithJobQueueSize(size int) Option {
+	return func(o *options) error {
+		o.jobQueueSize = size
+		return nil
+	}
+}
+
+// WithPauseMode sets what happens to the jobs received while the agent is
+// paused: PauseModeReject fails them, PauseModePark keeps them until the
+// agent is resumed
+func WithPauseMode(mode string) Option {
+	return func(o *options) error {
+		switch mode {
+		case PauseModeReject, PauseModePark:
+			o.pauseMode = mode
+			return nil
+		}
+		return fmt.Errorf("unknown pause mode: %s", mode)
+	}
+}
+
+// WithParkedJobTTL sets how long a job parked while the agent is paused
+// waits before expiring. Zero keeps parked jobs until the agent is resumed.
+func WithParkedJobTTL(ttl time.Duration) Option {
+	return func(o *options) error {
+		o.parkedJobTTL = ttl
+		return nil
+	}
+}
+
+// WithEmbeddingModel sets the model computing the embeddings of the agent,
+// llm.DefaultEmbeddingModel by default
+func WithEmbeddingModel(model string) Option {
+	return func(o *options) error {
+		o.embeddingModel = model
+		return nil
+	}
+}
+
+// WithSemanticMemory stores the messages of the agent as embedded chunks in
+// the store and recalls the long-term memories by similarity, keywords and
+// recency instead of the keyword search of MySQL. key identifies where the
+// store keeps the chunks: the messages indexed under another key (e.g. in a
+// store kept in memory by a previous run) are embedded again.
+func WithSemanticMemory(store SemanticMemory, key string) Option {
+	return func(o *options) error {
+		if key == "" {
+			return fmt.Errorf("the semantic memory needs a key")
+		}
+		o.semanticMemory = store
+		o.semanticMemoryKey = key
+		return nil
+	}
+}
+
+// WithToolRetrieval offers to the picker only the topK actions most relevant
+// to the conversation, along with the core actions (reply, stop, plan, ...)
+// and the tools of the job, instead of every action. The actions are ranked
+// by the similarity of their embedded description to the last messages.
+func WithToolRetrieval(topK int) Option {
+	return func(o *options) error {
+		if topK < 0 {
+			return fmt.Errorf("invalid tool retrieval top k: %d", topK)
+		}
+		o.toolRetrievalTopK = topK
+		return nil
+	}
+}
+
+// WithRetryPolicy sets how the jobs failing with transient errors are
+// retried before being moved to the dead-letter list
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) error {
+		if policy.MaxAttempts < 0 || policy.InitialBackoff < 0 || policy.MaxBackoff < 0 {
+			return fmt.Errorf("invalid retry policy: %+v", policy)
+		}
+		o.retryPolicy = policy
+		return nil
+	}
+}
+
+// WithLLMFallbacks sets the chain of LLM endpoints and models the agent
+// fails over to, in order, when its LLM API fails
+func WithLLMFallbacks(fallbacks ...LLMFallback) Option {
+	return func(o *options) error {
+		o.llmFallbacks = append(o.llmFallbacks, fallbacks...)
+		return nil
+	}
+}
+
+// WithSamplingParams sets the sampling parameters of every LLM request
+// of the agent
+func WithSamplingParams(params llm.SamplingParams) Option {
+	return func(o *options) error {
+		o.sampling = params
+		return nil
+	}
+}
+
+// WithLLMTransport sends the requests to the LLM API through transport.
+// Tests use it with a llm.Cassette to record and replay the interactions.
+func WithLLMTransport(transport http.RoundTripper) Option {
+	return func(o *options) error {
+		o.llmTransport = transport
+		return nil
+	}
+}
+
+// WithPhaseModel routes the LLM calls of a phase (e.g. PhasePick) to a
+// specific model. An empty model keeps the agent model.
+func WithPhaseModel(phase, model string) Option {
+	return func(o *options) error {
+		if o.phaseModels == nil {
+			o.phaseModels = map[string]string{}
+		}
+		o.phaseModels[phase] = model
+		if phase == PhaseMultimodal {
+			o.LLMAPI.MultimodalModel = model
+		}
+		return nil
+	}
+}
+
+// WithContextWindow sets the context length of the model in tokens,
+// used to compact the conversations that would not fit
+func WithContextWindow(tokens int) Option {
+	return func(o *options) error {
+		o.contextWindow = tokens
+		return nil
+	}
+}
+
+// WithParallelToolCalls sets how many of the tool calls returned by the
+// model in a single turn can run concurrently
+func WithParallelToolCalls(calls int) Option {
+	return func(o *options) error {
+		o.parallelToolCalls = calls
+		return nil
+	}
+}
+
+// WithActionsRequiringApproval pauses the job before running any of the
+// given actions until the request is approved, modified or denied
+func WithActionsRequiringApproval(names ...string) Option {
+	return func(o *options) error {
+		if o.approvalRequired == nil {
+			o.approvalRequired = map[string]bool{}
+		}
+		for _, name := range names {
+			o.approvalRequired[name] = true
+		}
+		return nil
+	}
+}
+
+// WithApprovalCallback registers a callback notified of every new approval request
+func WithApprovalCallback(cb func(types.ApprovalRequest)) Option {
+	return func(o *options) error {
+		o.approvalCallbacks = append(o.approvalCallbacks, cb)
+		return nil
+	}
+}
+
 func WithNewConversationSubscriber(sub func(openai.ChatCompletionMessage)) Option {
 	return func(o *options) error {
 		o.newConversationsSubscribers = append(o.newConversationsSubscribers, sub)
@@ -204,6 +479,24 @@ var EnableSummaryMemory = func(o *options) error {
 	return nil
 }
 
+// EnableMemoryActions lets the agent remember and forget things and update
+// its goal, during the conversations and the periodic runs
+var EnableMemoryActions = func(o *options) error {
+	o.memoryActions = true
+	return nil
+}
+
+// WithMaxMemories bounds the short-term memories of the agent
+func WithMaxMemories(n int) Option {
+	return func(o *options) error {
+		if n < 0 {
+			return fmt.Errorf("invalid max memories: %d", n)
+		}
+		o.maxMemories = n
+		return nil
+	}
+}
+
 var EnableLongTermMemory = func(o *options) error {
 	o.enableLongTermMemory = true
 	return nil
@@ -386,6 +679,24 @@ var EnableStripThinkingTags = func(o *options) error {
 	return nil
 }
 
+// WithMaxReplans sets how many times a plan can be revised when its
+// subtasks fail, 0 disables replanning
+func WithMaxReplans(replans int) Option {
+	return func(o *options) error {
+		o.maxReplans = replans
+		return nil
+	}
+}
+
+// WithEvaluators sets the evaluators checking the outcome of the jobs,
+// the job is retried while one of them fails
+func WithEvaluators(evaluators ...types.Evaluator) Option {
+	return func(o *options) error {
+		o.evaluators = append(o.evaluators, evaluators...)
+		return nil
+	}
+}
+
 func WithMaxEvaluationLoops(loops int) Option {
 	return func(o *options) error {
 		o.maxEvaluationLoops = loops
@@ -0,0 +1,286 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/db"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/sashabaranov/go-openai"
+	"gorm.io/datatypes"
+)
+
+const (
+	// PauseModeReject fails the jobs received while the agent is paused
+	PauseModeReject = "reject"
+	// PauseModePark keeps the jobs received while the agent is paused and
+	// runs them once it's resumed
+	PauseModePark = "park"
+)
+
+var (
+	ErrAgentPaused  = errors.New("agent is paused")
+	ErrJobExpired   = errors.New("the job expired while the agent was paused")
+	ErrJobDiscarded = errors.New("the job was discarded while the agent was paused")
+	ErrJobNotParked = errors.New("parked job not found")
+)
+
+type parkedJob struct {
+	job      *types.Job
+	role     string
+	parkedAt time.Time
+	timer    *time.Timer
+}
+
+func (a *Agent) persisted() bool {
+	return a.options.agentID != uuid.Nil && a.options.userID != uuid.Nil
+}
+
+// parkJob keeps a job received while the agent is paused until the agent
+// is resumed or the job expires
+func (a *Agent) parkJob(job *types.Job, role string) {
+	ttl := a.options.parkedJobTTL
+
+	stored := false
+	if a.persisted() {
+		parked := models.ParkedJob{
+			ID:              uuid.New(),
+			JobID:           job.UUID,
+			AgentID:         a.options.agentID,
+			UserID:          a.options.userID,
+			Role:            role,
+			Priority:        int(job.Priority),
+			ConversationKey: job.ConversationKey,
+			Conversation:    marshalCheckpointField(job.ConversationHistory),
+			Metadata:        marshalCheckpointField(job.Metadata),
+		}
+		if ttl > 0 {
+			expiresAt := time.Now().Add(ttl)
+			parked.ExpiresAt = &expiresAt
+		}
+		if err := db.DB.Create(&parked).Error; err != nil {
+			xlog.Error("Failed to persist parked job", "error", err, "agent", a.Character.Name, "job", job.UUID)
+		} else {
+			stored = true
+		}
+	}
+
+	a.Lock()
+	if !a.pause {
+		// resumed in the meantime, unless the job was drained already
+		a.Unlock()
+		if !stored || a.deleteParkedJob(job.UUID) > 0 {
+			a.enqueue(job, role)
+		}
+		return
+	}
+	p := &parkedJob{job: job, role: role, parkedAt: time.Now()}
+	if ttl > 0 {
+		p.timer = time.AfterFunc(ttl, func() {
+			a.dropParkedJob(job.UUID, ErrJobExpired)
+		})
+	}
+	a.parkedJobs = append(a.parkedJobs, p)
+	a.Unlock()
+
+	a.updateJob(job, func(j *activeJob) {
+		j.status = JobStatusParked
+	})
+
+	xlog.Info("Agent is paused, parked job", "agent", a.Character.Name, "job", job.UUID, "ttl", ttl)
+}
+
+// unparkJob removes a job from the parked jobs, it returns nil if the job
+// isn't parked in memory
+func (a *Agent) unparkJob(jobID string) *parkedJob {
+	a.Lock()
+	defer a.Unlock()
+
+	for i, p := range a.parkedJobs {
+		if p.job.UUID == jobID {
+			a.parkedJobs = append(a.parkedJobs[:i], a.parkedJobs[i+1:]...)
+			if p.timer != nil {
+				p.timer.Stop()
+			}
+			return p
+		}
+	}
+	return nil
+}
+
+func (a *Agent) deleteParkedJob(jobID string) int64 {
+	if !a.persisted() {
+		return 0
+	}
+
+	res := db.DB.Where("AgentID = ? AND JobID = ?", a.options.agentID, jobID).Delete(&models.ParkedJob{})
+	if res.Error != nil {
+		xlog.Error("Failed to delete parked job", "error", res.Error, "agent", a.Character.Name, "job", jobID)
+	}
+	return res.RowsAffected
+}
+
+// dropParkedJob fails a parked job with the given error
+func (a *Agent) dropParkedJob(jobID string, reason error) bool {
+	deleted := a.deleteParkedJob(jobID)
+
+	p := a.unparkJob(jobID)
+	if p == nil {
+		return deleted > 0
+	}
+
+	xlog.Info("Dropping parked job", "agent", a.Character.Name, "job", jobID, "reason", reason)
+	p.job.Result.Finish(reason)
+	return true
+}
+
+// DiscardParkedJob drops a job parked while the agent is paused
+func (a *Agent) DiscardParkedJob(jobID string) error {
+	if !a.dropParkedJob(jobID, ErrJobDiscarded) {
+		return ErrJobNotParked
+	}
+	return nil
+}
+
+// ParkedJobs lists the jobs waiting for the agent to be resumed, oldest first
+func (a *Agent) ParkedJobs() ([]models.ParkedJob, error) {
+	if !a.persisted() {
+		a.Lock()
+		defer a.Unlock()
+
+		jobs := []models.ParkedJob{}
+		for _, p := range a.parkedJobs {
+			jobs = append(jobs, models.ParkedJob{
+				JobID:           p.job.UUID,
+				Role:            p.role,
+				Priority:        int(p.job.Priority),
+				ConversationKey: p.job.ConversationKey,
+				Conversation:    marshalCheckpointField(p.job.ConversationHistory),
+				Metadata:        marshalCheckpointField(p.job.Metadata),
+				CreatedAt:       p.parkedAt,
+			})
+		}
+		return jobs, nil
+	}
+
+	a.expireParkedJobs()
+
+	var jobs []models.ParkedJob
+	if err := db.DB.Where("AgentID = ?", a.options.agentID).
+		Order("CreatedAt ASC").
+		Find(&jobs).Error; err != nil {
+		return nil, fmt.Errorf("failed to load parked jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// expireParkedJobs drops the persisted parked jobs past their TTL, the jobs
+// parked by this process expire on their own
+func (a *Agent) expireParkedJobs() {
+	var expired []models.ParkedJob
+	if err := db.DB.Where("AgentID = ? AND ExpiresAt < ?", a.options.agentID, time.Now()).
+		Find(&expired).Error; err != nil {
+		xlog.Error("Failed to load expired parked jobs", "error", err, "agent", a.Character.Name)
+		return
+	}
+
+	for _, p := range expired {
+		a.dropParkedJob(p.JobID, ErrJobExpired)
+	}
+}
+
+// drainParkedJobs queues the parked jobs once the agent is resumed. The jobs
+// parked before a restart are restored from the database, nobody is waiting
+// for them anymore so they are run like resumed jobs.
+func (a *Agent) drainParkedJobs() {
+	a.Lock()
+	parked := a.parkedJobs
+	a.parkedJobs = nil
+	a.Unlock()
+
+	for _, p := range parked {
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+		a.deleteParkedJob(p.job.UUID)
+		xlog.Info("Running parked job", "agent", a.Character.Name, "job", p.job.UUID)
+		a.enqueue(p.job, p.role)
+	}
+
+	if !a.persisted() {
+		return
+	}
+
+	a.expireParkedJobs()
+
+	var stored []models.ParkedJob
+	if err := db.DB.Where("AgentID = ?", a.options.agentID).
+		Order("CreatedAt ASC").
+		Find(&stored).Error; err != nil {
+		xlog.Error("Failed to load parked jobs", "error", err, "agent", a.Character.Name)
+		return
+	}
+
+	for _, p := range stored {
+		if a.deleteParkedJob(p.JobID) == 0 {
+			// drained concurrently
+			continue
+		}
+
+		job, err := a.restoreJob(p.JobID, p.Conversation, p.Metadata, p.Priority, p.ConversationKey)
+		if err != nil {
+			xlog.Warn("Cannot restore parked job, dropping it", "agent", a.Character.Name, "job", p.JobID, "error", err)
+			continue
+		}
+
+		xlog.Info("Running parked job", "agent", a.Character.Name, "job", job.UUID)
+		go a.resumeJob(job, p.Role)
+	}
+}
+
+// restoreJob rebuilds a job persisted before it ran, e.g. parked or waiting
+// for a retry
+func (a *Agent) restoreJob(jobID string, conversation, meta datatypes.JSON, priority int, conversationKey string) (*types.Job, error) {
+	conv := []openai.ChatCompletionMessage{}
+	if err := unmarshalCheckpointField(conversation, &conv); err != nil {
+		return nil, fmt.Errorf("invalid conversation: %w", err)
+	}
+	if len(conv) == 0 {
+		return nil, fmt.Errorf("empty conversation")
+	}
+
+	metadata := map[string]interface{}{}
+	if err := unmarshalCheckpointField(meta, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+
+	return types.NewJob(
+		types.WithUUID(jobID),
+		types.WithConversationHistory(conv),
+		types.WithMetadata(metadata),
+		types.WithPriority(types.JobPriority(priority)),
+		types.WithConversationKey(conversationKey),
+		types.WithReasoningCallback(a.options.reasoningCallback),
+		types.WithResultCallback(a.options.resultCallback),
+	), nil
+}
+
+// releaseParkedJobs fails the jobs parked in memory once the agent stops,
+// their persisted copies run when the agent is started again
+func (a *Agent) releaseParkedJobs() {
+	a.Lock()
+	parked := a.parkedJobs
+	a.parkedJobs = nil
+	a.Unlock()
+
+	for _, p := range parked {
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+		p.job.Result.Finish(ErrContextCanceled)
+	}
+}
@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/mudler/LocalAGI/core/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sashabaranov/go-openai"
+)
+
+// executeAsync runs a job in the background, the result is sent once done
+func executeAsync(a *Agent, job *types.Job) <-chan *types.JobResult {
+	done := make(chan *types.JobResult, 1)
+	go func() {
+		done <- a.Execute(job)
+	}()
+	return done
+}
+
+func parkedIDs(a *Agent) []string {
+	parked, err := a.ParkedJobs()
+	Expect(err).ToNot(HaveOccurred())
+	ids := []string{}
+	for _, p := range parked {
+		ids = append(ids, p.JobID)
+	}
+	return ids
+}
+
+var _ = Describe("Parked jobs", func() {
+	It("fails the jobs received while paused by default", func() {
+		a := newTestAgent(newFakeLLM())
+		a.Pause()
+
+		result := a.Ask(types.WithText("hello"))
+		Expect(result.Error).To(MatchError(ErrAgentPaused))
+	})
+
+	It("parks the jobs received while paused and runs them once resumed", func() {
+		a := newTestAgent(newFakeLLM(textReply("hello there")), WithPauseMode(PauseModePark))
+		a.Pause()
+
+		job := types.NewJob(types.WithText("hello"))
+		done := executeAsync(a, job)
+		Eventually(func() []string { return parkedIDs(a) }).Should(ConsistOf(job.UUID))
+
+		info, _, err := a.ActiveJob(job.UUID)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Status).To(Equal(JobStatusParked))
+		Consistently(done, 50*time.Millisecond).ShouldNot(Receive())
+
+		a.Resume()
+		var result *types.JobResult
+		Eventually(done).Should(Receive(&result))
+		Expect(result.Error).ToNot(HaveOccurred())
+		Expect(result.Response).To(Equal("hello there"))
+		Expect(parkedIDs(a)).To(BeEmpty())
+	})
+
+	It("expires the jobs parked for longer than their TTL", func() {
+		a := newTestAgent(newFakeLLM(), WithPauseMode(PauseModePark), WithParkedJobTTL(50*time.Millisecond))
+		a.Pause()
+
+		job := types.NewJob(types.WithText("hello"))
+		done := executeAsync(a, job)
+
+		var result *types.JobResult
+		Eventually(done).Should(Receive(&result))
+		Expect(result.Error).To(MatchError(ErrJobExpired))
+		Expect(parkedIDs(a)).To(BeEmpty())
+
+		// a resume doesn't run the expired jobs
+		a.Resume()
+		Expect(a.ActiveJobs()).To(BeEmpty())
+	})
+
+	It("discards a parked job", func() {
+		a := newTestAgent(newFakeLLM(), WithPauseMode(PauseModePark))
+		a.Pause()
+
+		job := types.NewJob(types.WithText("hello"))
+		done := executeAsync(a, job)
+		Eventually(func() []string { return parkedIDs(a) }).Should(ConsistOf(job.UUID))
+
+		Expect(a.DiscardParkedJob(job.UUID)).To(Succeed())
+		var result *types.JobResult
+		Eventually(done).Should(Receive(&result))
+		Expect(result.Error).To(MatchError(ErrJobDiscarded))
+		Expect(a.DiscardParkedJob(job.UUID)).To(MatchError(ErrJobNotParked))
+	})
+
+	It("fails the parked jobs when the agent stops", func() {
+		fake := newFakeLLM()
+		a, err := New(append(fakeLLMOptions(fake), WithPauseMode(PauseModePark))...)
+		Expect(err).ToNot(HaveOccurred())
+		go a.Run()
+		a.Pause()
+
+		job := types.NewJob(types.WithText("hello"))
+		done := executeAsync(a, job)
+		Eventually(func() []string { return parkedIDs(a) }).Should(ConsistOf(job.UUID))
+
+		a.Stop()
+		var result *types.JobResult
+		Eventually(done).Should(Receive(&result))
+		Expect(result.Error).To(MatchError(ErrContextCanceled))
+		Expect(fake.Requests()).To(BeEmpty())
+	})
+
+	It("restores the jobs parked before a restart", func() {
+		a := newTestAgent(newFakeLLM())
+		conv := []openai.ChatCompletionMessage{{Role: UserRole, Content: "hello"}}
+
+		job, err := a.restoreJob("parked-job",
+			marshalCheckpointField(conv),
+			marshalCheckpointField(map[string]any{"channel": "general"}),
+			int(types.JobPriorityReminder), "thread")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(job.UUID).To(Equal("parked-job"))
+		Expect(job.ConversationHistory).To(Equal(conv))
+		Expect(job.Metadata).To(HaveKeyWithValue("channel", "general"))
+		Expect(job.Priority).To(Equal(types.JobPriorityReminder))
+		Expect(job.ConversationKey).To(Equal("thread"))
+
+		_, err = a.restoreJob("empty", marshalCheckpointField([]openai.ChatCompletionMessage{}), nil, 0, "")
+		Expect(err).To(MatchError(ContainSubstring("empty conversation")))
+	})
+})
@@ -0,0 +1,517 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mudler/LocalAGI/core/action"
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/pkg/llm"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// subtask statuses reported by the subtask observables
+const (
+	subtaskPending = "pending"
+	subtaskRunning = "running"
+	subtaskDone    = "done"
+	subtaskFailed  = "failed"
+	subtaskSkipped = "skipped"
+)
+
+// planSubtask is a subtask of a plan being executed
+type planSubtask struct {
+	action.PlanSubtask
+
+	act       types.Action
+	params    types.ActionParams
+	reasoning string
+	result    types.ActionResult
+	err       error
+	status    string
+	obs       *types.Observable
+}
+
+// errPlanStopped is returned when the job callback stopped the plan
+var errPlanStopped = errors.New("plan stopped by callback")
+
+// executePlan runs a plan, revising it when subtasks fail or their results
+// are not good enough: the planner gets the progress so far and writes the
+// remaining subtasks, keeping the completed ones. Every version of the plan
+// is kept in the job result.
+func (a *Agent) executePlan(job *types.Job, plan action.PlanResult, planParams types.ActionParams, pickTemplate string, conv Messages) (Messages, error) {
+	completed := map[string]*planSubtask{}
+	incomplete := []*planSubtask{}
+	reason := ""
+
+	for revision := 0; ; revision++ {
+		job.Result.AddPlanRevision(types.PlanRevision{
+			Revision: revision,
+			Plan:     planParams,
+			Reason:   reason,
+			Kept:     completedIDs(completed),
+		})
+
+		var subtasks []*planSubtask
+		var err error
+		conv, subtasks, err = a.runPlan(job, plan, pickTemplate, conv, completed)
+		if errors.Is(err, errPlanStopped) {
+			return conv, nil
+		}
+		if err != nil && revision == 0 {
+			return conv, err
+		}
+		if err != nil {
+			// the revised plan is unusable, keep the outcome of the previous one
+			xlog.Warn("[Planning] Revised plan failed", "agent", a.Character.Name, "error", err)
+			break
+		}
+
+		incomplete = incomplete[:0]
+		for _, subtask := range subtasks {
+			if subtask.status == subtaskDone {
+				completed[subtask.ID] = subtask
+			} else {
+				incomplete = append(incomplete, subtask)
+			}
+		}
+
+		if revision >= a.options.maxReplans {
+			break
+		}
+
+		if len(incomplete) == 0 {
+			incomplete = a.reviewSubtasks(job, plan.Goal, subtasks)
+			for _, subtask := range incomplete {
+				delete(completed, subtask.ID)
+			}
+		}
+		if len(incomplete) == 0 {
+			break
+		}
+
+		reason = describeSubtasks(incomplete)
+		xlog.Info("[Planning] Replanning", "agent", a.Character.Name, "revision", revision+1, "reason", reason)
+
+		revised, revisedParams, err := a.replan(job, plan.Goal, completed, incomplete)
+		if err != nil {
+			xlog.Warn("[Planning] Failed to revise the plan", "agent", a.Character.Name, "error", err)
+			break
+		}
+		if len(revised.Subtasks) == 0 {
+			break
+		}
+		plan, planParams = revised, revisedParams
+	}
+
+	if len(completed) == 0 {
+		return conv, fmt.Errorf("no subtask of the plan could be completed:\n%s", describeSubtasks(incomplete))
+	}
+	if len(incomplete) > 0 {
+		conv = append(conv, openai.ChatCompletionMessage{
+			Role:    SystemRole,
+			Content: "The following subtasks of the plan could not be completed:\n" + describeSubtasks(incomplete),
+		})
+	}
+
+	return conv, nil
+}
+
+// runPlan executes the subtasks of a plan following their dependencies:
+// the subtasks of a wave run concurrently, and each subtask sees the results
+// of the previous waves. A subtask that fails is reported and the subtasks
+// depending on it are skipped, the rest of the plan keeps going.
+//
+// The completed subtasks satisfy the dependencies of the plan. The subtasks
+// of the plan are returned in order with their outcome.
+func (a *Agent) runPlan(job *types.Job, plan action.PlanResult, pickTemplate string, conv Messages, completed map[string]*planSubtask) (Messages, []*planSubtask, error) {
+	waves, err := plan.Waves(completedIDs(completed)...)
+	if err != nil {
+		return conv, nil, fmt.Errorf("invalid plan: %w", err)
+	}
+
+	subtasks := map[string]*planSubtask{}
+	for id, subtask := range completed {
+		subtasks[id] = subtask
+	}
+	ordered := []*planSubtask{}
+	for _, wave := range waves {
+		for _, s := range wave {
+			subtask := &planSubtask{PlanSubtask: s, status: subtaskPending}
+			subtask.obs = a.subtaskObservable(job, subtask)
+			subtasks[s.ID] = subtask
+			ordered = append(ordered, subtask)
+		}
+	}
+
+	for _, wave := range waves {
+		ready := []*planSubtask{}
+
+		for _, s := range wave {
+			subtask := subtasks[s.ID]
+
+			if failed := failedDependencies(subtask, subtasks); len(failed) > 0 {
+				a.finishSubtask(subtask, subtaskSkipped, fmt.Errorf("depends on subtasks that did not complete: %s", strings.Join(failed, ", ")))
+				continue
+			}
+
+			if exceeded := job.Budget.Check(len(job.GetSteps())+len(ready), 0, 0, 0); exceeded != nil {
+				return conv, ordered, exceeded
+			}
+
+			subtask.act = a.availableActions().Find(subtask.Action)
+			if subtask.act == nil {
+				xlog.Error("Action not found", "action", subtask.Action)
+				a.finishSubtask(subtask, subtaskFailed, fmt.Errorf("action %s not found", subtask.Action))
+				continue
+			}
+
+			subtask.reasoning = subtaskReasoning(subtask, plan.Goal, subtasks)
+
+			xlog.Info("[subtask] Generating parameters",
+				"agent", a.Character.Name,
+				"subtask", subtask.ID,
+				"action", subtask.Action,
+			)
+
+			params, err := a.generateParameters(job, pickTemplate, subtask.act, conv, subtask.reasoning, maxRetries)
+			if err != nil {
+				xlog.Error("error generating action's parameters", "error", err)
+				a.finishSubtask(subtask, subtaskFailed, fmt.Errorf("error generating action's parameters: %w", err))
+				continue
+			}
+			subtask.params = params.actionParams
+
+			if !job.Callback(types.ActionCurrentState{
+				Job:       job,
+				Action:    subtask.act,
+				Params:    subtask.params,
+				Reasoning: subtask.reasoning,
+			}) {
+				job.Result.SetResult(types.ActionState{
+					ActionCurrentState: types.ActionCurrentState{
+						Job:       job,
+						Action:    subtask.act,
+						Params:    subtask.params,
+						Reasoning: subtask.reasoning,
+					},
+					ActionResult: types.ActionResult{
+						Result: "stopped by callback",
+					},
+				})
+				job.Result.Conversation = conv
+				job.Result.Finish(nil)
+				return conv, ordered, errPlanStopped
+			}
+
+			ready = append(ready, subtask)
+		}
+
+		if len(ready) == 0 {
+			continue
+		}
+
+		requests := make([]*types.ActionRequest, len(ready))
+		for i, subtask := range ready {
+			requests[i] = &types.ActionRequest{Action: subtask.act, Params: &subtask.params}
+		}
+		if err := a.approveActions(job, requests, plan.Goal); err != nil {
+			return conv, ordered, err
+		}
+
+		a.runSubtasks(job, ready)
+
+		// results are added in the order of the plan
+		for _, subtask := range ready {
+			if subtask.err != nil {
+				xlog.Error("error running action", "subtask", subtask.ID, "error", subtask.err)
+				a.finishSubtask(subtask, subtaskFailed, subtask.err)
+				a.recordJobStep(job, subtask.act, subtask.params, subtask.reasoning, types.ActionResult{}, subtask.err, conv)
+				continue
+			}
+
+			stateResult := types.ActionState{
+				ActionCurrentState: types.ActionCurrentState{
+					Job:       job,
+					Action:    subtask.act,
+					Params:    subtask.params,
+					Reasoning: subtask.reasoning,
+				},
+				ActionResult: subtask.result,
+			}
+			job.Result.SetResult(stateResult)
+			job.CallbackWithResult(stateResult)
+			xlog.Debug("[subtask] Action executed", "agent", a.Character.Name, "subtask", subtask.ID, "action", subtask.Action, "result", subtask.result)
+
+			conv = a.addFunctionResultToConversation(subtask.act, subtask.params, subtask.result, conv)
+			a.recordJobStep(job, subtask.act, subtask.params, subtask.reasoning, subtask.result, nil, conv)
+			a.finishSubtask(subtask, subtaskDone, nil)
+		}
+	}
+
+	return conv, ordered, nil
+}
+
+// runSubtasks runs the actions of the subtasks of a wave, at most
+// parallelToolCalls at a time. Actions that can't run concurrently run on
+// their own after the others.
+func (a *Agent) runSubtasks(job *types.Job, subtasks []*planSubtask) {
+	limit := a.options.parallelToolCalls
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	sequential := []*planSubtask{}
+
+	for _, subtask := range subtasks {
+		a.updateSubtask(subtask, subtaskRunning)
+		if len(subtasks) > 1 && !canRunInParallel(subtask.act) {
+			sequential = append(sequential, subtask)
+			continue
+		}
+
+		wg.Add(1)
+		go func(subtask *planSubtask) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			subtask.result, subtask.err = a.runAction(job, subtask.act, subtask.params)
+		}(subtask)
+	}
+	wg.Wait()
+
+	for _, subtask := range sequential {
+		subtask.result, subtask.err = a.runAction(job, subtask.act, subtask.params)
+	}
+}
+
+// subtaskReasoning is the reasoning given to generate the parameters of a
+// subtask, including the results of the subtasks it depends on
+func subtaskReasoning(subtask *planSubtask, goal string, subtasks map[string]*planSubtask) string {
+	reasoning := fmt.Sprintf("%s Overall goal is: %s", subtask.Reasoning, goal)
+	if len(subtask.DependsOn) == 0 {
+		return reasoning
+	}
+
+	reasoning += "\n\nResults of the subtasks this one depends on:"
+	for _, dep := range subtask.DependsOn {
+		reasoning += fmt.Sprintf("\n- %s (%s): %s", dep, subtasks[dep].Action, subtasks[dep].result.Result)
+	}
+	return reasoning
+}
+
+func failedDependencies(subtask *planSubtask, subtasks map[string]*planSubtask) []string {
+	failed := []string{}
+	for _, dep := range subtask.DependsOn {
+		if subtasks[dep].status != subtaskDone {
+			failed = append(failed, dep)
+		}
+	}
+	return failed
+}
+
+func (a *Agent) subtaskObservable(job *types.Job, subtask *planSubtask) *types.Observable {
+	if job.Obs == nil {
+		return nil
+	}
+
+	obs := a.observer.NewObservable()
+	obs.Name = "subtask"
+	obs.Icon = "list-check"
+	obs.ParentID = job.Obs.ID
+	obs.Creation = &types.Creation{
+		FunctionParams: types.ActionParams{
+			"id":         subtask.ID,
+			"action":     subtask.Action,
+			"reasoning":  subtask.Reasoning,
+			"depends_on": subtask.DependsOn,
+		},
+	}
+	obs.AddProgress(types.Progress{ActionResult: subtaskPending})
+	a.observer.Update(*obs)
+	return obs
+}
+
+func (a *Agent) updateSubtask(subtask *planSubtask, status string) {
+	subtask.status = status
+	if subtask.obs == nil {
+		return
+	}
+	subtask.obs.AddProgress(types.Progress{ActionResult: status})
+	a.observer.Update(*subtask.obs)
+}
+
+func (a *Agent) finishSubtask(subtask *planSubtask, status string, err error) {
+	subtask.status = status
+	if err != nil {
+		subtask.err = err
+		xlog.Warn("[subtask] Not completed", "agent", a.Character.Name, "subtask", subtask.ID, "status", status, "error", err)
+	}
+	if subtask.obs == nil {
+		return
+	}
+
+	subtask.obs.Completion = &types.Completion{ActionResult: status}
+	if status == subtaskDone {
+		subtask.obs.Completion.ActionResult = subtask.result.Result
+	}
+	if err != nil {
+		subtask.obs.Completion.Error = err.Error()
+	}
+	a.observer.Update(*subtask.obs)
+}
+
+const planReviewPrompt = `You are reviewing the results of the subtasks of a plan.
+Overall goal: %s
+
+%s
+For each subtask, decide whether its result actually accomplishes what the subtask was meant to do and is useful to reach the goal. Report the subtasks whose result is missing, wrong, empty or off-topic.`
+
+const replanPrompt = `A plan to reach the following goal did not fully succeed.
+Goal: %s
+
+Completed subtasks, their results are available and they must not be repeated:
+%s
+
+Subtasks that failed or whose result was not good enough:
+%s
+
+Write a revised plan with only the subtasks still needed to reach the goal. Use new ids, subtasks can depend on the ids of the completed subtasks to use their results. Don't repeat an approach that already failed.`
+
+// maxPlanResultLength bounds the result of a subtask quoted to the planner
+const maxPlanResultLength = 1000
+
+// reviewSubtasks asks the LLM whether the results of the completed subtasks
+// are good enough, it returns the ones that are not
+func (a *Agent) reviewSubtasks(job *types.Job, goal string, subtasks []*planSubtask) []*planSubtask {
+	type review struct {
+		Insufficient []struct {
+			ID     string `json:"id"`
+			Reason string `json:"reason"`
+		} `json:"insufficient"`
+	}
+
+	schema := jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"insufficient": {
+				Type:        jsonschema.Array,
+				Description: "The subtasks whose result is not good enough, empty if all the results are fine",
+				Items: &jsonschema.Definition{
+					Type: jsonschema.Object,
+					Properties: map[string]jsonschema.Definition{
+						"id": {
+							Type:        jsonschema.String,
+							Description: "The id of the subtask",
+						},
+						"reason": {
+							Type:        jsonschema.String,
+							Description: "What is wrong with the result",
+						},
+					},
+					Required: []string{"id", "reason"},
+				},
+			},
+		},
+		Required: []string{"insufficient"},
+	}
+
+	var result review
+	err := a.generateJSONWithGuidance(llm.WithRequestType(job.GetContext(), PhaseEvaluate), a.options.modelFor(PhaseEvaluate),
+		fmt.Sprintf(planReviewPrompt, goal, describeResults(subtasks)), schema, &result)
+	if err != nil {
+		xlog.Warn("[Planning] Failed to review the subtasks", "agent", a.Character.Name, "error", err)
+		return nil
+	}
+
+	byID := map[string]*planSubtask{}
+	for _, subtask := range subtasks {
+		byID[subtask.ID] = subtask
+	}
+
+	insufficient := []*planSubtask{}
+	for _, r := range result.Insufficient {
+		subtask, exists := byID[r.ID]
+		if !exists || subtask.status != subtaskDone {
+			continue
+		}
+		subtask.status = subtaskFailed
+		subtask.err = fmt.Errorf("result not good enough: %s", r.Reason)
+		insufficient = append(insufficient, subtask)
+	}
+	return insufficient
+}
+
+// replan asks the LLM for the subtasks still needed to reach the goal
+func (a *Agent) replan(job *types.Job, goal string, completed map[string]*planSubtask, incomplete []*planSubtask) (action.PlanResult, types.ActionParams, error) {
+	planAction := a.availableActions().Find(action.PlanActionName)
+	if planAction == nil {
+		return action.PlanResult{}, nil, fmt.Errorf("planning is not available")
+	}
+	definition := planAction.Definition()
+
+	done := []*planSubtask{}
+	for _, id := range completedIDs(completed) {
+		done = append(done, completed[id])
+	}
+
+	params := types.ActionParams{}
+	err := a.generateJSONWithGuidance(llm.WithRequestType(job.GetContext(), PhasePick), a.options.modelFor(PhasePick),
+		fmt.Sprintf(replanPrompt, goal, describeResults(done), describeSubtasks(incomplete)),
+		jsonschema.Definition{
+			Type:       jsonschema.Object,
+			Properties: definition.Properties,
+			Required:   definition.Required,
+		}, &params)
+	if err != nil {
+		return action.PlanResult{}, nil, err
+	}
+
+	plan := action.PlanResult{}
+	if err := params.Unmarshal(&plan); err != nil {
+		return action.PlanResult{}, nil, fmt.Errorf("error unmarshalling revised plan: %w", err)
+	}
+	if plan.Goal == "" {
+		plan.Goal = goal
+	}
+	return plan, params, nil
+}
+
+func completedIDs(completed map[string]*planSubtask) []string {
+	ids := make([]string, 0, len(completed))
+	for id := range completed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// describeSubtasks lists the subtasks with the reason they were not completed
+func describeSubtasks(subtasks []*planSubtask) string {
+	lines := []string{}
+	for _, subtask := range subtasks {
+		lines = append(lines, fmt.Sprintf("- %s (%s): %v", subtask.ID, subtask.Action, subtask.err))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// describeResults lists the subtasks with their results
+func describeResults(subtasks []*planSubtask) string {
+	var b strings.Builder
+	for _, subtask := range subtasks {
+		result := subtask.result.Result
+		if len(result) > maxPlanResultLength {
+			result = result[:maxPlanResultLength] + "..."
+		}
+		fmt.Fprintf(&b, "Subtask %s (%s): %s\nResult: %s\n\n", subtask.ID, subtask.Action, subtask.Reasoning, result)
+	}
+	return b.String()
+}
@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/mudler/LocalAGI/core/action"
+	"github.com/mudler/LocalAGI/core/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// gaugeAction records how many of its runs overlap
+type gaugeAction struct {
+	countAction
+	running atomic.Int32
+	peak    atomic.Int32
+}
+
+func (g *gaugeAction) Run(ctx context.Context, state *types.AgentSharedState, params types.ActionParams) (types.ActionResult, error) {
+	running := g.running.Add(1)
+	defer g.running.Add(-1)
+	for {
+		peak := g.peak.Load()
+		if running <= peak || g.peak.CompareAndSwap(peak, running) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	return g.countAction.Run(ctx, state, params)
+}
+
+func gaugeSubtasks(act types.Action, n int) []*planSubtask {
+	subtasks := []*planSubtask{}
+	for i := 0; i < n; i++ {
+		subtasks = append(subtasks, &planSubtask{
+			PlanSubtask: action.PlanSubtask{Action: "gauge"},
+			act:         act,
+			params:      types.ActionParams{},
+		})
+	}
+	return subtasks
+}
+
+var _ = Describe("Plan subtasks", func() {
+	It("runs at most parallelToolCalls subtasks at a time", func() {
+		gauge := &gaugeAction{countAction: countAction{name: "gauge"}}
+		a := newTestAgent(newFakeLLM(), WithActions(gauge), WithParallelToolCalls(2))
+
+		subtasks := gaugeSubtasks(gauge, 6)
+		a.runSubtasks(types.NewJob(), subtasks)
+
+		Expect(gauge.runs.Load()).To(Equal(int32(6)))
+		Expect(gauge.peak.Load()).To(Equal(int32(2)))
+		for _, subtask := range subtasks {
+			Expect(subtask.err).ToNot(HaveOccurred())
+			Expect(subtask.result.Result).To(Equal("counted"))
+		}
+	})
+
+	It("runs the subtasks one at a time by default", func() {
+		gauge := &gaugeAction{countAction: countAction{name: "gauge"}}
+		a := newTestAgent(newFakeLLM(), WithActions(gauge))
+
+		a.runSubtasks(types.NewJob(), gaugeSubtasks(gauge, 3))
+
+		Expect(gauge.runs.Load()).To(Equal(int32(3)))
+		Expect(gauge.peak.Load()).To(Equal(int32(1)))
+	})
+
+	It("doesn't revise the plans by default", func() {
+		Expect(defaultOptions().maxReplans).To(BeZero())
+	})
+})
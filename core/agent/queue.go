@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/mudler/LocalAGI/core/types"
+)
+
+// ErrJobQueueFull is returned to jobs enqueued when the backlog of the agent
+// is full
+var ErrJobQueueFull = errors.New("the job queue of the agent is full")
+
+// QueueStats is a snapshot of the job queue of an agent
+type QueueStats struct {
+	// Queued is the number of jobs waiting to run, by priority
+	Queued map[string]int `json:"queued"`
+	// Depth is the total number of jobs waiting to run
+	Depth int `json:"depth"`
+	// Running is the number of jobs being run by the workers
+	Running  int `json:"running"`
+	Capacity int `json:"capacity"`
+}
+
+type queuedJob struct {
+	job  *types.Job
+	role string
+	seq  uint64
+}
+
+// jobQueue is the backlog of an agent. Jobs are served by priority, then in
+// the order they were queued. A job is only served once the previous jobs of
+// its conversation are done, so that a thread is answered in order.
+type jobQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	jobs     []*queuedJob
+	running  map[string]int
+	active   int
+	seq      uint64
+	capacity int
+	closed   bool
+}
+
+func newJobQueue(capacity int) *jobQueue {
+	q := &jobQueue{
+		running:  make(map[string]int),
+		capacity: capacity,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push queues a job, it fails when the backlog is full or the queue is closed
+func (q *jobQueue) push(job *types.Job, role string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return ErrContextCanceled
+	}
+	if q.capacity > 0 && len(q.jobs) >= q.capacity {
+		return ErrJobQueueFull
+	}
+
+	q.seq++
+	q.jobs = append(q.jobs, &queuedJob{job: job, role: role, seq: q.seq})
+	q.cond.Signal()
+	return nil
+}
+
+// pop waits for the next job which can run. The job must be released with
+// done once it's finished. It returns nil once the queue is closed.
+func (q *jobQueue) pop() *queuedJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if q.closed {
+			return nil
+		}
+		if i := q.next(); i >= 0 {
+			item := q.jobs[i]
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			if key := item.job.ConversationKey; key != "" {
+				q.running[key]++
+			}
+			q.active++
+			return item
+		}
+		q.cond.Wait()
+	}
+}
+
+// next returns the index of the job to serve, or -1 if every queued job
+// waits for its conversation
+func (q *jobQueue) next() int {
+	best := -1
+	// conversations with an older job queued, which must run first
+	seen := map[string]struct{}{}
+	for i, item := range q.jobs {
+		key := item.job.ConversationKey
+		if key != "" {
+			if _, blocked := seen[key]; blocked {
+				continue
+			}
+			seen[key] = struct{}{}
+			if q.running[key] > 0 {
+				continue
+			}
+		}
+		if best < 0 || item.job.Priority > q.jobs[best].job.Priority ||
+			(item.job.Priority == q.jobs[best].job.Priority && item.seq < q.jobs[best].seq) {
+			best = i
+		}
+	}
+	return best
+}
+
+// remove drops a job which is still queued
+func (q *jobQueue) remove(job *types.Job) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, item := range q.jobs {
+		if item.job == job {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			// the next job of its conversation may be unblocked
+			q.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+// done releases the conversation of a job served by pop
+func (q *jobQueue) done(item *queuedJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if key := item.job.ConversationKey; key != "" {
+		q.running[key]--
+		if q.running[key] <= 0 {
+			delete(q.running, key)
+		}
+	}
+	q.active--
+	q.cond.Broadcast()
+}
+
+// close wakes up the workers, the jobs still queued are failed
+func (q *jobQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	jobs := q.jobs
+	q.jobs = nil
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	for _, item := range jobs {
+		item.job.Result.Finish(ErrContextCanceled)
+	}
+}
+
+// closeWith closes the queue once the context is done
+func (q *jobQueue) closeWith(ctx context.Context) {
+	context.AfterFunc(ctx, q.close)
+}
+
+func (q *jobQueue) stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := QueueStats{
+		Queued:   map[string]int{},
+		Depth:    len(q.jobs),
+		Running:  q.active,
+		Capacity: q.capacity,
+	}
+	for _, item := range q.jobs {
+		stats.Queued[item.job.Priority.String()]++
+	}
+	return stats
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/mudler/LocalAGI/core/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func queueJob(priority types.JobPriority, key string) *types.Job {
+	return types.NewJob(types.WithPriority(priority), types.WithConversationKey(key))
+}
+
+// popAsync pops the next job of the queue in the background
+func popAsync(q *jobQueue) <-chan *queuedJob {
+	popped := make(chan *queuedJob, 1)
+	go func() {
+		popped <- q.pop()
+	}()
+	return popped
+}
+
+var _ = Describe("Job queue", func() {
+	It("serves the jobs by priority, then in order", func() {
+		q := newJobQueue(10)
+		periodic := queueJob(types.JobPriorityPeriodic, "")
+		user := queueJob(types.JobPriorityUser, "")
+		reminder := queueJob(types.JobPriorityReminder, "")
+		user2 := queueJob(types.JobPriorityUser, "")
+		for _, job := range []*types.Job{periodic, user, reminder, user2} {
+			Expect(q.push(job, "user")).To(Succeed())
+		}
+
+		for _, job := range []*types.Job{user, user2, reminder, periodic} {
+			item := q.pop()
+			Expect(item.job).To(BeIdenticalTo(job))
+			q.done(item)
+		}
+	})
+
+	It("serves the jobs of a conversation one at a time, in order", func() {
+		q := newJobQueue(10)
+		first := queueJob(types.JobPriorityReminder, "thread")
+		second := queueJob(types.JobPriorityUser, "thread")
+		other := queueJob(types.JobPriorityPeriodic, "other")
+		for _, job := range []*types.Job{first, second, other} {
+			Expect(q.push(job, "user")).To(Succeed())
+		}
+
+		// the second job has a higher priority, but must wait for the first
+		running := q.pop()
+		Expect(running.job).To(BeIdenticalTo(first))
+		Expect(q.pop().job).To(BeIdenticalTo(other))
+
+		popped := popAsync(q)
+		Consistently(popped, 50*time.Millisecond).ShouldNot(Receive())
+		Expect(q.stats().Running).To(Equal(2))
+
+		q.done(running)
+		var item *queuedJob
+		Eventually(popped).Should(Receive(&item))
+		Expect(item.job).To(BeIdenticalTo(second))
+	})
+
+	It("fails the jobs pushed to a full queue", func() {
+		q := newJobQueue(2)
+		Expect(q.push(queueJob(types.JobPriorityUser, ""), "user")).To(Succeed())
+		Expect(q.push(queueJob(types.JobPriorityPeriodic, ""), "user")).To(Succeed())
+		Expect(q.push(queueJob(types.JobPriorityUser, ""), "user")).To(MatchError(ErrJobQueueFull))
+
+		stats := q.stats()
+		Expect(stats.Depth).To(Equal(2))
+		Expect(stats.Capacity).To(Equal(2))
+		Expect(stats.Queued).To(Equal(map[string]int{
+			types.JobPriorityUser.String():     1,
+			types.JobPriorityPeriodic.String(): 1,
+		}))
+	})
+
+	It("removes the queued jobs, unblocking their conversation", func() {
+		q := newJobQueue(10)
+		first := queueJob(types.JobPriorityUser, "thread")
+		second := queueJob(types.JobPriorityUser, "thread")
+		Expect(q.push(first, "user")).To(Succeed())
+		Expect(q.push(second, "user")).To(Succeed())
+
+		Expect(q.remove(first)).To(BeTrue())
+		Expect(q.remove(first)).To(BeFalse())
+		Expect(q.pop().job).To(BeIdenticalTo(second))
+		Expect(q.remove(second)).To(BeFalse())
+	})
+
+	It("fails the queued jobs and wakes up the workers once closed", func() {
+		q := newJobQueue(10)
+		running := queueJob(types.JobPriorityUser, "thread")
+		queued := queueJob(types.JobPriorityUser, "thread")
+		Expect(q.push(running, "user")).To(Succeed())
+		Expect(q.push(queued, "user")).To(Succeed())
+		Expect(q.pop().job).To(BeIdenticalTo(running))
+
+		popped := popAsync(q)
+		Consistently(popped, 50*time.Millisecond).ShouldNot(Receive())
+
+		q.close()
+		Eventually(popped).Should(Receive(BeNil()))
+		Expect(queued.Result.WaitResult().Error).To(MatchError(ErrContextCanceled))
+		Expect(q.push(queueJob(types.JobPriorityUser, ""), "user")).To(MatchError(ErrContextCanceled))
+	})
+})
@@ -0,0 +1,314 @@
+package agent
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/db"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/llm"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	FailedJobRetrying = "retrying"
+	FailedJobDead     = "dead"
+
+	JobStatusRetrying = "retrying"
+)
+
+var ErrFailedJobNotFound = errors.New("dead-lettered job not found")
+
+// RetryPolicy is how the jobs of an agent failing with transient errors
+// (LLM 5xx, timeouts, dropped connections, ...) are retried. Failed jobs are
+// persisted, once they run out of attempts they are moved to the dead-letter
+// list. Every attempt starts again from the conversation of the first one, so
+// a job is dead-lettered instead of retried once it ran an action which isn't
+// idempotent.
+type RetryPolicy struct {
+	// MaxAttempts is how many times a job runs before being dead-lettered,
+	// zero disables the retries and the dead-letter list
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry, doubled at every
+	// following one up to MaxBackoff
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultRetryPolicy doesn't retry the jobs, set MaxAttempts to enable the
+// retries: they wait 30s, then 1m, and so on up to 10m
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		InitialBackoff: 30 * time.Second,
+		MaxBackoff:     10 * time.Minute,
+	}
+}
+
+// backoff returns the wait after the given failed attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.InitialBackoff << (attempt - 1)
+	if p.MaxBackoff > 0 && (wait > p.MaxBackoff || wait <= 0) {
+		wait = p.MaxBackoff
+	}
+	return wait
+}
+
+// isTransientJobError reports whether a failed job is worth retrying later
+func isTransientJobError(err error) bool {
+	if llm.IsTransient(err) || errors.Is(err, ErrJobQueueFull) || errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	// MCP and action errors don't always wrap the underlying error
+	msg := strings.ToLower(err.Error())
+	for _, transient := range []string{"timeout", "timed out", "connection reset", "connection refused", "deadline exceeded"} {
+		if strings.Contains(msg, transient) {
+			return true
+		}
+	}
+	return false
+}
+
+// isDeliberateJobError reports whether a job was stopped on purpose rather
+// than failing, such jobs are neither retried nor dead-lettered
+func isDeliberateJobError(err error) bool {
+	exceeded := &types.BudgetExceeded{}
+	return errors.Is(err, ErrJobCanceled) ||
+		errors.Is(err, ErrAgentPaused) ||
+		errors.Is(err, ErrJobExpired) ||
+		errors.Is(err, ErrJobDiscarded) ||
+		errors.Is(err, ErrActionDenied) ||
+		errors.As(err, &exceeded)
+}
+
+// sideEffects returns the actions with side effects among the steps run by
+// an attempt of a job, running the attempt again would repeat them
+func (a *Agent) sideEffects(job *types.Job, steps []types.JobStep) []string {
+	actions := a.getAvailableActionsForJob(job)
+	var names []string
+	for _, step := range steps {
+		// an action which isn't available anymore can't tell
+		if act := actions.Find(step.Action); act == nil || !types.IsActionIdempotent(act) {
+			names = append(names, step.Action)
+		}
+	}
+	return names
+}
+
+// retryError returns why a failed attempt of a job can't be retried, or nil
+// if it can. steps are the steps run by the attempt.
+func (a *Agent) retryError(job *types.Job, steps []types.JobStep, attempt int, err error) error {
+	if attempt >= a.options.retryPolicy.MaxAttempts {
+		return fmt.Errorf("failed %d times: %w", attempt, err)
+	}
+	if !isTransientJobError(err) {
+		return err
+	}
+	if actions := a.sideEffects(job, steps); len(actions) > 0 {
+		return fmt.Errorf("already ran %s, retrying would run it again: %w", strings.Join(actions, ", "), err)
+	}
+	return nil
+}
+
+func (a *Agent) retriesEnabled() bool {
+	return a.options.retryPolicy.MaxAttempts > 0 && a.persisted()
+}
+
+// retryOnFailure retries the job when it fails, attempt is the number of the
+// next run of the job. Every attempt shares the result of the job, so its
+// caller waits for the last one.
+func (a *Agent) retryOnFailure(job *types.Job, role string, attempt int) {
+	conversation := append([]openai.ChatCompletionMessage{}, job.ConversationHistory...)
+	// the steps of a job restored from a checkpoint already are in its
+	// conversation, they aren't run again
+	firstStep := len(job.GetSteps())
+
+	// the finalizers of the result are reset by every attempt, the failed
+	// job is deleted once by the last one
+	deleteOnSuccess := func(_ []openai.ChatCompletionMessage) {
+		if job.Result.Error == nil {
+			a.deleteFailedJob(job.UUID)
+		}
+	}
+	job.Result.AddFinalizer(deleteOnSuccess)
+
+	var mu sync.Mutex
+	current := job
+	job.Result.SetRetryHook(func(err error) bool {
+		if isDeliberateJobError(err) {
+			return false
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		a.failJobCheckpoint(current, err)
+
+		if a.context.Context.Err() != nil {
+			// the agent is stopping, the job is retried once it starts again
+			now := time.Now()
+			a.recordFailedJob(current, role, conversation, attempt, err, FailedJobRetrying, &now)
+			return false
+		}
+
+		if reason := a.retryError(current, current.GetSteps()[firstStep:], attempt, err); reason != nil {
+			xlog.Warn("Job failed, moving it to the dead-letter list", "agent", a.Character.Name, "job", current.UUID, "attempts", attempt, "error", reason)
+			a.recordFailedJob(current, role, conversation, attempt, reason, FailedJobDead, nil)
+			return false
+		}
+
+		wait := a.options.retryPolicy.backoff(attempt)
+		nextAttemptAt := time.Now().Add(wait)
+		xlog.Warn("Job failed, retrying", "agent", a.Character.Name, "job", current.UUID, "attempt", attempt, "backoff", wait, "error", err)
+		a.recordFailedJob(current, role, conversation, attempt, err, FailedJobRetrying, &nextAttemptAt)
+		a.updateJob(current, func(j *activeJob) {
+			j.status = JobStatusRetrying
+		})
+
+		previous := current
+		next := current.Retry(conversation)
+		next.Result.AddFinalizer(deleteOnSuccess)
+		if next.Obs != nil && a.observer != nil {
+			a.observeCompletion(next)
+		}
+		current = next
+		firstStep = 0
+		attempt++
+
+		time.AfterFunc(wait, func() {
+			if previous.Stopped() {
+				next.Result.Finish(ErrJobCanceled)
+				return
+			}
+			a.enqueue(next, role)
+		})
+		return true
+	})
+}
+
+// recordFailedJob persists a failed attempt of a job
+func (a *Agent) recordFailedJob(job *types.Job, role string, conversation []openai.ChatCompletionMessage, attempts int, err error, status string, nextAttemptAt *time.Time) {
+	failed := models.FailedJob{
+		ID:              uuid.New(),
+		JobID:           job.UUID,
+		AgentID:         a.options.agentID,
+		UserID:          a.options.userID,
+		Role:            role,
+		Priority:        int(job.Priority),
+		ConversationKey: job.ConversationKey,
+		Conversation:    marshalCheckpointField(conversation),
+		Metadata:        marshalCheckpointField(job.Metadata),
+	}
+	if err := db.DB.Where("JobID = ?", job.UUID).
+		Assign(map[string]interface{}{
+			"Status":        status,
+			"Attempts":      attempts,
+			"LastError":     err.Error(),
+			"NextAttemptAt": nextAttemptAt,
+		}).
+		FirstOrCreate(&failed).Error; err != nil {
+		xlog.Error("Failed to persist failed job", "error", err, "agent", a.Character.Name, "job", job.UUID)
+	}
+}
+
+func (a *Agent) deleteFailedJob(jobID string) int64 {
+	res := db.DB.Where("AgentID = ? AND JobID = ?", a.options.agentID, jobID).Delete(&models.FailedJob{})
+	if res.Error != nil {
+		xlog.Error("Failed to delete failed job", "error", res.Error, "agent", a.Character.Name, "job", jobID)
+	}
+	return res.RowsAffected
+}
+
+// failedAttempts returns how many times a job waiting for a retry failed
+func (a *Agent) failedAttempts(jobID string) int {
+	if !a.retriesEnabled() {
+		return 0
+	}
+
+	var failed models.FailedJob
+	if err := db.DB.Where("AgentID = ? AND JobID = ? AND Status = ?", a.options.agentID, jobID, FailedJobRetrying).
+		First(&failed).Error; err != nil {
+		return 0
+	}
+	return failed.Attempts
+}
+
+// resumeFailedJobs schedules the retries of the jobs that were waiting for
+// one when the process stopped
+func (a *Agent) resumeFailedJobs() int {
+	if !a.retriesEnabled() {
+		return 0
+	}
+
+	// the jobs interrupted while being retried are resumed from their checkpoint
+	running := db.DB.Model(&models.JobCheckpoint{}).
+		Select("JobID").
+		Where("AgentID = ? AND Status = ?", a.options.agentID, JobStatusRunning)
+
+	var failed []models.FailedJob
+	if err := db.DB.Where("AgentID = ? AND Status = ?", a.options.agentID, FailedJobRetrying).
+		Where("JobID NOT IN (?)", running).
+		Order("CreatedAt ASC").
+		Find(&failed).Error; err != nil {
+		xlog.Error("Failed to load failed jobs", "error", err, "agent", a.Character.Name)
+		return 0
+	}
+
+	for _, f := range failed {
+		job, err := a.restoreJob(f.JobID, f.Conversation, f.Metadata, f.Priority, f.ConversationKey)
+		if err != nil {
+			xlog.Warn("Cannot retry job, moving it to the dead-letter list", "agent", a.Character.Name, "job", f.JobID, "error", err)
+			db.DB.Model(&models.FailedJob{}).Where("ID = ?", f.ID).Updates(map[string]interface{}{
+				"Status":    FailedJobDead,
+				"LastError": err.Error(),
+			})
+			continue
+		}
+
+		a.retryOnFailure(job, f.Role, f.Attempts+1)
+
+		wait := time.Duration(0)
+		if f.NextAttemptAt != nil {
+			wait = time.Until(*f.NextAttemptAt)
+		}
+		role := f.Role
+		time.AfterFunc(max(wait, 0), func() {
+			a.resumeJob(job, role)
+		})
+	}
+
+	return len(failed)
+}
+
+// RedriveJob runs a dead-lettered job again, with a fresh set of attempts.
+// It starts again from the conversation of the first attempt, running again
+// the actions run by the failed ones. Nobody is waiting for its result
+// anymore, so the response is delivered as a new conversation.
+func (a *Agent) RedriveJob(jobID string) error {
+	var failed models.FailedJob
+	if err := db.DB.Where("AgentID = ? AND JobID = ? AND Status = ?", a.options.agentID, jobID, FailedJobDead).
+		First(&failed).Error; err != nil {
+		return ErrFailedJobNotFound
+	}
+
+	job, err := a.restoreJob(failed.JobID, failed.Conversation, failed.Metadata, failed.Priority, failed.ConversationKey)
+	if err != nil {
+		return fmt.Errorf("cannot restore job: %w", err)
+	}
+
+	if a.deleteFailedJob(jobID) == 0 {
+		// re-driven concurrently
+		return ErrFailedJobNotFound
+	}
+
+	xlog.Info("Re-driving dead-lettered job", "agent", a.Character.Name, "job", jobID)
+	go a.resumeJob(job, failed.Role)
+	return nil
+}
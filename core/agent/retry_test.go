@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mudler/LocalAGI/core/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sashabaranov/go-openai"
+)
+
+// readAction is a countAction without side effects
+type readAction struct {
+	countAction
+}
+
+func (r *readAction) IsIdempotent() bool {
+	return true
+}
+
+var _ = Describe("Job retries", func() {
+	unavailable := &openai.APIError{HTTPStatusCode: http.StatusServiceUnavailable, Message: "unavailable"}
+
+	It("doubles the backoff at every attempt up to the maximum", func() {
+		policy := RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+		Expect(policy.backoff(1)).To(Equal(time.Second))
+		Expect(policy.backoff(2)).To(Equal(2 * time.Second))
+		Expect(policy.backoff(3)).To(Equal(4 * time.Second))
+		Expect(policy.backoff(4)).To(Equal(5 * time.Second))
+		Expect(policy.backoff(100)).To(Equal(5 * time.Second))
+	})
+
+	It("is disabled by default", func() {
+		Expect(DefaultRetryPolicy().MaxAttempts).To(BeZero())
+		Expect(defaultOptions().retryPolicy.MaxAttempts).To(BeZero())
+	})
+
+	It("tells the transient failures from the permanent and deliberate ones", func() {
+		Expect(isTransientJobError(unavailable)).To(BeTrue())
+		Expect(isTransientJobError(fmt.Errorf("mcp: %w", ErrJobQueueFull))).To(BeTrue())
+		Expect(isTransientJobError(errors.New("read tcp: i/o timeout"))).To(BeTrue())
+		Expect(isTransientJobError(&openai.APIError{HTTPStatusCode: http.StatusBadRequest})).To(BeFalse())
+		Expect(isTransientJobError(errors.New("invalid parameters"))).To(BeFalse())
+
+		Expect(isDeliberateJobError(ErrJobCanceled)).To(BeTrue())
+		Expect(isDeliberateJobError(fmt.Errorf("send: %w", ErrActionDenied))).To(BeTrue())
+		Expect(isDeliberateJobError(&types.BudgetExceeded{Budget: types.BudgetSteps})).To(BeTrue())
+		Expect(isDeliberateJobError(unavailable)).To(BeFalse())
+	})
+
+	It("runs the finalizers of the last attempt only", func() {
+		job := types.NewJob(types.WithText("send the report"))
+		saved := 0
+		saveConversation := func([]openai.ChatCompletionMessage) { saved++ }
+
+		var next *types.Job
+		job.Result.SetRetryHook(func(error) bool {
+			next = job.Retry(job.ConversationHistory)
+			return true
+		})
+
+		// the first attempt saved its conversation before failing
+		job.Result.AddFinalizer(saveConversation)
+		job.Result.Finish(unavailable)
+		Expect(next).ToNot(BeNil())
+		Expect(saved).To(BeZero())
+
+		next.Result.AddFinalizer(saveConversation)
+		next.Result.Finish(nil)
+		Expect(job.Result.WaitResult().Error).ToNot(HaveOccurred())
+		Expect(saved).To(Equal(1))
+	})
+
+	Context("with a retry policy", func() {
+		var (
+			a   *Agent
+			job *types.Job
+		)
+
+		BeforeEach(func() {
+			a = newTestAgent(newFakeLLM(), WithActions(&countAction{name: "send"}, &readAction{countAction{name: "search"}}),
+				WithRetryPolicy(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}))
+			job = types.NewJob(types.WithText("send the report"))
+		})
+
+		It("retries the transient failures", func() {
+			Expect(a.retryError(job, nil, 1, unavailable)).ToNot(HaveOccurred())
+			Expect(a.retryError(job, nil, 2, unavailable)).ToNot(HaveOccurred())
+		})
+
+		It("dead-letters the jobs out of attempts", func() {
+			err := a.retryError(job, nil, 3, unavailable)
+			Expect(err).To(MatchError(ContainSubstring("failed 3 times")))
+			Expect(errors.Is(err, unavailable)).To(BeTrue())
+		})
+
+		It("dead-letters the permanent failures at once", func() {
+			err := errors.New("invalid parameters")
+			Expect(a.retryError(job, nil, 1, err)).To(MatchError(err))
+		})
+
+		It("retries the jobs which only ran idempotent actions", func() {
+			steps := []types.JobStep{{Action: "search"}, {Action: "search"}}
+			Expect(a.retryError(job, steps, 1, unavailable)).ToNot(HaveOccurred())
+		})
+
+		It("dead-letters the jobs which ran actions with side effects", func() {
+			steps := []types.JobStep{{Action: "search"}, {Action: "send"}}
+			err := a.retryError(job, steps, 1, unavailable)
+			Expect(err).To(MatchError(ContainSubstring("already ran send")))
+			Expect(errors.Is(err, unavailable)).To(BeTrue())
+		})
+
+		It("dead-letters the jobs which ran actions not available anymore", func() {
+			steps := []types.JobStep{{Action: "removed"}}
+			Expect(a.retryError(job, steps, 1, unavailable)).To(MatchError(ContainSubstring("already ran removed")))
+		})
+	})
+})
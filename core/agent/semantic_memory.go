@@ -0,0 +1,268 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/db"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/vectorstore"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/mudler/LocalAGI/pkg/xstrings"
+)
+
+// SemanticMemory stores the long-term memory of an agent as embedded chunks,
+// vectorstore.ChromemDB and vectorstore.LocalAIRAGDB implement it
+type SemanticMemory interface {
+	StoreDocuments(ctx context.Context, documents []vectorstore.Document) error
+	SearchDocuments(ctx context.Context, query string, similarEntries int, filter vectorstore.Filter) ([]vectorstore.Result, error)
+	DeleteDocuments(ctx context.Context, documents []vectorstore.Document) error
+}
+
+const (
+	// memoryChunkSize is the maximum length of an embedded chunk
+	memoryChunkSize = 1000
+	// memoryIndexBatch bounds the messages embedded by a single pass
+	memoryIndexBatch = 200
+	// memoryCandidates is how many candidates per result are scored
+	memoryCandidates = 4
+
+	// the score of a memory is the weighted sum of its similarity to the
+	// query and of the share of the keywords of the query it contains...
+	memoryVectorWeight  = 0.7
+	memoryKeywordWeight = 0.3
+	// ...scaled down with its age, memories lose half of the recency bonus
+	// every memoryRecencyHalfLife
+	memoryRecencyHalfLife = 30 * 24 * time.Hour
+	memoryRecencyFloor    = 0.6
+)
+
+// Metadata of the documents of the semantic memory
+const (
+	memoryMetadataMessageID = "message_id"
+	memoryMetadataSender    = "sender"
+	memoryMetadataCreatedAt = "created_at"
+)
+
+// scoreMemory is the hybrid score of a memory
+func scoreMemory(similarity, keywordScore float64, age time.Duration) float64 {
+	recency := math.Pow(0.5, max(age, 0).Hours()/memoryRecencyHalfLife.Hours())
+	return (memoryVectorWeight*similarity + memoryKeywordWeight*keywordScore) *
+		(memoryRecencyFloor + (1-memoryRecencyFloor)*recency)
+}
+
+// keywordScore is the share of the keywords found in the content
+func keywordScore(keywords []string, content string) float64 {
+	if len(keywords) == 0 {
+		return 0
+	}
+	content = strings.ToLower(content)
+	found := 0
+	for _, keyword := range keywords {
+		if strings.Contains(content, keyword) {
+			found++
+		}
+	}
+	return float64(found) / float64(len(keywords))
+}
+
+func (a *Agent) semanticMemoryEnabled() bool {
+	return a.options.semanticMemory != nil && a.persisted()
+}
+
+// indexMemories embeds the messages of the agent which aren't in its
+// semantic memory yet. Only one pass runs at a time.
+func (a *Agent) indexMemories(ctx context.Context) {
+	if !a.semanticMemoryEnabled() || !a.memoryIndexing.CompareAndSwap(false, true) {
+		return
+	}
+	defer a.memoryIndexing.Store(false)
+
+	a.memoryIndexCheck.Do(func() {
+		// the chunks of another store (e.g. one kept in memory by a previous
+		// run) aren't in this one, embed their messages again
+		if err := db.DB.Where("AgentID = ? AND Store <> ?", a.options.agentID, a.options.semanticMemoryKey).
+			Delete(&models.MemoryChunk{}).Error; err != nil {
+			xlog.Error("Failed to reset memory chunks", "error", err, "agent", a.Character.Name)
+		}
+	})
+
+	indexed := db.DB.Model(&models.MemoryChunk{}).
+		Select("MessageID").
+		Where("AgentID = ? AND MessageID IS NOT NULL", a.options.agentID)
+
+	// the blank messages have nothing to embed, skipped here they would be
+	// loaded again by every pass
+	var messages []models.AgentMessage
+	if err := db.DB.Where("AgentID = ? AND Type = ?", a.options.agentID, "message").
+		Where("Content REGEXP ?", "[^[:space:]]").
+		Where("ID NOT IN (?)", indexed).
+		Order("CreatedAt ASC").
+		Limit(memoryIndexBatch).
+		Find(&messages).Error; err != nil {
+		xlog.Error("Failed to load messages to index", "error", err, "agent", a.Character.Name)
+		return
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	chunks := []models.MemoryChunk{}
+	documents := []vectorstore.Document{}
+	for _, message := range messages {
+		messageID := message.ID
+		for _, content := range xstrings.SplitParagraph(message.Content, memoryChunkSize) {
+			chunk := models.MemoryChunk{
+				ID:        uuid.New(),
+				AgentID:   a.options.agentID,
+				UserID:    a.options.userID,
+				MessageID: &messageID,
+				Store:     a.options.semanticMemoryKey,
+				Sender:    message.Sender,
+				Content:   content,
+				CreatedAt: message.CreatedAt,
+			}
+			chunks = append(chunks, chunk)
+			documents = append(documents, vectorstore.Document{
+				ID:      chunk.ID.String(),
+				Content: content,
+				Metadata: map[string]string{
+					memoryMetadataMessageID: messageID.String(),
+					memoryMetadataSender:    message.Sender,
+					memoryMetadataCreatedAt: message.CreatedAt.Format(time.RFC3339Nano),
+				},
+			})
+		}
+	}
+	if len(documents) == 0 {
+		return
+	}
+
+	if err := a.options.semanticMemory.StoreDocuments(ctx, documents); err != nil {
+		xlog.Error("Failed to embed messages into memory", "error", err, "agent", a.Character.Name)
+		return
+	}
+	if err := db.DB.Create(&chunks).Error; err != nil {
+		xlog.Error("Failed to record memory chunks", "error", err, "agent", a.Character.Name)
+		return
+	}
+
+	xlog.Debug("Indexed messages into semantic memory", "agent", a.Character.Name, "messages", len(messages), "chunks", len(chunks))
+}
+
+type memoryCandidate struct {
+	result     MemoryResult
+	similarity float64
+}
+
+// searchMemories returns the memories most relevant to the query, scored by
+// similarity, keywords and recency. The excludeCount most recent messages
+// are part of the conversation already and skipped.
+func (a *Agent) searchMemories(ctx context.Context, query string, n int, excludeCount int) ([]MemoryResult, error) {
+	excluded := map[uuid.UUID]bool{}
+	if excludeCount > 0 {
+		var recent []uuid.UUID
+		if err := db.DB.Model(&models.AgentMessage{}).
+			Where("AgentID = ? AND Type = ?", a.options.agentID, "message").
+			Order("CreatedAt desc").
+			Limit(excludeCount).
+			Pluck("ID", &recent).Error; err != nil {
+			return nil, err
+		}
+		for _, id := range recent {
+			excluded[id] = true
+		}
+	}
+
+	candidates := map[string]*memoryCandidate{}
+
+	results, err := a.options.semanticMemory.SearchDocuments(ctx, query, n*memoryCandidates, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range results {
+		result := MemoryResult{
+			Sender:  r.Metadata[memoryMetadataSender],
+			Content: r.Content,
+		}
+		if id, err := uuid.Parse(r.Metadata[memoryMetadataMessageID]); err == nil {
+			if excluded[id] {
+				continue
+			}
+			result.ID = id
+		}
+		if createdAt, err := time.Parse(time.RFC3339Nano, r.Metadata[memoryMetadataCreatedAt]); err == nil {
+			result.CreatedAt = createdAt
+		}
+
+		key := r.ID
+		if result.ID != uuid.Nil {
+			// the chunks of a message count once, with their best similarity
+			key = result.ID.String()
+		}
+		if c, ok := candidates[key]; ok && c.similarity >= float64(r.Similarity) {
+			continue
+		}
+		candidates[key] = &memoryCandidate{result: result, similarity: float64(r.Similarity)}
+	}
+
+	// exact keyword matches the embeddings may have missed
+	keywordResults, err := NewMySQLStorage(a.options.agentID, a.options.userID).Search(query, n*memoryCandidates, excludeCount)
+	if err != nil {
+		xlog.Warn("Keyword memory search failed", "error", err, "agent", a.Character.Name)
+	}
+	for _, r := range keywordResults {
+		if _, ok := candidates[r.ID.String()]; !ok {
+			candidates[r.ID.String()] = &memoryCandidate{result: r}
+		}
+	}
+
+	keywords := extractKeywords(query)
+	now := time.Now()
+	scored := make([]*memoryCandidate, 0, len(candidates))
+	scores := map[*memoryCandidate]float64{}
+	for _, c := range candidates {
+		scores[c] = scoreMemory(c.similarity, keywordScore(keywords, c.result.Content), now.Sub(c.result.CreatedAt))
+		scored = append(scored, c)
+	}
+	sort.Slice(scored, func(i, j int) bool {
+		return scores[scored[i]] > scores[scored[j]]
+	})
+
+	memories := []MemoryResult{}
+	for _, c := range scored[:min(n, len(scored))] {
+		memories = append(memories, c.result)
+	}
+	return memories, nil
+}
+
+// forgetMessages removes the chunks of the messages from the semantic memory
+func (a *Agent) forgetMessages(ctx context.Context, messageIDs []uuid.UUID) error {
+	if a.options.semanticMemory == nil || len(messageIDs) == 0 {
+		return nil
+	}
+
+	var chunks []models.MemoryChunk
+	if err := db.DB.Where("AgentID = ? AND MessageID IN ?", a.options.agentID, messageIDs).Find(&chunks).Error; err != nil {
+		return err
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	documents := make([]vectorstore.Document, 0, len(chunks))
+	ids := make([]uuid.UUID, 0, len(chunks))
+	for _, chunk := range chunks {
+		documents = append(documents, vectorstore.Document{ID: chunk.ID.String(), Content: chunk.Content})
+		ids = append(ids, chunk.ID)
+	}
+	if err := a.options.semanticMemory.DeleteDocuments(ctx, documents); err != nil {
+		return fmt.Errorf("failed to delete memory chunks from the vector store: %w", err)
+	}
+	return db.DB.Where("ID IN ?", ids).Delete(&models.MemoryChunk{}).Error
+}
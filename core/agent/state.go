@@ -21,6 +21,8 @@ type PromptHUD struct {
 	CurrentState  types.AgentInternalState `json:"current_state"`
 	PermanentGoal string                   `json:"permanent_goal"`
 	ShowCharacter bool                     `json:"show_character"`
+	// ActionWarnings are about the actions failing repeatedly
+	ActionWarnings []string `json:"action_warnings,omitempty"`
 }
 
 type Character struct {
@@ -71,6 +73,8 @@ func (c *Character) ToJSONSchema() jsonschema.Definition {
 }
 
 func (a *Agent) State() types.AgentInternalState {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
 	return *a.currentState
 }
 
@@ -207,6 +211,7 @@ func (a *Agent) LoadStateFromDB() error {
 		json.Unmarshal(dbState.Memories, &memories)
 	}
 
+	a.stateMutex.Lock()
 	a.currentState = &types.AgentInternalState{
 		NowDoing:    dbState.NowDoing,
 		DoingNext:   dbState.DoingNext,
@@ -214,12 +219,20 @@ func (a *Agent) LoadStateFromDB() error {
 		Memories:    memories,
 		Goal:        dbState.Goal,
 	}
+	a.stateMutex.Unlock()
 
 	return nil
 }
 
 // SaveStateToDB saves agent state to database
 func (a *Agent) SaveStateToDB() error {
+	a.stateMutex.Lock()
+	defer a.stateMutex.Unlock()
+	return a.saveState()
+}
+
+// saveState saves the current state to database, stateMutex must be held
+func (a *Agent) saveState() error {
 	// Validate that we have valid IDs
 	if a.options.agentID == uuid.Nil {
 		return fmt.Errorf("invalid agent ID: cannot save state")
@@ -254,6 +267,7 @@ func (a *Agent) prepareState() error {
 	}
 
 	// No state found, initialize with empty state
+	a.stateMutex.Lock()
 	a.currentState = &types.AgentInternalState{
 		NowDoing:    "",
 		DoingNext:   "",
@@ -261,6 +275,12 @@ func (a *Agent) prepareState() error {
 		Memories:    []string{},
 		Goal:        "",
 	}
+	a.stateMutex.Unlock()
+
+	// agents without IDs, e.g. in tests, keep their state in memory
+	if !a.persisted() {
+		return nil
+	}
 
 	// Save the initial empty state to database
 	if err := a.SaveStateToDB(); err != nil {
@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	. "github.com/mudler/LocalAGI/core/agent"
+	"github.com/mudler/LocalAGI/pkg/llm"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -13,6 +14,9 @@ var _ = Describe("Agent test", func() {
 		var agent *Agent
 
 		BeforeEach(func() {
+			if cassetteMode == llm.CassetteReplay {
+				return
+			}
 			Eventually(func() error {
 				// test apiURL is working and available
 				_, err := http.Get(apiURL + "/readyz")
@@ -23,6 +27,7 @@ var _ = Describe("Agent test", func() {
 		It("generates all the fields with random data", func() {
 			var err error
 			agent, err = New(
+				withCassette("random_identity"),
 				WithLLMAPIURL(apiURL),
 				WithModel(testModel),
 				WithTimeout("10m"),
@@ -45,6 +50,7 @@ var _ = Describe("Agent test", func() {
 			var err error
 
 			agent, err := New(
+				withCassette("guided_identity"),
 				WithLLMAPIURL(apiURL),
 				WithModel(testModel),
 				WithRandomIdentity("An 90-year old man with a long beard, a wizard, who lives in a tower."),
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/pkg/llm"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// maxResponseRepairs is how many times the LLM is asked to fix a structured
+// response that doesn't follow the schema of the job
+const maxResponseRepairs = 2
+
+const structuredResponsePrompt = `Based on the conversation and the tool results above, answer the user with a JSON document following the given schema. Do not add any prose outside of the JSON document.`
+
+// structuredResponse generates the response of a job with a response schema
+// and sets it as the result of the job. Schemas which are not objects are
+// wrapped in a "response" property, as the JSON is generated by a tool call.
+func (a *Agent) structuredResponse(job *types.Job, conv Messages) error {
+	schema := *job.ResponseSchema
+	wrapped := schema.Type != jsonschema.Object
+	if wrapped {
+		schema = jsonschema.Definition{
+			Type:       jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{"response": *job.ResponseSchema},
+			Required:   []string{"response"},
+		}
+	}
+
+	conv = append(Messages{
+		{
+			Role:    "system",
+			Content: structuredResponsePrompt,
+		},
+	}, conv...)
+
+	var object any
+	err := llm.GenerateValidatedJSON(llm.WithRequestType(job.GetContext(), PhaseReply), a.client,
+		conv, a.options.modelFor(PhaseReply), a.options.userID, a.options.agentID, schema, maxResponseRepairs, &object)
+	if err != nil {
+		a.storeErrorMessage(err.Error())
+		return fmt.Errorf("error generating structured response: %w", err)
+	}
+
+	if wrapped {
+		object = object.(map[string]any)["response"]
+	}
+
+	xlog.Info("Structured response from LLM", "response", object, "agent", a.Character.Name)
+	return job.Result.SetResponseObject(object)
+}
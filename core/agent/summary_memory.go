@@ -0,0 +1,412 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/db"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/llm"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	// summaryConsolidationInterval is how often the duplicate summaries of
+	// an agent are merged
+	summaryConsolidationInterval = 6 * time.Hour
+	// summaryDuplicateThreshold is the similarity above which two summaries
+	// are merged
+	summaryDuplicateThreshold = 0.5
+	// summaryConsolidationBatch bounds the summaries compared by a pass, the
+	// most recent first
+	summaryConsolidationBatch = 200
+	// summaryMergeMax bounds the summaries merged at once
+	summaryMergeMax = 8
+)
+
+// ConversationSummary is what is remembered of a conversation
+type ConversationSummary struct {
+	Highlights []string `json:"highlights"`
+	Facts      []string `json:"facts"`
+	Entities   []string `json:"entities"`
+	Decisions  []string `json:"decisions"`
+}
+
+func conversationSummarySchema() jsonschema.Definition {
+	list := func(description string) jsonschema.Definition {
+		return jsonschema.Definition{
+			Type:        jsonschema.Array,
+			Items:       &jsonschema.Definition{Type: jsonschema.String},
+			Description: description,
+		}
+	}
+	return jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"highlights": list("The highlights of the conversation, one short sentence each"),
+			"facts":      list("Durable facts learned, e.g. about the user, their preferences or their projects"),
+			"entities":   list("The people, organizations, places, projects or products mentioned"),
+			"decisions":  list("The decisions taken and the commitments made"),
+		},
+		Required: []string{"highlights", "facts", "entities", "decisions"},
+	}
+}
+
+func jsonList(values []string) datatypes.JSON {
+	if values == nil {
+		values = []string{}
+	}
+	b, _ := json.Marshal(values)
+	return datatypes.JSON(b)
+}
+
+func parseJSONList(data datatypes.JSON) []string {
+	values := []string{}
+	if len(data) > 0 {
+		_ = json.Unmarshal(data, &values)
+	}
+	return values
+}
+
+// summaryText is the text the summaries are searched and compared by
+func summaryText(s models.MemorySummary) string {
+	return strings.Join(append(append([]string{s.Summary}, parseJSONList(s.Facts)...), parseJSONList(s.Decisions)...), "\n")
+}
+
+// dialogue keeps the messages exchanged with the user
+func dialogue(conv Messages) Messages {
+	return conv.RemoveIf(func(msg openai.ChatCompletionMessage) bool {
+		return (msg.Role != UserRole && msg.Role != AssistantRole) ||
+			len(msg.ToolCalls) > 0 || strings.TrimSpace(msg.Content) == ""
+	})
+}
+
+// summarizeConversation stores the summary of a completed conversation,
+// linked to the messages it was made from
+func (a *Agent) summarizeConversation(ctx context.Context, conv Messages) {
+	conv = dialogue(conv)
+	if len(conv) < 2 || conv.GetLatestUserMessage() == nil {
+		return
+	}
+
+	summary, err := a.summarizeStructured(ctx, []openai.ChatCompletionMessage{
+		{
+			Role: SystemRole,
+			Content: `Summarize the conversation below for your long-term memory. List its highlights, the durable facts learned, the entities mentioned and the decisions taken. Be concise and only keep what is worth remembering in later conversations, leave a list empty when there is nothing to report.
+
+` + conv.String(),
+		},
+	})
+	if err != nil {
+		xlog.Error("Error summarizing conversation", "error", err, "agent", a.Character.Name)
+		return
+	}
+	if len(summary.Highlights) == 0 && len(summary.Facts) == 0 && len(summary.Decisions) == 0 {
+		return
+	}
+
+	// the reply of the agent is stored after the job finished, it had the
+	// time to be while the model was summarizing
+	sources, err := a.sourceMessages(conv)
+	if err != nil {
+		xlog.Warn("Failed to link the summary to its messages", "error", err, "agent", a.Character.Name)
+	}
+
+	row := newMemorySummary(a.options.agentID, a.options.userID, summary, sources)
+	if err := db.DB.Create(&row).Error; err != nil {
+		xlog.Error("Error storing conversation summary", "error", err, "agent", a.Character.Name)
+		return
+	}
+	xlog.Debug("Stored conversation summary", "agent", a.Character.Name, "summary", row.ID, "sources", len(sources))
+}
+
+// summarizeStructured asks the summarization model for a structured summary
+func (a *Agent) summarizeStructured(ctx context.Context, conv []openai.ChatCompletionMessage) (ConversationSummary, error) {
+	var summary ConversationSummary
+	err := a.generateJSON(llm.WithRequestType(ctx, PhaseSummarize), a.options.modelFor(PhaseSummarize),
+		conv, conversationSummarySchema(), &summary)
+	return summary, err
+}
+
+// sourceMessages returns the stored messages of the conversation, oldest
+// first
+func (a *Agent) sourceMessages(conv Messages) ([]models.AgentMessage, error) {
+	contents := []string{}
+	for _, msg := range conv {
+		contents = append(contents, msg.Content)
+	}
+
+	var messages []models.AgentMessage
+	if err := db.DB.Where("AgentID = ? AND Type = ? AND Content IN ?", a.options.agentID, "message", contents).
+		Order("CreatedAt DESC").
+		Limit(len(contents) * 2).
+		Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	// the same content may have been sent in older conversations, only keep
+	// the latest occurrence
+	seen := map[string]bool{}
+	sources := []models.AgentMessage{}
+	for _, m := range messages {
+		if seen[m.Sender+m.Content] {
+			continue
+		}
+		seen[m.Sender+m.Content] = true
+		sources = append(sources, m)
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].CreatedAt.Before(sources[j].CreatedAt)
+	})
+	return sources, nil
+}
+
+func newMemorySummary(agentID, userID uuid.UUID, summary ConversationSummary, sources []models.AgentMessage) models.MemorySummary {
+	ids := []string{}
+	for _, m := range sources {
+		ids = append(ids, m.ID.String())
+	}
+
+	highlights := []string{}
+	for _, h := range summary.Highlights {
+		highlights = append(highlights, "- "+strings.TrimPrefix(strings.TrimSpace(h), "- "))
+	}
+
+	now := time.Now()
+	row := models.MemorySummary{
+		ID:               uuid.New(),
+		AgentID:          agentID,
+		UserID:           userID,
+		Summary:          strings.Join(highlights, "\n"),
+		Facts:            jsonList(summary.Facts),
+		Entities:         jsonList(summary.Entities),
+		Decisions:        jsonList(summary.Decisions),
+		SourceMessageIDs: jsonList(ids),
+		StartedAt:        now,
+		EndedAt:          now,
+	}
+	if len(sources) > 0 {
+		row.StartedAt = sources[0].CreatedAt
+		row.EndedAt = sources[len(sources)-1].CreatedAt
+	}
+	return row
+}
+
+// searchSummaries returns the summaries matching the keywords of the query,
+// the most relevant first
+func (a *Agent) searchSummaries(query string, n int) ([]models.MemorySummary, error) {
+	keywords := extractKeywords(query)
+	if len(keywords) == 0 || n <= 0 {
+		return nil, nil
+	}
+
+	conditions := []string{}
+	args := []interface{}{}
+	for _, keyword := range keywords {
+		conditions = append(conditions, "LOWER(Summary) LIKE ? OR LOWER(Facts) LIKE ? OR LOWER(Entities) LIKE ? OR LOWER(Decisions) LIKE ?")
+		pattern := "%" + keyword + "%"
+		args = append(args, pattern, pattern, pattern, pattern)
+	}
+
+	var summaries []models.MemorySummary
+	if err := db.DB.Where("AgentID = ?", a.options.agentID).
+		Where(strings.Join(conditions, " OR "), args...).
+		Order("EndedAt DESC").
+		Limit(n * memoryCandidates).
+		Find(&summaries).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	scores := map[uuid.UUID]float64{}
+	for _, s := range summaries {
+		text := summaryText(s) + "\n" + strings.Join(parseJSONList(s.Entities), "\n")
+		scores[s.ID] = scoreMemory(0, keywordScore(keywords, text), now.Sub(s.EndedAt))
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		return scores[summaries[i].ID] > scores[summaries[j].ID]
+	})
+	return summaries[:min(n, len(summaries))], nil
+}
+
+// formatSummaries renders the summaries for the memory context
+func (a *Agent) formatSummaries(summaries []models.MemorySummary) string {
+	var formatted strings.Builder
+	formatted.WriteString(fmt.Sprintf("Summaries of past conversations (%d found):\n\n", len(summaries)))
+
+	now := time.Now()
+	for i, s := range summaries {
+		formatted.WriteString(fmt.Sprintf("%d. Conversation from %s ago (%d messages)\n%s\n",
+			i+1, a.formatTimeAgo(now.Sub(s.EndedAt)), len(parseJSONList(s.SourceMessageIDs)), s.Summary))
+		if facts := parseJSONList(s.Facts); len(facts) > 0 {
+			formatted.WriteString("   Facts: " + strings.Join(facts, "; ") + "\n")
+		}
+		if decisions := parseJSONList(s.Decisions); len(decisions) > 0 {
+			formatted.WriteString("   Decisions: " + strings.Join(decisions, "; ") + "\n")
+		}
+		formatted.WriteString("\n")
+	}
+	return formatted.String()
+}
+
+// consolidateSummariesLoop periodically merges the duplicate summaries until
+// the agent stops
+func (a *Agent) consolidateSummariesLoop() {
+	if !a.options.enableSummaryMemory || !a.persisted() {
+		return
+	}
+
+	// a first pass on start, the agents may not run long enough for the
+	// ticker to fire
+	timer := time.NewTimer(time.Minute)
+	defer timer.Stop()
+	for {
+		select {
+		case <-a.context.Done():
+			return
+		case <-timer.C:
+			if err := a.consolidateSummaries(a.context.Context); err != nil {
+				xlog.Error("Failed to consolidate conversation summaries", "error", err, "agent", a.Character.Name)
+			}
+			timer.Reset(summaryConsolidationInterval)
+		}
+	}
+}
+
+// consolidateSummaries merges the summaries of the agent which repeat each
+// other. The merged summary keeps the sources of all of them. The summaries
+// pinned or edited by the user are kept as they are.
+func (a *Agent) consolidateSummaries(ctx context.Context) error {
+	var summaries []models.MemorySummary
+	if err := db.DB.Where("AgentID = ? AND Pinned = ? AND Edited = ?", a.options.agentID, false, false).
+		Order("EndedAt DESC").
+		Limit(summaryConsolidationBatch).
+		Find(&summaries).Error; err != nil {
+		return err
+	}
+
+	groups := duplicateSummaries(summaries, a.calculateContentSimilarity)
+	merged := 0
+	for _, group := range groups {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := a.mergeSummaries(ctx, group); err != nil {
+			xlog.Warn("Failed to merge conversation summaries", "error", err, "agent", a.Character.Name, "summaries", len(group))
+			continue
+		}
+		merged += len(group)
+	}
+
+	if merged > 0 {
+		xlog.Info("Consolidated conversation summaries", "agent", a.Character.Name, "merged", merged, "into", len(groups))
+	}
+	return nil
+}
+
+// duplicateSummaries groups the summaries similar to each other, the groups
+// of a single summary and the summaries pinned or edited by the user are
+// left out
+func duplicateSummaries(summaries []models.MemorySummary, similarity func(string, string) float64) [][]models.MemorySummary {
+	summaries = slices.DeleteFunc(slices.Clone(summaries), func(s models.MemorySummary) bool {
+		return s.Pinned || s.Edited
+	})
+
+	texts := make([]string, len(summaries))
+	for i, s := range summaries {
+		texts[i] = summaryText(s)
+	}
+
+	grouped := make([]bool, len(summaries))
+	groups := [][]models.MemorySummary{}
+	for i := range summaries {
+		if grouped[i] {
+			continue
+		}
+		group := []models.MemorySummary{summaries[i]}
+		for j := i + 1; j < len(summaries) && len(group) < summaryMergeMax; j++ {
+			if !grouped[j] && similarity(texts[i], texts[j]) >= summaryDuplicateThreshold {
+				grouped[j] = true
+				group = append(group, summaries[j])
+			}
+		}
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// mergeSummaries replaces the summaries by a single one
+func (a *Agent) mergeSummaries(ctx context.Context, group []models.MemorySummary) error {
+	var text strings.Builder
+	for i, s := range group {
+		text.WriteString(fmt.Sprintf("Summary %d:\n%s\nFacts: %s\nEntities: %s\nDecisions: %s\n\n", i+1, s.Summary,
+			strings.Join(parseJSONList(s.Facts), "; "),
+			strings.Join(parseJSONList(s.Entities), "; "),
+			strings.Join(parseJSONList(s.Decisions), "; ")))
+	}
+
+	summary, err := a.summarizeStructured(ctx, []openai.ChatCompletionMessage{
+		{
+			Role: SystemRole,
+			Content: `The summaries below of past conversations repeat each other. Merge them into a single summary without duplicates. Keep every distinct highlight, fact, entity and decision, and when they contradict each other keep the most recent one (the summaries are sorted from the most recent).
+
+` + text.String(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	ids := []uuid.UUID{}
+	sources := []string{}
+	seen := map[string]bool{}
+	startedAt, endedAt := group[0].StartedAt, group[0].EndedAt
+	for _, s := range group {
+		ids = append(ids, s.ID)
+		for _, id := range parseJSONList(s.SourceMessageIDs) {
+			if !seen[id] {
+				seen[id] = true
+				sources = append(sources, id)
+			}
+		}
+		if s.StartedAt.Before(startedAt) {
+			startedAt = s.StartedAt
+		}
+		if s.EndedAt.After(endedAt) {
+			endedAt = s.EndedAt
+		}
+	}
+
+	row := newMemorySummary(a.options.agentID, a.options.userID, summary, nil)
+	row.SourceMessageIDs = jsonList(sources)
+	row.StartedAt = startedAt
+	row.EndedAt = endedAt
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&row).Error; err != nil {
+			return err
+		}
+		// pinned or edited by the user while merging
+		res := tx.Where("AgentID = ? AND ID IN ? AND Pinned = ? AND Edited = ?", a.options.agentID, ids, false, false).
+			Delete(&models.MemorySummary{})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected != int64(len(ids)) {
+			return fmt.Errorf("the summaries changed while being merged")
+		}
+		return nil
+	})
+}
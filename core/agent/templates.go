@@ -79,7 +79,11 @@ Current State:
 - Permanent Goal: {{if .PermanentGoal}}{{.PermanentGoal}}{{else}}None{{end}}
 - Current Goal: {{if .CurrentState.Goal}}{{.CurrentState.Goal}}{{else}}None{{end}}
 - Action History: {{range .CurrentState.DoneHistory}}{{.}} {{end}}
-- Short-term Memory: {{range .CurrentState.Memories}}{{.}} {{end}}{{end}}
+- Short-term Memory: {{range .CurrentState.Memories}}{{.}} {{end}}{{if .ActionWarnings}}
+
+Unreliable Tools (avoid them or try an alternative, unless the user insists):
+{{range .ActionWarnings}}- {{.}}
+{{end}}{{end}}{{end}}
 Current Time: {{.Time}}`
 
 const pickSelfTemplate = `
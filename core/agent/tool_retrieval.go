@@ -0,0 +1,180 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/mudler/LocalAGI/core/action"
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/pkg/llm"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/sashabaranov/go-openai"
+)
+
+// errUnavailableTool is returned by the picker when the model asks for a tool
+// that wasn't retrieved, the action is then picked among all the actions
+var errUnavailableTool = errors.New("the model asked for a tool which wasn't offered")
+
+// coreActions are always offered to the picker, whatever the conversation
+var coreActions = []string{
+	action.ReplyActionName,
+	action.StopActionName,
+	action.PlanActionName,
+	action.StateActionName,
+	action.ConversationActionName,
+}
+
+// toolRetrievalMessages is how many of the last messages describe what the
+// conversation needs
+const toolRetrievalMessages = 4
+
+type toolEmbedding struct {
+	text      string
+	embedding []float32
+}
+
+// toolText is what is embedded to retrieve an action
+func toolText(act types.Action) string {
+	definition := act.Definition()
+
+	text := strings.Builder{}
+	text.WriteString(definition.Name.String())
+	text.WriteString(": ")
+	text.WriteString(definition.Description)
+	// sorted, the text of an action must not change between calls for its
+	// embedding to be cached
+	for _, name := range slices.Sorted(maps.Keys(definition.Properties)) {
+		text.WriteString(fmt.Sprintf("\n- %s: %s", name, definition.Properties[name].Description))
+	}
+	return text.String()
+}
+
+// retrievalQuery is what the actions are compared to: the last messages of
+// the conversation
+func retrievalQuery(conv []openai.ChatCompletionMessage) string {
+	parts := []string{}
+	for i := len(conv) - 1; i >= 0 && len(parts) < toolRetrievalMessages; i-- {
+		if conv[i].Role == SystemRole || conv[i].Content == "" {
+			continue
+		}
+		parts = append(parts, conv[i].Content)
+	}
+	slices.Reverse(parts)
+	return strings.Join(parts, "\n")
+}
+
+// retrieveActions returns the actions to offer to the picker: the core
+// actions, the tools of the job and the k actions most relevant to the
+// conversation. All the actions are returned when tool retrieval is
+// disabled or fails.
+func (a *Agent) retrieveActions(job *types.Job, conv []openai.ChatCompletionMessage, actions types.Actions) types.Actions {
+	topK := a.options.toolRetrievalTopK
+	if topK <= 0 || len(actions) <= topK+len(coreActions) {
+		return actions
+	}
+
+	query := retrievalQuery(conv)
+	if query == "" {
+		return actions
+	}
+
+	keep := map[string]bool{}
+	for _, name := range coreActions {
+		keep[name] = true
+	}
+	if job.ToolChoice != "" {
+		keep[job.ToolChoice] = true
+	}
+	for _, tool := range job.GetUserTools() {
+		keep[tool.Name.String()] = true
+	}
+	// the actions already run by the job are likely needed again
+	for _, step := range job.GetSteps() {
+		keep[step.Action] = true
+	}
+
+	candidates := types.Actions{}
+	for _, act := range actions {
+		if !keep[act.Definition().Name.String()] {
+			candidates = append(candidates, act)
+		}
+	}
+	if len(candidates) <= topK {
+		return actions
+	}
+
+	embeddings, queryEmbedding, err := a.embedActions(job, candidates, query)
+	if err != nil {
+		xlog.Warn("Tool retrieval failed, offering every action", "agent", a.Character.Name, "error", err)
+		return actions
+	}
+
+	for _, i := range llm.TopK(queryEmbedding, embeddings, topK) {
+		keep[candidates[i].Definition().Name.String()] = true
+	}
+
+	offered := types.Actions{}
+	for _, act := range actions {
+		if keep[act.Definition().Name.String()] {
+			offered = append(offered, act)
+		}
+	}
+
+	xlog.Debug("Retrieved actions", "agent", a.Character.Name, "offered", len(offered), "available", len(actions))
+	return offered
+}
+
+// embedActions returns the embeddings of the actions and of the query. The
+// embeddings of the actions are cached until their definition changes.
+func (a *Agent) embedActions(job *types.Job, actions types.Actions, query string) ([][]float32, []float32, error) {
+	texts := make([]string, len(actions))
+	embeddings := make([][]float32, len(actions))
+	missing := []int{}
+
+	a.toolIndexMutex.Lock()
+	for i, act := range actions {
+		texts[i] = toolText(act)
+		if cached, ok := a.toolIndex[act.Definition().Name.String()]; ok && cached.text == texts[i] {
+			embeddings[i] = cached.embedding
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	a.toolIndexMutex.Unlock()
+
+	inputs := []string{query}
+	for _, i := range missing {
+		inputs = append(inputs, texts[i])
+	}
+
+	result, err := llm.Embed(job.GetContext(), a.embedder, a.options.embeddingModel, a.options.userID, a.options.agentID, inputs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	a.toolIndexMutex.Lock()
+	for j, i := range missing {
+		embeddings[i] = result[j+1]
+		a.toolIndex[actions[i].Definition().Name.String()] = toolEmbedding{
+			text:      texts[i],
+			embedding: result[j+1],
+		}
+	}
+	a.toolIndexMutex.Unlock()
+
+	return embeddings, result[0], nil
+}
+
+// keepOffered filters the actions to the offered ones
+func keepOffered(actions, offered types.Actions) types.Actions {
+	kept := types.Actions{}
+	for _, act := range actions {
+		if offered.Find(act.Definition().Name.String()) != nil {
+			kept = append(kept, act)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/mudler/LocalAGI/core/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// describedAction is an action only meant to be retrieved
+type describedAction struct {
+	name, description string
+}
+
+func (d *describedAction) Run(context.Context, *types.AgentSharedState, types.ActionParams) (types.ActionResult, error) {
+	return types.ActionResult{}, nil
+}
+
+func (d *describedAction) Definition() types.ActionDefinition {
+	return types.ActionDefinition{
+		Name:        types.ActionDefinitionName(d.name),
+		Description: d.description,
+		Properties: map[string]jsonschema.Definition{
+			"target":   {Type: jsonschema.String, Description: "Target"},
+			"amount":   {Type: jsonschema.Number, Description: "Amount"},
+			"when":     {Type: jsonschema.String, Description: "When"},
+			"where":    {Type: jsonschema.String, Description: "Where"},
+			"priority": {Type: jsonschema.String, Description: "Priority"},
+		},
+	}
+}
+
+func (d *describedAction) Plannable() bool {
+	return true
+}
+
+func actionNames(actions types.Actions) []string {
+	names := []string{}
+	for _, act := range actions {
+		names = append(names, act.Definition().Name.String())
+	}
+	return names
+}
+
+var _ = Describe("Tool retrieval", func() {
+	var (
+		fake    *fakeLLM
+		actions types.Actions
+		conv    []openai.ChatCompletionMessage
+	)
+
+	BeforeEach(func() {
+		fake = newFakeLLM()
+		actions = types.Actions{
+			&describedAction{name: "feed_goats", description: "feed goats on a farm"},
+			&describedAction{name: "water_plants", description: "water plants in a garden"},
+			&describedAction{name: "send_email", description: "send an email to someone"},
+			&describedAction{name: "read_news", description: "read latest news headlines"},
+			&describedAction{name: "book_flight", description: "book a flight ticket"},
+			&describedAction{name: "play_music", description: "play some music"},
+			&describedAction{name: "check_weather", description: "check weather forecast"},
+			&describedAction{name: "convert_currency", description: "convert an amount between currencies"},
+		}
+		conv = []openai.ChatCompletionMessage{
+			{Role: SystemRole, Content: "you are a farmer"},
+			{Role: UserRole, Content: "please feed goats"},
+		}
+	})
+
+	It("describes the actions the same way at every call", func() {
+		text := toolText(actions[0])
+		for range 20 {
+			Expect(toolText(actions[0])).To(Equal(text))
+		}
+		Expect(text).To(Equal("feed_goats: feed goats on a farm\n- amount: Amount\n- priority: Priority\n- target: Target\n- when: When\n- where: Where"))
+	})
+
+	It("offers the actions most relevant to the conversation", func() {
+		a := newTestAgent(fake, WithToolRetrieval(1))
+
+		offered := a.retrieveActions(types.NewJob(), conv, actions)
+		Expect(actionNames(offered)).To(Equal([]string{"feed_goats"}))
+	})
+
+	It("keeps the actions already run by the job", func() {
+		a := newTestAgent(fake, WithToolRetrieval(1))
+		job := types.NewJob()
+		job.AddStep(types.JobStep{Action: "send_email"})
+
+		offered := a.retrieveActions(job, conv, actions)
+		Expect(actionNames(offered)).To(ConsistOf("feed_goats", "send_email"))
+	})
+
+	It("embeds the actions only once", func() {
+		a := newTestAgent(fake, WithToolRetrieval(1))
+
+		a.retrieveActions(types.NewJob(), conv, actions)
+		Expect(fake.Embedded()).To(HaveLen(len(actions) + 1))
+
+		a.retrieveActions(types.NewJob(), conv, actions)
+		Expect(fake.Embedded()).To(HaveLen(len(actions) + 2))
+	})
+
+	It("offers every action when it is disabled", func() {
+		a := newTestAgent(fake)
+
+		Expect(a.retrieveActions(types.NewJob(), conv, actions)).To(Equal(actions))
+		Expect(fake.Embedded()).To(BeEmpty())
+	})
+
+	It("offers every action when there are only a few", func() {
+		a := newTestAgent(fake, WithToolRetrieval(1))
+
+		Expect(a.retrieveActions(types.NewJob(), conv, actions[:3])).To(Equal(actions[:3]))
+		Expect(fake.Embedded()).To(BeEmpty())
+	})
+
+	It("offers every action when the conversation is empty", func() {
+		a := newTestAgent(fake, WithToolRetrieval(1))
+
+		system := []openai.ChatCompletionMessage{{Role: SystemRole, Content: "you are a farmer"}}
+		Expect(a.retrieveActions(types.NewJob(), system, actions)).To(Equal(actions))
+	})
+
+	It("offers every action when the embeddings fail", func() {
+		fake.embeddingError = "no embedding model"
+		a := newTestAgent(fake, WithToolRetrieval(1))
+
+		Expect(a.retrieveActions(types.NewJob(), conv, actions)).To(Equal(actions))
+	})
+})
@@ -0,0 +1,52 @@
+// Package evaluators implements the built-in job evaluators of the agents
+package evaluators
+
+import (
+	"fmt"
+
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/pkg/config"
+)
+
+const (
+	EvaluatorLLMRubric      = "llm_rubric"
+	EvaluatorRegex          = "regex"
+	EvaluatorContains       = "contains"
+	EvaluatorJSONSchema     = "json_schema"
+	EvaluatorRequiredAction = "required_action"
+)
+
+// New returns the evaluator of the given type configured with configJSON
+func New(evaluatorType, configJSON string) (types.Evaluator, error) {
+	switch evaluatorType {
+	case EvaluatorLLMRubric:
+		return NewLLMRubricEvaluator(configJSON)
+	case EvaluatorRegex:
+		return NewRegexEvaluator(configJSON)
+	case EvaluatorContains:
+		return NewContainsEvaluator(configJSON)
+	case EvaluatorJSONSchema:
+		return NewJSONSchemaEvaluator(configJSON)
+	case EvaluatorRequiredAction:
+		return NewRequiredActionEvaluator(configJSON)
+	}
+	return nil, fmt.Errorf("unknown evaluator type %q", evaluatorType)
+}
+
+// ConfigMeta returns the config metas of the evaluators for the UI
+func ConfigMeta() []config.FieldGroup {
+	return []config.FieldGroup{
+		LLMRubricEvaluatorConfigMeta(),
+		RegexEvaluatorConfigMeta(),
+		ContainsEvaluatorConfigMeta(),
+		JSONSchemaEvaluatorConfigMeta(),
+		RequiredActionEvaluatorConfigMeta(),
+	}
+}
+
+func nameOr(name, def string) string {
+	if name != "" {
+		return name
+	}
+	return def
+}
@@ -0,0 +1,13 @@
+package evaluators_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestEvaluators(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Evaluators test suite")
+}
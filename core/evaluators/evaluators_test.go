@@ -0,0 +1,56 @@
+package evaluators_test
+
+import (
+	"context"
+
+	"github.com/mudler/LocalAGI/core/evaluators"
+	"github.com/mudler/LocalAGI/core/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Evaluators", func() {
+	evaluate := func(evaluatorType, config string, input types.EvaluationInput) types.EvaluationVerdict {
+		evaluator, err := evaluators.New(evaluatorType, config)
+		Expect(err).ToNot(HaveOccurred())
+		verdict, err := evaluator.Evaluate(context.Background(), input)
+		Expect(err).ToNot(HaveOccurred())
+		return verdict
+	}
+
+	It("matches the response against a regex", func() {
+		config := `{"name": "ticket", "pattern": "TICKET-\\d+"}`
+
+		Expect(evaluate(evaluators.EvaluatorRegex, config, types.EvaluationInput{Response: "Opened TICKET-42"}).Passed).To(BeTrue())
+
+		verdict := evaluate(evaluators.EvaluatorRegex, config, types.EvaluationInput{Response: "Done"})
+		Expect(verdict.Passed).To(BeFalse())
+		Expect(verdict.Evaluator).To(Equal("ticket"))
+		Expect(verdict.Reason).ToNot(BeEmpty())
+	})
+
+	It("checks the response contains a text", func() {
+		Expect(evaluate(evaluators.EvaluatorContains, `{"text": "summary"}`, types.EvaluationInput{Response: "## Summary"}).Passed).To(BeTrue())
+		Expect(evaluate(evaluators.EvaluatorContains, `{"text": "summary", "case_sensitive": true}`, types.EvaluationInput{Response: "## Summary"}).Passed).To(BeFalse())
+	})
+
+	It("validates the response against a JSON schema", func() {
+		config := `{"schema": "{\"type\": \"object\", \"properties\": {\"status\": {\"type\": \"string\"}}, \"required\": [\"status\"]}"}`
+
+		Expect(evaluate(evaluators.EvaluatorJSONSchema, config, types.EvaluationInput{Response: "```json\n{\"status\": \"ok\"}\n```"}).Passed).To(BeTrue())
+		Expect(evaluate(evaluators.EvaluatorJSONSchema, config, types.EvaluationInput{Response: `{"code": 1}`}).Passed).To(BeFalse())
+		Expect(evaluate(evaluators.EvaluatorJSONSchema, config, types.EvaluationInput{Response: "not json"}).Passed).To(BeFalse())
+	})
+
+	It("requires an action to be called", func() {
+		config := `{"action": "send_email"}`
+
+		Expect(evaluate(evaluators.EvaluatorRequiredAction, config, types.EvaluationInput{Actions: []string{"search", "send_email"}}).Passed).To(BeTrue())
+		Expect(evaluate(evaluators.EvaluatorRequiredAction, config, types.EvaluationInput{Actions: []string{"search"}}).Passed).To(BeFalse())
+	})
+
+	It("rejects unknown evaluator types", func() {
+		_, err := evaluators.New("unknown", `{}`)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,85 @@
+package evaluators
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/pkg/config"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// JSONSchemaEvaluator checks that the response is JSON valid against a
+// schema. A response wrapped in a markdown code block is accepted.
+type JSONSchemaEvaluator struct {
+	name   string
+	schema jsonschema.Definition
+}
+
+type JSONSchemaEvaluatorConfig struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+func NewJSONSchemaEvaluator(configJSON string) (*JSONSchemaEvaluator, error) {
+	var cfg JSONSchemaEvaluatorConfig
+	if err := json.Unmarshal([]byte(configJSON), &cfg); err != nil {
+		return nil, err
+	}
+
+	var schema jsonschema.Definition
+	if err := json.Unmarshal([]byte(cfg.Schema), &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return &JSONSchemaEvaluator{
+		name:   nameOr(cfg.Name, EvaluatorJSONSchema),
+		schema: schema,
+	}, nil
+}
+
+func (e *JSONSchemaEvaluator) Name() string { return e.name }
+
+func (e *JSONSchemaEvaluator) Evaluate(ctx context.Context, input types.EvaluationInput) (types.EvaluationVerdict, error) {
+	verdict := types.EvaluationVerdict{Evaluator: e.name}
+
+	var data any
+	if err := json.Unmarshal([]byte(stripCodeBlock(input.Response)), &data); err != nil {
+		verdict.Reason = fmt.Sprintf("the response must be valid JSON: %v", err)
+		return verdict, nil
+	}
+
+	if !jsonschema.Validate(e.schema, data) {
+		schema, _ := json.Marshal(e.schema)
+		verdict.Reason = fmt.Sprintf("the response must follow the JSON schema %s", schema)
+		return verdict, nil
+	}
+
+	verdict.Passed = true
+	return verdict, nil
+}
+
+// stripCodeBlock removes the markdown code block around a response
+func stripCodeBlock(response string) string {
+	response = strings.TrimSpace(response)
+	if !strings.HasPrefix(response, "```") {
+		return response
+	}
+	response = strings.TrimPrefix(response, "```")
+	if i := strings.Index(response, "\n"); i >= 0 {
+		response = response[i+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(response), "```"))
+}
+
+func JSONSchemaEvaluatorConfigMeta() config.FieldGroup {
+	return config.FieldGroup{
+		Name:  EvaluatorJSONSchema,
+		Label: "JSON Schema",
+		Fields: []config.Field{
+			{Name: "name", Label: "Name", Type: "text"},
+			{Name: "schema", Label: "Schema", Type: "textarea", Required: true, HelpText: "JSON schema the response must be valid against"},
+		},
+	}
+}
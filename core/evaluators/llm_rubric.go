@@ -0,0 +1,104 @@
+package evaluators
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/pkg/config"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+const llmRubricPrompt = `Judge the response of an AI agent against the following rubric.
+
+Rubric:
+%s
+
+Request of the user:
+%s
+
+Response of the agent:
+%s
+
+Tell whether the response meets every criterion of the rubric, and if not which criteria it misses.`
+
+// LLMRubricEvaluator asks the LLM of the agent to judge the response against
+// a rubric
+type LLMRubricEvaluator struct {
+	name   string
+	rubric string
+}
+
+type LLMRubricEvaluatorConfig struct {
+	Name   string `json:"name"`
+	Rubric string `json:"rubric"`
+}
+
+func NewLLMRubricEvaluator(configJSON string) (*LLMRubricEvaluator, error) {
+	var cfg LLMRubricEvaluatorConfig
+	if err := json.Unmarshal([]byte(configJSON), &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Rubric == "" {
+		return nil, fmt.Errorf("rubric is required")
+	}
+	return &LLMRubricEvaluator{
+		name:   nameOr(cfg.Name, EvaluatorLLMRubric),
+		rubric: cfg.Rubric,
+	}, nil
+}
+
+func (e *LLMRubricEvaluator) Name() string { return e.name }
+
+func (e *LLMRubricEvaluator) Evaluate(ctx context.Context, input types.EvaluationInput) (types.EvaluationVerdict, error) {
+	if input.Judge == nil {
+		return types.EvaluationVerdict{}, fmt.Errorf("no LLM to judge the rubric")
+	}
+
+	request := ""
+	for _, msg := range input.Conversation {
+		if msg.Role == "user" {
+			request = msg.Content
+		}
+	}
+
+	var result struct {
+		Passed bool   `json:"passed"`
+		Reason string `json:"reason"`
+	}
+	err := input.Judge(ctx, fmt.Sprintf(llmRubricPrompt, e.rubric, request, input.Response), jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"passed": {
+				Type:        jsonschema.Boolean,
+				Description: "Whether the response meets every criterion of the rubric",
+			},
+			"reason": {
+				Type:        jsonschema.String,
+				Description: "The criteria the response misses, or why it meets them",
+			},
+		},
+		Required: []string{"passed", "reason"},
+	}, &result)
+	if err != nil {
+		return types.EvaluationVerdict{}, err
+	}
+
+	return types.EvaluationVerdict{
+		Evaluator: e.name,
+		Passed:    result.Passed,
+		Reason:    result.Reason,
+	}, nil
+}
+
+func LLMRubricEvaluatorConfigMeta() config.FieldGroup {
+	return config.FieldGroup{
+		Name:  EvaluatorLLMRubric,
+		Label: "LLM Rubric",
+		Fields: []config.Field{
+			{Name: "name", Label: "Name", Type: "text"},
+			{Name: "rubric", Label: "Rubric", Type: "textarea", Required: true, HelpText: "Criteria the response must meet, judged by the LLM"},
+		},
+	}
+}
@@ -0,0 +1,125 @@
+package evaluators
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/pkg/config"
+)
+
+// RegexEvaluator checks that the response matches, or doesn't match, a
+// regular expression
+type RegexEvaluator struct {
+	name         string
+	pattern      *regexp.Regexp
+	mustNotMatch bool
+}
+
+type RegexEvaluatorConfig struct {
+	Name         string `json:"name"`
+	Pattern      string `json:"pattern"`
+	MustNotMatch bool   `json:"must_not_match"`
+}
+
+func NewRegexEvaluator(configJSON string) (*RegexEvaluator, error) {
+	var cfg RegexEvaluatorConfig
+	if err := json.Unmarshal([]byte(configJSON), &cfg); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(cfg.Pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &RegexEvaluator{
+		name:         nameOr(cfg.Name, EvaluatorRegex),
+		pattern:      re,
+		mustNotMatch: cfg.MustNotMatch,
+	}, nil
+}
+
+func (e *RegexEvaluator) Name() string { return e.name }
+
+func (e *RegexEvaluator) Evaluate(ctx context.Context, input types.EvaluationInput) (types.EvaluationVerdict, error) {
+	matched := e.pattern.MatchString(input.Response)
+	verdict := types.EvaluationVerdict{Evaluator: e.name, Passed: matched != e.mustNotMatch}
+	switch {
+	case !verdict.Passed && e.mustNotMatch:
+		verdict.Reason = fmt.Sprintf("the response must not match the pattern %s", e.pattern)
+	case !verdict.Passed:
+		verdict.Reason = fmt.Sprintf("the response must match the pattern %s", e.pattern)
+	}
+	return verdict, nil
+}
+
+func RegexEvaluatorConfigMeta() config.FieldGroup {
+	return config.FieldGroup{
+		Name:  EvaluatorRegex,
+		Label: "Regex",
+		Fields: []config.Field{
+			{Name: "name", Label: "Name", Type: "text"},
+			{Name: "pattern", Label: "Pattern", Type: "text", Required: true},
+			{Name: "must_not_match", Label: "Must Not Match", Type: "checkbox"},
+		},
+	}
+}
+
+// ContainsEvaluator checks that the response contains a text
+type ContainsEvaluator struct {
+	name          string
+	text          string
+	caseSensitive bool
+}
+
+type ContainsEvaluatorConfig struct {
+	Name          string `json:"name"`
+	Text          string `json:"text"`
+	CaseSensitive bool   `json:"case_sensitive"`
+}
+
+func NewContainsEvaluator(configJSON string) (*ContainsEvaluator, error) {
+	var cfg ContainsEvaluatorConfig
+	if err := json.Unmarshal([]byte(configJSON), &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Text == "" {
+		return nil, fmt.Errorf("text is required")
+	}
+	return &ContainsEvaluator{
+		name:          nameOr(cfg.Name, EvaluatorContains),
+		text:          cfg.Text,
+		caseSensitive: cfg.CaseSensitive,
+	}, nil
+}
+
+func (e *ContainsEvaluator) Name() string { return e.name }
+
+func (e *ContainsEvaluator) Evaluate(ctx context.Context, input types.EvaluationInput) (types.EvaluationVerdict, error) {
+	response, text := input.Response, e.text
+	if !e.caseSensitive {
+		response, text = strings.ToLower(response), strings.ToLower(text)
+	}
+
+	if strings.Contains(response, text) {
+		return types.EvaluationVerdict{Evaluator: e.name, Passed: true}, nil
+	}
+	return types.EvaluationVerdict{
+		Evaluator: e.name,
+		Reason:    fmt.Sprintf("the response must contain %q", e.text),
+	}, nil
+}
+
+func ContainsEvaluatorConfigMeta() config.FieldGroup {
+	return config.FieldGroup{
+		Name:  EvaluatorContains,
+		Label: "Contains",
+		Fields: []config.Field{
+			{Name: "name", Label: "Name", Type: "text"},
+			{Name: "text", Label: "Text", Type: "text", Required: true},
+			{Name: "case_sensitive", Label: "Case Sensitive", Type: "checkbox"},
+		},
+	}
+}
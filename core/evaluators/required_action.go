@@ -0,0 +1,60 @@
+package evaluators
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/pkg/config"
+)
+
+// RequiredActionEvaluator checks that the job ran an action
+type RequiredActionEvaluator struct {
+	name   string
+	action string
+}
+
+type RequiredActionEvaluatorConfig struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+func NewRequiredActionEvaluator(configJSON string) (*RequiredActionEvaluator, error) {
+	var cfg RequiredActionEvaluatorConfig
+	if err := json.Unmarshal([]byte(configJSON), &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Action == "" {
+		return nil, fmt.Errorf("action is required")
+	}
+	return &RequiredActionEvaluator{
+		name:   nameOr(cfg.Name, EvaluatorRequiredAction),
+		action: cfg.Action,
+	}, nil
+}
+
+func (e *RequiredActionEvaluator) Name() string { return e.name }
+
+func (e *RequiredActionEvaluator) Evaluate(ctx context.Context, input types.EvaluationInput) (types.EvaluationVerdict, error) {
+	for _, action := range input.Actions {
+		if action == e.action {
+			return types.EvaluationVerdict{Evaluator: e.name, Passed: true}, nil
+		}
+	}
+	return types.EvaluationVerdict{
+		Evaluator: e.name,
+		Reason:    fmt.Sprintf("the action %s must be called", e.action),
+	}, nil
+}
+
+func RequiredActionEvaluatorConfigMeta() config.FieldGroup {
+	return config.FieldGroup{
+		Name:  EvaluatorRequiredAction,
+		Label: "Required Action",
+		Fields: []config.Field{
+			{Name: "name", Label: "Name", Type: "text"},
+			{Name: "action", Label: "Action", Type: "text", Required: true},
+		},
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 
 	"github.com/mudler/LocalAGI/core/agent"
+	"github.com/mudler/LocalAGI/core/evaluators"
 	"github.com/mudler/LocalAGI/core/types"
 	"github.com/mudler/LocalAGI/pkg/config"
 )
@@ -16,6 +17,9 @@ type ConnectorConfig struct {
 type ActionsConfig struct {
 	Name   string `json:"name"` // e.g. search
 	Config string `json:"config"`
+	// RequiresApproval pauses the job before running the action
+	// until a user approves, modifies or denies it
+	RequiresApproval bool `json:"requires_approval,omitempty"`
 }
 
 type DynamicPromptsConfig struct {
@@ -34,12 +38,27 @@ type FiltersConfig struct {
 	Config string `json:"config"`
 }
 
+// EvaluatorsConfig is an evaluator of the jobs of an agent, see
+// core/evaluators for the types and their config
+type EvaluatorsConfig struct {
+	Type   string `json:"type"`
+	Config string `json:"config"`
+}
+
+// Vector stores of the long-term memory of the agents
+const (
+	MemoryVectorStoreChromem = "chromem"
+	MemoryVectorStoreLocalAI = "localai"
+)
+
 type AgentConfig struct {
 	Connector      []ConnectorConfig          `json:"connectors" form:"connectors" `
 	Actions        []ActionsConfig            `json:"actions" form:"actions"`
 	DynamicPrompts []DynamicPromptsConfig     `json:"dynamic_prompts" form:"dynamic_prompts"`
 	MCPServers     []agent.MCPServer          `json:"mcp_servers" form:"mcp_servers"`
+	LLMFallbacks   []agent.LLMFallback        `json:"llm_fallbacks" form:"llm_fallbacks"`
 	Filters        []FiltersConfig            `json:"filters" form:"filters"`
+	Evaluators     []EvaluatorsConfig         `json:"evaluators" form:"evaluators"`
 	ServerWallets  []types.ServerWalletConfig `json:"server_wallets" form:"server_wallets"`
 	PayLimits      map[string]float64         `json:"pay_limits" form:"pay_limits"`
 
@@ -47,8 +66,19 @@ type AgentConfig struct {
 
 	Model           string `json:"model" form:"model"`
 	MultimodalModel string `json:"multimodal_model" form:"multimodal_model"`
-	LocalRAGURL     string `json:"local_rag_url" form:"local_rag_url"`
-	LocalRAGAPIKey  string `json:"local_rag_api_key" form:"local_rag_api_key"`
+	PickModel       string `json:"pick_model" form:"pick_model"`
+	ParamsModel     string `json:"params_model" form:"params_model"`
+	EvaluateModel   string `json:"evaluate_model" form:"evaluate_model"`
+	ReplyModel      string `json:"reply_model" form:"reply_model"`
+	SummarizeModel  string `json:"summarize_model" form:"summarize_model"`
+	EmbeddingModel  string `json:"embedding_model" form:"embedding_model"`
+	// LLMAPIURL and LLMAPIKey override the LLM endpoint of the instance. When
+	// the endpoint is the one of the instance, the key can reference an
+	// environment variable prefixed with LOCALAGI_TENANT_ as $NAME or ${NAME}.
+	LLMAPIURL      string `json:"llm_api_url" form:"llm_api_url"`
+	LLMAPIKey      string `json:"llm_api_key" form:"llm_api_key"`
+	LocalRAGURL    string `json:"local_rag_url" form:"local_rag_url"`
+	LocalRAGAPIKey string `json:"local_rag_api_key" form:"local_rag_api_key"`
 
 	Name                  string `json:"name" form:"name"`
 	HUD                   bool   `json:"hud" form:"hud"`
@@ -61,17 +91,43 @@ type AgentConfig struct {
 	PermanentGoal         string `json:"permanent_goal" form:"permanent_goal"`
 	EnableKnowledgeBase   bool   `json:"enable_kb" form:"enable_kb"`
 	EnableReasoning       bool   `json:"enable_reasoning" form:"enable_reasoning"`
+	NativeToolCalls       bool   `json:"native_tool_calls" form:"native_tool_calls"`
+	ToolRetrievalTopK     int    `json:"tool_retrieval_top_k" form:"tool_retrieval_top_k"`
+	ActionReliability     bool   `json:"action_reliability_hints" form:"action_reliability_hints"`
 	KnowledgeBaseResults  int    `json:"kb_results" form:"kb_results"`
 	LoopDetectionSteps    int    `json:"loop_detection_steps" form:"loop_detection_steps"`
 	CanStopItself         bool   `json:"can_stop_itself" form:"can_stop_itself"`
 	SystemPrompt          string `json:"system_prompt" form:"system_prompt"`
 	LongTermMemory        bool   `json:"long_term_memory" form:"long_term_memory"`
 	SummaryLongTermMemory bool   `json:"summary_long_term_memory" form:"summary_long_term_memory"`
+	MemoryVectorStore     string `json:"memory_vector_store" form:"memory_vector_store"`
+	MemoryActions         bool   `json:"memory_actions" form:"memory_actions"`
+	MaxMemories           int    `json:"max_memories" form:"max_memories"`
 	ParallelJobs          int    `json:"parallel_jobs" form:"parallel_jobs"`
+	JobQueueSize          int    `json:"job_queue_size" form:"job_queue_size"`
+	PauseMode             string `json:"pause_mode" form:"pause_mode"`
+	ParkedJobTTL          string `json:"parked_job_ttl" form:"parked_job_ttl"`
+	MaxJobAttempts        int    `json:"max_job_attempts" form:"max_job_attempts"`
+	RetryBackoff          string `json:"retry_backoff" form:"retry_backoff"`
+	MaxRetryBackoff       string `json:"max_retry_backoff" form:"max_retry_backoff"`
+	ParallelToolCalls     int    `json:"parallel_tool_calls" form:"parallel_tool_calls"`
 	StripThinkingTags     bool   `json:"strip_thinking_tags" form:"strip_thinking_tags"`
 	EnableEvaluation      bool   `json:"enable_evaluation" form:"enable_evaluation"`
 	MaxEvaluationLoops    int    `json:"max_evaluation_loops" form:"max_evaluation_loops"`
+	MaxReplans            int    `json:"max_replans" form:"max_replans"`
 	LastMessageDuration   string `json:"last_message_duration" form:"last_message_duration"`
+	ContextWindow         int    `json:"context_window" form:"context_window"`
+
+	MaxJobSteps    int     `json:"max_job_steps" form:"max_job_steps"`
+	MaxJobLLMCalls int     `json:"max_job_llm_calls" form:"max_job_llm_calls"`
+	MaxJobDuration string  `json:"max_job_duration" form:"max_job_duration"`
+	MaxJobCost     float64 `json:"max_job_cost" form:"max_job_cost"`
+
+	// Sampling parameters of the LLM requests, unset keeps the provider defaults
+	Temperature *float32 `json:"temperature,omitempty" form:"temperature"`
+	TopP        *float32 `json:"top_p,omitempty" form:"top_p"`
+	MaxTokens   *int     `json:"max_tokens,omitempty" form:"max_tokens"`
+	Seed        *int     `json:"seed,omitempty" form:"seed"`
 }
 
 type AgentConfigMeta struct {
@@ -81,6 +137,8 @@ type AgentConfigMeta struct {
 	Actions        []config.FieldGroup
 	DynamicPrompts []config.FieldGroup
 	MCPServers     []config.Field
+	LLMFallbacks   []config.Field
+	Evaluators     []config.FieldGroup
 }
 
 func NewAgentConfigMeta(
@@ -141,6 +199,108 @@ func NewAgentConfigMeta(
 				DefaultValue: "",
 				Tags:         config.Tags{Section: "ModelSettings"},
 			},
+			{
+				Name:         "pick_model",
+				Label:        "Action Selection Model",
+				Type:         "text",
+				DefaultValue: "",
+				HelpText:     "Model picking the actions to run (empty to use the agent model)",
+				Tags:         config.Tags{Section: "ModelSettings"},
+			},
+			{
+				Name:         "params_model",
+				Label:        "Parameters Model",
+				Type:         "text",
+				DefaultValue: "",
+				HelpText:     "Model generating the parameters of the actions (empty to use the agent model)",
+				Tags:         config.Tags{Section: "ModelSettings"},
+			},
+			{
+				Name:         "evaluate_model",
+				Label:        "Evaluation Model",
+				Type:         "text",
+				DefaultValue: "",
+				HelpText:     "Model evaluating whether the goal was reached (empty to use the agent model)",
+				Tags:         config.Tags{Section: "ModelSettings"},
+			},
+			{
+				Name:         "reply_model",
+				Label:        "Reply Model",
+				Type:         "text",
+				DefaultValue: "",
+				HelpText:     "Model writing the replies to the user (empty to use the agent model)",
+				Tags:         config.Tags{Section: "ModelSettings"},
+			},
+			{
+				Name:         "summarize_model",
+				Label:        "Summarization Model",
+				Type:         "text",
+				DefaultValue: "",
+				HelpText:     "Model summarizing older turns when the context window is exceeded (empty to use the agent model)",
+				Tags:         config.Tags{Section: "ModelSettings"},
+			},
+			{
+				Name:         "embedding_model",
+				Label:        "Embedding Model",
+				Type:         "text",
+				DefaultValue: "",
+				Placeholder:  "text-embedding-ada-002",
+				HelpText:     "Model computing the embeddings, e.g. to retrieve the relevant tools (empty for text-embedding-ada-002)",
+				Tags:         config.Tags{Section: "ModelSettings"},
+			},
+			{
+				Name:         "llm_api_url",
+				Label:        "LLM API URL",
+				Type:         "text",
+				DefaultValue: "",
+				HelpText:     "OpenAI compatible endpoint of the agent (empty to use the instance endpoint)",
+				Tags:         config.Tags{Section: "ModelSettings"},
+			},
+			{
+				Name:         "llm_api_key",
+				Label:        "LLM API Key",
+				Type:         "password",
+				DefaultValue: "",
+				HelpText:     "API key of the endpoint. With the instance endpoint, it can reference an environment variable prefixed with LOCALAGI_TENANT_, such as $LOCALAGI_TENANT_TEAM_KEY",
+				Tags:         config.Tags{Section: "ModelSettings"},
+			},
+			{
+				Name:     "temperature",
+				Label:    "Temperature",
+				Type:     "number",
+				Min:      0,
+				Max:      2,
+				Step:     0.1,
+				HelpText: "Sampling temperature (empty for the model default)",
+				Tags:     config.Tags{Section: "ModelSettings"},
+			},
+			{
+				Name:     "top_p",
+				Label:    "Top P",
+				Type:     "number",
+				Min:      0,
+				Max:      1,
+				Step:     0.05,
+				HelpText: "Nucleus sampling probability mass (empty for the model default)",
+				Tags:     config.Tags{Section: "ModelSettings"},
+			},
+			{
+				Name:     "max_tokens",
+				Label:    "Max Tokens",
+				Type:     "number",
+				Min:      1,
+				Step:     1,
+				HelpText: "Maximum number of tokens of each completion (empty for the model default)",
+				Tags:     config.Tags{Section: "ModelSettings"},
+			},
+			{
+				Name:     "seed",
+				Label:    "Seed",
+				Type:     "number",
+				Step:     1,
+				HelpText: "Seed for reproducible sampling, when supported by the model",
+				Tags:     config.Tags{Section: "ModelSettings"},
+			},
 			{
 				Name:         "local_rag_url",
 				Label:        "Local RAG URL",
@@ -183,6 +343,37 @@ func NewAgentConfigMeta(
 				Label:        "Summary Long Term Memory",
 				Type:         "checkbox",
 				DefaultValue: false,
+				HelpText:     "Summarize the completed conversations into highlights, facts, entities and decisions, recalled along with the past messages",
+				Tags:         config.Tags{Section: "MemorySettings"},
+			},
+			{
+				Name:         "memory_vector_store",
+				Label:        "Memory Vector Store",
+				Type:         "select",
+				DefaultValue: MemoryVectorStoreChromem,
+				Options: []config.FieldOption{
+					{Value: MemoryVectorStoreChromem, Label: "Embedded (chromem)"},
+					{Value: MemoryVectorStoreLocalAI, Label: "LocalAI stores"},
+				},
+				HelpText: "Where the embeddings of the long-term memory are kept. Memories are searched by meaning only when an embedding model is set, by keywords otherwise",
+				Tags:     config.Tags{Section: "MemorySettings"},
+			},
+			{
+				Name:         "memory_actions",
+				Label:        "Memory Actions",
+				Type:         "checkbox",
+				DefaultValue: false,
+				HelpText:     "Let the agent remember and forget things and update its goal, in conversations and periodic runs",
+				Tags:         config.Tags{Section: "MemorySettings"},
+			},
+			{
+				Name:         "max_memories",
+				Label:        "Max Memories",
+				Type:         "number",
+				DefaultValue: agent.DefaultMaxMemories,
+				Min:          1,
+				Step:         1,
+				HelpText:     "How many short-term memories the agent keeps",
 				Tags:         config.Tags{Section: "MemorySettings"},
 			},
 			{
@@ -250,6 +441,32 @@ func NewAgentConfigMeta(
 				HelpText:     "Enable agent to explain its reasoning process",
 				Tags:         config.Tags{Section: "AdvancedSettings"},
 			},
+			{
+				Name:         "native_tool_calls",
+				Label:        "Native Tool Calls",
+				Type:         "checkbox",
+				DefaultValue: false,
+				HelpText:     "Pick actions and their parameters in a single request using the model's native tool calling (falls back to prompt-based selection if the model does not support tools)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
+			{
+				Name:         "tool_retrieval_top_k",
+				Label:        "Tool Retrieval",
+				Type:         "number",
+				DefaultValue: 0,
+				Min:          0,
+				Step:         1,
+				HelpText:     "Only offer the N actions most relevant to the conversation when picking an action, plus the core ones like reply and stop (0 to offer every action)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
+			{
+				Name:         "action_reliability_hints",
+				Label:        "Warn About Failing Tools",
+				Type:         "checkbox",
+				DefaultValue: false,
+				HelpText:     "Tell the agent in its HUD which tools failed repeatedly in the last hour, so it stops calling a broken integration (requires the HUD)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
 			{
 				Name:         "loop_detection_steps",
 				Label:        "Max Loop Detection Steps",
@@ -269,6 +486,75 @@ func NewAgentConfigMeta(
 				HelpText:     "Number of concurrent tasks that can run in parallel",
 				Tags:         config.Tags{Section: "AdvancedSettings"},
 			},
+			{
+				Name:         "job_queue_size",
+				Label:        "Job Queue Size",
+				Type:         "number",
+				DefaultValue: 100,
+				Min:          0,
+				Step:         1,
+				HelpText:     "Maximum number of jobs waiting to run, new jobs are rejected beyond it (0 for the default of 100)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
+			{
+				Name:         "pause_mode",
+				Label:        "Pause Mode",
+				Type:         "select",
+				DefaultValue: agent.PauseModeReject,
+				Options: []config.FieldOption{
+					{Value: agent.PauseModeReject, Label: "Reject messages while paused"},
+					{Value: agent.PauseModePark, Label: "Queue messages until resumed"},
+				},
+				HelpText: "What happens to the messages received while the agent is paused",
+				Tags:     config.Tags{Section: "AdvancedSettings"},
+			},
+			{
+				Name:         "parked_job_ttl",
+				Label:        "Queued Message Expiry",
+				Type:         "text",
+				DefaultValue: "",
+				Placeholder:  "24h",
+				HelpText:     "How long messages queued while paused are kept before expiring (empty for the default of 24h, 0 to never expire)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
+			{
+				Name:         "max_job_attempts",
+				Label:        "Max Job Attempts",
+				Type:         "number",
+				DefaultValue: 0,
+				Min:          0,
+				Step:         1,
+				HelpText:     "How many times a job failing with a transient error (LLM unavailable, timeout, ...) runs before being moved to the dead-letter list, jobs which already ran an action with side effects aren't retried (0 to disable the retries and the dead-letter list, 1 to never retry)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
+			{
+				Name:         "retry_backoff",
+				Label:        "Retry Backoff",
+				Type:         "text",
+				DefaultValue: "",
+				Placeholder:  "30s",
+				HelpText:     "Wait before retrying a failed job, doubled at every attempt (empty for the default of 30s)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
+			{
+				Name:         "max_retry_backoff",
+				Label:        "Max Retry Backoff",
+				Type:         "text",
+				DefaultValue: "",
+				Placeholder:  "10m",
+				HelpText:     "Longest wait between two attempts of a failed job (empty for the default of 10m)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
+			{
+				Name:         "parallel_tool_calls",
+				Label:        "Parallel Tool Calls",
+				Type:         "number",
+				DefaultValue: 1,
+				Min:          1,
+				Step:         1,
+				HelpText:     "Number of tool calls returned in a single turn that can run concurrently",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
 			{
 				Name:         "strip_thinking_tags",
 				Label:        "Strip Thinking Tags",
@@ -295,6 +581,16 @@ func NewAgentConfigMeta(
 				HelpText:     "Maximum number of evaluation loops to perform when addressing gaps in responses",
 				Tags:         config.Tags{Section: "AdvancedSettings"},
 			},
+			{
+				Name:         "max_replans",
+				Label:        "Max Replans",
+				Type:         "number",
+				DefaultValue: 0,
+				Min:          0,
+				Step:         1,
+				HelpText:     "Maximum number of times a plan is revised when its subtasks fail (0 to disable replanning)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
 			{
 				Name:         "last_message_duration",
 				Label:        "Last Message Duration",
@@ -303,6 +599,55 @@ func NewAgentConfigMeta(
 				HelpText:     "Duration for the last message to be considered in the conversation",
 				Tags:         config.Tags{Section: "AdvancedSettings"},
 			},
+			{
+				Name:         "context_window",
+				Label:        "Context Window",
+				Type:         "number",
+				DefaultValue: 0,
+				Min:          0,
+				Step:         1024,
+				HelpText:     "Context length of the model in tokens, older messages are summarized when it is exceeded (0 to detect it from the model name)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
+			{
+				Name:         "max_job_steps",
+				Label:        "Max Job Steps",
+				Type:         "number",
+				DefaultValue: 0,
+				Min:          0,
+				Step:         1,
+				HelpText:     "Maximum number of actions a single job can run (0 for no limit)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
+			{
+				Name:         "max_job_llm_calls",
+				Label:        "Max Job LLM Calls",
+				Type:         "number",
+				DefaultValue: 0,
+				Min:          0,
+				Step:         1,
+				HelpText:     "Maximum number of LLM calls a single job can make (0 for no limit)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
+			{
+				Name:         "max_job_duration",
+				Label:        "Max Job Duration",
+				Type:         "text",
+				DefaultValue: "",
+				Placeholder:  "10m",
+				HelpText:     "Maximum wall-clock time a single job can run (empty for no limit)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
+			{
+				Name:         "max_job_cost",
+				Label:        "Max Job Cost",
+				Type:         "number",
+				DefaultValue: 0,
+				Min:          0,
+				Step:         0.01,
+				HelpText:     "Maximum LLM cost a single job can spend (0 for no limit)",
+				Tags:         config.Tags{Section: "AdvancedSettings"},
+			},
 		},
 		MCPServers: []config.Field{
 			{
@@ -312,10 +657,31 @@ func NewAgentConfigMeta(
 				Required: true,
 			},
 		},
+		LLMFallbacks: []config.Field{
+			{
+				Name:     "model",
+				Label:    "Model",
+				Type:     config.FieldTypeText,
+				HelpText: "Model to fail over to (empty to keep the agent model)",
+			},
+			{
+				Name:     "api_url",
+				Label:    "API URL",
+				Type:     config.FieldTypeText,
+				HelpText: "OpenAI compatible API of the model (empty to keep the agent API)",
+			},
+			{
+				Name:     "api_key",
+				Label:    "API Key",
+				Type:     config.FieldTypeText,
+				HelpText: "API key of the API (empty to keep the agent API key)",
+			},
+		},
 		DynamicPrompts: dynamicPromptsConfig,
 		Connectors:     connectorsConfig,
 		Actions:        actionsConfig,
 		Filters:        filtersConfig,
+		Evaluators:     evaluators.ConfigMeta(),
 	}
 }
 
@@ -324,3 +690,9 @@ type Connector interface {
 	AgentReasoningCallback() func(state types.ActionCurrentState) bool
 	Start(a *agent.Agent)
 }
+
+// ApprovalConnector is implemented by the connectors that can notify
+// their users of the actions waiting for approval
+type ApprovalConnector interface {
+	AgentApprovalCallback() func(types.ApprovalRequest)
+}
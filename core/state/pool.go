@@ -5,17 +5,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	. "github.com/mudler/LocalAGI/core/agent"
+	"github.com/mudler/LocalAGI/core/evaluators"
 	"github.com/mudler/LocalAGI/core/sse"
 	"github.com/mudler/LocalAGI/core/types"
 	"github.com/mudler/LocalAGI/db"
+	"github.com/mudler/LocalAGI/pkg/llm"
 	"github.com/mudler/LocalAGI/pkg/localrag"
 	"github.com/mudler/LocalAGI/pkg/utils"
+	"github.com/mudler/LocalAGI/pkg/vectorstore"
 	"github.com/sashabaranov/go-openai"
 
 	models "github.com/mudler/LocalAGI/dbmodels"
@@ -243,6 +248,21 @@ func (a *AgentPool) startAgentWithConfig(id string, name string, config *AgentCo
 		a.localRAGKey = config.LocalRAGAPIKey
 	}
 
+	llmAPIURL := os.Getenv("LOCALAGI_LLM_API_URL")
+	llmAPIKey := os.Getenv("LOCALAGI_LLM_API_KEY")
+	if config.LLMAPIURL != "" {
+		llmAPIURL = config.LLMAPIURL
+		// don't send the key of the instance endpoint to another one
+		llmAPIKey = ""
+	}
+	if config.LLMAPIKey != "" {
+		key, err := envReference(config.LLMAPIKey, config.LLMAPIURL != "")
+		if err != nil {
+			return fmt.Errorf("invalid LLM API key: %w", err)
+		}
+		llmAPIKey = key
+	}
+
 	connectors := a.connectors(config)
 	promptBlocks := a.dynamicPrompt(config)
 	actions := a.availableActions(config)(ctx, a)
@@ -377,8 +397,14 @@ func (a *AgentPool) startAgentWithConfig(id string, name string, config *AgentCo
 				c.AgentResultCallback()(state)
 			}
 		}),
-		WithLLMAPIURL(os.Getenv("LOCALAGI_LLM_API_URL")),
-		WithLLMAPIKey(os.Getenv("LOCALAGI_LLM_API_KEY")),
+		WithLLMAPIURL(llmAPIURL),
+		WithLLMAPIKey(llmAPIKey),
+		WithSamplingParams(llm.SamplingParams{
+			Temperature: config.Temperature,
+			TopP:        config.TopP,
+			MaxTokens:   config.MaxTokens,
+			Seed:        config.Seed,
+		}),
 		WithObserver(obs),
 	}
 
@@ -439,6 +465,40 @@ func (a *AgentPool) startAgentWithConfig(id string, name string, config *AgentCo
 		opts = append(opts, EnableForceReasoning)
 	}
 
+	if config.NativeToolCalls {
+		opts = append(opts, EnableNativeToolCalls)
+	}
+
+	if config.ActionReliability {
+		opts = append(opts, EnableActionReliabilityHints)
+	}
+
+	if config.MemoryActions {
+		opts = append(opts, EnableMemoryActions)
+	}
+
+	if config.MaxMemories > 0 {
+		opts = append(opts, WithMaxMemories(config.MaxMemories))
+	}
+
+	if config.ToolRetrievalTopK > 0 {
+		opts = append(opts, WithToolRetrieval(config.ToolRetrievalTopK))
+	}
+
+	if config.EmbeddingModel != "" {
+		opts = append(opts, WithEmbeddingModel(config.EmbeddingModel))
+
+		// without an embedding model the memories are searched by keywords
+		if config.LongTermMemory || config.SummaryLongTermMemory {
+			memory, key, err := a.semanticMemory(id, config, llmAPIURL, llmAPIKey)
+			if err != nil {
+				xlog.Error("Failed to open the semantic memory, falling back to keyword search", "agent", name, "error", err)
+			} else {
+				opts = append(opts, WithSemanticMemory(memory, key))
+			}
+		}
+	}
+
 	if config.StripThinkingTags {
 		opts = append(opts, EnableStripThinkingTags)
 	}
@@ -453,11 +513,134 @@ func (a *AgentPool) startAgentWithConfig(id string, name string, config *AgentCo
 		opts = append(opts, WithParallelJobs(config.ParallelJobs))
 	}
 
+	if config.JobQueueSize > 0 {
+		opts = append(opts, WithJobQueueSize(config.JobQueueSize))
+	}
+
+	if config.PauseMode != "" {
+		opts = append(opts, WithPauseMode(config.PauseMode))
+	}
+
+	if config.ParkedJobTTL != "" {
+		if d, err := time.ParseDuration(config.ParkedJobTTL); err == nil {
+			opts = append(opts, WithParkedJobTTL(d))
+		} else {
+			xlog.Warn("Invalid parked job TTL, ignoring", "id", id, "ttl", config.ParkedJobTTL, "error", err)
+		}
+	}
+
+	retryPolicy := DefaultRetryPolicy()
+	if config.MaxJobAttempts > 0 {
+		retryPolicy.MaxAttempts = config.MaxJobAttempts
+	}
+	if config.RetryBackoff != "" {
+		if d, err := time.ParseDuration(config.RetryBackoff); err == nil {
+			retryPolicy.InitialBackoff = d
+		} else {
+			xlog.Warn("Invalid retry backoff, ignoring", "id", id, "backoff", config.RetryBackoff, "error", err)
+		}
+	}
+	if config.MaxRetryBackoff != "" {
+		if d, err := time.ParseDuration(config.MaxRetryBackoff); err == nil {
+			retryPolicy.MaxBackoff = d
+		} else {
+			xlog.Warn("Invalid max retry backoff, ignoring", "id", id, "backoff", config.MaxRetryBackoff, "error", err)
+		}
+	}
+	opts = append(opts, WithRetryPolicy(retryPolicy))
+
+	if len(config.LLMFallbacks) > 0 {
+		fallbacks := make([]LLMFallback, len(config.LLMFallbacks))
+		for i, fallback := range config.LLMFallbacks {
+			// the fallbacks without an URL are sent to the endpoint of the agent
+			key, err := envReference(fallback.APIKey, fallback.APIURL != "" || config.LLMAPIURL != "")
+			if err != nil {
+				return fmt.Errorf("invalid API key of LLM fallback %d: %w", i+1, err)
+			}
+			fallback.APIKey = key
+			fallbacks[i] = fallback
+		}
+		opts = append(opts, WithLLMFallbacks(fallbacks...))
+	}
+
+	for phase, model := range map[string]string{
+		PhasePick:      config.PickModel,
+		PhaseParams:    config.ParamsModel,
+		PhaseEvaluate:  config.EvaluateModel,
+		PhaseReply:     config.ReplyModel,
+		PhaseSummarize: config.SummarizeModel,
+	} {
+		if model != "" {
+			opts = append(opts, WithPhaseModel(phase, model))
+		}
+	}
+
+	if config.ContextWindow > 0 {
+		opts = append(opts, WithContextWindow(config.ContextWindow))
+	}
+
+	if config.ParallelToolCalls > 0 {
+		opts = append(opts, WithParallelToolCalls(config.ParallelToolCalls))
+	}
+
+	jobBudget := types.JobBudget{
+		MaxSteps:    config.MaxJobSteps,
+		MaxLLMCalls: config.MaxJobLLMCalls,
+		MaxCost:     config.MaxJobCost,
+	}
+	if config.MaxJobDuration != "" {
+		if d, err := time.ParseDuration(config.MaxJobDuration); err == nil {
+			jobBudget.MaxDuration = d
+		} else {
+			xlog.Warn("Invalid max job duration, ignoring", "id", id, "duration", config.MaxJobDuration, "error", err)
+		}
+	}
+	opts = append(opts, WithJobBudget(jobBudget))
+
+	approvalRequired := []string{}
+	for _, action := range config.Actions {
+		if action.RequiresApproval {
+			approvalRequired = append(approvalRequired, action.Name)
+		}
+	}
+	if len(approvalRequired) > 0 {
+		opts = append(opts,
+			WithActionsRequiringApproval(approvalRequired...),
+			WithApprovalCallback(func(request types.ApprovalRequest) {
+				data, err := json.Marshal(request)
+				if err != nil {
+					xlog.Error("Failed to marshal approval request", "error", err)
+					return
+				}
+				manager.Send(sse.NewMessage(string(data)).WithEvent("request_action_approval"))
+			}),
+		)
+		for _, c := range connectors {
+			if ac, ok := c.(ApprovalConnector); ok {
+				opts = append(opts, WithApprovalCallback(ac.AgentApprovalCallback()))
+			}
+		}
+	}
+
+	if config.MaxReplans > 0 {
+		opts = append(opts, WithMaxReplans(config.MaxReplans))
+	}
+
+	for _, e := range config.Evaluators {
+		evaluator, err := evaluators.New(e.Type, e.Config)
+		if err != nil {
+			xlog.Error("Failed to configure evaluator", "type", e.Type, "error", err)
+			continue
+		}
+		opts = append(opts, WithEvaluators(evaluator))
+	}
+
 	if config.EnableEvaluation {
 		opts = append(opts, EnableEvaluation())
-		if config.MaxEvaluationLoops > 0 {
-			opts = append(opts, WithMaxEvaluationLoops(config.MaxEvaluationLoops))
-		}
+	}
+
+	if (config.EnableEvaluation || len(config.Evaluators) > 0) && config.MaxEvaluationLoops > 0 {
+		opts = append(opts, WithMaxEvaluationLoops(config.MaxEvaluationLoops))
 	}
 
 	xlog.Info("Starting agent", "id", id, "config", config)
@@ -511,6 +694,79 @@ func (a *AgentPool) StartAll() error {
 	return nil
 }
 
+// ResumeInterruptedJobs starts the agents of the pool that still have
+// running job checkpoints or parked jobs (e.g. after a restart) and resumes
+// those jobs, the running ones from their last completed step.
+func (a *AgentPool) ResumeInterruptedJobs() error {
+	var agentIDs []uuid.UUID
+	if err := db.DB.Model(&models.JobCheckpoint{}).
+		Where("UserID = ? AND Status = ?", a.userId, JobStatusRunning).
+		Where("AgentID IN (?)", db.DB.Model(&models.Agent{}).Select("ID").Where("Archive = ?", false)).
+		Distinct().
+		Pluck("AgentID", &agentIDs).Error; err != nil {
+		return fmt.Errorf("failed to load job checkpoints: %w", err)
+	}
+
+	var parkedAgentIDs []uuid.UUID
+	if err := db.DB.Model(&models.ParkedJob{}).
+		Where("UserID = ?", a.userId).
+		Where("AgentID IN (?)", db.DB.Model(&models.Agent{}).Select("ID").Where("Archive = ?", false)).
+		Distinct().
+		Pluck("AgentID", &parkedAgentIDs).Error; err != nil {
+		return fmt.Errorf("failed to load parked jobs: %w", err)
+	}
+	for _, id := range parkedAgentIDs {
+		if !slices.Contains(agentIDs, id) {
+			agentIDs = append(agentIDs, id)
+		}
+	}
+
+	var retryingAgentIDs []uuid.UUID
+	if err := db.DB.Model(&models.FailedJob{}).
+		Where("UserID = ? AND Status = ?", a.userId, FailedJobRetrying).
+		Where("AgentID IN (?)", db.DB.Model(&models.Agent{}).Select("ID").Where("Archive = ?", false)).
+		Distinct().
+		Pluck("AgentID", &retryingAgentIDs).Error; err != nil {
+		return fmt.Errorf("failed to load failed jobs: %w", err)
+	}
+	for _, id := range retryingAgentIDs {
+		if !slices.Contains(agentIDs, id) {
+			agentIDs = append(agentIDs, id)
+		}
+	}
+
+	for _, agentID := range agentIDs {
+		id := agentID.String()
+
+		a.Lock()
+		agent := a.agents[id]
+		if agent == nil {
+			config, ok := a.pool[id]
+			if !ok {
+				a.Unlock()
+				xlog.Warn("Agent with interrupted jobs not found in pool", "id", id)
+				continue
+			}
+			if err := a.startAgentWithConfig(id, config.Name, &config, nil); err != nil {
+				a.Unlock()
+				xlog.Error("Failed to start agent with interrupted jobs", "id", id, "error", err)
+				continue
+			}
+			agent = a.agents[id]
+		}
+		a.Unlock()
+
+		resumed, err := agent.ResumeJobs()
+		if err != nil {
+			xlog.Error("Failed to resume interrupted jobs", "id", id, "error", err)
+			continue
+		}
+		xlog.Info("Resumed interrupted jobs", "id", id, "jobs", resumed)
+	}
+
+	return nil
+}
+
 func (a *AgentPool) StopAll() {
 	a.Lock()
 	defer a.Unlock()
@@ -709,3 +965,54 @@ func (a *AgentPool) CreateAgentWithExistingManager(id string, agentConfig *Agent
 
 	return nil
 }
+
+// tenantEnvPrefix is the prefix of the environment variables the API keys
+// of the agent configurations can reference
+const tenantEnvPrefix = "LOCALAGI_TENANT_"
+
+// envReference resolves an API key referencing an environment variable,
+// written as $NAME or ${NAME}. Other values are returned as they are.
+// Agent configurations are edited by the users, so only the variables the
+// operator prefixed with LOCALAGI_TENANT_ can be referenced, and only by
+// keys sent to the endpoint of the instance: a custom endpoint would receive
+// the secret.
+func envReference(value string, customEndpoint bool) (string, error) {
+	name, ok := strings.CutPrefix(value, "$")
+	if !ok {
+		return value, nil
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "{"), "}")
+
+	if !strings.HasPrefix(name, tenantEnvPrefix) {
+		return "", fmt.Errorf("only the environment variables prefixed with %s can be referenced, not %s", tenantEnvPrefix, name)
+	}
+	if customEndpoint {
+		return "", fmt.Errorf("the key of a custom endpoint can't reference the environment variable %s", name)
+	}
+	return os.Getenv(name), nil
+}
+
+// semanticMemory opens the vector store of the long-term memory of an agent
+// and returns the key identifying it. The embedded store is persisted under
+// LOCALAGI_MEMORY_DIR, in memory when unset: its key is then new at every
+// start so that it is reindexed from the messages of the agent.
+func (a *AgentPool) semanticMemory(id string, config *AgentConfig, llmAPIURL, llmAPIKey string) (SemanticMemory, string, error) {
+	client := llm.NewClient(llmAPIKey, llmAPIURL, a.timeout)
+
+	switch config.MemoryVectorStore {
+	case MemoryVectorStoreLocalAI:
+		key := fmt.Sprintf("localai:%s:%s", llmAPIURL, config.EmbeddingModel)
+		return vectorstore.NewLocalAIRAGDB(vectorstore.NewStoreClient(llmAPIURL, llmAPIKey), client, config.EmbeddingModel), key, nil
+	case "", MemoryVectorStoreChromem:
+		path := ""
+		key := "memory:" + uuid.New().String()
+		if dir := os.Getenv("LOCALAGI_MEMORY_DIR"); dir != "" {
+			path = filepath.Join(dir, id)
+			key = "chromem:" + path
+		}
+		memory, err := vectorstore.NewChromemDB("memory", path, client, config.EmbeddingModel)
+		return memory, key, err
+	default:
+		return nil, "", fmt.Errorf("unknown memory vector store: %s", config.MemoryVectorStore)
+	}
+}
@@ -134,6 +134,24 @@ func IsActionUserDefined(action Action) bool {
 	return false // Actions without UserDefinedChecker are not user-defined
 }
 
+// IdempotentChecker identifies the actions that can safely run again with
+// the same parameters, e.g. because they only read data
+type IdempotentChecker interface {
+	IsIdempotent() bool
+}
+
+// IsActionIdempotent checks if an action can run again without side effects.
+// User-defined actions are run by the caller, not by the agent.
+func IsActionIdempotent(action Action) bool {
+	if IsActionUserDefined(action) {
+		return true
+	}
+	if checker, ok := action.(IdempotentChecker); ok {
+		return checker.IsIdempotent()
+	}
+	return false // Actions are assumed to have side effects
+}
+
 // UserDefinedAction represents a user-defined function tool
 type UserDefinedAction struct {
 	ActionDef *ActionDefinition
@@ -0,0 +1,23 @@
+package types
+
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalModified = "modified"
+	ApprovalDenied   = "denied"
+	ApprovalExpired  = "expired"
+)
+
+// ApprovalRequest is sent to the approval callbacks of an agent when an
+// action that requires approval is about to run.
+type ApprovalRequest struct {
+	ID        string       `json:"id"`
+	JobID     string       `json:"jobId"`
+	Action    string       `json:"action"`
+	Params    ActionParams `json:"params"`
+	Reasoning string       `json:"reasoning,omitempty"`
+
+	// Job is the job waiting for the approval, connectors use its
+	// metadata to reach the user that originated it
+	Job *Job `json:"-"`
+}
@@ -0,0 +1,72 @@
+package types
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	BudgetSteps    = "steps"
+	BudgetLLMCalls = "llm_calls"
+	BudgetDuration = "duration"
+	BudgetCost     = "cost"
+)
+
+// JobBudget bounds the resources a single job can consume.
+// A zero value means no limit.
+type JobBudget struct {
+	MaxSteps    int           `json:"max_steps,omitempty"`
+	MaxLLMCalls int           `json:"max_llm_calls,omitempty"`
+	MaxDuration time.Duration `json:"max_duration,omitempty"`
+	MaxCost     float64       `json:"max_cost,omitempty"`
+}
+
+// Merge returns the budget where the unset limits are taken from defaults
+func (b JobBudget) Merge(defaults JobBudget) JobBudget {
+	if b.MaxSteps == 0 {
+		b.MaxSteps = defaults.MaxSteps
+	}
+	if b.MaxLLMCalls == 0 {
+		b.MaxLLMCalls = defaults.MaxLLMCalls
+	}
+	if b.MaxDuration == 0 {
+		b.MaxDuration = defaults.MaxDuration
+	}
+	if b.MaxCost == 0 {
+		b.MaxCost = defaults.MaxCost
+	}
+	return b
+}
+
+// Check returns the first limit of the budget exceeded by the given usage, or nil
+func (b JobBudget) Check(steps, llmCalls int, elapsed time.Duration, cost float64) *BudgetExceeded {
+	switch {
+	case b.MaxSteps > 0 && steps >= b.MaxSteps:
+		return &BudgetExceeded{Budget: BudgetSteps, Limit: float64(b.MaxSteps), Used: float64(steps)}
+	case b.MaxLLMCalls > 0 && llmCalls >= b.MaxLLMCalls:
+		return &BudgetExceeded{Budget: BudgetLLMCalls, Limit: float64(b.MaxLLMCalls), Used: float64(llmCalls)}
+	case b.MaxDuration > 0 && elapsed >= b.MaxDuration:
+		return &BudgetExceeded{Budget: BudgetDuration, Limit: b.MaxDuration.Seconds(), Used: elapsed.Seconds()}
+	case b.MaxCost > 0 && cost >= b.MaxCost:
+		return &BudgetExceeded{Budget: BudgetCost, Limit: b.MaxCost, Used: cost}
+	}
+	return nil
+}
+
+// BudgetExceeded reports which limit of a JobBudget stopped a job.
+// Durations are expressed in seconds.
+type BudgetExceeded struct {
+	Budget string  `json:"budget"`
+	Limit  float64 `json:"limit"`
+	Used   float64 `json:"used"`
+}
+
+func (b *BudgetExceeded) Error() string {
+	return fmt.Sprintf("job budget exceeded: %s (limit %g, used %g)", b.Budget, b.Limit, b.Used)
+}
+
+func WithBudget(budget JobBudget) JobOption {
+	return func(j *Job) {
+		j.Budget = budget
+	}
+}
@@ -0,0 +1,41 @@
+package types
+
+import (
+	"context"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// Evaluator checks the outcome of a job, e.g. that the response follows an
+// output contract. The job is retried with the reasons of the failing
+// verdicts until every evaluator passes or the evaluation loops run out.
+type Evaluator interface {
+	Name() string
+	Evaluate(ctx context.Context, input EvaluationInput) (EvaluationVerdict, error)
+}
+
+// JudgeFunc asks the LLM of the agent to answer the prompt as JSON
+// following the schema, decoded into dst
+type JudgeFunc func(ctx context.Context, prompt string, schema jsonschema.Definition, dst any) error
+
+// EvaluationInput is what the evaluators get to judge a job
+type EvaluationInput struct {
+	Job          *Job
+	Conversation []openai.ChatCompletionMessage
+	// Response is the response of the agent to the job
+	Response string
+	// Actions are the names of the actions run by the job, in order
+	Actions []string
+	// Judge is the LLM of the agent, for evaluators that need one
+	Judge JudgeFunc
+}
+
+// EvaluationVerdict is the outcome of an evaluator
+type EvaluationVerdict struct {
+	Evaluator string `json:"evaluator"`
+	Passed    bool   `json:"passed"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+type Evaluators []Evaluator
@@ -2,10 +2,15 @@ package types
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/pkg/llm"
 	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
 )
 
 // Job is a request to the agent to do something
@@ -21,19 +26,41 @@ type Job struct {
 	UUID                string
 	Metadata            map[string]interface{}
 	DoneFilter          bool
+	// Restored jobs resume from a checkpoint, their conversation already
+	// went through the prompts, the knowledge base and the pinned memories
+	Restored bool
 
 	// Tools available for this job
 	BuiltinTools []ActionDefinition // Built-in tools like web search
 	UserTools    []ActionDefinition // User-defined function tools
 	ToolChoice   string
 
+	// Budget bounds the resources the job can consume,
+	// unset limits fall back to the agent defaults
+	Budget JobBudget
+
+	// ResponseSchema makes the final reply a JSON document following the
+	// schema, see WithResponseSchema
+	ResponseSchema *jsonschema.Definition
+
+	// Priority orders the job in the queue of the agent
+	Priority JobPriority
+	// ConversationKey identifies the thread or chat of the job, jobs with
+	// the same key run one at a time in the order they were queued
+	ConversationKey string
+
 	pastActions         []*ActionRequest
+	steps               []JobStep
 	nextAction          *Action
 	nextActionParams    *ActionParams
 	nextActionReasoning string
+	queuedActions       []*ActionRequest
+
+	usage *llm.UsageTracker
 
 	context context.Context
 	cancel  context.CancelFunc
+	stopped atomic.Bool
 
 	Obs *Observable
 }
@@ -43,6 +70,40 @@ type ActionRequest struct {
 	Params *ActionParams
 }
 
+// JobStep is a completed step of a job: the action that was run,
+// its parameters and its outcome. Steps are persisted in the job
+// checkpoint so that an interrupted job can be resumed.
+type JobStep struct {
+	Action         string       `json:"action"`
+	Params         ActionParams `json:"params,omitempty"`
+	Reasoning      string       `json:"reasoning,omitempty"`
+	Result         string       `json:"result,omitempty"`
+	Error          string       `json:"error,omitempty"`
+	EvaluationLoop int          `json:"evaluation_loop"`
+	CompletedAt    time.Time    `json:"completed_at"`
+}
+
+// JobPriority orders the jobs queued to an agent, higher priorities run first
+type JobPriority int
+
+const (
+	JobPriorityPeriodic JobPriority = iota
+	JobPriorityReminder
+	JobPriorityUser
+)
+
+func (p JobPriority) String() string {
+	switch p {
+	case JobPriorityPeriodic:
+		return "periodic"
+	case JobPriorityReminder:
+		return "reminder"
+	case JobPriorityUser:
+		return "user"
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
 type JobOption func(*Job)
 
 func WithConversationHistory(history []openai.ChatCompletionMessage) JobOption {
@@ -69,6 +130,31 @@ func WithToolChoice(choice string) JobOption {
 	}
 }
 
+// WithResponseSchema makes the final reply of the job a JSON document
+// following the schema. The parsed document is available in
+// JobResult.ResponseObject.
+func WithResponseSchema(schema jsonschema.Definition) JobOption {
+	return func(j *Job) {
+		j.ResponseSchema = &schema
+	}
+}
+
+// WithPriority sets the priority of the job in the queue of the agent,
+// jobs are user requests by default
+func WithPriority(priority JobPriority) JobOption {
+	return func(j *Job) {
+		j.Priority = priority
+	}
+}
+
+// WithConversationKey sets the thread or chat the job belongs to, so that
+// jobs of the same conversation never run concurrently
+func WithConversationKey(key string) JobOption {
+	return func(j *Job) {
+		j.ConversationKey = key
+	}
+}
+
 func WithReasoningCallback(f func(ActionCurrentState) bool) JobOption {
 	return func(r *Job) {
 		r.ReasoningCallback = f
@@ -132,6 +218,16 @@ func (j *Job) GetPastActions() []*ActionRequest {
 	return j.pastActions
 }
 
+// AddStep records a completed step of the job
+func (j *Job) AddStep(step JobStep) {
+	j.steps = append(j.steps, step)
+}
+
+// GetSteps returns the completed steps of the job
+func (j *Job) GetSteps() []JobStep {
+	return j.steps
+}
+
 func (j *Job) GetNextAction() (*Action, *ActionParams, string) {
 	return j.nextAction, j.nextActionParams, j.nextActionReasoning
 }
@@ -146,6 +242,34 @@ func (j *Job) ResetNextAction() {
 	j.nextActionReasoning = ""
 }
 
+// QueueActions appends actions to run after the current one, e.g. the
+// additional tool calls returned by the model in a single turn
+func (j *Job) QueueActions(actions ...*ActionRequest) {
+	j.queuedActions = append(j.queuedActions, actions...)
+}
+
+// PopQueuedAction removes and returns the first queued action, or nil
+func (j *Job) PopQueuedAction() *ActionRequest {
+	if len(j.queuedActions) == 0 {
+		return nil
+	}
+	next := j.queuedActions[0]
+	j.queuedActions = j.queuedActions[1:]
+	return next
+}
+
+// PeekQueuedAction returns the first queued action without removing it, or nil
+func (j *Job) PeekQueuedAction() *ActionRequest {
+	if len(j.queuedActions) == 0 {
+		return nil
+	}
+	return j.queuedActions[0]
+}
+
+func (j *Job) HasQueuedActions() bool {
+	return len(j.queuedActions) > 0
+}
+
 func WithTextImage(text, image string) JobOption {
 	return func(j *Job) {
 		j.ConversationHistory = append(j.ConversationHistory, openai.ChatCompletionMessage{
@@ -197,6 +321,7 @@ func NewJob(opts ...JobOption) *Job {
 		Metadata:            make(map[string]interface{}),
 		context:             context.Background(),
 		ConversationHistory: []openai.ChatCompletionMessage{},
+		Priority:            JobPriorityUser,
 	}
 
 	for _, opt := range opts {
@@ -205,13 +330,25 @@ func NewJob(opts ...JobOption) *Job {
 
 	// Store the original request if it exists in the conversation history
 
-	ctx, cancel := context.WithCancel(j.context)
+	j.usage = &llm.UsageTracker{}
+	ctx, cancel := context.WithCancel(context.WithValue(llm.WithUsageTracker(j.context, j.usage), jobContextKey{}, j))
 	j.context = ctx
 	j.cancel = cancel
 
 	return j
 }
 
+type jobContextKey struct{}
+
+// JobFromContext returns the job a context belongs to, or nil
+func JobFromContext(ctx context.Context) *Job {
+	if ctx == nil {
+		return nil
+	}
+	j, _ := ctx.Value(jobContextKey{}).(*Job)
+	return j
+}
+
 func WithUUID(uuid string) JobOption {
 	return func(j *Job) {
 		j.UUID = uuid
@@ -228,16 +365,67 @@ func (j *Job) Cancel() {
 	j.cancel()
 }
 
+// Stop cancels the job on behalf of the user, as opposed to Cancel which is
+// also used to release the job once it's done
+func (j *Job) Stop() {
+	j.stopped.Store(true)
+	j.cancel()
+}
+
+// Stopped reports whether the job was stopped by the user
+func (j *Job) Stopped() bool {
+	return j.stopped.Load()
+}
+
 func (j *Job) GetContext() context.Context {
 	return j.context
 }
 
+// Usage returns the tracker of the LLM calls made for the job
+func (j *Job) Usage() *llm.UsageTracker {
+	return j.usage
+}
+
 func WithObservable(obs *Observable) JobOption {
 	return func(j *Job) {
 		j.Obs = obs
 	}
 }
 
+// Retry returns a new attempt of the job starting from the given
+// conversation. The attempt shares the result of the job, so whoever waits
+// for the job gets the outcome of the last attempt. The finalizers of the
+// failed attempt are dropped, the new one registers its own.
+func (j *Job) Retry(conversation []openai.ChatCompletionMessage) *Job {
+	metadata := make(map[string]interface{}, len(j.Metadata))
+	for k, v := range j.Metadata {
+		if k != "evaluation_loop" {
+			metadata[k] = v
+		}
+	}
+
+	attempt := NewJob(
+		WithUUID(j.UUID),
+		WithConversationHistory(conversation),
+		WithMetadata(metadata),
+		WithBuiltinTools(j.BuiltinTools),
+		WithUserTools(j.UserTools),
+		WithToolChoice(j.ToolChoice),
+		WithReasoningCallback(j.ReasoningCallback),
+		WithResultCallback(j.ResultCallback),
+		WithStreamCallback(j.StreamCallback),
+		WithPriority(j.Priority),
+		WithConversationKey(j.ConversationKey),
+		WithObservable(j.Obs),
+	)
+	attempt.Budget = j.Budget
+	attempt.ResponseSchema = j.ResponseSchema
+	attempt.Restored = j.Restored
+	attempt.Result = j.Result
+	attempt.Result.reset()
+	return attempt
+}
+
 // GetEvaluationLoop returns the current evaluation loop count
 func (j *Job) GetEvaluationLoop() int {
 	if j.Metadata == nil {
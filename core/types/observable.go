@@ -17,6 +17,7 @@ type Progress struct {
 	ChatCompletionResponse *openai.ChatCompletionResponse `json:"chat_completion_response,omitempty"`
 	ActionResult           string                         `json:"action_result,omitempty"`
 	AgentState             *AgentInternalState            `json:"agent_state"`
+	Evaluations            []EvaluationVerdict            `json:"evaluations,omitempty"`
 }
 
 type Completion struct {
@@ -26,6 +27,19 @@ type Completion struct {
 	ActionResult           string                         `json:"action_result,omitempty"`
 	AgentState             *AgentInternalState            `json:"agent_state,omitempty"`
 	FilterResult           *FilterResult                  `json:"filter_result,omitempty"`
+	CompactionResult       *CompactionResult              `json:"compaction_result,omitempty"`
+	Evaluations            []EvaluationVerdict            `json:"evaluations,omitempty"`
+}
+
+// CompactionResult describes how a conversation was trimmed to fit
+// the context window of the model
+type CompactionResult struct {
+	ContextWindow      int `json:"context_window"`
+	TokensBefore       int `json:"tokens_before"`
+	TokensAfter        int `json:"tokens_after"`
+	TruncatedResults   int `json:"truncated_results,omitempty"`
+	SummarizedMessages int `json:"summarized_messages,omitempty"`
+	DroppedMessages    int `json:"dropped_messages,omitempty"`
 }
 
 type Observable struct {
@@ -59,5 +73,6 @@ func (o *Observable) MakeLastProgressCompletion() {
 		ChatCompletionResponse: p.ChatCompletionResponse,
 		ActionResult:           p.ActionResult,
 		AgentState:             p.AgentState,
+		Evaluations:            p.Evaluations,
 	}
 }
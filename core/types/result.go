@@ -1,6 +1,8 @@
 package types
 
 import (
+	"encoding/json"
+	"fmt"
 	"sync"
 
 	"github.com/sashabaranov/go-openai"
@@ -16,8 +18,47 @@ type JobResult struct {
 	Finalizers []func([]openai.ChatCompletionMessage)
 
 	Response string
-	Error    error
-	ready    chan bool
+	// ResponseObject is the parsed response of jobs with a response schema
+	ResponseObject any
+	Error          error
+	// BudgetExceeded is set when the job was stopped by its budget
+	BudgetExceeded *BudgetExceeded
+	// FailedEvaluations are the verdicts the response still failed after
+	// the last evaluation loop of the job
+	FailedEvaluations []EvaluationVerdict
+	// PlanRevisions is the history of the plans of the job, the original
+	// plan first followed by the revisions made when subtasks failed
+	PlanRevisions []PlanRevision
+	ready         chan bool
+
+	// retry is called when the job fails, see SetRetryHook
+	retry func(error) bool
+}
+
+// PlanRevision is a version of the plan of a job
+type PlanRevision struct {
+	Revision int          `json:"revision"`
+	Plan     ActionParams `json:"plan"`
+	// Reason is why the previous revision was replaced
+	Reason string `json:"reason,omitempty"`
+	// Kept are the ids of the subtasks completed by the previous revisions
+	Kept []string `json:"kept,omitempty"`
+}
+
+// AddPlanRevision appends a revision to the plan history of the job
+func (j *JobResult) AddPlanRevision(revision PlanRevision) {
+	j.Lock()
+	defer j.Unlock()
+
+	j.PlanRevisions = append(j.PlanRevisions, revision)
+}
+
+// SetFailedEvaluations flags the response of the job as failing the verdicts
+func (j *JobResult) SetFailedEvaluations(verdicts []EvaluationVerdict) {
+	j.Lock()
+	defer j.Unlock()
+
+	j.FailedEvaluations = verdicts
 }
 
 // SetResult sets the result of a job
@@ -28,8 +69,49 @@ func (j *JobResult) SetResult(text ActionState) {
 	j.State = append(j.State, text)
 }
 
+// SetRetryHook sets the function called when the job fails. If it returns
+// true the job is retried and the result is not finished yet.
+func (j *JobResult) SetRetryHook(retry func(error) bool) {
+	j.Lock()
+	defer j.Unlock()
+
+	j.retry = retry
+}
+
+// HasRetryHook reports whether the failures of the job are retried
+func (j *JobResult) HasRetryHook() bool {
+	j.Lock()
+	defer j.Unlock()
+
+	return j.retry != nil
+}
+
+// reset clears the outcome of a failed attempt of the job, the finalizers
+// registered by the attempt included
+func (j *JobResult) reset() {
+	j.Lock()
+	defer j.Unlock()
+
+	j.State = nil
+	j.Conversation = nil
+	j.Finalizers = nil
+	j.Response = ""
+	j.ResponseObject = nil
+	j.Error = nil
+	j.BudgetExceeded = nil
+	j.PlanRevisions = nil
+	j.FailedEvaluations = nil
+}
+
 // SetResult sets the result of a job
 func (j *JobResult) Finish(e error) {
+	j.Lock()
+	retry := j.retry
+	j.Unlock()
+	if e != nil && retry != nil && retry(e) {
+		return
+	}
+
 	j.Lock()
 	j.Error = e
 	j.Unlock()
@@ -58,6 +140,34 @@ func (j *JobResult) SetResponse(response string) {
 	j.Response = response
 }
 
+// SetResponseObject sets the parsed response of a job with a response schema,
+// the response is set to its JSON encoding
+func (j *JobResult) SetResponseObject(object any) error {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	j.ResponseObject = object
+	j.Response = string(data)
+	return nil
+}
+
+// UnmarshalResponse decodes the JSON response of a job with a response
+// schema into dst
+func (j *JobResult) UnmarshalResponse(dst any) error {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.ResponseObject == nil {
+		return fmt.Errorf("the job has no structured response")
+	}
+	return json.Unmarshal([]byte(j.Response), dst)
+}
+
 // WaitResult waits for the result of a job
 func (j *JobResult) WaitResult() *JobResult {
 	<-j.ready
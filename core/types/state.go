@@ -15,9 +15,9 @@ import (
 // And a context memory (that is always powered by a vector database),
 // this memory is the shorter one that the LLM keeps across conversation and across its
 // reasoning process's and life time.
-// TODO: A special action is then used to let the LLM itself update its memory
-// periodically during self-processing, and the same action is ALSO exposed
-// during the conversation to let the user put for example, a new goal to the agent.
+// The LLM updates its memory and its goal with the remember, forget and
+// update_goal actions, both during self-processing and during the
+// conversations, e.g. to let the user give a new goal to the agent.
 type AgentInternalState struct {
 	NowDoing    string   `json:"doing_now"`
 	DoingNext   string   `json:"doing_next"`
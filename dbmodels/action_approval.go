@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// ActionApproval is a request to approve an action before an agent runs it.
+// The job waits until the request is approved, modified or denied.
+type ActionApproval struct {
+	ID        uuid.UUID      `gorm:"type:char(36);primaryKey" json:"id"`
+	AgentID   uuid.UUID      `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"agentId"`
+	UserID    uuid.UUID      `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"userId"`
+	JobID     string         `gorm:"type:varchar(64);index;not null" json:"jobId"`
+	Action    string         `gorm:"type:varchar(255);not null" json:"action"`
+	Params    datatypes.JSON `gorm:"type:json" json:"params"`
+	Reasoning string         `gorm:"type:text" json:"reasoning,omitempty"`
+	Status    string         `gorm:"type:varchar(20);not null;default:'pending';index" json:"status"` // "pending", "approved", "modified", "denied" or "expired"
+	DecidedAt *time.Time     `json:"decidedAt,omitempty"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+
+	Agent Agent `gorm:"foreignKey:AgentID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+	User  User  `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+}
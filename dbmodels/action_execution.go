@@ -7,10 +7,17 @@ import (
 )
 
 type ActionExecution struct {
-	ID         uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
-	UserID     uuid.UUID `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"userId"`
-	ActionName string    `gorm:"type:varchar(255);not null;index" json:"actionName"`
-	Status     string    `gorm:"type:varchar(50);not null;index" json:"status"` // "success" or "error"
+	ID     uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
+	UserID uuid.UUID `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"userId"`
+	// AgentID is nil for the actions run from the actions playground
+	AgentID    *uuid.UUID `gorm:"type:char(36);index" json:"agentId,omitempty"`
+	ActionName string     `gorm:"type:varchar(255);not null;index" json:"actionName"`
+	Status     string     `gorm:"type:varchar(50);not null;index" json:"status"` // "success" or "error"
+	DurationMs int64      `gorm:"not null;default:0" json:"durationMs"`
+	// ParamsHash tells apart the calls with the same parameters
+	ParamsHash string    `gorm:"type:varchar(64)" json:"paramsHash,omitempty"`
+	ErrorClass string    `gorm:"type:varchar(50);index" json:"errorClass,omitempty"` // e.g. "timeout", "auth", see agent.ClassifyActionError
+	Error      string    `gorm:"type:text" json:"error,omitempty"`
 	CreatedAt  time.Time `json:"createdAt"`
 	UpdatedAt  time.Time `json:"updatedAt"`
 
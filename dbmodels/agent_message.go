@@ -12,6 +12,7 @@ type AgentMessage struct {
 	Sender    string    `gorm:"type:varchar(255);not null" json:"sender"` // "user" or "agent"
 	Content   string    `gorm:"type:text;not null" json:"content"`
 	Type      string    `gorm:"type:varchar(50);not null;default:'message'" json:"type"` // "message" or "error"
+	Pinned    bool      `gorm:"index;not null;default:false" json:"pinned"`              // always recalled by the agent
 	CreatedAt time.Time `json:"createdAt"`
 
 	Agent Agent `gorm:"foreignKey:AgentID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
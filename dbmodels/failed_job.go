@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// FailedJob is a job of an agent that failed. It's retried with a backoff
+// according to the retry policy of the agent, then moved to the dead-letter
+// list once it runs out of attempts, where it can be re-driven.
+type FailedJob struct {
+	ID              uuid.UUID      `gorm:"type:char(36);primaryKey" json:"id"`
+	JobID           string         `gorm:"type:varchar(64);uniqueIndex;not null" json:"jobId"`
+	AgentID         uuid.UUID      `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"agentId"`
+	UserID          uuid.UUID      `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"userId"`
+	Role            string         `gorm:"type:varchar(20);not null" json:"role"`
+	Priority        int            `gorm:"not null;default:0" json:"priority"`
+	ConversationKey string         `gorm:"type:varchar(255)" json:"conversationKey,omitempty"`
+	Conversation    datatypes.JSON `gorm:"type:json" json:"conversation"`
+	Metadata        datatypes.JSON `gorm:"type:json" json:"metadata,omitempty"`
+	Status          string         `gorm:"type:varchar(20);not null;default:'retrying';index" json:"status"` // "retrying" or "dead"
+	Attempts        int            `gorm:"not null;default:0" json:"attempts"`
+	LastError       string         `gorm:"type:text" json:"lastError,omitempty"`
+	NextAttemptAt   *time.Time     `json:"nextAttemptAt,omitempty"`
+	CreatedAt       time.Time      `json:"createdAt"`
+	UpdatedAt       time.Time      `json:"updatedAt"`
+
+	Agent Agent `gorm:"foreignKey:AgentID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+	User  User  `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+}
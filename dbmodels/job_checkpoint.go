@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// JobCheckpoint is the persisted state of an agent job, updated after every
+// completed step so that unfinished jobs can be resumed after a restart.
+type JobCheckpoint struct {
+	ID                  uuid.UUID      `gorm:"type:char(36);primaryKey" json:"id"`
+	JobID               string         `gorm:"type:varchar(64);uniqueIndex;not null" json:"jobId"`
+	AgentID             uuid.UUID      `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"agentId"`
+	UserID              uuid.UUID      `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"userId"`
+	Role                string         `gorm:"type:varchar(20);not null" json:"role"`
+	Status              string         `gorm:"type:varchar(20);not null;default:'running';index" json:"status"` // "running", "completed", "failed" or "interrupted"
+	Conversation        datatypes.JSON `gorm:"type:json" json:"conversation"`
+	Steps               datatypes.JSON `gorm:"type:json" json:"steps"`
+	NextAction          string         `gorm:"type:varchar(255)" json:"nextAction,omitempty"`
+	NextActionParams    datatypes.JSON `gorm:"type:json" json:"nextActionParams,omitempty"`
+	NextActionReasoning string         `gorm:"type:text" json:"nextActionReasoning,omitempty"`
+	EvaluationLoop      int            `gorm:"not null;default:0" json:"evaluationLoop"`
+	Metadata            datatypes.JSON `gorm:"type:json" json:"metadata,omitempty"`
+	Error               string         `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt           time.Time      `json:"createdAt"`
+	UpdatedAt           time.Time      `json:"updatedAt"`
+
+	Agent Agent `gorm:"foreignKey:AgentID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+	User  User  `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+}
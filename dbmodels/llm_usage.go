@@ -11,6 +11,7 @@ type LLMUsage struct {
 	UserID           uuid.UUID `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"userId"`
 	AgentID          uuid.UUID `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"agentId"`
 	Model            string    `gorm:"type:varchar(100);not null" json:"model"`
+	Provider         string    `gorm:"type:varchar(255)" json:"provider"` // the LLM API that served the request
 	PromptTokens     int       `gorm:"not null" json:"promptTokens"`
 	CompletionTokens int       `gorm:"not null" json:"completionTokens"`
 	TotalTokens      int       `gorm:"not null" json:"totalTokens"`
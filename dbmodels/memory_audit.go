@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryAudit records a change of the memories or of the goal of an agent
+type MemoryAudit struct {
+	ID      uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
+	AgentID uuid.UUID `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"agentId"`
+	UserID  uuid.UUID `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"userId"`
+	// Operation is what changed: remember, forget, update, pin, ...
+	Operation string `gorm:"type:varchar(50);not null" json:"operation"`
+	// Source is the kind of memory changed: state, goal, message, ...
+	Source   string `gorm:"type:varchar(50);not null" json:"source"`
+	MemoryID string `gorm:"type:varchar(255)" json:"memoryId,omitempty"`
+	OldValue string `gorm:"type:text" json:"oldValue,omitempty"`
+	NewValue string `gorm:"type:text" json:"newValue,omitempty"`
+	// Actor is who changed the memory: the agent or the user
+	Actor string `gorm:"type:varchar(50);not null" json:"actor"`
+	// Origin details the actor, e.g. the action and the job of the agent
+	Origin    string    `gorm:"type:varchar(255)" json:"origin,omitempty"`
+	JobID     string    `gorm:"type:varchar(255)" json:"jobId,omitempty"`
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+
+	Agent Agent `gorm:"foreignKey:AgentID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+	User  User  `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+}
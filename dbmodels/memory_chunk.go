@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MemoryChunk is a piece of a message of an agent embedded in its semantic
+// memory. The ID of the chunk is the ID of its document in the vector store.
+type MemoryChunk struct {
+	ID      uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
+	AgentID uuid.UUID `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"agentId"`
+	UserID  uuid.UUID `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"userId"`
+	// MessageID is the AgentMessage the chunk comes from
+	MessageID *uuid.UUID `gorm:"type:char(36);index" json:"messageId,omitempty"`
+	// Store is the key of the vector store holding the chunk
+	Store   string `gorm:"type:varchar(255);index" json:"store"`
+	Sender  string `gorm:"type:varchar(255);not null" json:"sender"`
+	Content string `gorm:"type:text;not null" json:"content"`
+	// CreatedAt is the time of the source message
+	CreatedAt time.Time `gorm:"index" json:"createdAt"`
+
+	Agent Agent `gorm:"foreignKey:AgentID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+	User  User  `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// MemorySummary is the summary of a conversation of an agent, kept in its
+// long-term memory
+type MemorySummary struct {
+	ID      uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
+	AgentID uuid.UUID `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"agentId"`
+	UserID  uuid.UUID `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"userId"`
+	// Summary lists the highlights of the conversation
+	Summary   string         `gorm:"type:text;not null" json:"summary"`
+	Facts     datatypes.JSON `gorm:"type:json" json:"facts"`
+	Entities  datatypes.JSON `gorm:"type:json" json:"entities"`
+	Decisions datatypes.JSON `gorm:"type:json" json:"decisions"`
+	// SourceMessageIDs are the AgentMessage rows the summary was made from
+	SourceMessageIDs datatypes.JSON `gorm:"type:json" json:"sourceMessageIds"`
+	// Pinned summaries are always recalled by the agent
+	Pinned bool `gorm:"index;not null;default:false" json:"pinned"`
+	// Edited summaries were changed by the user, they are never merged
+	Edited bool `gorm:"not null;default:false" json:"edited"`
+	// StartedAt and EndedAt bound the time of the summarized messages
+	StartedAt time.Time `json:"startedAt"`
+	EndedAt   time.Time `gorm:"index" json:"endedAt"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	Agent Agent `gorm:"foreignKey:AgentID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+	User  User  `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+)
+
+// ParkedJob is a job received while its agent was paused. Parked jobs run
+// once the agent is resumed, unless they expired or were discarded.
+type ParkedJob struct {
+	ID              uuid.UUID      `gorm:"type:char(36);primaryKey" json:"id"`
+	JobID           string         `gorm:"type:varchar(64);uniqueIndex;not null" json:"jobId"`
+	AgentID         uuid.UUID      `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"agentId"`
+	UserID          uuid.UUID      `gorm:"type:char(36);index;not null;constraint:OnDelete:CASCADE" json:"userId"`
+	Role            string         `gorm:"type:varchar(20);not null" json:"role"`
+	Priority        int            `gorm:"not null;default:0" json:"priority"`
+	ConversationKey string         `gorm:"type:varchar(255)" json:"conversationKey,omitempty"`
+	Conversation    datatypes.JSON `gorm:"type:json" json:"conversation"`
+	Metadata        datatypes.JSON `gorm:"type:json" json:"metadata,omitempty"`
+	ExpiresAt       *time.Time     `gorm:"index" json:"expiresAt,omitempty"`
+	CreatedAt       time.Time      `json:"createdAt"`
+
+	Agent Agent `gorm:"foreignKey:AgentID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+	User  User  `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+}
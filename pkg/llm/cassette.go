@@ -0,0 +1,217 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// CassetteMode tells whether a Cassette records the LLM interactions or
+// replays them
+type CassetteMode string
+
+const (
+	// CassetteRecord forwards the requests to the API and saves them
+	CassetteRecord CassetteMode = "record"
+	// CassetteReplay serves the saved responses without any network access
+	CassetteReplay CassetteMode = "replay"
+)
+
+// Interaction is a request to the LLM API and the response it got
+type Interaction struct {
+	Method      string          `json:"method"`
+	Path        string          `json:"path"`
+	Request     json.RawMessage `json:"request,omitempty"`
+	Status      int             `json:"status"`
+	ContentType string          `json:"content_type,omitempty"`
+	Response    string          `json:"response"`
+}
+
+// Cassette is an http.RoundTripper that records the LLM interactions to a
+// fixture file, or replays them offline so that agent flows can be tested
+// deterministically. Use it as the transport of the LLM client.
+//
+// In replay mode requests are matched on method, path and body, and every
+// recorded interaction is served once, in order. A request without a match
+// fails.
+type Cassette struct {
+	sync.Mutex
+
+	path         string
+	mode         CassetteMode
+	transport    http.RoundTripper
+	interactions []Interaction
+	used         []bool
+}
+
+// timestamps are part of the prompts, they are masked so that a recording
+// keeps matching when replayed later
+var cassetteTimestamp = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})`)
+
+// NewCassette opens the cassette stored at path. In replay mode the file
+// must exist, in record mode it is (re)written as interactions happen.
+func NewCassette(path string, mode CassetteMode) (*Cassette, error) {
+	c := &Cassette{
+		path:      path,
+		mode:      mode,
+		transport: http.DefaultTransport,
+	}
+
+	switch mode {
+	case CassetteRecord:
+	case CassetteReplay:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading cassette: %w", err)
+		}
+		if err := json.Unmarshal(data, &c.interactions); err != nil {
+			return nil, fmt.Errorf("parsing cassette %s: %w", path, err)
+		}
+		c.used = make([]bool, len(c.interactions))
+	default:
+		return nil, fmt.Errorf("unknown cassette mode %q", mode)
+	}
+
+	return c, nil
+}
+
+// Interactions returns the interactions recorded or loaded so far
+func (c *Cassette) Interactions() []Interaction {
+	c.Lock()
+	defer c.Unlock()
+	return append([]Interaction{}, c.interactions...)
+}
+
+// Unused returns how many recorded interactions were not replayed
+func (c *Cassette) Unused() int {
+	c.Lock()
+	defer c.Unlock()
+	n := 0
+	for _, used := range c.used {
+		if !used {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.mode == CassetteReplay {
+		return c.replay(req, body)
+	}
+	return c.record(req, body)
+}
+
+func (c *Cassette) record(req *http.Request, body []byte) (*http.Response, error) {
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	resp, err := c.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	c.Lock()
+	defer c.Unlock()
+	c.interactions = append(c.interactions, Interaction{
+		Method:      req.Method,
+		Path:        req.URL.Path,
+		Request:     rawJSON(body),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Response:    string(respBody),
+	})
+
+	if err := c.save(); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Cassette) replay(req *http.Request, body []byte) (*http.Response, error) {
+	key := normalizeRequest(body)
+
+	c.Lock()
+	defer c.Unlock()
+	for i, interaction := range c.interactions {
+		if c.used[i] || interaction.Method != req.Method || interaction.Path != req.URL.Path ||
+			normalizeRequest(interaction.Request) != key {
+			continue
+		}
+		c.used[i] = true
+
+		header := http.Header{}
+		if interaction.ContentType != "" {
+			header.Set("Content-Type", interaction.ContentType)
+		}
+		return &http.Response{
+			Status:        http.StatusText(interaction.Status),
+			StatusCode:    interaction.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader([]byte(interaction.Response))),
+			ContentLength: int64(len(interaction.Response)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("cassette %s: no recorded interaction matches %s %s", c.path, req.Method, req.URL.Path)
+}
+
+func (c *Cassette) save() error {
+	data, err := json.MarshalIndent(c.interactions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0644)
+}
+
+// rawJSON keeps JSON bodies readable in the fixture files
+func rawJSON(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return body
+	}
+	quoted, _ := json.Marshal(string(body))
+	return quoted
+}
+
+// normalizeRequest returns a canonical form of a request body, with the
+// timestamps masked
+func normalizeRequest(body []byte) string {
+	var v any
+	if err := json.Unmarshal(body, &v); err == nil {
+		if canonical, err := json.Marshal(v); err == nil {
+			body = canonical
+		}
+	}
+	return cassetteTimestamp.ReplaceAllString(string(body), "<timestamp>")
+}
@@ -0,0 +1,94 @@
+package llm_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"time"
+
+	"github.com/mudler/LocalAGI/pkg/llm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sashabaranov/go-openai"
+)
+
+var _ = Describe("Cassette", func() {
+	var (
+		server   *httptest.Server
+		calls    int
+		fixture  string
+		question = func(content string) openai.ChatCompletionRequest {
+			return openai.ChatCompletionRequest{
+				Model: "test-model",
+				Messages: []openai.ChatCompletionMessage{
+					{Role: "system", Content: "Current time: " + time.Now().Format(time.RFC3339)},
+					{Role: "user", Content: content},
+				},
+			}
+		}
+	)
+
+	BeforeEach(func() {
+		calls = 0
+		fixture = filepath.Join(GinkgoT().TempDir(), "cassettes", "chat.json")
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"id":"gen-%d","model":"test-model","choices":[{"index":0,"message":{"role":"assistant","content":"answer %d"}}]}`, calls, calls)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("replays the recorded interactions offline", func() {
+		recorder, err := llm.NewCassette(fixture, llm.CassetteRecord)
+		Expect(err).ToNot(HaveOccurred())
+
+		client := llm.NewClientWithTransport("", server.URL, "10s", recorder)
+		resp, err := client.CreateChatCompletion(context.Background(), question("hello"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Choices[0].Message.Content).To(Equal("answer 1"))
+		Expect(recorder.Interactions()).To(HaveLen(1))
+
+		server.Close()
+
+		player, err := llm.NewCassette(fixture, llm.CassetteReplay)
+		Expect(err).ToNot(HaveOccurred())
+
+		client = llm.NewClientWithTransport("", server.URL, "10s", player)
+		// the timestamp of the prompt changed since the recording
+		time.Sleep(time.Second)
+		resp, err = client.CreateChatCompletion(context.Background(), question("hello"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Choices[0].Message.Content).To(Equal("answer 1"))
+		Expect(player.Unused()).To(BeZero())
+		Expect(calls).To(Equal(1))
+	})
+
+	It("fails on unmatched requests", func() {
+		recorder, err := llm.NewCassette(fixture, llm.CassetteRecord)
+		Expect(err).ToNot(HaveOccurred())
+
+		client := llm.NewClientWithTransport("", server.URL, "10s", recorder)
+		_, err = client.CreateChatCompletion(context.Background(), question("hello"))
+		Expect(err).ToNot(HaveOccurred())
+
+		player, err := llm.NewCassette(fixture, llm.CassetteReplay)
+		Expect(err).ToNot(HaveOccurred())
+
+		client = llm.NewClientWithTransport("", server.URL, "10s", player)
+		_, err = client.CreateChatCompletion(context.Background(), question("something else"))
+		Expect(err).To(MatchError(ContainSubstring("no recorded interaction")))
+
+		_, err = client.CreateChatCompletion(context.Background(), question("hello"))
+		Expect(err).ToNot(HaveOccurred())
+		// every interaction is served once
+		_, err = client.CreateChatCompletion(context.Background(), question("hello"))
+		Expect(err).To(HaveOccurred())
+		Expect(calls).To(Equal(1))
+	})
+})
@@ -8,6 +8,12 @@ import (
 )
 
 func NewClient(APIKey, URL, timeout string) *openai.Client {
+	return NewClientWithTransport(APIKey, URL, timeout, nil)
+}
+
+// NewClientWithTransport returns a client sending its requests through
+// transport, e.g. a Cassette. A nil transport uses the default one.
+func NewClientWithTransport(APIKey, URL, timeout string, transport http.RoundTripper) *openai.Client {
 	// Set up OpenAI client
 	if APIKey == "" {
 		//log.Fatal("OPENAI_API_KEY environment variable not set")
@@ -22,7 +28,8 @@ func NewClient(APIKey, URL, timeout string) *openai.Client {
 	}
 
 	config.HTTPClient = &http.Client{
-		Timeout: dur,
+		Timeout:   dur,
+		Transport: transport,
 	}
 	return openai.NewClientWithConfig(config)
 }
@@ -0,0 +1,104 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/sashabaranov/go-openai"
+)
+
+// DefaultEmbeddingModel is used when no embedding model is configured
+const DefaultEmbeddingModel = string(openai.AdaEmbeddingV2)
+
+// Embedder computes embeddings. *openai.Client implements it for any OpenAI
+// compatible API.
+type Embedder interface {
+	CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error)
+}
+
+// Embed returns the embeddings of the texts, in the same order
+func Embed(ctx context.Context, embedder Embedder, model string, userID, agentID uuid.UUID, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	if model == "" {
+		model = DefaultEmbeddingModel
+	}
+
+	resp, err := embedder.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: texts,
+		Model: openai.EmbeddingModel(model),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if userID != uuid.Nil && agentID != uuid.Nil {
+		TrackUsage(ctx, &models.LLMUsage{
+			ID:           uuid.New(),
+			UserID:       userID,
+			AgentID:      agentID,
+			Model:        model,
+			PromptTokens: resp.Usage.PromptTokens,
+			TotalTokens:  resp.Usage.TotalTokens,
+			RequestType:  "embedding",
+			CreatedAt:    time.Now(),
+		})
+	}
+
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Data))
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for _, data := range resp.Data {
+		if data.Index < 0 || data.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index out of range: %d", data.Index)
+		}
+		embeddings[data.Index] = data.Embedding
+	}
+	return embeddings, nil
+}
+
+// CosineSimilarity returns the cosine similarity of two embeddings, zero if
+// their sizes differ or one of them is empty
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// TopK returns the indexes of the k candidates most similar to the query,
+// most similar first
+func TopK(query []float32, candidates [][]float32, k int) []int {
+	scores := make([]float64, len(candidates))
+	indexes := make([]int, len(candidates))
+	for i, candidate := range candidates {
+		scores[i] = CosineSimilarity(query, candidate)
+		indexes[i] = i
+	}
+
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return scores[indexes[i]] > scores[indexes[j]]
+	})
+	if k < len(indexes) {
+		indexes = indexes[:k]
+	}
+	return indexes
+}
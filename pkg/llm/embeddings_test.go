@@ -0,0 +1,55 @@
+package llm_test
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/pkg/llm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sashabaranov/go-openai"
+)
+
+type fakeEmbedder struct {
+	vectors map[string][]float32
+}
+
+func (f *fakeEmbedder) CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error) {
+	request := conv.Convert()
+	resp := openai.EmbeddingResponse{}
+	// answer in reverse order, Embed must sort them back
+	texts := request.Input.([]string)
+	for i := len(texts) - 1; i >= 0; i-- {
+		resp.Data = append(resp.Data, openai.Embedding{Index: i, Embedding: f.vectors[texts[i]]})
+	}
+	return resp, nil
+}
+
+var _ = Describe("Embeddings", func() {
+	embedder := &fakeEmbedder{vectors: map[string][]float32{
+		"send an email":     {1, 0, 0},
+		"create an event":   {0, 1, 0},
+		"search the web":    {0, 0, 1},
+		"email to my boss":  {0.9, 0.1, 0},
+		"meeting on monday": {0.2, 0.8, 0.1},
+	}}
+
+	It("returns the embeddings in the order of the texts", func() {
+		embeddings, err := llm.Embed(context.Background(), embedder, "", uuid.Nil, uuid.Nil, []string{"send an email", "search the web"})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(embeddings).To(Equal([][]float32{{1, 0, 0}, {0, 0, 1}}))
+	})
+
+	It("ranks the candidates by similarity", func() {
+		candidates := [][]float32{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+		Expect(llm.TopK([]float32{0.9, 0.1, 0}, candidates, 2)).To(Equal([]int{0, 1}))
+		Expect(llm.TopK([]float32{0.2, 0.8, 0.1}, candidates, 5)).To(Equal([]int{1, 0, 2}))
+	})
+
+	It("doesn't compare embeddings of different sizes", func() {
+		Expect(llm.CosineSimilarity([]float32{1, 0}, []float32{1, 0, 0})).To(BeZero())
+		Expect(llm.CosineSimilarity([]float32{1, 0}, []float32{2, 0})).To(BeNumerically("~", 1, 1e-9))
+	})
+})
@@ -3,19 +3,21 @@ package llm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/mudler/LocalAGI/db"
 	models "github.com/mudler/LocalAGI/dbmodels"
 	"github.com/mudler/LocalAGI/pkg/utils"
-	"github.com/mudler/LocalAGI/pkg/xlog"
 	"github.com/sashabaranov/go-openai"
 	"github.com/sashabaranov/go-openai/jsonschema"
 )
 
-func GenerateTypedJSONWithGuidance(ctx context.Context, client *openai.Client, guidance, model string, userID uuid.UUID, agentID uuid.UUID, i jsonschema.Definition, dst any) error {
+func GenerateTypedJSONWithGuidance(ctx context.Context, client Provider, guidance, model string, userID uuid.UUID, agentID uuid.UUID, i jsonschema.Definition, dst any) error {
 	return GenerateTypedJSONWithConversation(ctx, client, []openai.ChatCompletionMessage{
 		{
 			Role:    "user",
@@ -24,24 +26,39 @@ func GenerateTypedJSONWithGuidance(ctx context.Context, client *openai.Client, g
 	}, model, userID, agentID, i, dst)
 }
 
-func GenerateTypedJSONWithConversation(ctx context.Context, client *openai.Client, conv []openai.ChatCompletionMessage, model string, userID uuid.UUID, agentID uuid.UUID, i jsonschema.Definition, dst any) error {
-	toolName := "json"
+// jsonToolName is the tool the LLM calls to generate JSON documents
+const jsonToolName = "json"
+
+// JSONTool is the tool the LLM is forced to call to generate a JSON document
+// following the schema
+func JSONTool(schema jsonschema.Definition) openai.Tool {
+	return openai.Tool{
+		Type: openai.ToolTypeFunction,
+		Function: &openai.FunctionDefinition{
+			Name:       jsonToolName,
+			Parameters: schema,
+		},
+	}
+}
+
+func GenerateTypedJSONWithConversation(ctx context.Context, client Provider, conv []openai.ChatCompletionMessage, model string, userID uuid.UUID, agentID uuid.UUID, i jsonschema.Definition, dst any) error {
+	arguments, err := generateJSON(ctx, client, conv, model, userID, agentID, i)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(arguments), dst)
+}
+
+// generateJSON forces the LLM to call the JSON tool and returns the
+// arguments of the call, the generated document
+func generateJSON(ctx context.Context, client Provider, conv []openai.ChatCompletionMessage, model string, userID uuid.UUID, agentID uuid.UUID, i jsonschema.Definition) (string, error) {
 	decision := openai.ChatCompletionRequest{
 		Model:    model,
 		Messages: conv,
-		Tools: []openai.Tool{
-			{
-
-				Type: openai.ToolTypeFunction,
-				Function: &openai.FunctionDefinition{
-					Name:       toolName,
-					Parameters: i,
-				},
-			},
-		},
+		Tools:    []openai.Tool{JSONTool(i)},
 		ToolChoice: openai.ToolChoice{
 			Type:     openai.ToolTypeFunction,
-			Function: openai.ToolFunction{Name: toolName},
+			Function: openai.ToolFunction{Name: jsonToolName},
 		},
 	}
 
@@ -54,26 +71,25 @@ func GenerateTypedJSONWithConversation(ctx context.Context, client *openai.Clien
 			ID:               uuid.New(),
 			UserID:           userID,
 			AgentID:          agentID,
-			Model:            model,
+			Model:            ServedModel(resp, model),
+			Provider:         ServedProvider(resp.Header()),
 			PromptTokens:     usage.PromptTokens,
 			CompletionTokens: usage.CompletionTokens,
 			TotalTokens:      usage.TotalTokens,
 			Cost:             usage.Cost,
-			RequestType:      "chat",
+			RequestType:      RequestTypeFromContext(ctx, "chat"),
 			GenID:            resp.ID,
 			CreatedAt:        time.Now(),
 		}
-		if err := db.DB.Create(llmUsage).Error; err != nil {
-			xlog.Error("Error tracking LLM usage", "error", err)
-		}
+		TrackUsage(ctx, llmUsage)
 	}
 
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	if len(resp.Choices) != 1 {
-		return fmt.Errorf("no choices: %d", len(resp.Choices))
+		return "", fmt.Errorf("no choices: %d", len(resp.Choices))
 	}
 
 	jsonSchema, _ := json.MarshalIndent(i, "", "  ")
@@ -85,10 +101,131 @@ func GenerateTypedJSONWithConversation(ctx context.Context, client *openai.Clien
 	msg := resp.Choices[0].Message
 
 	if len(msg.ToolCalls) == 0 {
-		return fmt.Errorf("no tool calls: %d", len(msg.ToolCalls))
+		return "", fmt.Errorf("no tool calls: %d", len(msg.ToolCalls))
 	}
 
 	fmt.Println("JSON generated", "Arguments", msg.ToolCalls)
 
-	return json.Unmarshal([]byte(msg.ToolCalls[0].Function.Arguments), dst)
+	return msg.ToolCalls[0].Function.Arguments, nil
+}
+
+const jsonRepairPrompt = `The JSON you generated is not valid: %s
+Generate it again, following the schema exactly.`
+
+// GenerateValidatedJSON generates a JSON document following the schema, like
+// GenerateTypedJSONWithConversation, and validates it. When the document does
+// not conform, the document and the errors are sent back to the LLM to repair
+// it, up to maxRepairs times.
+func GenerateValidatedJSON(ctx context.Context, client Provider, conv []openai.ChatCompletionMessage, model string, userID uuid.UUID, agentID uuid.UUID, schema jsonschema.Definition, maxRepairs int, dst any) error {
+	// the repair turns are added to a copy of the conversation of the caller
+	conv = append([]openai.ChatCompletionMessage{}, conv...)
+
+	var lastErr error
+	for attempt := 0; attempt <= maxRepairs; attempt++ {
+		var document any
+		arguments, err := generateJSON(ctx, client, conv, model, userID, agentID, schema)
+		if err == nil {
+			err = json.Unmarshal([]byte(arguments), &document)
+		}
+		if err == nil {
+			err = ValidateJSON(schema, document)
+		}
+		if err == nil {
+			data, err := json.Marshal(document)
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(data, dst)
+		}
+
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+		if arguments != "" {
+			// the LLM has to see what it generated to repair it
+			conv = append(conv, openai.ChatCompletionMessage{
+				Role:    "assistant",
+				Content: arguments,
+			})
+		}
+		conv = append(conv, openai.ChatCompletionMessage{
+			Role:    "user",
+			Content: fmt.Sprintf(jsonRepairPrompt, err),
+		})
+	}
+
+	return fmt.Errorf("failed to generate valid JSON: %w", lastErr)
+}
+
+// ValidateJSON checks a decoded JSON document against the schema, the
+// error lists the paths that don't conform
+func ValidateJSON(schema jsonschema.Definition, document any) error {
+	errs := validateJSON(schema, document, "$")
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func validateJSON(schema jsonschema.Definition, value any, path string) []string {
+	if len(schema.Enum) > 0 {
+		s, ok := value.(string)
+		if !ok || !slices.Contains(schema.Enum, s) {
+			return []string{fmt.Sprintf("%s must be one of %v", path, schema.Enum)}
+		}
+	}
+
+	switch schema.Type {
+	case jsonschema.Object:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s must be an object", path)}
+		}
+		errs := []string{}
+		for _, name := range schema.Required {
+			if _, exists := object[name]; !exists {
+				errs = append(errs, fmt.Sprintf("%s.%s is required", path, name))
+			}
+		}
+		for name, property := range schema.Properties {
+			if v, exists := object[name]; exists {
+				errs = append(errs, validateJSON(property, v, path+"."+name)...)
+			}
+		}
+		return errs
+	case jsonschema.Array:
+		array, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s must be an array", path)}
+		}
+		errs := []string{}
+		if schema.Items != nil {
+			for i, item := range array {
+				errs = append(errs, validateJSON(*schema.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+		return errs
+	case jsonschema.String:
+		if _, ok := value.(string); !ok {
+			return []string{fmt.Sprintf("%s must be a string", path)}
+		}
+	case jsonschema.Number:
+		if _, ok := value.(float64); !ok {
+			return []string{fmt.Sprintf("%s must be a number", path)}
+		}
+	case jsonschema.Integer:
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			return []string{fmt.Sprintf("%s must be an integer", path)}
+		}
+	case jsonschema.Boolean:
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s must be a boolean", path)}
+		}
+	case jsonschema.Null:
+		if value != nil {
+			return []string{fmt.Sprintf("%s must be null", path)}
+		}
+	}
+	return nil
 }
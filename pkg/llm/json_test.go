@@ -0,0 +1,134 @@
+package llm_test
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/pkg/llm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+// jsonProvider answers every request with the next of its documents as
+// the arguments of a call to the JSON tool
+type jsonProvider struct {
+	fakeProvider
+	documents []string
+}
+
+func (j *jsonProvider) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	j.requests = append(j.requests, request)
+	document := j.documents[0]
+	j.documents = j.documents[1:]
+	return openai.ChatCompletionResponse{
+		Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{
+				Role: "assistant",
+				ToolCalls: []openai.ToolCall{{
+					Type:     openai.ToolTypeFunction,
+					Function: openai.FunctionCall{Name: "json", Arguments: document},
+				}},
+			},
+		}},
+	}, nil
+}
+
+var _ = Describe("ValidateJSON", func() {
+	schema := jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"status": {Type: jsonschema.String, Enum: []string{"open", "closed"}},
+			"count":  {Type: jsonschema.Integer},
+			"tags": {
+				Type:  jsonschema.Array,
+				Items: &jsonschema.Definition{Type: jsonschema.String},
+			},
+		},
+		Required: []string{"status", "count"},
+	}
+
+	decode := func(document string) any {
+		var v any
+		Expect(json.Unmarshal([]byte(document), &v)).To(Succeed())
+		return v
+	}
+
+	It("accepts documents following the schema", func() {
+		Expect(llm.ValidateJSON(schema, decode(`{"status":"open","count":2,"tags":["a","b"]}`))).To(Succeed())
+	})
+
+	It("reports the paths which don't conform", func() {
+		err := llm.ValidateJSON(schema, decode(`{"status":"pending","count":1.5,"tags":["a",3]}`))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("$.status must be one of [open closed]"))
+		Expect(err.Error()).To(ContainSubstring("$.count must be an integer"))
+		Expect(err.Error()).To(ContainSubstring("$.tags[1] must be a string"))
+	})
+
+	It("reports missing required properties", func() {
+		err := llm.ValidateJSON(schema, decode(`{"tags":[]}`))
+		Expect(err).To(MatchError(And(ContainSubstring("$.status is required"), ContainSubstring("$.count is required"))))
+	})
+})
+
+var _ = Describe("GenerateValidatedJSON", func() {
+	schema := jsonschema.Definition{
+		Type: jsonschema.Object,
+		Properties: map[string]jsonschema.Definition{
+			"count": {Type: jsonschema.Integer},
+		},
+		Required: []string{"count"},
+	}
+	conv := []openai.ChatCompletionMessage{{Role: "user", Content: "count the sheep"}}
+
+	generate := func(provider llm.Provider, maxRepairs int) (map[string]any, error) {
+		var dst map[string]any
+		err := llm.GenerateValidatedJSON(context.Background(), provider, conv, "model", uuid.Nil, uuid.Nil, schema, maxRepairs, &dst)
+		return dst, err
+	}
+
+	It("returns the valid documents", func() {
+		provider := &jsonProvider{documents: []string{`{"count":3}`}}
+
+		document, err := generate(provider, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(document).To(HaveKeyWithValue("count", BeNumerically("==", 3)))
+		Expect(provider.requests).To(HaveLen(1))
+	})
+
+	It("sends the invalid document and its errors back to repair it", func() {
+		provider := &jsonProvider{documents: []string{`{"count":"three"}`, `{"count":3}`}}
+
+		document, err := generate(provider, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(document).To(HaveKeyWithValue("count", BeNumerically("==", 3)))
+
+		Expect(provider.requests).To(HaveLen(2))
+		repair := provider.requests[1].Messages
+		Expect(repair).To(HaveLen(3))
+		Expect(repair[1].Role).To(Equal("assistant"))
+		Expect(repair[1].Content).To(Equal(`{"count":"three"}`))
+		Expect(repair[2].Role).To(Equal("user"))
+		Expect(repair[2].Content).To(ContainSubstring("$.count must be an integer"))
+		Expect(conv).To(HaveLen(1))
+	})
+
+	It("sends back the documents which are not JSON", func() {
+		provider := &jsonProvider{documents: []string{`{"count":`, `{"count":3}`}}
+
+		_, err := generate(provider, 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(provider.requests[1].Messages[1].Content).To(Equal(`{"count":`))
+	})
+
+	It("gives up after the repairs", func() {
+		provider := &jsonProvider{documents: []string{`{}`, `{}`}}
+
+		_, err := generate(provider, 1)
+		Expect(err).To(MatchError(ContainSubstring("$.count is required")))
+		Expect(provider.requests).To(HaveLen(2))
+	})
+})
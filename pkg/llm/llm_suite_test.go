@@ -0,0 +1,13 @@
+package llm_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestLLM(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "LLM test suite")
+}
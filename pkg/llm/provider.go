@@ -0,0 +1,261 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Provider serves chat completions. *openai.Client implements it for any
+// OpenAI compatible API (OpenAI, LocalAI, OpenRouter, ...).
+type Provider interface {
+	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error)
+}
+
+// NewOpenAIProvider returns a Provider for an OpenAI compatible API
+func NewOpenAIProvider(APIKey, URL, timeout string) Provider {
+	return NewClient(APIKey, URL, timeout)
+}
+
+// NewOpenAIProviderWithTransport returns a Provider for an OpenAI compatible
+// API sending its requests through transport
+func NewOpenAIProviderWithTransport(APIKey, URL, timeout string, transport http.RoundTripper) Provider {
+	return NewClientWithTransport(APIKey, URL, timeout, transport)
+}
+
+// ChainEntry is a provider of a Chain, with the model to ask it for.
+// An empty model keeps the model of the request.
+type ChainEntry struct {
+	Name     string
+	Provider Provider
+	Model    string
+}
+
+// Chain is a Provider that tries its entries in order: when an entry fails
+// with a transient error (see IsTransient), is rate limited or returns no
+// choices, the request fails over to the next one after an exponential
+// backoff. Once all the entries were tried it starts again from the first,
+// up to MaxAttempts calls. Other errors, like invalid requests, are returned
+// at once.
+//
+// The responses keep the model reported by the provider that served them,
+// or the model it was asked for, and carry the name of the entry in their
+// ProviderHeader.
+type Chain struct {
+	entries []ChainEntry
+
+	// Sampling is applied to every request served by the chain
+	Sampling SamplingParams
+
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+func NewChain(entries ...ChainEntry) *Chain {
+	attempts := 3
+	if len(entries) > attempts {
+		attempts = len(entries)
+	}
+
+	return &Chain{
+		entries:        entries,
+		MaxAttempts:    attempts,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+	}
+}
+
+// backoff waits before the given attempt, it returns false if the context
+// was canceled in the meantime
+func (c *Chain) backoff(ctx context.Context, attempt int) bool {
+	if attempt == 0 {
+		return true
+	}
+
+	wait := c.InitialBackoff << (attempt - 1)
+	if wait > c.MaxBackoff || wait <= 0 {
+		wait = c.MaxBackoff
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (c *Chain) request(entry ChainEntry, request openai.ChatCompletionRequest) openai.ChatCompletionRequest {
+	if entry.Model != "" {
+		request.Model = entry.Model
+	}
+	c.Sampling.Apply(&request)
+	return request
+}
+
+func (c *Chain) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	if len(c.entries) == 0 {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("no LLM provider configured")
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < c.MaxAttempts; attempt++ {
+		if !c.backoff(ctx, attempt) {
+			break
+		}
+
+		entry := c.entries[attempt%len(c.entries)]
+		req := c.request(entry, request)
+
+		resp, err := entry.Provider.CreateChatCompletion(ctx, req)
+		if err == nil && !hasChoices(resp) {
+			err = errNoChoices
+		}
+		if err == nil {
+			if resp.Model == "" {
+				resp.Model = req.Model
+			}
+			resp.SetHeader(withProvider(resp.Header(), entry.Name))
+			return resp, nil
+		}
+
+		lastErr = err
+		if ctx.Err() != nil || !canFailOver(err) {
+			break
+		}
+		xlog.Warn("LLM provider failed, failing over", "provider", entry.Name, "model", req.Model, "attempt", attempt+1, "rate_limited", IsRateLimited(err), "error", err)
+	}
+
+	if lastErr == nil {
+		lastErr = ctx.Err()
+	}
+	return openai.ChatCompletionResponse{}, lastErr
+}
+
+func (c *Chain) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error) {
+	if len(c.entries) == 0 {
+		return nil, fmt.Errorf("no LLM provider configured")
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < c.MaxAttempts; attempt++ {
+		if !c.backoff(ctx, attempt) {
+			break
+		}
+
+		entry := c.entries[attempt%len(c.entries)]
+		req := c.request(entry, request)
+
+		stream, err := entry.Provider.CreateChatCompletionStream(ctx, req)
+		if err == nil {
+			if stream != nil {
+				stream.SetHeader(withProvider(stream.Header(), entry.Name))
+			}
+			return stream, nil
+		}
+
+		lastErr = err
+		if ctx.Err() != nil || !canFailOver(err) {
+			break
+		}
+		xlog.Warn("LLM provider failed to stream, failing over", "provider", entry.Name, "model", req.Model, "attempt", attempt+1, "rate_limited", IsRateLimited(err), "error", err)
+	}
+
+	if lastErr == nil {
+		lastErr = ctx.Err()
+	}
+	return nil, lastErr
+}
+
+// ProviderHeader is the header of the responses of a Chain holding the name
+// of the entry that served them
+const ProviderHeader = "X-LocalAGI-Provider"
+
+func withProvider(header http.Header, name string) http.Header {
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set(ProviderHeader, name)
+	return header
+}
+
+// ServedProvider returns the name of the Chain entry that served a
+// response, given its headers
+func ServedProvider(header http.Header) string {
+	return header.Get(ProviderHeader)
+}
+
+var errNoChoices = errors.New("no choices returned")
+
+// canFailOver reports whether another entry of the chain may serve the
+// request that failed with err
+func canFailOver(err error) bool {
+	return errors.Is(err, errNoChoices) || IsTransient(err) || IsRateLimited(err)
+}
+
+func hasChoices(resp openai.ChatCompletionResponse) bool {
+	return len(resp.Choices) > 0
+}
+
+// IsRateLimited reports whether the error is a rate limit of the API
+func IsRateLimited(err error) bool {
+	apiErr := &openai.APIError{}
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode == http.StatusTooManyRequests
+	}
+	reqErr := &openai.RequestError{}
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// IsTransient reports whether the error is likely to go away when the request
+// is retried later: rate limits, server errors, timeouts and dropped
+// connections
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	apiErr := &openai.APIError{}
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode == http.StatusTooManyRequests || apiErr.HTTPStatusCode >= 500
+	}
+	reqErr := &openai.RequestError{}
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode == http.StatusTooManyRequests || reqErr.HTTPStatusCode >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// ServedModel returns the model that served a response, or the requested
+// one if the provider did not report it
+func ServedModel(resp openai.ChatCompletionResponse, requested string) string {
+	if resp.Model != "" {
+		return resp.Model
+	}
+	return requested
+}
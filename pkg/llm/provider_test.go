@@ -0,0 +1,181 @@
+package llm_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mudler/LocalAGI/pkg/llm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sashabaranov/go-openai"
+)
+
+type fakeProvider struct {
+	err       error
+	noChoices bool
+	content   string
+	model     string
+	requests  []openai.ChatCompletionRequest
+}
+
+func (f *fakeProvider) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	f.requests = append(f.requests, request)
+	if f.err != nil {
+		return openai.ChatCompletionResponse{}, f.err
+	}
+	if f.noChoices {
+		return openai.ChatCompletionResponse{Model: f.model}, nil
+	}
+	return openai.ChatCompletionResponse{
+		Model: f.model,
+		Choices: []openai.ChatCompletionChoice{
+			{Message: openai.ChatCompletionMessage{Role: "assistant", Content: f.content}},
+		},
+	}, nil
+}
+
+func (f *fakeProvider) CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error) {
+	f.requests = append(f.requests, request)
+	return nil, f.err
+}
+
+func newTestChain(entries ...llm.ChainEntry) *llm.Chain {
+	chain := llm.NewChain(entries...)
+	chain.InitialBackoff = time.Millisecond
+	chain.MaxBackoff = time.Millisecond
+	return chain
+}
+
+var _ = Describe("Chain", func() {
+	request := openai.ChatCompletionRequest{Model: "primary-model"}
+
+	It("serves the request with the first provider", func() {
+		primary := &fakeProvider{content: "hello"}
+		fallback := &fakeProvider{content: "fallback"}
+
+		resp, err := newTestChain(
+			llm.ChainEntry{Provider: primary},
+			llm.ChainEntry{Provider: fallback, Model: "fallback-model"},
+		).CreateChatCompletion(context.Background(), request)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Choices[0].Message.Content).To(Equal("hello"))
+		Expect(resp.Model).To(Equal("primary-model"))
+		Expect(fallback.requests).To(BeEmpty())
+	})
+
+	It("fails over to the next entry on errors and empty choices", func() {
+		primary := &fakeProvider{err: &openai.APIError{HTTPStatusCode: http.StatusServiceUnavailable, Message: "unavailable"}}
+		empty := &fakeProvider{noChoices: true}
+		fallback := &fakeProvider{content: "fallback"}
+
+		resp, err := newTestChain(
+			llm.ChainEntry{Name: "primary", Provider: primary},
+			llm.ChainEntry{Name: "empty", Provider: empty},
+			llm.ChainEntry{Name: "fallback", Provider: fallback, Model: "fallback-model"},
+		).CreateChatCompletion(context.Background(), request)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Choices[0].Message.Content).To(Equal("fallback"))
+		Expect(resp.Model).To(Equal("fallback-model"))
+		Expect(llm.ServedProvider(resp.Header())).To(Equal("fallback"))
+		Expect(fallback.requests[0].Model).To(Equal("fallback-model"))
+	})
+
+	It("returns the responses with empty content", func() {
+		primary := &fakeProvider{}
+		fallback := &fakeProvider{content: "fallback"}
+
+		resp, err := newTestChain(
+			llm.ChainEntry{Provider: primary},
+			llm.ChainEntry{Provider: fallback},
+		).CreateChatCompletion(context.Background(), request)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Choices[0].Message.Content).To(BeEmpty())
+		Expect(fallback.requests).To(BeEmpty())
+	})
+
+	It("keeps the model reported by the provider that served the request", func() {
+		routed := &fakeProvider{content: "hello", model: "openai/gpt-4o-2024-08-06"}
+
+		resp, err := newTestChain(
+			llm.ChainEntry{Name: "router", Provider: routed, Model: "openrouter/auto"},
+		).CreateChatCompletion(context.Background(), request)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(resp.Model).To(Equal("openai/gpt-4o-2024-08-06"))
+		Expect(llm.ServedProvider(resp.Header())).To(Equal("router"))
+		Expect(llm.ServedModel(resp, "openrouter/auto")).To(Equal("openai/gpt-4o-2024-08-06"))
+	})
+
+	It("applies the sampling parameters to the requests", func() {
+		primary := &fakeProvider{content: "hello"}
+		temperature, seed := float32(0), 42
+
+		chain := newTestChain(llm.ChainEntry{Provider: primary})
+		chain.Sampling = llm.SamplingParams{Temperature: &temperature, Seed: &seed}
+
+		_, err := chain.CreateChatCompletion(context.Background(), request)
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(primary.requests[0].Temperature).To(BeNumerically(">", 0))
+		Expect(*primary.requests[0].Seed).To(Equal(42))
+		Expect(primary.requests[0].TopP).To(BeZero())
+	})
+
+	It("doesn't retry the requests rejected by the provider", func() {
+		invalid := &fakeProvider{err: &openai.APIError{HTTPStatusCode: http.StatusBadRequest, Message: "invalid request"}}
+		fallback := &fakeProvider{content: "fallback"}
+
+		_, err := newTestChain(
+			llm.ChainEntry{Provider: invalid},
+			llm.ChainEntry{Provider: fallback},
+		).CreateChatCompletion(context.Background(), request)
+
+		Expect(err).To(MatchError(ContainSubstring("invalid request")))
+		Expect(invalid.requests).To(HaveLen(1))
+		Expect(fallback.requests).To(BeEmpty())
+	})
+
+	It("doesn't retry the streams rejected by the provider", func() {
+		invalid := &fakeProvider{err: fmt.Errorf("invalid tool call")}
+		fallback := &fakeProvider{}
+
+		_, err := newTestChain(
+			llm.ChainEntry{Provider: invalid},
+			llm.ChainEntry{Provider: fallback},
+		).CreateChatCompletionStream(context.Background(), request)
+
+		Expect(err).To(MatchError("invalid tool call"))
+		Expect(invalid.requests).To(HaveLen(1))
+		Expect(fallback.requests).To(BeEmpty())
+	})
+
+	It("returns the last error when every attempt fails", func() {
+		rateLimited := &fakeProvider{err: &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Message: "slow down"}}
+
+		chain := newTestChain(llm.ChainEntry{Provider: rateLimited})
+		_, err := chain.CreateChatCompletion(context.Background(), request)
+
+		Expect(err).To(HaveOccurred())
+		Expect(llm.IsRateLimited(err)).To(BeTrue())
+		Expect(rateLimited.requests).To(HaveLen(chain.MaxAttempts))
+	})
+})
+
+var _ = Describe("IsTransient", func() {
+	It("retries server errors, rate limits and timeouts", func() {
+		Expect(llm.IsTransient(&openai.APIError{HTTPStatusCode: http.StatusBadGateway})).To(BeTrue())
+		Expect(llm.IsTransient(fmt.Errorf("request: %w", &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}))).To(BeTrue())
+		Expect(llm.IsTransient(context.DeadlineExceeded)).To(BeTrue())
+	})
+
+	It("doesn't retry client errors", func() {
+		Expect(llm.IsTransient(&openai.APIError{HTTPStatusCode: http.StatusBadRequest})).To(BeFalse())
+		Expect(llm.IsTransient(fmt.Errorf("invalid tool call"))).To(BeFalse())
+		Expect(llm.IsTransient(nil)).To(BeFalse())
+	})
+})
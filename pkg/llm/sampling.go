@@ -0,0 +1,43 @@
+package llm
+
+import (
+	"math"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// SamplingParams are the sampling settings applied to the chat completion
+// requests. Nil fields keep the default of the provider.
+type SamplingParams struct {
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        *float32 `json:"top_p,omitempty"`
+	MaxTokens   *int     `json:"max_tokens,omitempty"`
+	Seed        *int     `json:"seed,omitempty"`
+}
+
+// IsZero reports whether no sampling parameter is set
+func (s SamplingParams) IsZero() bool {
+	return s.Temperature == nil && s.TopP == nil && s.MaxTokens == nil && s.Seed == nil
+}
+
+// Apply sets the parameters on the request, without overriding the ones
+// the request already carries
+func (s SamplingParams) Apply(request *openai.ChatCompletionRequest) {
+	if s.Temperature != nil && request.Temperature == 0 {
+		request.Temperature = *s.Temperature
+		// a zero temperature would be omitted from the request
+		if request.Temperature == 0 {
+			request.Temperature = math.SmallestNonzeroFloat32
+		}
+	}
+	if s.TopP != nil && request.TopP == 0 {
+		request.TopP = *s.TopP
+	}
+	if s.MaxTokens != nil && request.MaxTokens == 0 && request.MaxCompletionTokens == 0 {
+		request.MaxTokens = *s.MaxTokens
+	}
+	if s.Seed != nil && request.Seed == nil {
+		seed := *s.Seed
+		request.Seed = &seed
+	}
+}
@@ -0,0 +1,98 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// DefaultContextWindow is used for the models we don't know the context length of
+const DefaultContextWindow = 32768
+
+// contextWindows maps model name prefixes to their context length in tokens.
+// Longer prefixes must come first.
+var contextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-4.1", 1047576},
+	{"gpt-4o", 128000},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4-32k", 32768},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo", 16385},
+	{"gpt-5", 400000},
+	{"o1", 200000},
+	{"o3", 200000},
+	{"o4", 200000},
+	{"claude", 200000},
+	{"gemini", 1048576},
+	{"llama-3.1", 131072},
+	{"llama-3.2", 131072},
+	{"llama-3.3", 131072},
+	{"llama-3", 8192},
+	{"llama-4", 1048576},
+	{"mistral-large", 131072},
+	{"mistral", 32768},
+	{"mixtral", 32768},
+	{"qwen3", 40960},
+	{"qwen", 32768},
+	{"deepseek", 65536},
+	{"grok", 131072},
+}
+
+// ContextWindow returns the context length of a model in tokens.
+// Provider prefixes (e.g. "openai/gpt-4o") are ignored.
+func ContextWindow(model string) int {
+	name := strings.ToLower(model)
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	for _, w := range contextWindows {
+		if strings.HasPrefix(name, w.prefix) {
+			return w.tokens
+		}
+	}
+	return DefaultContextWindow
+}
+
+// EstimateTokens approximates the number of tokens of a text.
+// It assumes ~4 characters per token, which is close enough for
+// the tokenizers of the common models to budget a context window.
+func EstimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// EstimateMessageTokens approximates the tokens of a chat message,
+// including the overhead of the message framing
+func EstimateMessageTokens(msg openai.ChatCompletionMessage) int {
+	tokens := 4 + EstimateTokens(msg.Content) + EstimateTokens(msg.Name)
+	for _, part := range msg.MultiContent {
+		tokens += EstimateTokens(part.Text)
+		if part.ImageURL != nil {
+			// images are billed separately, count a flat amount
+			tokens += 765
+		}
+	}
+	for _, tc := range msg.ToolCalls {
+		tokens += EstimateTokens(tc.Function.Name) + EstimateTokens(tc.Function.Arguments)
+	}
+	return tokens
+}
+
+// EstimateConversationTokens approximates the tokens of a request
+// with the given messages and tools
+func EstimateConversationTokens(messages []openai.ChatCompletionMessage, tools []openai.Tool) int {
+	tokens := 3
+	for _, msg := range messages {
+		tokens += EstimateMessageTokens(msg)
+	}
+	if len(tools) > 0 {
+		if b, err := json.Marshal(tools); err == nil {
+			tokens += EstimateTokens(string(b))
+		}
+	}
+	return tokens
+}
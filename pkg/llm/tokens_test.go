@@ -0,0 +1,67 @@
+package llm_test
+
+import (
+	"strings"
+
+	"github.com/mudler/LocalAGI/pkg/llm"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+)
+
+var _ = Describe("Token estimation", func() {
+	DescribeTable("knows the context window of the models",
+		func(model string, tokens int) {
+			Expect(llm.ContextWindow(model)).To(Equal(tokens))
+		},
+		Entry("by prefix", "gpt-4o-mini", 128000),
+		Entry("preferring the longest prefix", "gpt-4-32k-0613", 32768),
+		Entry("ignoring the provider", "openai/gpt-4.1-nano", 1047576),
+		Entry("ignoring the case", "Qwen3-8B", 40960),
+		Entry("defaulting for unknown models", "hermes-2-pro-mistral", llm.DefaultContextWindow),
+	)
+
+	It("estimates four characters per token", func() {
+		Expect(llm.EstimateTokens("")).To(Equal(0))
+		Expect(llm.EstimateTokens("abc")).To(Equal(1))
+		Expect(llm.EstimateTokens("abcd")).To(Equal(1))
+		Expect(llm.EstimateTokens("abcde")).To(Equal(2))
+	})
+
+	It("counts the framing, the tool calls and the images of a message", func() {
+		text := llm.EstimateMessageTokens(openai.ChatCompletionMessage{Role: "user", Content: strings.Repeat("a", 40)})
+		Expect(text).To(Equal(4 + 10))
+
+		call := llm.EstimateMessageTokens(openai.ChatCompletionMessage{
+			Role: "assistant",
+			ToolCalls: []openai.ToolCall{{
+				Function: openai.FunctionCall{Name: "search", Arguments: `{"query":"go"}`},
+			}},
+		})
+		Expect(call).To(Equal(4 + 2 + 4))
+
+		image := llm.EstimateMessageTokens(openai.ChatCompletionMessage{
+			Role: "user",
+			MultiContent: []openai.ChatMessagePart{
+				{Type: openai.ChatMessagePartTypeText, Text: "what is it"},
+				{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{URL: "http://image"}},
+			},
+		})
+		Expect(image).To(Equal(4 + 3 + 765))
+	})
+
+	It("counts the tools of a request", func() {
+		conv := []openai.ChatCompletionMessage{{Role: "user", Content: "hi"}}
+		withoutTools := llm.EstimateConversationTokens(conv, nil)
+		Expect(withoutTools).To(Equal(3 + 4 + 1))
+
+		withTools := llm.EstimateConversationTokens(conv, []openai.Tool{llm.JSONTool(jsonschema.Definition{
+			Type: jsonschema.Object,
+			Properties: map[string]jsonschema.Definition{
+				"answer": {Type: jsonschema.String},
+			},
+		})})
+		Expect(withTools).To(BeNumerically(">", withoutTools))
+	})
+})
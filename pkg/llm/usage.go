@@ -0,0 +1,97 @@
+package llm
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mudler/LocalAGI/db"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+)
+
+// UsageTracker accumulates the LLM calls made for a unit of work (e.g. an
+// agent job) together with their tokens and cost.
+// It travels with the request context, see WithUsageTracker.
+type UsageTracker struct {
+	mu     sync.Mutex
+	calls  int
+	tokens int
+	cost   float64
+}
+
+func (u *UsageTracker) Add(tokens int, cost float64) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.calls++
+	u.tokens += tokens
+	u.cost += cost
+}
+
+func (u *UsageTracker) Calls() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.calls
+}
+
+func (u *UsageTracker) Tokens() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.tokens
+}
+
+func (u *UsageTracker) Cost() float64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.cost
+}
+
+type usageTrackerKey struct{}
+
+// WithUsageTracker returns a context that accounts every tracked LLM call to u
+func WithUsageTracker(ctx context.Context, u *UsageTracker) context.Context {
+	return context.WithValue(ctx, usageTrackerKey{}, u)
+}
+
+// UsageTrackerFromContext returns the tracker of the context, or nil
+func UsageTrackerFromContext(ctx context.Context) *UsageTracker {
+	if ctx == nil {
+		return nil
+	}
+	u, _ := ctx.Value(usageTrackerKey{}).(*UsageTracker)
+	return u
+}
+
+// TrackUsage stores the usage of an LLM call in the database and accounts it
+// to the tracker carried by ctx, if any.
+func TrackUsage(ctx context.Context, usage *models.LLMUsage) {
+	if tracker := UsageTrackerFromContext(ctx); tracker != nil {
+		tracker.Add(usage.TotalTokens, usage.Cost)
+	}
+
+	// without a database, e.g. in tests, the usage is only accounted
+	if db.DB == nil {
+		return
+	}
+	if err := db.DB.Create(usage).Error; err != nil {
+		xlog.Error("Error tracking LLM usage", "error", err)
+	}
+}
+
+type requestTypeKey struct{}
+
+// WithRequestType returns a context whose LLM calls are recorded with the
+// given request type (e.g. the phase of an agent job)
+func WithRequestType(ctx context.Context, requestType string) context.Context {
+	return context.WithValue(ctx, requestTypeKey{}, requestType)
+}
+
+// RequestTypeFromContext returns the request type of the context, or def
+func RequestTypeFromContext(ctx context.Context, def string) string {
+	if ctx == nil {
+		return def
+	}
+	if t, ok := ctx.Value(requestTypeKey{}).(string); ok && t != "" {
+		return t
+	}
+	return def
+}
@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/mudler/LocalAGI/core/agent"
@@ -20,6 +21,7 @@ import (
 )
 
 var _ agent.RAGDB = &WrappedClient{}
+var _ agent.RAGDBEntries = &WrappedClient{}
 
 type WrappedClient struct {
 	*Client
@@ -49,6 +51,35 @@ func (c *WrappedClient) Reset() error {
 	return c.Client.Reset(c.collection)
 }
 
+// Entries lists the files stored in the collection
+func (c *WrappedClient) Entries() ([]string, error) {
+	return c.Client.ListEntries(c.collection)
+}
+
+// RemoveEntry deletes a file from the collection
+func (c *WrappedClient) RemoveEntry(entry string) error {
+	_, err := c.Client.DeleteEntry(c.collection, entry)
+	return err
+}
+
+// RemoveContent deletes the files stored from the string by Store
+func (c *WrappedClient) RemoveContent(s string) error {
+	entries, err := c.Entries()
+	if err != nil {
+		return err
+	}
+	hash := md5.Sum([]byte(s))
+	suffix := "-" + hex.EncodeToString(hash[:]) + ".txt"
+	for _, entry := range entries {
+		if strings.HasSuffix(entry, suffix) {
+			if err := c.RemoveEntry(entry); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (c *WrappedClient) Search(s string, similarity int) ([]string, error) {
 	results, err := c.Client.Search(c.collection, s, similarity)
 	if err != nil {
@@ -65,12 +96,17 @@ func (c *WrappedClient) Store(s string) error {
 	// the Client API of LocalRAG takes only files at the moment.
 	// So we take the string that we want to store, write it to a file, and then store the file.
 	t := time.Now()
-	dateTime := t.Format("2006-01-02-15-04-05")
+	dateTime := t.Format(entryTimeLayout)
 	hash := md5.Sum([]byte(s))
 	fileName := fmt.Sprintf("%s-%s.%s", dateTime, hex.EncodeToString(hash[:]), "txt")
 
 	xlog.Debug("Storing string in LocalRAG", "collection", c.collection, "fileName", fileName)
 
+	return c.storeFile(fileName, s)
+}
+
+// storeFile stores the content in the collection as a file named fileName
+func (c *WrappedClient) storeFile(fileName, s string) error {
 	tempdir, err := os.MkdirTemp("", "localrag")
 	if err != nil {
 		return err
@@ -0,0 +1,187 @@
+package localrag
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mudler/LocalAGI/pkg/vectorstore"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+)
+
+const (
+	// entryTimeLayout prefixes the names of the entries stored by the client
+	entryTimeLayout = "2006-01-02-15-04-05"
+	// entryMetadataSeparator separates the ID of a document from its
+	// metadata in the name of its entry
+	entryMetadataSeparator = "@"
+	// maxEntryName is the longest file name most file systems accept
+	maxEntryName = 255
+)
+
+// entryName returns the name of the file storing a document. LocalRAG only
+// stores files, the ID and the metadata of the document are kept in the name
+// so they can be listed and filtered without reading the files.
+func entryName(d vectorstore.Document, t time.Time) (string, error) {
+	name := t.Format(entryTimeLayout) + "-" + url.QueryEscape(d.ID)
+	if len(d.Metadata) > 0 {
+		values := url.Values{}
+		for key, value := range d.Metadata {
+			values.Set(key, value)
+		}
+		name += entryMetadataSeparator + values.Encode()
+	}
+	name += ".txt"
+
+	if len(name) > maxEntryName {
+		return "", fmt.Errorf("the ID and the metadata of document %q are too long to be stored in LocalRAG", d.ID)
+	}
+	return name, nil
+}
+
+// parseEntryName returns the ID and the metadata of the document stored in
+// an entry. The entries stored with Store are named after the hash of their
+// content, the files uploaded to LocalRAG after the file: their metadata
+// only has their source.
+func parseEntryName(name string) (string, map[string]string) {
+	metadata := map[string]string{vectorstore.MetadataSource: name}
+
+	trimmed := strings.TrimSuffix(name, ".txt")
+	if len(trimmed) <= len(entryTimeLayout)+1 {
+		return name, metadata
+	}
+	if _, err := time.Parse(entryTimeLayout, trimmed[:len(entryTimeLayout)]); err != nil {
+		return name, metadata
+	}
+
+	id, encoded, found := strings.Cut(trimmed[len(entryTimeLayout)+1:], entryMetadataSeparator)
+	if unescaped, err := url.QueryUnescape(id); err == nil {
+		id = unescaped
+	}
+	if found {
+		values, err := url.ParseQuery(encoded)
+		if err != nil {
+			return name, metadata
+		}
+		for key := range values {
+			metadata[key] = values.Get(key)
+		}
+	}
+	return id, metadata
+}
+
+// StoreDocuments stores each document as a file, documents with the ID of
+// a stored one replace it
+func (c *WrappedClient) StoreDocuments(ctx context.Context, documents []vectorstore.Document) error {
+	if err := c.DeleteDocuments(ctx, documents); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, d := range documents {
+		if d.Content == "" {
+			return fmt.Errorf("empty document: %s", d.ID)
+		}
+		fileName, err := entryName(d, now)
+		if err != nil {
+			return err
+		}
+
+		xlog.Debug("Storing document in LocalRAG", "collection", c.collection, "fileName", fileName)
+		if err := c.storeFile(fileName, d.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchDocuments returns the chunks most similar to the query among the
+// documents matching the filter. LocalRAG keeps the name of the file a
+// chunk comes from as its source, the ID and the metadata of the document
+// are read from it. LocalRAG can't filter, more candidates are searched and
+// filtered here.
+func (c *WrappedClient) SearchDocuments(ctx context.Context, query string, similarEntries int, filter vectorstore.Filter) ([]vectorstore.Result, error) {
+	maxResults := similarEntries
+	if len(filter) > 0 {
+		maxResults *= vectorstore.FilterOversampling
+	}
+
+	res, err := c.Client.Search(c.collection, query, maxResults)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]vectorstore.Result, 0, similarEntries)
+	for _, r := range res {
+		id, metadata := r.ID, r.Metadata
+		if entry := r.Metadata[vectorstore.MetadataSource]; entry != "" {
+			id, metadata = parseEntryName(entry)
+		}
+		if !filter.Match(metadata) {
+			continue
+		}
+
+		results = append(results, vectorstore.Result{
+			Document: vectorstore.Document{
+				ID:       id,
+				Content:  r.Content,
+				Metadata: metadata,
+			},
+			Similarity: r.Similarity,
+		})
+		if len(results) == similarEntries {
+			break
+		}
+	}
+	return results, nil
+}
+
+// DeleteDocuments removes the files of the documents by ID
+func (c *WrappedClient) DeleteDocuments(ctx context.Context, documents []vectorstore.Document) error {
+	if len(documents) == 0 {
+		return nil
+	}
+
+	ids := map[string]bool{}
+	for _, d := range documents {
+		ids[d.ID] = true
+	}
+
+	_, err := c.removeEntries(func(id string, _ map[string]string) bool {
+		return ids[id]
+	})
+	return err
+}
+
+// DeleteWhere removes the files of the documents matching the filter and
+// returns how many were removed
+func (c *WrappedClient) DeleteWhere(ctx context.Context, filter vectorstore.Filter) (int, error) {
+	if len(filter) == 0 {
+		return 0, fmt.Errorf("empty filter, use Reset to delete all the documents")
+	}
+
+	return c.removeEntries(func(_ string, metadata map[string]string) bool {
+		return filter.Match(metadata)
+	})
+}
+
+func (c *WrappedClient) removeEntries(match func(id string, metadata map[string]string) bool) (int, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, entry := range entries {
+		if !match(parseEntryName(entry)) {
+			continue
+		}
+		if err := c.RemoveEntry(entry); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,62 @@
+package localrag
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mudler/LocalAGI/pkg/vectorstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Entry names", func() {
+	storedAt := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	It("keeps the ID and the metadata of the documents", func() {
+		d := vectorstore.Document{
+			ID: "notes/2025 @home+work.md",
+			Metadata: map[string]string{
+				vectorstore.MetadataSource:       "https://example.com/notes?page=1&lang=en",
+				vectorstore.MetadataConversation: "support & sales",
+				vectorstore.MetadataTags:         "hr,policy",
+			},
+		}
+
+		name, err := entryName(d, storedAt)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(name).To(HavePrefix("2025-03-04-05-06-07-"))
+		Expect(name).To(HaveSuffix(".txt"))
+
+		id, metadata := parseEntryName(name)
+		Expect(id).To(Equal(d.ID))
+		Expect(metadata).To(Equal(d.Metadata))
+	})
+
+	It("names the documents without metadata after their entry", func() {
+		name, err := entryName(vectorstore.Document{ID: "memory-1"}, storedAt)
+		Expect(err).ToNot(HaveOccurred())
+
+		id, metadata := parseEntryName(name)
+		Expect(id).To(Equal("memory-1"))
+		Expect(metadata).To(Equal(map[string]string{vectorstore.MetadataSource: name}))
+	})
+
+	It("reads the files uploaded to LocalRAG as documents named after the file", func() {
+		for _, name := range []string{"report.pdf", "2025-notes.txt", "not-a-date-at-all-but-long.txt"} {
+			id, metadata := parseEntryName(name)
+			Expect(id).To(Equal(name))
+			Expect(metadata).To(Equal(map[string]string{vectorstore.MetadataSource: name}))
+		}
+	})
+
+	It("refuses the documents whose entry name would be too long", func() {
+		_, err := entryName(vectorstore.Document{ID: strings.Repeat("a", maxEntryName)}, storedAt)
+		Expect(err).To(HaveOccurred())
+
+		_, err = entryName(vectorstore.Document{
+			ID:       "short",
+			Metadata: map[string]string{vectorstore.MetadataSource: strings.Repeat("b", maxEntryName)},
+		}, storedAt)
+		Expect(err).To(HaveOccurred())
+	})
+})
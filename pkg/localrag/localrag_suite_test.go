@@ -0,0 +1,13 @@
+package localrag
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestLocalRAG(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "LocalRAG test suite")
+}
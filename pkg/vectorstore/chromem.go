@@ -18,12 +18,17 @@ type ChromemDB struct {
 	embeddingsModel string
 }
 
+// NewChromemDB returns a collection persisted in path, or kept in memory if
+// path is empty
 func NewChromemDB(collection, path string, openaiClient *openai.Client, embeddingsModel string) (*ChromemDB, error) {
-	// db, err := chromem.NewPersistentDB(path, true)
-	// if err != nil {
-	// 	return nil, err
-	// }
 	db := chromem.NewDB()
+	if path != "" {
+		persistent, err := chromem.NewPersistentDB(path, true)
+		if err != nil {
+			return nil, err
+		}
+		db = persistent
+	}
 
 	chromem := &ChromemDB{
 		collectionName:  collection,
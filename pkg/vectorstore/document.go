@@ -0,0 +1,340 @@
+package vectorstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/philippgille/chromem-go"
+)
+
+// Metadata of the documents shared by the stores
+const (
+	// MetadataSource is where the document comes from, a file name or an URL
+	MetadataSource = "source"
+	// MetadataConversation is the conversation the document was stored from
+	MetadataConversation = "conversation"
+	// MetadataTimestamp is when the document was created, in RFC 3339
+	MetadataTimestamp = "timestamp"
+	// MetadataTags are the tags of the document, separated by commas
+	MetadataTags = "tags"
+)
+
+// FilterOversampling is how many candidates per result are searched by the
+// stores which filter the results themselves
+const FilterOversampling = 4
+
+// Filter selects documents by metadata, a document matches when all the
+// values are equal. The tags of a document match when it has all the
+// filtered tags.
+type Filter map[string]string
+
+// Match reports whether the metadata match the filter
+func (f Filter) Match(metadata map[string]string) bool {
+	for key, value := range f {
+		if key == MetadataTags {
+			tags := SplitTags(metadata[MetadataTags])
+			for _, tag := range SplitTags(value) {
+				if !slices.Contains(tags, tag) {
+					return false
+				}
+			}
+			continue
+		}
+		if metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// SplitTags returns the tags of a MetadataTags value
+func SplitTags(tags string) []string {
+	var split []string
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			split = append(split, tag)
+		}
+	}
+	return split
+}
+
+// JoinTags returns the MetadataTags value of tags
+func JoinTags(tags ...string) string {
+	return strings.Join(SplitTags(strings.Join(tags, ",")), ",")
+}
+
+// Document is a text stored with its metadata
+type Document struct {
+	ID       string            `json:"id"`
+	Content  string            `json:"content"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Result is a document found by a search
+type Result struct {
+	Document
+	// Similarity is the cosine similarity of the document to the query
+	Similarity float32
+}
+
+// chromem filters on exact values, the tags of the documents are stored
+// as one metadata each
+const chromemTagPrefix = "tag:"
+
+func chromemMetadata(metadata map[string]string) map[string]string {
+	tags := SplitTags(metadata[MetadataTags])
+	if len(tags) == 0 {
+		return metadata
+	}
+	m := maps.Clone(metadata)
+	for _, tag := range tags {
+		m[chromemTagPrefix+tag] = "true"
+	}
+	return m
+}
+
+func chromemWhere(filter Filter) map[string]string {
+	if len(filter) == 0 {
+		return nil
+	}
+	where := map[string]string{}
+	for key, value := range filter {
+		if key == MetadataTags {
+			for _, tag := range SplitTags(value) {
+				where[chromemTagPrefix+tag] = "true"
+			}
+			continue
+		}
+		where[key] = value
+	}
+	return where
+}
+
+func documentMetadata(metadata map[string]string) map[string]string {
+	m := maps.Clone(metadata)
+	maps.DeleteFunc(m, func(key, _ string) bool {
+		return strings.HasPrefix(key, chromemTagPrefix)
+	})
+	return m
+}
+
+// StoreDocuments adds documents to the collection, documents with the ID of
+// a stored one replace it
+func (c *ChromemDB) StoreDocuments(ctx context.Context, documents []Document) error {
+	docs := make([]chromem.Document, 0, len(documents))
+	for _, d := range documents {
+		if d.Content == "" {
+			return fmt.Errorf("empty document: %s", d.ID)
+		}
+		docs = append(docs, chromem.Document{
+			ID:       d.ID,
+			Content:  d.Content,
+			Metadata: chromemMetadata(d.Metadata),
+		})
+	}
+	return c.collection.AddDocuments(ctx, docs, runtime.NumCPU())
+}
+
+// SearchDocuments returns the documents most similar to the query among the
+// ones matching the filter
+func (c *ChromemDB) SearchDocuments(ctx context.Context, query string, similarEntries int, filter Filter) ([]Result, error) {
+	// chromem refuses to return more results than documents
+	similarEntries = min(similarEntries, c.collection.Count())
+	if similarEntries <= 0 {
+		return nil, nil
+	}
+
+	res, err := c.collection.Query(ctx, query, similarEntries, chromemWhere(filter), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]Result, 0, len(res))
+	for _, r := range res {
+		results = append(results, Result{
+			Document: Document{
+				ID:       r.ID,
+				Content:  r.Content,
+				Metadata: documentMetadata(r.Metadata),
+			},
+			Similarity: r.Similarity,
+		})
+	}
+	return results, nil
+}
+
+// DeleteDocuments removes documents from the collection by ID
+func (c *ChromemDB) DeleteDocuments(ctx context.Context, documents []Document) error {
+	ids := make([]string, 0, len(documents))
+	for _, d := range documents {
+		ids = append(ids, d.ID)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return c.collection.Delete(ctx, nil, nil, ids...)
+}
+
+// DeleteWhere removes the documents matching the filter and returns how many
+// were removed
+func (c *ChromemDB) DeleteWhere(ctx context.Context, filter Filter) (int, error) {
+	if len(filter) == 0 {
+		return 0, fmt.Errorf("empty filter, use Reset to delete all the documents")
+	}
+	count := c.collection.Count()
+	if err := c.collection.Delete(ctx, chromemWhere(filter), nil); err != nil {
+		return 0, err
+	}
+	return count - c.collection.Count(), nil
+}
+
+// StoreDocuments adds documents to the store. The store only keeps values,
+// the documents are stored encoded as JSON. The store is keyed by
+// embeddings: the documents stored or found since the store was opened are
+// replaced by ID, the others are only replaced by a document with the same
+// content.
+func (db *LocalAIRAGDB) StoreDocuments(ctx context.Context, documents []Document) error {
+	req := SetRequest{}
+	replaced := DeleteRequest{}
+	for _, d := range documents {
+		embedding, err := db.embed(ctx, d.Content)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		req.Keys = append(req.Keys, embedding)
+		req.Values = append(req.Values, string(value))
+
+		// a new content has a new key, the previous one must go
+		if key, ok := db.indexedKey(d.ID); ok && !slices.Equal(key, embedding) {
+			replaced.Keys = append(replaced.Keys, key)
+		}
+	}
+
+	if err := db.client.Set(req); err != nil {
+		return fmt.Errorf("error setting keys: %v", err)
+	}
+	if len(replaced.Keys) > 0 {
+		if err := db.client.Delete(replaced); err != nil {
+			return fmt.Errorf("error deleting replaced keys: %v", err)
+		}
+	}
+
+	for i, d := range documents {
+		db.indexDocument(d.ID, req.Keys[i])
+	}
+	return nil
+}
+
+// SearchDocuments returns the documents most similar to the query among the
+// ones matching the filter. The store can't filter, more candidates are
+// searched and filtered here. Values stored with Store are returned as
+// documents without ID nor metadata.
+func (db *LocalAIRAGDB) SearchDocuments(ctx context.Context, query string, similarEntries int, filter Filter) ([]Result, error) {
+	embedding, err := db.embed(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	topK := similarEntries
+	if len(filter) > 0 {
+		topK *= FilterOversampling
+	}
+	findResp, err := db.client.Find(FindRequest{
+		TopK: topK,
+		Key:  embedding,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error finding keys: %v", err)
+	}
+
+	results := make([]Result, 0, similarEntries)
+	for i, value := range findResp.Values {
+		result := Result{}
+		if err := json.Unmarshal([]byte(value), &result.Document); err != nil || result.Content == "" {
+			result.Document = Document{Content: value}
+		}
+		if i < len(findResp.Similarities) {
+			result.Similarity = findResp.Similarities[i]
+		}
+		if i < len(findResp.Keys) {
+			db.indexDocument(result.ID, findResp.Keys[i])
+		}
+		if !filter.Match(result.Metadata) {
+			continue
+		}
+		if len(results) < similarEntries {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+// DeleteDocuments removes documents from the store. The store is keyed by
+// embeddings: the documents stored or found since the store was opened are
+// removed by ID, the content of the others is embedded again to find them.
+func (db *LocalAIRAGDB) DeleteDocuments(ctx context.Context, documents []Document) error {
+	req := DeleteRequest{}
+	for _, d := range documents {
+		if key, ok := db.indexedKey(d.ID); ok {
+			req.Keys = append(req.Keys, key)
+			continue
+		}
+		if d.Content == "" {
+			return fmt.Errorf("unknown document %q, its content is needed to delete it", d.ID)
+		}
+		embedding, err := db.embed(ctx, d.Content)
+		if err != nil {
+			return err
+		}
+		req.Keys = append(req.Keys, embedding)
+	}
+	if len(req.Keys) == 0 {
+		return nil
+	}
+
+	if err := db.client.Delete(req); err != nil {
+		return fmt.Errorf("error deleting keys: %v", err)
+	}
+
+	db.mu.Lock()
+	for _, d := range documents {
+		delete(db.index, d.ID)
+	}
+	db.mu.Unlock()
+	return nil
+}
+
+// DeleteWhere is unsupported: the store can't be listed, so the documents
+// matching the filter can't all be found. It returns errors.ErrUnsupported.
+func (db *LocalAIRAGDB) DeleteWhere(ctx context.Context, filter Filter) (int, error) {
+	return 0, fmt.Errorf("the LocalAI store can't be listed, delete the documents by ID: %w", errors.ErrUnsupported)
+}
+
+func (db *LocalAIRAGDB) indexDocument(id string, key []float32) {
+	if id == "" {
+		return
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.index[id] = key
+}
+
+func (db *LocalAIRAGDB) indexedKey(id string) ([]float32, bool) {
+	if id == "" {
+		return nil, false
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	key, ok := db.index[id]
+	return key, ok
+}
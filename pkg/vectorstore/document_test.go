@@ -0,0 +1,43 @@
+package vectorstore_test
+
+import (
+	"github.com/mudler/LocalAGI/pkg/vectorstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filter", func() {
+	metadata := map[string]string{
+		vectorstore.MetadataSource:       "handbook.md",
+		vectorstore.MetadataConversation: "support",
+		vectorstore.MetadataTags:         "policy, hr,onboarding",
+	}
+
+	It("should match everything when empty", func() {
+		Expect(vectorstore.Filter(nil).Match(metadata)).To(BeTrue())
+		Expect(vectorstore.Filter{}.Match(nil)).To(BeTrue())
+	})
+
+	It("should match when all the values are equal", func() {
+		Expect(vectorstore.Filter{vectorstore.MetadataSource: "handbook.md"}.Match(metadata)).To(BeTrue())
+		Expect(vectorstore.Filter{
+			vectorstore.MetadataSource:       "handbook.md",
+			vectorstore.MetadataConversation: "sales",
+		}.Match(metadata)).To(BeFalse())
+		Expect(vectorstore.Filter{vectorstore.MetadataSource: "handbook.md"}.Match(nil)).To(BeFalse())
+	})
+
+	It("should match when the document has all the filtered tags", func() {
+		Expect(vectorstore.Filter{vectorstore.MetadataTags: "hr"}.Match(metadata)).To(BeTrue())
+		Expect(vectorstore.Filter{vectorstore.MetadataTags: "onboarding,policy"}.Match(metadata)).To(BeTrue())
+		Expect(vectorstore.Filter{vectorstore.MetadataTags: "hr,finance"}.Match(metadata)).To(BeFalse())
+	})
+})
+
+var _ = Describe("Tags", func() {
+	It("should trim and drop the empty tags", func() {
+		Expect(vectorstore.SplitTags(" a, ,b,")).To(Equal([]string{"a", "b"}))
+		Expect(vectorstore.SplitTags("")).To(BeEmpty())
+		Expect(vectorstore.JoinTags("a, b", "", "c")).To(Equal("a,b,c"))
+	})
+})
@@ -0,0 +1,163 @@
+package vectorstore_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mudler/LocalAGI/pkg/vectorstore"
+	"github.com/sashabaranov/go-openai"
+)
+
+// fakeLocalAI serves the embeddings and the stores API of LocalAI. The
+// embeddings are derived from the words of the text, so texts sharing words
+// are similar.
+type fakeLocalAI struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	keys   map[string][]float32
+	values map[string]string
+}
+
+func newFakeLocalAI() *fakeLocalAI {
+	f := &fakeLocalAI{keys: map[string][]float32{}, values: map[string]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/embeddings", f.embeddings)
+	mux.HandleFunc("/stores/set", f.set)
+	mux.HandleFunc("/stores/delete", f.delete)
+	mux.HandleFunc("/stores/find", f.find)
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakeLocalAI) openAIClient() *openai.Client {
+	config := openai.DefaultConfig("")
+	config.BaseURL = f.URL
+	return openai.NewClientWithConfig(config)
+}
+
+func (f *fakeLocalAI) localAIRAGDB() *vectorstore.LocalAIRAGDB {
+	return vectorstore.NewLocalAIRAGDB(vectorstore.NewStoreClient(f.URL, ""), f.openAIClient(), "fake")
+}
+
+// Values returns the values in the store
+func (f *fakeLocalAI) Values() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	values := []string{}
+	for _, v := range f.values {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func wordsEmbedding(s string) []float32 {
+	embedding := make([]float32, 32)
+	for _, word := range strings.Fields(strings.ToLower(s)) {
+		h := fnv.New32a()
+		h.Write([]byte(word))
+		embedding[h.Sum32()%32]++
+	}
+	embedding[0] += 0.01
+
+	norm := float32(0)
+	for _, v := range embedding {
+		norm += v * v
+	}
+	norm = float32(math.Sqrt(float64(norm)))
+	for i := range embedding {
+		embedding[i] /= norm
+	}
+	return embedding
+}
+
+func storeKey(key []float32) string {
+	return fmt.Sprint(key)
+}
+
+func (f *fakeLocalAI) embeddings(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Input []string `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := openai.EmbeddingResponse{Object: "list"}
+	for i, input := range req.Input {
+		resp.Data = append(resp.Data, openai.Embedding{Object: "embedding", Index: i, Embedding: wordsEmbedding(input)})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (f *fakeLocalAI) set(w http.ResponseWriter, r *http.Request) {
+	var req vectorstore.SetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, key := range req.Keys {
+		f.keys[storeKey(key)] = key
+		f.values[storeKey(key)] = req.Values[i]
+	}
+}
+
+func (f *fakeLocalAI) delete(w http.ResponseWriter, r *http.Request) {
+	var req vectorstore.DeleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range req.Keys {
+		delete(f.keys, storeKey(key))
+		delete(f.values, storeKey(key))
+	}
+}
+
+func (f *fakeLocalAI) find(w http.ResponseWriter, r *http.Request) {
+	var req vectorstore.FindRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	type match struct {
+		key        string
+		similarity float32
+	}
+	matches := []match{}
+	for k, key := range f.keys {
+		similarity := float32(0)
+		for i := range key {
+			similarity += key[i] * req.Key[i]
+		}
+		matches = append(matches, match{key: k, similarity: similarity})
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].similarity > matches[j].similarity })
+
+	resp := vectorstore.FindResponse{}
+	for _, m := range matches[:min(req.TopK, len(matches))] {
+		resp.Keys = append(resp.Keys, f.keys[m.key])
+		resp.Values = append(resp.Values, f.values[m.key])
+		resp.Similarities = append(resp.Similarities, m.similarity)
+	}
+	f.mu.Unlock()
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -3,19 +3,31 @@ package vectorstore
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/sashabaranov/go-openai"
 )
 
 type LocalAIRAGDB struct {
-	client       *StoreClient
-	openaiClient *openai.Client
+	client          *StoreClient
+	openaiClient    *openai.Client
+	embeddingsModel string
+
+	// the store is keyed by embeddings, the keys of the documents are
+	// remembered to replace and delete them by ID
+	mu    sync.Mutex
+	index map[string][]float32
 }
 
-func NewLocalAIRAGDB(storeClient *StoreClient, openaiClient *openai.Client) *LocalAIRAGDB {
+func NewLocalAIRAGDB(storeClient *StoreClient, openaiClient *openai.Client, embeddingsModel string) *LocalAIRAGDB {
+	if embeddingsModel == "" {
+		embeddingsModel = string(openai.AdaEmbeddingV2)
+	}
 	return &LocalAIRAGDB{
-		client:       storeClient,
-		openaiClient: openaiClient,
+		client:          storeClient,
+		openaiClient:    openaiClient,
+		embeddingsModel: embeddingsModel,
+		index:           map[string][]float32{},
 	}
 }
 
@@ -27,22 +39,29 @@ func (db *LocalAIRAGDB) Count() int {
 	return 0
 }
 
-func (db *LocalAIRAGDB) Store(s string) error {
-	resp, err := db.openaiClient.CreateEmbeddings(context.TODO(),
+func (db *LocalAIRAGDB) embed(ctx context.Context, s string) ([]float32, error) {
+	resp, err := db.openaiClient.CreateEmbeddings(ctx,
 		openai.EmbeddingRequestStrings{
 			Input: []string{s},
-			Model: openai.AdaEmbeddingV2,
+			Model: openai.EmbeddingModel(db.embeddingsModel),
 		},
 	)
 	if err != nil {
-		return fmt.Errorf("error getting keys: %v", err)
+		return nil, fmt.Errorf("error getting keys: %v", err)
 	}
 
 	if len(resp.Data) == 0 {
-		return fmt.Errorf("no response from OpenAI API")
+		return nil, fmt.Errorf("no response from OpenAI API")
 	}
 
-	embedding := resp.Data[0].Embedding
+	return resp.Data[0].Embedding, nil
+}
+
+func (db *LocalAIRAGDB) Store(s string) error {
+	embedding, err := db.embed(context.TODO(), s)
+	if err != nil {
+		return err
+	}
 
 	setReq := SetRequest{
 		Keys:   [][]float32{embedding},
@@ -57,20 +76,10 @@ func (db *LocalAIRAGDB) Store(s string) error {
 }
 
 func (db *LocalAIRAGDB) Search(s string, similarEntries int) ([]string, error) {
-	resp, err := db.openaiClient.CreateEmbeddings(context.TODO(),
-		openai.EmbeddingRequestStrings{
-			Input: []string{s},
-			Model: openai.AdaEmbeddingV2,
-		},
-	)
+	embedding, err := db.embed(context.TODO(), s)
 	if err != nil {
-		return []string{}, fmt.Errorf("error getting keys: %v", err)
-	}
-
-	if len(resp.Data) == 0 {
-		return []string{}, fmt.Errorf("no response from OpenAI API")
+		return []string{}, err
 	}
-	embedding := resp.Data[0].Embedding
 
 	// Find example
 	findReq := FindRequest{
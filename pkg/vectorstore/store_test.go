@@ -0,0 +1,154 @@
+package vectorstore_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mudler/LocalAGI/pkg/vectorstore"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type documentStore interface {
+	StoreDocuments(ctx context.Context, documents []vectorstore.Document) error
+	SearchDocuments(ctx context.Context, query string, similarEntries int, filter vectorstore.Filter) ([]vectorstore.Result, error)
+	DeleteDocuments(ctx context.Context, documents []vectorstore.Document) error
+	DeleteWhere(ctx context.Context, filter vectorstore.Filter) (int, error)
+}
+
+func resultIDs(results []vectorstore.Result) []string {
+	ids := []string{}
+	for _, r := range results {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+var documents = []vectorstore.Document{
+	{ID: "vacation", Content: "employees have twenty days of paid vacation", Metadata: map[string]string{
+		vectorstore.MetadataSource: "handbook.md",
+		vectorstore.MetadataTags:   "hr,policy",
+	}},
+	{ID: "laptops", Content: "employees get a laptop on their first day", Metadata: map[string]string{
+		vectorstore.MetadataSource: "handbook.md",
+		vectorstore.MetadataTags:   "it",
+	}},
+	{ID: "pricing", Content: "the product costs ten dollars per month", Metadata: map[string]string{
+		vectorstore.MetadataSource: "sales.md",
+	}},
+}
+
+var _ = Describe("Document stores", func() {
+	var (
+		ctx    context.Context
+		server *fakeLocalAI
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		server = newFakeLocalAI()
+		DeferCleanup(server.Close)
+	})
+
+	for name, open := range map[string]func() documentStore{
+		"ChromemDB": func() documentStore {
+			db, err := vectorstore.NewChromemDB("test", "", server.openAIClient(), "fake")
+			Expect(err).ToNot(HaveOccurred())
+			return db
+		},
+		"LocalAIRAGDB": func() documentStore {
+			return server.localAIRAGDB()
+		},
+	} {
+		Context(name, func() {
+			var store documentStore
+
+			BeforeEach(func() {
+				store = open()
+				Expect(store.StoreDocuments(ctx, documents)).To(Succeed())
+			})
+
+			It("finds the documents with their ID and metadata", func() {
+				results, err := store.SearchDocuments(ctx, "how many vacation days do employees have", 1, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(results).To(HaveLen(1))
+				Expect(results[0].Document).To(Equal(documents[0]))
+				Expect(results[0].Similarity).To(BeNumerically(">", 0))
+			})
+
+			It("finds only the documents matching the filter", func() {
+				results, err := store.SearchDocuments(ctx, "employees vacation", 3, vectorstore.Filter{vectorstore.MetadataTags: "it"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resultIDs(results)).To(Equal([]string{"laptops"}))
+
+				results, err = store.SearchDocuments(ctx, "employees vacation", 3, vectorstore.Filter{vectorstore.MetadataSource: "sales.md"})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resultIDs(results)).To(Equal([]string{"pricing"}))
+			})
+
+			It("replaces the documents with the same ID", func() {
+				updated := vectorstore.Document{ID: "pricing", Content: "the product costs twenty dollars per month"}
+				Expect(store.StoreDocuments(ctx, []vectorstore.Document{updated})).To(Succeed())
+
+				results, err := store.SearchDocuments(ctx, "product costs dollars per month", 3, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resultIDs(results)).To(ConsistOf("pricing", "vacation", "laptops"))
+				Expect(results[0].Content).To(Equal(updated.Content))
+			})
+
+			It("deletes the documents by ID", func() {
+				Expect(store.DeleteDocuments(ctx, []vectorstore.Document{{ID: "vacation"}})).To(Succeed())
+
+				results, err := store.SearchDocuments(ctx, "vacation", 3, nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resultIDs(results)).To(ConsistOf("laptops", "pricing"))
+			})
+		})
+	}
+
+	Context("ChromemDB", func() {
+		It("deletes the documents matching a filter", func() {
+			db, err := vectorstore.NewChromemDB("test", "", server.openAIClient(), "fake")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(db.StoreDocuments(ctx, documents)).To(Succeed())
+
+			_, err = db.DeleteWhere(ctx, nil)
+			Expect(err).To(HaveOccurred())
+
+			removed, err := db.DeleteWhere(ctx, vectorstore.Filter{vectorstore.MetadataSource: "handbook.md"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(removed).To(Equal(2))
+			Expect(db.Count()).To(Equal(1))
+		})
+	})
+
+	Context("LocalAIRAGDB", func() {
+		It("removes the previous value of a replaced document from the store", func() {
+			db := server.localAIRAGDB()
+			Expect(db.StoreDocuments(ctx, documents)).To(Succeed())
+			Expect(server.Values()).To(HaveLen(3))
+
+			Expect(db.StoreDocuments(ctx, []vectorstore.Document{{ID: "pricing", Content: "the product is free"}})).To(Succeed())
+			Expect(server.Values()).To(HaveLen(3))
+			Expect(server.Values()).ToNot(ContainElement(ContainSubstring("ten dollars")))
+		})
+
+		It("deletes the documents stored before it was opened by content", func() {
+			Expect(server.localAIRAGDB().StoreDocuments(ctx, documents)).To(Succeed())
+
+			db := server.localAIRAGDB()
+			Expect(db.DeleteDocuments(ctx, []vectorstore.Document{{ID: "pricing"}})).To(HaveOccurred())
+			Expect(db.DeleteDocuments(ctx, []vectorstore.Document{documents[2]})).To(Succeed())
+			Expect(server.Values()).To(HaveLen(2))
+		})
+
+		It("can't delete the documents matching a filter", func() {
+			db := server.localAIRAGDB()
+			Expect(db.StoreDocuments(ctx, documents)).To(Succeed())
+
+			_, err := db.DeleteWhere(ctx, vectorstore.Filter{vectorstore.MetadataSource: "handbook.md"})
+			Expect(errors.Is(err, errors.ErrUnsupported)).To(BeTrue())
+			Expect(server.Values()).To(HaveLen(3))
+		})
+	})
+})
@@ -0,0 +1,37 @@
+package xstrings
+
+import "unicode/utf8"
+
+// Truncate returns the longest prefix of s of at most maxBytes bytes which
+// doesn't split a multi-byte character
+func Truncate(s string, maxBytes int) string {
+	if maxBytes <= 0 {
+		return ""
+	}
+	if len(s) <= maxBytes {
+		return s
+	}
+
+	end := maxBytes
+	for end > 0 && !utf8.RuneStart(s[end]) {
+		end--
+	}
+	return s[:end]
+}
+
+// TruncateStart returns the longest suffix of s of at most maxBytes bytes
+// which doesn't split a multi-byte character
+func TruncateStart(s string, maxBytes int) string {
+	if maxBytes <= 0 {
+		return ""
+	}
+	if len(s) <= maxBytes {
+		return s
+	}
+
+	start := len(s) - maxBytes
+	for start < len(s) && !utf8.RuneStart(s[start]) {
+		start++
+	}
+	return s[start:]
+}
@@ -0,0 +1,41 @@
+package xstrings_test
+
+import (
+	"unicode/utf8"
+
+	xtrings "github.com/mudler/LocalAGI/pkg/xstrings"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Truncate", func() {
+	It("should return the text if it's shorter than the limit", func() {
+		Expect(xtrings.Truncate("short", 10)).To(Equal("short"))
+		Expect(xtrings.TruncateStart("short", 10)).To(Equal("short"))
+	})
+
+	It("should cut the text at the limit", func() {
+		Expect(xtrings.Truncate("abcdef", 3)).To(Equal("abc"))
+		Expect(xtrings.TruncateStart("abcdef", 3)).To(Equal("def"))
+	})
+
+	It("should not split multi-byte characters", func() {
+		text := "añb€c"
+		for n := 0; n <= len(text); n++ {
+			head := xtrings.Truncate(text, n)
+			Expect(utf8.ValidString(head)).To(BeTrue())
+			Expect(len(head)).To(BeNumerically("<=", n))
+
+			tail := xtrings.TruncateStart(text, n)
+			Expect(utf8.ValidString(tail)).To(BeTrue())
+			Expect(len(tail)).To(BeNumerically("<=", n))
+		}
+		Expect(xtrings.Truncate(text, 5)).To(Equal("añb"))
+		Expect(xtrings.TruncateStart(text, 3)).To(Equal("c"))
+	})
+
+	It("should return nothing for a limit of zero", func() {
+		Expect(xtrings.Truncate("abc", 0)).To(BeEmpty())
+		Expect(xtrings.TruncateStart("abc", 0)).To(BeEmpty())
+	})
+})
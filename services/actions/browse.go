@@ -157,3 +157,8 @@ func (a *BrowseAction) Definition() types.ActionDefinition {
 func (a *BrowseAction) Plannable() bool {
 	return true
 }
+
+// IsIdempotent is true as the action only reads data
+func (a *BrowseAction) IsIdempotent() bool {
+	return true
+}
@@ -58,3 +58,8 @@ func (a *ScraperAction) Definition() types.ActionDefinition {
 func (a *ScraperAction) Plannable() bool {
 	return true
 }
+
+// IsIdempotent is true as the action only reads data
+func (a *ScraperAction) IsIdempotent() bool {
+	return true
+}
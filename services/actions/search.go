@@ -104,6 +104,11 @@ func (a *SearchAction) Plannable() bool {
 	return true
 }
 
+// IsIdempotent is true as the action only reads data
+func (a *SearchAction) IsIdempotent() bool {
+	return true
+}
+
 // SearchConfigMeta returns the metadata for Search action configuration fields
 func SearchConfigMeta() []config.Field {
 	return []config.Field{
@@ -52,3 +52,8 @@ func (a *WikipediaAction) Definition() types.ActionDefinition {
 func (a *WikipediaAction) Plannable() bool {
 	return true
 }
+
+// IsIdempotent is true as the action only reads data
+func (a *WikipediaAction) IsIdempotent() bool {
+	return true
+}
@@ -0,0 +1,17 @@
+package connectors
+
+import (
+	"fmt"
+
+	"github.com/mudler/LocalAGI/core/types"
+)
+
+// approvalMessage is the text sent to the users of a connector when an
+// action of their job waits for approval
+func approvalMessage(request types.ApprovalRequest) string {
+	msg := fmt.Sprintf("Approval required to run %s with parameters:\n%s", request.Action, request.Params.String())
+	if request.Reasoning != "" {
+		msg += "\n\nReasoning: " + request.Reasoning
+	}
+	return msg + fmt.Sprintf("\n\nApprove, modify or deny the request %s from the agent page.", request.ID)
+}
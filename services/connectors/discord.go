@@ -156,6 +156,7 @@ func (d *Discord) handleThreadMessage(a *agent.Agent, s *discordgo.Session, m *d
 
 	jobResult := a.Ask(
 		types.WithConversationHistory(conv),
+		types.WithConversationKey(fmt.Sprintf("discord:%s", m.ChannelID)),
 	)
 
 	if jobResult.Error != nil {
@@ -180,6 +181,7 @@ func (d *Discord) handleChannelMessage(a *agent.Agent, s *discordgo.Session, m *
 
 	jobResult := a.Ask(
 		types.WithConversationHistory(conv),
+		types.WithConversationKey(fmt.Sprintf("discord:%s", m.ChannelID)),
 	)
 
 	if jobResult.Error != nil {
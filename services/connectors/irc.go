@@ -172,6 +172,7 @@ func (i *IRC) Start(a *agent.Agent) {
 
 			res := a.Ask(
 				types.WithConversationHistory(conv),
+				types.WithConversationKey(fmt.Sprintf("irc:%s", channel)),
 			)
 
 			if res.Response == "" {
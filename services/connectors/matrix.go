@@ -164,6 +164,7 @@ func (m *Matrix) handleRoomMessage(a *agent.Agent, evt *event.Event) {
 			"room": evt.RoomID.String(),
 		}
 		agentOptions = append(agentOptions, types.WithMetadata(metadata))
+		agentOptions = append(agentOptions, types.WithConversationKey(fmt.Sprintf("matrix:%s", evt.RoomID.String())))
 
 		job := types.NewJob(agentOptions...)
 
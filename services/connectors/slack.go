@@ -70,6 +70,24 @@ func (t *Slack) AgentResultCallback() func(state types.ActionState) {
 	}
 }
 
+func (t *Slack) AgentApprovalCallback() func(request types.ApprovalRequest) {
+	return func(request types.ApprovalRequest) {
+		if request.Job == nil || request.Job.Metadata == nil || t.apiClient == nil {
+			return
+		}
+		channel, ok := request.Job.Metadata["channel"].(string)
+		if !ok || channel == "" {
+			return
+		}
+
+		if _, _, err := t.apiClient.PostMessage(channel,
+			slack.MsgOptionText(githubmarkdownconvertergo.Slack(approvalMessage(request)), false),
+		); err != nil {
+			xlog.Error("Error sending approval request", "error", err)
+		}
+	}
+}
+
 func (t *Slack) AgentReasoningCallback() func(state types.ActionCurrentState) bool {
 	return func(state types.ActionCurrentState) bool {
 		// Check if we have a placeholder message for this job
@@ -416,6 +434,7 @@ func (t *Slack) handleChannelMessage(
 			"channel": ev.Channel,
 		}
 		agentOptions = append(agentOptions, types.WithMetadata(metadata))
+		agentOptions = append(agentOptions, types.WithConversationKey(fmt.Sprintf("slack:%s", ev.Channel)))
 
 		job := types.NewJob(agentOptions...)
 
@@ -681,10 +700,16 @@ func (t *Slack) handleMention(
 		}
 
 		// Call the agent with the conversation history
+		threadKey := ts
+		if threadKey == "" {
+			threadKey = ev.TimeStamp
+		}
+
 		res := a.Ask(
 			types.WithConversationHistory(threadMessages),
 			types.WithUUID(jobUUID),
 			types.WithMetadata(metadata),
+			types.WithConversationKey(fmt.Sprintf("slack:%s:%s", ev.Channel, threadKey)),
 		)
 
 		if res.Response == "" {
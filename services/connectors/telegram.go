@@ -222,6 +222,7 @@ func (t *Telegram) handleGroupMessage(ctx context.Context, b *bot.Bot, a *agent.
 		types.WithConversationHistory(currentConv),
 		types.WithUUID(jobUUID),
 		types.WithMetadata(metadata),
+		types.WithConversationKey(fmt.Sprintf("telegram:%d", update.Message.Chat.ID)),
 	)
 
 	if res.Response == "" {
@@ -312,6 +313,25 @@ func (t *Telegram) AgentResultCallback() func(state types.ActionState) {
 	}
 }
 
+func (t *Telegram) AgentApprovalCallback() func(request types.ApprovalRequest) {
+	return func(request types.ApprovalRequest) {
+		if request.Job == nil || request.Job.Metadata == nil || t.bot == nil {
+			return
+		}
+		chatID, ok := request.Job.Metadata["chatID"].(int64)
+		if !ok || chatID == 0 {
+			return
+		}
+
+		if _, err := t.bot.SendMessage(t.agent.Context(), &bot.SendMessageParams{
+			ChatID: chatID,
+			Text:   approvalMessage(request),
+		}); err != nil {
+			xlog.Error("Error sending approval request", "error", err)
+		}
+	}
+}
+
 func (t *Telegram) AgentReasoningCallback() func(state types.ActionCurrentState) bool {
 	return func(state types.ActionCurrentState) bool {
 		// Check if we have a placeholder message for this job
@@ -574,6 +594,7 @@ func (t *Telegram) handleUpdate(ctx context.Context, b *bot.Bot, a *agent.Agent,
 		types.WithConversationHistory(currentConv),
 		types.WithUUID(jobUUID),
 		types.WithMetadata(metadata),
+		types.WithConversationKey(fmt.Sprintf("telegram:%d", update.Message.Chat.ID)),
 	)
 
 	if res.Response == "" {
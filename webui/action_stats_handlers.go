@@ -0,0 +1,42 @@
+package webui
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAGI/core/agent"
+	models "github.com/mudler/LocalAGI/dbmodels"
+)
+
+// defaultActionStatsWindow is how far back the action stats go by default
+const defaultActionStatsWindow = 7 * 24 * time.Hour
+
+// GetActionStats returns the reliability of the actions run by an agent over
+// the ?window= duration (7 days by default)
+func (a *App) GetActionStats() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agentModel, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agentModel == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		window := defaultActionStatsWindow
+		if w := c.Query("window"); w != "" {
+			d, err := time.ParseDuration(w)
+			if err != nil || d <= 0 {
+				return errorJSONMessage(c, "Invalid window: "+w)
+			}
+			window = d
+		}
+
+		stats, err := agent.LoadActionStats(agentModel.ID, time.Now().Add(-window))
+		if err != nil {
+			return errorJSONMessage(c, err.Error())
+		}
+
+		return c.JSON(fiber.Map{
+			"window":  window.String(),
+			"actions": stats,
+		})
+	}
+}
@@ -7,13 +7,16 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	coreAgent "github.com/mudler/LocalAGI/core/agent"
 	"github.com/mudler/LocalAGI/core/conversations"
 	"github.com/mudler/LocalAGI/core/serverwallet"
 	coreTypes "github.com/mudler/LocalAGI/core/types"
@@ -76,8 +79,10 @@ func init() {
 type (
 	App struct {
 		UserPools map[string]*state.AgentPool
-		htmx      *htmx.HTMX
-		config    *Config
+		// poolsMutex guards UserPools, the handlers run concurrently
+		poolsMutex sync.RWMutex
+		htmx       *htmx.HTMX
+		config     *Config
 		*fiber.App
 		sharedState *coreTypes.AgentSharedState
 	}
@@ -103,9 +108,106 @@ func NewApp(opts ...Option) *App {
 
 	a.registerRoutes(webapp)
 
+	go a.resumeInterruptedJobs()
+
 	return a
 }
 
+// resumeInterruptedJobs loads the pools of the users that have jobs which
+// were still running, parked or waiting for a retry when the server stopped,
+// and resumes them.
+func (a *App) resumeInterruptedJobs() {
+	var userIDs []uuid.UUID
+	if err := db.DB.Model(&models.JobCheckpoint{}).
+		Where("Status = ?", coreAgent.JobStatusRunning).
+		Distinct().
+		Pluck("UserID", &userIDs).Error; err != nil {
+		xlog.Error("Failed to load interrupted jobs", "error", err)
+		return
+	}
+
+	var parkedUserIDs []uuid.UUID
+	if err := db.DB.Model(&models.ParkedJob{}).
+		Distinct().
+		Pluck("UserID", &parkedUserIDs).Error; err != nil {
+		xlog.Error("Failed to load parked jobs", "error", err)
+		return
+	}
+	for _, id := range parkedUserIDs {
+		if !slices.Contains(userIDs, id) {
+			userIDs = append(userIDs, id)
+		}
+	}
+
+	var retryingUserIDs []uuid.UUID
+	if err := db.DB.Model(&models.FailedJob{}).
+		Where("Status = ?", coreAgent.FailedJobRetrying).
+		Distinct().
+		Pluck("UserID", &retryingUserIDs).Error; err != nil {
+		xlog.Error("Failed to load failed jobs", "error", err)
+		return
+	}
+	for _, id := range retryingUserIDs {
+		if !slices.Contains(userIDs, id) {
+			userIDs = append(userIDs, id)
+		}
+	}
+
+	for _, userID := range userIDs {
+		userIDStr := userID.String()
+
+		pool, err := a.loadUserPool(userIDStr)
+		if err != nil {
+			xlog.Error("Failed to load agent pool for interrupted jobs", "user", userIDStr, "error", err)
+			continue
+		}
+
+		if err := pool.ResumeInterruptedJobs(); err != nil {
+			xlog.Error("Failed to resume interrupted jobs", "user", userIDStr, "error", err)
+		}
+	}
+}
+
+// userPool returns the pool of the agents of a user loaded in memory
+func (a *App) userPool(userID string) (*state.AgentPool, bool) {
+	a.poolsMutex.RLock()
+	defer a.poolsMutex.RUnlock()
+	pool, ok := a.UserPools[userID]
+	return pool, ok
+}
+
+// loadUserPool returns the pool of the agents of a user, loading it from
+// the database if it isn't in memory yet
+func (a *App) loadUserPool(userID string) (*state.AgentPool, error) {
+	a.poolsMutex.Lock()
+	defer a.poolsMutex.Unlock()
+
+	if pool, ok := a.UserPools[userID]; ok {
+		return pool, nil
+	}
+
+	pool, err := state.NewAgentPool(
+		userID,
+		"", // Always use model from agent config
+		os.Getenv("LOCALAGI_MULTIMODAL_MODEL"),
+		os.Getenv("LOCALAGI_IMAGE_MODEL"),
+		os.Getenv("LOCALAGI_LOCALRAG_URL"),
+		services.Actions(map[string]string{
+			services.ActionConfigSSHBoxURL: os.Getenv("LOCALAGI_SSHBOX_URL"),
+		}),
+		services.Connectors,
+		services.DynamicPrompts,
+		services.Filters,
+		os.Getenv("LOCALAGI_TIMEOUT"),
+		os.Getenv("LOCALAGI_ENABLE_CONVERSATIONS_LOGGING") == "true",
+	)
+	if err != nil {
+		return nil, err
+	}
+	a.UserPools[userID] = pool
+	return pool, nil
+}
+
 func (a *App) Notify(pool *state.AgentPool) func(c *fiber.Ctx) error {
 	return func(c *fiber.Ctx) error {
 		payload := struct {
@@ -156,7 +258,7 @@ func (a *App) Delete() func(c *fiber.Ctx) error {
 		}
 
 		// 3. Remove from in-memory pool if exists
-		if pool, ok := a.UserPools[userIDStr]; ok {
+		if pool, ok := a.userPool(userIDStr); ok {
 			if err := pool.Remove(agentId); err != nil {
 				xlog.Warn("Agent archived in DB but failed to remove from memory", "error", err)
 			}
@@ -209,29 +311,9 @@ func (a *App) Pause() func(c *fiber.Ctx) error {
 		agentId := agent.ID.String()
 
 		// 2. Get or init pool
-		pool, ok := a.UserPools[userIDStr]
-		if !ok {
-			// Rehydrate pool from DB (no file fallback)
-			newPool, err := state.NewAgentPool(
-				userIDStr,
-				"", // Always use model from agent config
-				os.Getenv("LOCALAGI_MULTIMODAL_MODEL"),
-				os.Getenv("LOCALAGI_IMAGE_MODEL"),
-				os.Getenv("LOCALAGI_LOCALRAG_URL"),
-				services.Actions(map[string]string{
-					services.ActionConfigSSHBoxURL: os.Getenv("LOCALAGI_SSHBOX_URL"),
-				}),
-				services.Connectors,
-				services.DynamicPrompts,
-				services.Filters,
-				os.Getenv("LOCALAGI_TIMEOUT"),
-				os.Getenv("LOCALAGI_ENABLE_CONVERSATIONS_LOGGING") == "true",
-			)
-			if err != nil {
-				return errorJSONMessage(c, "Failed to load agent pool: "+err.Error())
-			}
-			a.UserPools[userIDStr] = newPool
-			pool = newPool
+		pool, err := a.loadUserPool(userIDStr)
+		if err != nil {
+			return errorJSONMessage(c, "Failed to load agent pool: "+err.Error())
 		}
 
 		// 3. Pause agent if exists in memory
@@ -262,28 +344,9 @@ func (a *App) Start() func(c *fiber.Ctx) error {
 		agentId := agent.ID.String()
 
 		// 2. Load or create in-memory pool
-		pool, ok := a.UserPools[userIDStr]
-		if !ok {
-			newPool, err := state.NewAgentPool(
-				userIDStr,
-				"", // Always use model from agent config
-				os.Getenv("LOCALAGI_MULTIMODAL_MODEL"),
-				os.Getenv("LOCALAGI_IMAGE_MODEL"),
-				os.Getenv("LOCALAGI_LOCALRAG_URL"),
-				services.Actions(map[string]string{
-					services.ActionConfigSSHBoxURL: os.Getenv("LOCALAGI_SSHBOX_URL"),
-				}),
-				services.Connectors,
-				services.DynamicPrompts,
-				services.Filters,
-				os.Getenv("LOCALAGI_TIMEOUT"),
-				os.Getenv("LOCALAGI_ENABLE_CONVERSATIONS_LOGGING") == "true",
-			)
-			if err != nil {
-				return errorJSONMessage(c, "Failed to load agent pool: "+err.Error())
-			}
-			a.UserPools[userIDStr] = newPool
-			pool = newPool
+		pool, err := a.loadUserPool(userIDStr)
+		if err != nil {
+			return errorJSONMessage(c, "Failed to load agent pool: "+err.Error())
 		}
 
 		// 3. Try to get the agent from memory
@@ -397,29 +460,9 @@ func (a *App) Create() func(c *fiber.Ctx) error {
 			return errorJSONMessage(c, "Failed to store agent: "+err.Error())
 		}
 
-		var pool *state.AgentPool
-		if p, ok := a.UserPools[userIDStr]; ok {
-			pool = p
-		} else {
-			pool, err = state.NewAgentPool(
-				userIDStr,
-				"", // Always use model from agent config
-				os.Getenv("LOCALAGI_MULTIMODAL_MODEL"),
-				os.Getenv("LOCALAGI_IMAGE_MODEL"),
-				os.Getenv("LOCALAGI_LOCALRAG_URL"),
-				services.Actions(map[string]string{
-					services.ActionConfigSSHBoxURL: os.Getenv("LOCALAGI_SSHBOX_URL"),
-				}),
-				services.Connectors,
-				services.DynamicPrompts,
-				services.Filters,
-				os.Getenv("LOCALAGI_TIMEOUT"),
-				os.Getenv("LOCALAGI_ENABLE_CONVERSATIONS_LOGGING") == "true",
-			)
-			if err != nil {
-				return errorJSONMessage(c, "Failed to create agent pool: "+err.Error())
-			}
-			a.UserPools[userIDStr] = pool
+		pool, err := a.loadUserPool(userIDStr)
+		if err != nil {
+			return errorJSONMessage(c, "Failed to create agent pool: "+err.Error())
 		}
 
 		if err := pool.CreateAgent(id.String(), &config); err != nil {
@@ -650,7 +693,7 @@ func (a *App) UpdateAgentConfig() func(c *fiber.Ctx) error {
 			return errorJSONMessage(c, "Failed to update config in DB: "+err.Error())
 		}
 
-		pool, ok := a.UserPools[userIDStr]
+		pool, ok := a.userPool(userIDStr)
 		if ok {
 			wasRunning := false
 			if existingAgent := pool.GetAgent(agentId); existingAgent != nil {
@@ -719,7 +762,7 @@ func (a *App) UpdateAgentPayLimits() func(c *fiber.Ctx) error {
 			return errorJSONMessage(c, "Failed to update pay limits in DB: "+err.Error())
 		}
 
-		pool, ok := a.UserPools[userIDStr]
+		pool, ok := a.userPool(userIDStr)
 		if ok {
 			wasRunning := false
 			if existingAgent := pool.GetAgent(agentId); existingAgent != nil {
@@ -880,29 +923,9 @@ func (a *App) ImportAgent() func(c *fiber.Ctx) error {
 		}
 
 		// 10. Ensure agent pool is initialized
-		var pool *state.AgentPool
-		if p, ok := a.UserPools[userIDStr]; ok {
-			pool = p
-		} else {
-			pool, err = state.NewAgentPool(
-				userIDStr,
-				"", // Always use model from agent config
-				os.Getenv("LOCALAGI_MULTIMODAL_MODEL"),
-				os.Getenv("LOCALAGI_IMAGE_MODEL"),
-				os.Getenv("LOCALAGI_LOCALRAG_URL"),
-				services.Actions(map[string]string{
-					services.ActionConfigSSHBoxURL: os.Getenv("LOCALAGI_SSHBOX_URL"),
-				}),
-				services.Connectors,
-				services.DynamicPrompts,
-				services.Filters,
-				os.Getenv("LOCALAGI_TIMEOUT"),
-				os.Getenv("LOCALAGI_ENABLE_CONVERSATIONS_LOGGING") == "true",
-			)
-			if err != nil {
-				return errorJSONMessage(c, "Failed to create agent pool: "+err.Error())
-			}
-			a.UserPools[userIDStr] = pool
+		pool, err := a.loadUserPool(userIDStr)
+		if err != nil {
+			return errorJSONMessage(c, "Failed to create agent pool: "+err.Error())
 		}
 
 		// 11. Register agent in the in-memory pool
@@ -1009,26 +1032,9 @@ func (a *App) Chat() func(c *fiber.Ctx) error {
 		}
 
 		// 4. Ensure in-memory pool exists
-		var pool *state.AgentPool
-		if p, ok := a.UserPools[userID]; ok {
-			pool = p
-		} else {
-			pool := state.NewEmptyAgentPool(
-				userID,
-				"", // Always use model from agent config
-				os.Getenv("LOCALAGI_MULTIMODAL_MODEL"),
-				os.Getenv("LOCALAGI_IMAGE_MODEL"),
-				os.Getenv("LOCALAGI_LOCALRAG_URL"),
-				services.Actions(map[string]string{
-					services.ActionConfigSSHBoxURL: os.Getenv("LOCALAGI_SSHBOX_URL"),
-				}),
-				services.Connectors,
-				services.DynamicPrompts,
-				services.Filters,
-				os.Getenv("LOCALAGI_TIMEOUT"),
-				os.Getenv("LOCALAGI_ENABLE_CONVERSATIONS_LOGGING") == "true",
-			)
-			a.UserPools[userID] = pool
+		pool, err := a.loadUserPool(userID)
+		if err != nil {
+			return errorJSONMessage(c, "Failed to load agent pool: "+err.Error())
 		}
 
 		// 5. Start agent in memory if not running
@@ -1037,6 +1043,10 @@ func (a *App) Chat() func(c *fiber.Ctx) error {
 				return errorJSONMessage(c, "Failed to start agent: "+err.Error())
 			}
 		}
+		agentInstance := pool.GetAgent(agentId)
+		if agentInstance == nil {
+			return errorJSONMessage(c, "Agent not found")
+		}
 
 		// 6. Emit user message via SSE
 		manager := pool.GetManager(agentId)
@@ -1058,9 +1068,35 @@ func (a *App) Chat() func(c *fiber.Ctx) error {
 			CreatedAt: time.Now(),
 		})
 
+		// 8. Queue the job before sending its id, so that it can be stopped
+		// through the jobs API as soon as the UI knows about it
+		jobID := uuid.New().String()
+		agentMessageID := messageID + "-agent"
+		var fullContent strings.Builder
+		// Stream callback to send partial responses
+		streamCallback := func(chunk string) {
+			fullContent.WriteString(chunk)
+
+			// Send streaming chunk via SSE
+			send("json_message_chunk", map[string]interface{}{
+				"id":        agentMessageID,
+				"sender":    "agent",
+				"content":   fullContent.String(),
+				"createdAt": time.Now().Format(time.RFC3339),
+			})
+		}
+
+		result := agentInstance.Submit(
+			coreTypes.WithText(message),
+			coreTypes.WithStreamCallback(streamCallback),
+			coreTypes.WithConversationKey("webui:"+agentId),
+			coreTypes.WithUUID(jobID),
+		)
+
 		// Send processing status
 		statusData, err := json.Marshal(map[string]interface{}{
 			"status":    "processing",
+			"job_id":    jobID,
 			"timestamp": time.Now().Format(time.RFC3339),
 		})
 
@@ -1071,28 +1107,38 @@ func (a *App) Chat() func(c *fiber.Ctx) error {
 				sse.NewMessage(string(statusData)).WithEvent("json_message_status"))
 		}
 
-		// 8. Ask agent asynchronously with streaming support
+		// 9. Wait for the response asynchronously
 		go func() {
-			var fullContent strings.Builder
-			agentMessageID := messageID + "-agent"
-			// Stream callback to send partial responses
-			streamCallback := func(chunk string) {
-				fullContent.WriteString(chunk)
-
-				// Send streaming chunk via SSE
-				send("json_message_chunk", map[string]interface{}{
-					"id":        agentMessageID,
-					"sender":    "agent",
-					"content":   fullContent.String(),
-					"createdAt": time.Now().Format(time.RFC3339),
+			response := result.WaitResult()
+
+			if errors.Is(response.Error, coreAgent.ErrJobCanceled) {
+				// Keep what was generated before the job was stopped
+				if partial := fullContent.String(); partial != "" {
+					send("json_message", map[string]interface{}{
+						"id":        agentMessageID,
+						"sender":    "agent",
+						"content":   partial,
+						"type":      "message",
+						"createdAt": time.Now().Format(time.RFC3339),
+						"final":     true,
+					})
+					_ = db.DB.Create(&models.AgentMessage{
+						ID:        uuid.New(),
+						AgentID:   agent.ID,
+						Sender:    "agent",
+						Content:   partial,
+						Type:      "message",
+						CreatedAt: time.Now(),
+					})
+				}
+				send("json_message_status", map[string]interface{}{
+					"status":    "cancelled",
+					"job_id":    jobID,
+					"timestamp": time.Now().Format(time.RFC3339),
 				})
+				return
 			}
 
-			response := pool.GetAgent(agentId).Ask(
-				coreTypes.WithText(message),
-				coreTypes.WithStreamCallback(streamCallback),
-			)
-
 			if response.Error != nil {
 				send("json_error", map[string]interface{}{
 					"error":     response.Error.Error(),
@@ -1135,10 +1181,11 @@ func (a *App) Chat() func(c *fiber.Ctx) error {
 
 		}()
 
-		// 9. Immediate 202 response
+		// 10. Immediate 202 response
 		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
 			"status":     "message_received",
 			"message_id": messageID,
+			"job_id":     jobID,
 		})
 	}
 }
@@ -1152,30 +1199,9 @@ func (a *App) GetActionDefinition() func(c *fiber.Ctx) error {
 		}
 
 		// 2. Get or create user pool
-		var pool *state.AgentPool
-		if p, ok := a.UserPools[userIDStr]; ok {
-			pool = p
-		} else {
-			var err error
-			pool, err = state.NewAgentPool(
-				userIDStr,
-				"", // Always use model from agent config
-				os.Getenv("LOCALAGI_MULTIMODAL_MODEL"),
-				os.Getenv("LOCALAGI_IMAGE_MODEL"),
-				os.Getenv("LOCALAGI_LOCALRAG_URL"),
-				services.Actions(map[string]string{
-					services.ActionConfigSSHBoxURL: os.Getenv("LOCALAGI_SSHBOX_URL"),
-				}),
-				services.Connectors,
-				services.DynamicPrompts,
-				services.Filters,
-				os.Getenv("LOCALAGI_TIMEOUT"),
-				os.Getenv("LOCALAGI_ENABLE_CONVERSATIONS_LOGGING") == "true",
-			)
-			if err != nil {
-				return errorJSONMessage(c, "Failed to create agent pool: "+err.Error())
-			}
-			a.UserPools[userIDStr] = pool
+		pool, err := a.loadUserPool(userIDStr)
+		if err != nil {
+			return errorJSONMessage(c, "Failed to create agent pool: "+err.Error())
 		}
 
 		payload := struct {
@@ -1215,30 +1241,9 @@ func (a *App) ExecuteAction() func(c *fiber.Ctx) error {
 		}
 
 		// 2. Get or create user pool
-		var pool *state.AgentPool
-		if p, ok := a.UserPools[userIDStr]; ok {
-			pool = p
-		} else {
-			var err error
-			pool, err = state.NewAgentPool(
-				userIDStr,
-				"", // Always use model from agent config
-				os.Getenv("LOCALAGI_MULTIMODAL_MODEL"),
-				os.Getenv("LOCALAGI_IMAGE_MODEL"),
-				os.Getenv("LOCALAGI_LOCALRAG_URL"),
-				services.Actions(map[string]string{
-					services.ActionConfigSSHBoxURL: os.Getenv("LOCALAGI_SSHBOX_URL"),
-				}),
-				services.Connectors,
-				services.DynamicPrompts,
-				services.Filters,
-				os.Getenv("LOCALAGI_TIMEOUT"),
-				os.Getenv("LOCALAGI_ENABLE_CONVERSATIONS_LOGGING") == "true",
-			)
-			if err != nil {
-				return errorJSONMessage(c, "Failed to create agent pool: "+err.Error())
-			}
-			a.UserPools[userIDStr] = pool
+		pool, err := a.loadUserPool(userIDStr)
+		if err != nil {
+			return errorJSONMessage(c, "Failed to create agent pool: "+err.Error())
 		}
 
 		payload := struct {
@@ -1306,6 +1311,7 @@ func (a *App) ExecuteAction() func(c *fiber.Ctx) error {
 			UserID:     userID,
 			ActionName: actionName,
 			Status:     "running",
+			ParamsHash: coreAgent.HashActionParams(payload.Params),
 			CreatedAt:  time.Now(),
 		}
 
@@ -1330,12 +1336,16 @@ func (a *App) ExecuteAction() func(c *fiber.Ctx) error {
 		ctx, cancel := context.WithTimeout(c.Context(), 200*time.Second)
 		defer cancel()
 
+		start := time.Now()
 		res, err := action.Run(ctx, a.sharedState, payload.Params)
 		if err != nil {
 			// Update status to error
 			_ = db.DB.Model(&actionExecution).Updates(map[string]interface{}{
-				"Status":    "error",
-				"UpdatedAt": time.Now(),
+				"Status":     "error",
+				"DurationMs": time.Since(start).Milliseconds(),
+				"ErrorClass": coreAgent.ClassifyActionError(err),
+				"Error":      err.Error(),
+				"UpdatedAt":  time.Now(),
 			})
 			xlog.Error("Error running action", "error", err)
 			return errorJSONMessage(c, err.Error())
@@ -1343,8 +1353,9 @@ func (a *App) ExecuteAction() func(c *fiber.Ctx) error {
 
 		// 8. Update status to success
 		_ = db.DB.Model(&actionExecution).Updates(map[string]interface{}{
-			"Status":    "success",
-			"UpdatedAt": time.Now(),
+			"Status":     "success",
+			"DurationMs": time.Since(start).Milliseconds(),
+			"UpdatedAt":  time.Now(),
 		})
 
 		xlog.Info("Action executed successfully", "action", actionName, "executionId", executionID, "result", res)
@@ -1465,6 +1476,10 @@ func (a *App) Responses(pool *state.AgentPool, tracker *conversations.Conversati
 			}
 		}
 
+		if schema := request.ResponseSchema(); schema != nil {
+			jobOptions = append(jobOptions, coreTypes.WithResponseSchema(*schema))
+		}
+
 		res := agent.Ask(jobOptions...)
 		if res.Error != nil {
 			xlog.Error("Error asking agent", "agent", agentName, "error", res.Error)
@@ -1610,28 +1625,9 @@ func (a *App) CreateGroup() func(c *fiber.Ctx) error {
 		}
 
 		// 2. Get or create user pool
-		pool, ok := a.UserPools[userIDStr]
-		if !ok {
-			var err error
-			pool, err = state.NewAgentPool(
-				userIDStr,
-				"", // Always use model from agent config
-				os.Getenv("LOCALAGI_MULTIMODAL_MODEL"),
-				os.Getenv("LOCALAGI_IMAGE_MODEL"),
-				os.Getenv("LOCALAGI_LOCALRAG_URL"),
-				services.Actions(map[string]string{
-					services.ActionConfigSSHBoxURL: os.Getenv("LOCALAGI_SSHBOX_URL"),
-				}),
-				services.Connectors,
-				services.DynamicPrompts,
-				services.Filters,
-				os.Getenv("LOCALAGI_TIMEOUT"),
-				os.Getenv("LOCALAGI_ENABLE_CONVERSATIONS_LOGGING") == "true",
-			)
-			if err != nil {
-				return errorJSONMessage(c, "Failed to create agent pool: "+err.Error())
-			}
-			a.UserPools[userIDStr] = pool
+		pool, err := a.loadUserPool(userIDStr)
+		if err != nil {
+			return errorJSONMessage(c, "Failed to create agent pool: "+err.Error())
 		}
 
 		agentConfig := &config.AgentConfig
@@ -2815,7 +2811,7 @@ func (a *App) RequireActiveStatusAgent() func(c *fiber.Ctx) error {
 		agentId := agent.ID.String()
 
 		// 3. Check if user has an agent pool in memory
-		pool, ok := a.UserPools[userIDStr]
+		pool, ok := a.userPool(userIDStr)
 		if !ok {
 			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
 				"success": false,
@@ -2877,31 +2873,11 @@ func (a *App) GetAgentDetails() func(c *fiber.Ctx) error {
 		agentId := agent.ID.String()
 
 		// 2. Load or create pool in memory
-		var pool *state.AgentPool
-		var exists bool
-		if pool, exists = a.UserPools[userIDStr]; !exists {
-			var err error
-			pool, err = state.NewAgentPool(
-				userIDStr,
-				"", // Always use model from agent config
-				os.Getenv("LOCALAGI_MULTIMODAL_MODEL"),
-				os.Getenv("LOCALAGI_IMAGE_MODEL"),
-				os.Getenv("LOCALAGI_LOCALRAG_URL"),
-				services.Actions(map[string]string{
-					services.ActionConfigSSHBoxURL: os.Getenv("LOCALAGI_SSHBOX_URL"),
-				}),
-				services.Connectors,
-				services.DynamicPrompts,
-				services.Filters,
-				os.Getenv("LOCALAGI_TIMEOUT"),
-				os.Getenv("LOCALAGI_ENABLE_CONVERSATIONS_LOGGING") == "true",
-			)
-			if err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": "Failed to load agent pool",
-				})
-			}
-			a.UserPools[userIDStr] = pool
+		pool, err := a.loadUserPool(userIDStr)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to load agent pool",
+			})
 		}
 
 		// 3. Just check if agent is running in memory, don't create it
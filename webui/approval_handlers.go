@@ -0,0 +1,100 @@
+package webui
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/mudler/LocalAGI/core/types"
+	"github.com/mudler/LocalAGI/db"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+	"gorm.io/datatypes"
+)
+
+// GetActionApprovals lists the approval requests of an agent,
+// optionally filtered by status (e.g. ?status=pending)
+func (a *App) GetActionApprovals() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agent, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agent == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		query := db.DB.Where("AgentID = ?", agent.ID)
+		if status := c.Query("status"); status != "" {
+			query = query.Where("Status = ?", status)
+		}
+
+		var approvals []models.ActionApproval
+		if err := query.Order("CreatedAt DESC").Find(&approvals).Error; err != nil {
+			return errorJSONMessage(c, "Failed to fetch approval requests: "+err.Error())
+		}
+
+		return c.JSON(approvals)
+	}
+}
+
+// DecideActionApproval approves, modifies or denies a pending approval request.
+// Modifying requires the new parameters of the action.
+func (a *App) DecideActionApproval() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agent, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agent == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		approvalID, err := uuid.Parse(c.Params("approvalId"))
+		if err != nil {
+			return errorJSONMessage(c, "Invalid approval ID format")
+		}
+
+		var payload struct {
+			Status string             `json:"status"`
+			Params types.ActionParams `json:"params"`
+		}
+		if err := c.BodyParser(&payload); err != nil {
+			return errorJSONMessage(c, "Invalid request body: "+err.Error())
+		}
+
+		updates := map[string]interface{}{
+			"Status":    payload.Status,
+			"DecidedAt": time.Now(),
+		}
+		switch payload.Status {
+		case types.ApprovalApproved, types.ApprovalDenied:
+		case types.ApprovalModified:
+			if payload.Params == nil {
+				return errorJSONMessage(c, "Parameters are required to modify the action")
+			}
+			params, err := json.Marshal(payload.Params)
+			if err != nil {
+				return errorJSONMessage(c, "Invalid parameters: "+err.Error())
+			}
+			updates["Params"] = datatypes.JSON(params)
+		default:
+			return errorJSONMessage(c, "Invalid status. Must be one of 'approved', 'modified' or 'denied'")
+		}
+
+		result := db.DB.Model(&models.ActionApproval{}).
+			Where("ID = ? AND AgentID = ? AND Status = ?", approvalID, agent.ID, types.ApprovalPending).
+			Updates(updates)
+		if result.Error != nil {
+			return errorJSONMessage(c, "Failed to update approval request: "+result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return errorJSONMessage(c, "Approval request not found or already decided")
+		}
+
+		xlog.Info("Action approval decided", "agent", agent.ID, "approval", approvalID, "status", payload.Status)
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Approval request " + payload.Status,
+			"data": fiber.Map{
+				"approvalId": approvalID.String(),
+				"status":     payload.Status,
+			},
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package webui
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAGI/core/agent"
+	"github.com/mudler/LocalAGI/db"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+)
+
+// GetFailedJobs lists the failed jobs of an agent, newest first. The
+// dead-letter list is returned with ?status=dead, the jobs waiting for a
+// retry with ?status=retrying.
+func (a *App) GetFailedJobs() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agent, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agent == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		query := db.DB.Where("AgentID = ?", agent.ID)
+		if status := c.Query("status"); status != "" {
+			query = query.Where("Status = ?", status)
+		}
+
+		var jobs []models.FailedJob
+		if err := query.Order("UpdatedAt DESC").Find(&jobs).Error; err != nil {
+			return errorJSONMessage(c, "Failed to fetch failed jobs: "+err.Error())
+		}
+
+		return c.JSON(jobs)
+	}
+}
+
+// RedriveFailedJob runs a dead-lettered job again
+func (a *App) RedriveFailedJob() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agentModel, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agentModel == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		jobID := c.Params("jobId")
+		if jobID == "" {
+			return errorJSONMessage(c, "Job id is required")
+		}
+
+		instance := a.runningAgent(c, agentModel.ID.String())
+		if instance == nil {
+			return errorJSONMessage(c, "Agent is not running")
+		}
+
+		if err := instance.RedriveJob(jobID); err != nil {
+			if errors.Is(err, agent.ErrFailedJobNotFound) {
+				return errorJSONMessage(c, "Dead-lettered job not found")
+			}
+			return errorJSONMessage(c, err.Error())
+		}
+
+		xlog.Info("Failed job re-driven", "agent", agentModel.ID, "job", jobID)
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Job re-driven",
+			"data": fiber.Map{
+				"jobId": jobID,
+			},
+		})
+	}
+}
+
+// DeleteFailedJob removes a job from the dead-letter list
+func (a *App) DeleteFailedJob() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agentModel, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agentModel == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		jobID := c.Params("jobId")
+		if jobID == "" {
+			return errorJSONMessage(c, "Job id is required")
+		}
+
+		result := db.DB.Where("AgentID = ? AND JobID = ? AND Status = ?", agentModel.ID, jobID, agent.FailedJobDead).
+			Delete(&models.FailedJob{})
+		if result.Error != nil {
+			return errorJSONMessage(c, "Failed to delete failed job: "+result.Error.Error())
+		}
+		if result.RowsAffected == 0 {
+			return errorJSONMessage(c, "Dead-lettered job not found")
+		}
+
+		xlog.Info("Failed job deleted", "agent", agentModel.ID, "job", jobID)
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Failed job deleted",
+			"data": fiber.Map{
+				"jobId": jobID,
+			},
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package webui
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAGI/core/agent"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+)
+
+// GetActiveJobs lists the jobs queued, parked or run by an agent, with their
+// current step and elapsed time
+func (a *App) GetActiveJobs() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agentModel, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agentModel == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		instance := a.runningAgent(c, agentModel.ID.String())
+		if instance == nil {
+			return c.JSON([]agent.JobInfo{})
+		}
+
+		return c.JSON(instance.ActiveJobs())
+	}
+}
+
+// GetActiveJob returns an active job of an agent and its conversation so far
+func (a *App) GetActiveJob() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agentModel, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agentModel == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		instance := a.runningAgent(c, agentModel.ID.String())
+		if instance == nil {
+			return errorJSONMessage(c, "Agent is not active in memory")
+		}
+
+		job, conv, err := instance.ActiveJob(c.Params("jobId"))
+		if err != nil {
+			return errorJSONMessage(c, err.Error())
+		}
+
+		return c.JSON(fiber.Map{
+			"job":          job,
+			"conversation": conv,
+		})
+	}
+}
+
+// CancelActiveJob stops a job of an agent, canceling its LLM and action calls
+func (a *App) CancelActiveJob() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agentModel, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agentModel == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		instance := a.runningAgent(c, agentModel.ID.String())
+		if instance == nil {
+			return errorJSONMessage(c, "Agent is not active in memory")
+		}
+
+		jobID := c.Params("jobId")
+		if err := instance.CancelJob(jobID); err != nil {
+			if errors.Is(err, agent.ErrJobNotFound) {
+				return errorJSONMessage(c, "Job not found or already finished")
+			}
+			return errorJSONMessage(c, err.Error())
+		}
+
+		xlog.Info("Job canceled", "agent", agentModel.ID, "job", jobID)
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Job canceled",
+			"data": fiber.Map{
+				"jobId": jobID,
+			},
+		})
+	}
+}
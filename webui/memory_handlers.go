@@ -0,0 +1,261 @@
+package webui
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAGI/core/agent"
+	models "github.com/mudler/LocalAGI/dbmodels"
+)
+
+// parseMemoryTime parses the bounds of a date range, as RFC 3339 times or
+// dates. A date as upper bound includes the whole day.
+func parseMemoryTime(value string, upper bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if upper {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+func memoryRange(c *fiber.Ctx) (time.Time, time.Time, error) {
+	from, err := parseMemoryTime(c.Query("from"), false)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("Invalid from: " + c.Query("from"))
+	}
+	to, err := parseMemoryTime(c.Query("to"), true)
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("Invalid to: " + c.Query("to"))
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		return time.Time{}, time.Time{}, errors.New("The end of the range is before its start")
+	}
+	return from, to, nil
+}
+
+func memoryError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, agent.ErrMemoryNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, agent.ErrMemoryUnsupported):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	return errorJSONMessage(c, err.Error())
+}
+
+// GetMemories lists what an agent remembers: its short-term memories, past
+// messages, conversation summaries and RAG entries. The memories are
+// filtered with ?source=, ?from=, ?to= and ?pinned=true, and searched with
+// ?q=. They are paginated with ?limit= and ?offset=.
+func (a *App) GetMemories() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agentModel, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agentModel == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		instance := a.runningAgent(c, agentModel.ID.String())
+		if instance == nil {
+			return errorJSONMessage(c, "Agent is not running")
+		}
+
+		from, to, err := memoryRange(c)
+		if err != nil {
+			return errorJSONMessage(c, err.Error())
+		}
+
+		filter := agent.MemoryFilter{
+			Source: c.Query("source"),
+			Query:  c.Query("q"),
+			From:   from,
+			To:     to,
+			Pinned: c.QueryBool("pinned"),
+			Limit:  c.QueryInt("limit", agent.DefaultMemoryLimit),
+			Offset: c.QueryInt("offset"),
+		}
+
+		memories, err := instance.ListMemories(c.Context(), filter)
+		if err != nil {
+			return errorJSONMessage(c, err.Error())
+		}
+
+		return c.JSON(fiber.Map{
+			"memories": memories,
+			"limit":    min(max(filter.Limit, 1), agent.MaxMemoryLimit),
+			"offset":   filter.Offset,
+		})
+	}
+}
+
+// UpdateMemory replaces the content of a memory
+func (a *App) UpdateMemory() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agentModel, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agentModel == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		var payload struct {
+			Content string `json:"content"`
+		}
+		if err := c.BodyParser(&payload); err != nil {
+			return errorJSONMessage(c, "Invalid request body")
+		}
+
+		instance := a.runningAgent(c, agentModel.ID.String())
+		if instance == nil {
+			return errorJSONMessage(c, "Agent is not running")
+		}
+
+		memory, err := instance.UpdateMemory(c.Context(), c.Params("source"), c.Params("memoryId"), payload.Content)
+		if err != nil {
+			return memoryError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Memory updated",
+			"data":    memory,
+		})
+	}
+}
+
+// DeleteMemory forgets a memory, including its copies in the vector stores
+func (a *App) DeleteMemory() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agentModel, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agentModel == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		instance := a.runningAgent(c, agentModel.ID.String())
+		if instance == nil {
+			return errorJSONMessage(c, "Agent is not running")
+		}
+
+		source, memoryID := c.Params("source"), c.Params("memoryId")
+		if err := instance.DeleteMemory(c.Context(), source, memoryID); err != nil {
+			return memoryError(c, err)
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Memory deleted",
+			"data": fiber.Map{
+				"source": source,
+				"id":     memoryID,
+			},
+		})
+	}
+}
+
+// PinMemory pins a memory so the agent recalls it in every job, or unpins it
+// with {"pinned": false}
+func (a *App) PinMemory() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agentModel, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agentModel == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		payload := struct {
+			Pinned *bool `json:"pinned"`
+		}{}
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&payload); err != nil {
+				return errorJSONMessage(c, "Invalid request body")
+			}
+		}
+		pinned := payload.Pinned == nil || *payload.Pinned
+
+		instance := a.runningAgent(c, agentModel.ID.String())
+		if instance == nil {
+			return errorJSONMessage(c, "Agent is not running")
+		}
+
+		memory, err := instance.PinMemory(c.Context(), c.Params("source"), c.Params("memoryId"), pinned)
+		if err != nil {
+			return memoryError(c, err)
+		}
+
+		message := "Memory pinned"
+		if !pinned {
+			message = "Memory unpinned"
+		}
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": message,
+			"data":    memory,
+		})
+	}
+}
+
+// WipeMemories forgets the memories of an agent between ?from= and ?to=
+func (a *App) WipeMemories() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agentModel, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agentModel == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		from, to, err := memoryRange(c)
+		if err != nil {
+			return errorJSONMessage(c, err.Error())
+		}
+		if from.IsZero() && to.IsZero() {
+			return errorJSONMessage(c, "A date range (from, to) is required")
+		}
+
+		instance := a.runningAgent(c, agentModel.ID.String())
+		if instance == nil {
+			return errorJSONMessage(c, "Agent is not running")
+		}
+
+		wipe, err := instance.WipeMemories(c.Context(), from, to)
+		if err != nil {
+			return errorJSONMessage(c, err.Error())
+		}
+
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Memories wiped",
+			"data":    wipe,
+		})
+	}
+}
+
+// GetMemoryAudit returns who changed the memories of an agent and when, the
+// latest changes first. The changes are filtered with ?source= and
+// paginated with ?limit= and ?offset=.
+func (a *App) GetMemoryAudit() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agentModel, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agentModel == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		limit := min(max(c.QueryInt("limit", agent.DefaultMemoryLimit), 1), agent.MaxMemoryLimit)
+		offset := max(c.QueryInt("offset"), 0)
+
+		trail, err := agent.MemoryAuditTrail(agentModel.ID, c.Query("source"), limit, offset)
+		if err != nil {
+			return errorJSONMessage(c, "Failed to fetch the memory audit trail: "+err.Error())
+		}
+
+		return c.JSON(fiber.Map{
+			"audit":  trail,
+			"limit":  limit,
+			"offset": offset,
+		})
+	}
+}
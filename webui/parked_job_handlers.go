@@ -0,0 +1,93 @@
+package webui
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mudler/LocalAGI/core/agent"
+	"github.com/mudler/LocalAGI/db"
+	models "github.com/mudler/LocalAGI/dbmodels"
+	"github.com/mudler/LocalAGI/pkg/xlog"
+)
+
+// runningAgent returns the in-memory instance of the agent of the request,
+// or nil if the agent isn't running
+func (a *App) runningAgent(c *fiber.Ctx, agentID string) *agent.Agent {
+	userID, ok := c.Locals("id").(string)
+	if !ok || userID == "" {
+		return nil
+	}
+
+	pool, ok := a.userPool(userID)
+	if !ok {
+		return nil
+	}
+	return pool.GetAgent(agentID)
+}
+
+// GetParkedJobs lists the jobs received while the agent was paused, which
+// run once it's resumed
+func (a *App) GetParkedJobs() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agent, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agent == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		if instance := a.runningAgent(c, agent.ID.String()); instance != nil {
+			jobs, err := instance.ParkedJobs()
+			if err != nil {
+				return errorJSONMessage(c, err.Error())
+			}
+			return c.JSON(jobs)
+		}
+
+		var jobs []models.ParkedJob
+		if err := db.DB.Where("AgentID = ?", agent.ID).Order("CreatedAt ASC").Find(&jobs).Error; err != nil {
+			return errorJSONMessage(c, "Failed to fetch parked jobs: "+err.Error())
+		}
+
+		return c.JSON(jobs)
+	}
+}
+
+// DiscardParkedJob drops a job received while the agent was paused
+func (a *App) DiscardParkedJob() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		agentModel, ok := c.Locals("agent").(*models.Agent)
+		if !ok || agentModel == nil {
+			return errorJSONMessage(c, "Agent not found in context")
+		}
+
+		jobID := c.Params("jobId")
+		if jobID == "" {
+			return errorJSONMessage(c, "Job id is required")
+		}
+
+		if instance := a.runningAgent(c, agentModel.ID.String()); instance != nil {
+			if err := instance.DiscardParkedJob(jobID); err != nil {
+				if errors.Is(err, agent.ErrJobNotParked) {
+					return errorJSONMessage(c, "Parked job not found")
+				}
+				return errorJSONMessage(c, err.Error())
+			}
+		} else {
+			result := db.DB.Where("AgentID = ? AND JobID = ?", agentModel.ID, jobID).Delete(&models.ParkedJob{})
+			if result.Error != nil {
+				return errorJSONMessage(c, "Failed to discard parked job: "+result.Error.Error())
+			}
+			if result.RowsAffected == 0 {
+				return errorJSONMessage(c, "Parked job not found")
+			}
+		}
+
+		xlog.Info("Parked job discarded", "agent", agentModel.ID, "job", jobID)
+		return c.JSON(fiber.Map{
+			"success": true,
+			"message": "Parked job discarded",
+			"data": fiber.Map{
+				"jobId": jobID,
+			},
+		})
+	}
+}
@@ -16,6 +16,7 @@ export function useChat(agentId, model, onStatusCompleted) {
   const processedMessageIds = useRef(new Set());
   const localMessageContents = useRef(new Set()); // Track locally added message contents
   const eventSourceRef = useRef(null);
+  const jobIdRef = useRef(null); // Job answering the last message
 
   // Fetch initial chat history on mount or when agentId changes
   useEffect(() => {
@@ -190,8 +191,21 @@ export function useChat(agentId, model, onStatusCompleted) {
         const statusData = latestStatus.content;
         if (statusData.status === "processing") {
           setSending(true);
-        } else if (statusData.status === "completed") {
+          if (statusData.job_id) {
+            jobIdRef.current = statusData.job_id;
+          }
+        } else if (
+          statusData.status === "completed" ||
+          statusData.status === "cancelled"
+        ) {
           setSending(false);
+          jobIdRef.current = null;
+          if (statusData.status === "cancelled") {
+            // Drop the loading message, partial replies are kept
+            setMessages((prev) =>
+              prev.filter((msg) => !(msg.sender === "assistant" && msg.loading))
+            );
+          }
           // Call the completion callback if provided
           if (onStatusCompleted) {
             onStatusCompleted();
@@ -247,7 +261,10 @@ export function useChat(agentId, model, onStatusCompleted) {
 
       try {
         // For local model (response comes from SSE), just wait
-        await chatApi.sendMessage(agentId, content);
+        const result = await chatApi.sendMessage(agentId, content);
+        if (result?.job_id) {
+          jobIdRef.current = result.job_id;
+        }
         // SSE will handle replacement, so leave loading message
       } catch (err) {
         setError(err.message || "Failed to send message");
@@ -270,6 +287,16 @@ export function useChat(agentId, model, onStatusCompleted) {
     localMessageContents.current.clear();
   }, [agentId]);
 
+  // Stop generating: cancels the job answering the last message
+  const stopGenerating = useCallback(async () => {
+    if (!jobIdRef.current) return;
+    try {
+      await chatApi.cancelJob(agentId, jobIdRef.current);
+    } catch (err) {
+      setError(err.message || "Failed to stop the response");
+    }
+  }, [agentId]);
+
   const clearError = useCallback(() => {
     setError(null);
   }, []);
@@ -280,6 +307,7 @@ export function useChat(agentId, model, onStatusCompleted) {
     error,
     isConnected,
     sendMessage,
+    stopGenerating,
     clearChat,
     clearError,
   };
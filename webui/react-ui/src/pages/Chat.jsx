@@ -233,6 +233,7 @@ function Chat() {
     error,
     isConnected,
     sendMessage,
+    stopGenerating,
     clearChat,
     clearError,
   } = useChat(id, agentConfig?.model, handleStatusCompleted);
@@ -514,13 +515,24 @@ function Chat() {
                       disabled={sending || !isConnected}
                       className="chat-input"
                     />
-                    <button
-                      type="submit"
-                      disabled={sending || !isConnected || !message.trim()}
-                      className="chat-send-button"
-                    >
-                      <i className="fas fa-paper-plane"></i>
-                    </button>
+                    {sending ? (
+                      <button
+                        type="button"
+                        onClick={stopGenerating}
+                        className="chat-send-button chat-stop-button"
+                        title="Stop generating"
+                      >
+                        <i className="fas fa-stop"></i>
+                      </button>
+                    ) : (
+                      <button
+                        type="submit"
+                        disabled={!isConnected || !message.trim()}
+                        className="chat-send-button"
+                      >
+                        <i className="fas fa-paper-plane"></i>
+                      </button>
+                    )}
                   </div>
                   {/* <button
                     type="button"
@@ -359,6 +359,18 @@ export const chatApi = {
     );
     return handleResponse(response);
   },
+
+  // Stop the job answering a message
+  cancelJob: async (id, jobId) => {
+    const response = await fetch(
+      buildUrl(API_CONFIG.endpoints.cancelJob(id, jobId)),
+      {
+        method: "POST",
+        headers: API_CONFIG.headers,
+      }
+    );
+    return handleResponse(response);
+  },
 };
 
 // Action-related API calls
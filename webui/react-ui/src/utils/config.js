@@ -42,6 +42,7 @@ export const API_CONFIG = {
     notify: (name) => `/notify/${name}`,
     responses: "/v1/responses",
     chatHistory: (id) => `/api/agent/${id}/chat`,
+    cancelJob: (id, jobId) => `/api/agent/${id}/jobs/${jobId}/cancel`,
 
     // SSE endpoint
     sse: (name) => `/sse/${name}`,
@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"math/rand"
 	"net/http"
-	"os"
 
 	"github.com/dave-gray101/v2keyauth"
 	fiber "github.com/gofiber/fiber/v2"
@@ -109,7 +108,7 @@ func (app *App) registerRoutes(webapp *fiber.App) {
 			return c.SendStatus(fiber.StatusUnauthorized)
 		}
 
-		pool, ok := app.UserPools[userID]
+		pool, ok := app.userPool(userID)
 		if !ok {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 				"error": "Agent pool not found",
@@ -218,6 +217,24 @@ func (app *App) registerRoutes(webapp *fiber.App) {
 
 	webapp.Put("/api/agent/:id/h402/:requestId/payment-header", app.RequireUser(), app.RequireActiveAgent(), app.RequireActiveStatusAgent(), app.SubmitPaymentHeader())
 
+	webapp.Get("/api/agent/:id/approvals", app.RequireUser(), app.RequireActiveAgent(), app.GetActionApprovals())
+	webapp.Put("/api/agent/:id/approvals/:approvalId", app.RequireUser(), app.RequireActiveAgent(), app.DecideActionApproval())
+	webapp.Get("/api/agent/:id/jobs", app.RequireUser(), app.RequireActiveAgent(), app.GetActiveJobs())
+	webapp.Get("/api/agent/:id/jobs/:jobId", app.RequireUser(), app.RequireActiveAgent(), app.GetActiveJob())
+	webapp.Post("/api/agent/:id/jobs/:jobId/cancel", app.RequireUser(), app.RequireActiveAgent(), app.CancelActiveJob())
+	webapp.Get("/api/agent/:id/parked-jobs", app.RequireUser(), app.RequireActiveAgent(), app.GetParkedJobs())
+	webapp.Delete("/api/agent/:id/parked-jobs/:jobId", app.RequireUser(), app.RequireActiveAgent(), app.DiscardParkedJob())
+	webapp.Get("/api/agent/:id/action-stats", app.RequireUser(), app.RequireActiveAgent(), app.GetActionStats())
+	webapp.Get("/api/agent/:id/failed-jobs", app.RequireUser(), app.RequireActiveAgent(), app.GetFailedJobs())
+	webapp.Post("/api/agent/:id/failed-jobs/:jobId/redrive", app.RequireUser(), app.RequireActiveAgent(), app.RedriveFailedJob())
+	webapp.Delete("/api/agent/:id/failed-jobs/:jobId", app.RequireUser(), app.RequireActiveAgent(), app.DeleteFailedJob())
+	webapp.Get("/api/agent/:id/memories", app.RequireUser(), app.RequireActiveAgent(), app.GetMemories())
+	webapp.Delete("/api/agent/:id/memories", app.RequireUser(), app.RequireActiveAgent(), app.WipeMemories())
+	webapp.Get("/api/agent/:id/memories/audit", app.RequireUser(), app.RequireActiveAgent(), app.GetMemoryAudit())
+	webapp.Put("/api/agent/:id/memories/:source/:memoryId", app.RequireUser(), app.RequireActiveAgent(), app.UpdateMemory())
+	webapp.Delete("/api/agent/:id/memories/:source/:memoryId", app.RequireUser(), app.RequireActiveAgent(), app.DeleteMemory())
+	webapp.Post("/api/agent/:id/memories/:source/:memoryId/pin", app.RequireUser(), app.RequireActiveAgent(), app.PinMemory())
+
 	// Metadata endpoint for agent configuration fields
 	webapp.Get("/api/agent/config/metadata", app.RequireUser(), app.GetAgentConfigMeta())
 
@@ -255,27 +272,9 @@ func (app *App) registerRoutes(webapp *fiber.App) {
 		statuses := make(map[string]bool)
 
 		// Use or init in-memory pool
-		pool, ok := app.UserPools[userID]
-		if !ok {
-			pool, err = state.NewAgentPool(
-				userID,
-				"",
-				os.Getenv("LOCALAGI_MULTIMODAL_MODEL"),
-				os.Getenv("LOCALAGI_IMAGE_MODEL"),
-				os.Getenv("LOCALAGI_LOCALRAG_URL"),
-				services.Actions(map[string]string{
-					services.ActionConfigSSHBoxURL: os.Getenv("LOCALAGI_SSHBOX_URL"),
-				}),
-				services.Connectors,
-				services.DynamicPrompts,
-				services.Filters,
-				os.Getenv("LOCALAGI_TIMEOUT"),
-				os.Getenv("LOCALAGI_ENABLE_CONVERSATIONS_LOGGING") == "true",
-			)
-			if err != nil {
-				return errorJSONMessage(c, "Failed to load agent pool: "+err.Error())
-			}
-			app.UserPools[userID] = pool
+		pool, err := app.loadUserPool(userID)
+		if err != nil {
+			return errorJSONMessage(c, "Failed to load agent pool: "+err.Error())
 		}
 
 		for _, agent := range dbAgents {
@@ -318,28 +317,9 @@ func (app *App) registerRoutes(webapp *fiber.App) {
 		}
 
 		// Load or init in-memory agent pool
-		pool, ok := app.UserPools[userID]
-		if !ok {
-			newPool, err := state.NewAgentPool(
-				userID,
-				"",
-				os.Getenv("LOCALAGI_MULTIMODAL_MODEL"),
-				os.Getenv("LOCALAGI_IMAGE_MODEL"),
-				os.Getenv("LOCALAGI_LOCALRAG_URL"),
-				services.Actions(map[string]string{
-					services.ActionConfigSSHBoxURL: os.Getenv("LOCALAGI_SSHBOX_URL"),
-				}),
-				services.Connectors,
-				services.DynamicPrompts,
-				services.Filters,
-				os.Getenv("LOCALAGI_TIMEOUT"),
-				os.Getenv("LOCALAGI_ENABLE_CONVERSATIONS_LOGGING") == "true",
-			)
-			if err != nil {
-				return errorJSONMessage(c, "Failed to load agent pool: "+err.Error())
-			}
-			app.UserPools[userID] = newPool
-			pool = newPool
+		pool, err := app.loadUserPool(userID)
+		if err != nil {
+			return errorJSONMessage(c, "Failed to load agent pool: "+err.Error())
 		}
 
 		// Get agent status history
@@ -360,11 +340,16 @@ func (app *App) registerRoutes(webapp *fiber.App) {
 				h.Result))
 		}
 
-		return c.JSON(fiber.Map{
+		response := fiber.Map{
 			"id":      agentId,
 			"active":  pool.IsAgentActive(agentId),
 			"history": entries,
-		})
+		}
+		if instance := pool.GetAgent(agentId); instance != nil {
+			response["queue"] = instance.QueueStats()
+		}
+
+		return c.JSON(response)
 	})
 
 	webapp.Post("/settings/import", app.RequireUser(), app.ImportAgent())
@@ -399,7 +384,7 @@ func (app *App) registerRoutes(webapp *fiber.App) {
 		var history []types.Observable
 
 		// Try to get observables from in-memory agent first (if running)
-		if pool, ok := app.UserPools[userID]; ok {
+		if pool, ok := app.userPool(userID); ok {
 			if agentInstance := pool.GetAgent(agent.ID.String()); agentInstance != nil {
 				// Agent is running in memory, use observer
 				history = agentInstance.Observer().History()
@@ -258,6 +258,18 @@ type TextConfig struct {
 // FormatConfig represents format configuration options
 type FormatConfig struct {
 	Type string `json:"type"`
+	// Name and Schema are set for the "json_schema" format
+	Name   string                 `json:"name,omitempty"`
+	Schema *jsonschema.Definition `json:"schema,omitempty"`
+	Strict *bool                  `json:"strict,omitempty"`
+}
+
+// ResponseSchema returns the schema the response must follow, if any
+func (r *RequestBody) ResponseSchema() *jsonschema.Definition {
+	if r.Text == nil || r.Text.Format == nil || r.Text.Format.Type != "json_schema" {
+		return nil
+	}
+	return r.Text.Format.Schema
 }
 
 // ResponseMessage represents a message in the response